@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// accessLogTimeFormat is the timestamp format of the Common Log Format
+const accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// maxPendingRequests is how many requests of a connection may wait for their
+// response before more go unlogged
+const maxPendingRequests = 64
+
+// AccessLog appends a line per HTTP request proxied through a tunnel to a file
+// in the Common Log Format, followed by how long the response took in
+// milliseconds, so standard log analyzers can read it
+type AccessLog struct {
+	path   string
+	file   *os.File
+	mu     sync.Mutex
+	closed bool
+}
+
+// accessLogPath returns the access log file of a tunnel, kept across runs so
+// earlier days can be looked up
+func accessLogPath(hostName string, remotePort int) (string, error) {
+	dir, err := kportCacheDir("access")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-%d.log", hostName, remotePort)), nil
+}
+
+// OpenAccessLog opens the access log of a tunnel for appending
+func OpenAccessLog(hostName string, remotePort int) (*AccessLog, error) {
+	path, err := accessLogPath(hostName, remotePort)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access log: %w", err)
+	}
+	return &AccessLog{path: path, file: file}, nil
+}
+
+// Path returns the path of the access log file
+func (al *AccessLog) Path() string {
+	return al.path
+}
+
+// Close stops logging and closes the file
+func (al *AccessLog) Close() error {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if al.closed {
+		return nil
+	}
+	al.closed = true
+	return al.file.Close()
+}
+
+// write appends a line, such as
+// 127.0.0.1 - - [10/Oct/2026:13:55:36 +0200] "GET /health HTTP/1.1" 200 2326 12
+func (al *AccessLog) write(client, user string, at time.Time, request, status string, size int64, duration time.Duration) {
+	bytes := "-"
+	if size > 0 {
+		bytes = strconv.FormatInt(size, 10)
+	}
+	line := fmt.Sprintf("%s - %s [%s] \"%s\" %s %s %d\n", client, user, at.Format(accessLogTimeFormat),
+		request, status, bytes, duration.Milliseconds())
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if !al.closed {
+		al.file.WriteString(line)
+	}
+}
+
+// OpenConn starts logging the requests of a proxied connection. user is the
+// name the client authenticated as, such as a LAN client's certificate, or "".
+func (al *AccessLog) OpenConn(clientAddr net.Addr, user string) *AccessConn {
+	client := clientAddr.String()
+	if host, _, err := net.SplitHostPort(client); err == nil {
+		client = host
+	}
+	if user == "" {
+		user = "-"
+	}
+
+	requestReader, requests := io.Pipe()
+	responseReader, responses := io.Pipe()
+	ac := &AccessConn{
+		log:       al,
+		client:    client,
+		user:      strings.ReplaceAll(user, " ", "_"),
+		started:   time.Now(),
+		requests:  requests,
+		responses: responses,
+		pending:   make(chan accessRequest, maxPendingRequests),
+		done:      make(chan struct{}),
+	}
+	go ac.readRequests(requestReader)
+	go ac.readResponses(responseReader)
+	return ac
+}
+
+// accessRequest is a request waiting for its response
+type accessRequest struct {
+	req *http.Request
+	at  time.Time
+}
+
+// AccessConn logs the requests of a single proxied connection, pairing each
+// request read from the client with the next response read from the server
+type AccessConn struct {
+	log       *AccessLog
+	client    string
+	user      string
+	started   time.Time
+	requests  *io.PipeWriter
+	responses *io.PipeWriter
+	pending   chan accessRequest
+	done      chan struct{}
+
+	// logged counts the lines written, so connections that weren't HTTP get one of their own
+	logged int
+}
+
+// Record passes a chunk of data flowing in one direction to its parser
+func (ac *AccessConn) Record(fromClient bool, data []byte) {
+	if fromClient {
+		ac.requests.Write(data)
+	} else {
+		ac.responses.Write(data)
+	}
+}
+
+// Close finishes the connection, logging it as a whole with the bytes it
+// received when none of it was HTTP
+func (ac *AccessConn) Close(bytesIn int64) {
+	ac.requests.Close()
+	ac.responses.Close()
+	<-ac.done
+	if ac.logged == 0 {
+		ac.log.write(ac.client, ac.user, ac.started, "-", "-", bytesIn, time.Since(ac.started))
+	}
+}
+
+// readRequests parses the requests of the client, draining the rest of the
+// stream once it isn't HTTP so the proxy never blocks
+func (ac *AccessConn) readRequests(r *io.PipeReader) {
+	defer close(ac.pending)
+	br := bufio.NewReader(r)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			io.Copy(io.Discard, r)
+			return
+		}
+		// A client sending Expect: 100-continue waits for the server before the
+		// body, so the request is handed on before its body is read. The
+		// response side stops taking requests once it drains an upgraded stream.
+		select {
+		case ac.pending <- accessRequest{req: req, at: time.Now()}:
+		default:
+		}
+		io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+}
+
+// readResponses parses the responses of the server and logs each with its
+// request. It never waits for a request before reading, so servers that speak
+// first, which aren't HTTP, aren't held up, and it drains the rest of the
+// stream after an upgrade.
+func (ac *AccessConn) readResponses(r *io.PipeReader) {
+	defer close(ac.done)
+	br := bufio.NewReader(r)
+	for {
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			break
+		}
+		// Informational responses such as 100 Continue precede the final one
+		if resp.StatusCode >= 100 && resp.StatusCode < 200 && resp.StatusCode != http.StatusSwitchingProtocols {
+			continue
+		}
+		request, ok := <-ac.pending
+		if !ok {
+			break
+		}
+		// The response to a HEAD request announces a body it doesn't carry
+		var size int64
+		if request.req.Method != http.MethodHead {
+			size, _ = io.Copy(io.Discard, resp.Body)
+		}
+		ac.logRequest(request, strconv.Itoa(resp.StatusCode), size)
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			break
+		}
+	}
+	io.Copy(io.Discard, r)
+	// Requests the server never answered are logged without a status
+	for request := range ac.pending {
+		ac.logRequest(request, "-", 0)
+	}
+}
+
+// logRequest writes the line of a request with its response
+func (ac *AccessConn) logRequest(request accessRequest, status string, size int64) {
+	line := fmt.Sprintf("%s %s %s", request.req.Method, request.req.RequestURI, request.req.Proto)
+	line = strings.NewReplacer(`"`, `\"`, "\n", "", "\r", "").Replace(line)
+	ac.log.write(ac.client, ac.user, request.at, line, status, size, time.Since(request.at))
+	ac.logged++
+}
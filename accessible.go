@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errQuit is returned by accessible prompts when the user asks to quit
+var errQuit = errors.New("quit")
+
+// AccessibleUI is a line-based front end for screen readers and dumb terminals.
+// It prints plain prompts and numbered menus and never moves the cursor.
+type AccessibleUI struct {
+	in          *bufio.Reader
+	out         io.Writer
+	hosts       []SSHHost
+	kportConfig *KportConfig
+	history     *PortHistory
+	tunnels     *TunnelManager
+}
+
+// NewAccessibleUI creates a line-based front end reading from stdin and writing to stdout
+func NewAccessibleUI() *AccessibleUI {
+	return &AccessibleUI{
+		in:      bufio.NewReader(os.Stdin),
+		out:     os.Stdout,
+		tunnels: NewTunnelManager(),
+	}
+}
+
+// Run runs the accessible front end until the user quits
+func (ui *AccessibleUI) Run() error {
+	// Debug output would be read out between prompts, so it is only kept for crash reports
+	restoreStderr, err := captureStderr(false)
+	if err == nil {
+		defer restoreStderr()
+	}
+	defer ui.tunnels.StopAll()
+
+	setSecurityKeyHandler(func(msg SecurityKeyMsg) {
+		if !msg.Done {
+			ui.println("Touch your security key to connect to %s.", msg.Host)
+		}
+	})
+	defer setSecurityKeyHandler(printSecurityKeyPrompt)
+
+	ui.println("kport - SSH Port Forwarder, accessible mode.")
+	ui.println("Loading SSH hosts...")
+
+	sshConfig := NewSSHConfig()
+	if err := sshConfig.LoadConfig(); err != nil {
+		return err
+	}
+	ui.kportConfig, err = LoadKportConfig()
+	if err != nil {
+		return err
+	}
+	setAuditConfig(ui.kportConfig.Audit)
+	setPolicy(ui.kportConfig.Policy)
+	setStrictModes(ui.kportConfig.StrictModes)
+	setFileLimit(ui.kportConfig.FileLimit)
+	ui.hosts = collectHosts(sshConfig, ui.kportConfig)
+	ui.history = LoadPortHistory()
+
+	for {
+		host, err := ui.chooseHost()
+		if err != nil {
+			return ignoreQuit(err)
+		}
+		if err := ui.forwardFromHost(host); err != nil {
+			return ignoreQuit(err)
+		}
+	}
+}
+
+// ignoreQuit treats quitting as a clean exit
+func ignoreQuit(err error) error {
+	if errors.Is(err, errQuit) {
+		return nil
+	}
+	return err
+}
+
+// println writes a line of output
+func (ui *AccessibleUI) println(format string, args ...any) {
+	fmt.Fprintf(ui.out, format+"\n", args...)
+}
+
+// prompt asks a question and returns the trimmed answer. q quits, as does end of input.
+func (ui *AccessibleUI) prompt(question string) (string, error) {
+	fmt.Fprintf(ui.out, "%s ", question)
+	line, err := ui.in.ReadString('\n')
+	if err != nil && line == "" {
+		ui.println("")
+		return "", errQuit
+	}
+	answer := strings.TrimSpace(line)
+	if answer == "q" {
+		return "", errQuit
+	}
+	return answer, nil
+}
+
+// chooseHost lists the hosts as a numbered menu and returns the chosen one
+func (ui *AccessibleUI) chooseHost() (SSHHost, error) {
+	if len(ui.hosts) == 0 {
+		ui.println("No SSH hosts found in your SSH config.")
+		return SSHHost{}, errQuit
+	}
+
+	ui.println("")
+	ui.println("SSH hosts (%d):", len(ui.hosts))
+	for i, host := range ui.hosts {
+		description := host.Hostname
+		if user := host.EffectiveUser(); user != "" {
+			description = fmt.Sprintf("%s at %s", user, host.Hostname)
+		}
+		if host.Transport != "" {
+			description += " via " + host.Transport
+		}
+		if host.Description != "" {
+			description += ": " + host.Description
+		}
+		ui.println("%d. %s, %s", i+1, host.Name, description)
+	}
+
+	for {
+		answer, err := ui.prompt("Enter a host number or name, or q to quit:")
+		if err != nil {
+			return SSHHost{}, err
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(ui.hosts) {
+			return ui.hosts[n-1], nil
+		}
+		if host, err := findHost(ui.hosts, answer); err == nil {
+			return host, nil
+		}
+		ui.println("%q is not a host. Enter a number from 1 to %d.", answer, len(ui.hosts))
+	}
+}
+
+// forwardFromHost detects ports on host, lets the user pick one and forwards it
+func (ui *AccessibleUI) forwardFromHost(host SSHHost) error {
+	ui.println("Detecting ports on %s, please wait...", host.Name)
+	detected := DetectPorts(host)().(PortsDetectedMsg)
+	if detected.Err != nil {
+		ui.println("Error: %v", detected.Err)
+	}
+	if len(detected.Ports) > 0 {
+		ui.history.RecordDetected(host.Name, detected.Ports)
+		ui.saveHistory()
+	}
+
+	for {
+		port, err := ui.choosePort(host, detected)
+		if err != nil || port == 0 {
+			return err
+		}
+
+		forwarder, err := ui.startForwarding(host, port, detected.Addresses[port])
+		if err != nil {
+			ui.println("Error: %v", err)
+			continue
+		}
+		return ui.watchForwarding(forwarder)
+	}
+}
+
+// choosePort lists the detected ports as a numbered menu and returns the chosen
+// port, or 0 to go back to the host list
+func (ui *AccessibleUI) choosePort(host SSHHost, detected PortsDetectedMsg) (int, error) {
+	ports, processes := detected.Ports, detected.Processes
+	ui.println("")
+	if len(ports) == 0 {
+		ui.println("No open ports detected on %s.", host.Name)
+	} else {
+		ui.println("Ports detected on %s (%d):", host.Name, len(ports))
+		for i, port := range ports {
+			description := string(categorizePort(port, processes[port]))
+			if process := processes[port]; process != "" {
+				description += ", " + process
+			}
+			if address := detected.Addresses[port]; address != "" && !isLoopbackHost(address) {
+				description += ", listening on " + address
+			}
+			ui.println("%d. Port %d, %s", i+1, port, description)
+		}
+	}
+	ui.println("m. Enter a port number")
+	ui.println("b. Back to the host list")
+	last, ok := ui.history.LastForwarded(host.Name)
+	repeat := ok && slices.Contains(ports, last)
+	if repeat {
+		ui.println("Press Enter to forward port %d again, as last time.", last)
+	}
+
+	for {
+		answer, err := ui.prompt("Choice:")
+		if err != nil {
+			return 0, err
+		}
+		switch answer {
+		case "":
+			if repeat {
+				return last, nil
+			}
+		case "b":
+			return 0, nil
+		case "m":
+			return ui.manualPort(host, ports)
+		}
+		if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(ports) {
+			return ports[n-1], nil
+		}
+		ui.println("%q is not a choice. Enter a port number from the list, m or b.", answer)
+	}
+}
+
+// manualPort asks for a remote port, mentioning the ports used before on host
+func (ui *AccessibleUI) manualPort(host SSHHost, detected []int) (int, error) {
+	if suggestions := ui.history.Suggestions(host.Name, detected); len(suggestions) > 0 {
+		ui.println("Ports used before on %s:", host.Name)
+		for _, suggestion := range suggestions {
+			ui.println("Port %d, %s", suggestion.Port, suggestion.Reason)
+		}
+	}
+
+	for {
+		answer, err := ui.prompt("Remote port number, or b to go back:")
+		if err != nil {
+			return 0, err
+		}
+		if answer == "b" {
+			return 0, nil
+		}
+		if port, err := parsePort(answer); err == nil {
+			return port, nil
+		}
+		ui.println("%q is not a port number between 1 and 65535.", answer)
+	}
+}
+
+// startForwarding starts a tunnel to port on host, dialed on address from the
+// host unless it is empty, and hands it to the tunnel manager
+func (ui *AccessibleUI) startForwarding(host SSHHost, port int, address string) (*PortForwarder, error) {
+	ui.println("Starting port forwarding to %s port %d...", host.Name, port)
+
+	options := forwardOptionsFor(host, ui.kportConfig.HostFor(host), port)
+	options.RemoteHost = address
+	risks := currentPolicy().tunnelRisks(0, port, options)
+	if err := policyError(risks, true); err != nil {
+		return nil, err
+	}
+	if needsConfirmation(risks) {
+		ui.println("The kport policy asks you to confirm this tunnel:\n%s", describeRisks(risks))
+		answer, err := ui.prompt(fmt.Sprintf("Type %s to continue:", host.Name))
+		if err != nil {
+			return nil, err
+		}
+		if answer != host.Name {
+			return nil, fmt.Errorf("not confirmed, nothing was started")
+		}
+		options.Confirmed = true
+	}
+
+	switch msg := StartPortForwarding(host, port, options)().(type) {
+	case ErrorMsg:
+		return nil, msg.Error
+	case ForwardFailedMsg:
+		return nil, msg.Err
+	case ForwardingStartedMsg:
+		ui.tunnels.Adopt("", msg.Forwarder)
+		ui.history.RecordForwarded(host.Name, port)
+		ui.saveHistory()
+
+		ui.println("Forwarding active: localhost port %d goes to %s port %d.", msg.LocalPort, host.Name, port)
+		if msg.LocalPort != port {
+			ui.println("Local port %d was unavailable, so port %d is used instead.", port, msg.LocalPort)
+		}
+		if options.HTTPS {
+			ui.println("Open https://localhost:%d", msg.LocalPort)
+		} else {
+			ui.println("Open http://localhost:%d or connect to localhost:%d with any client.", msg.LocalPort, msg.LocalPort)
+		}
+		if options.TTL > 0 {
+			ui.println("The tunnel closes after %s.", options.TTL)
+		}
+		return msg.Forwarder, nil
+	default:
+		return nil, fmt.Errorf("unexpected result %T", msg)
+	}
+}
+
+// watchForwarding reports on an active tunnel until the user stops it
+func (ui *AccessibleUI) watchForwarding(forwarder *PortForwarder) error {
+	defer ui.tunnels.Stop(forwarder)
+
+	for {
+		answer, err := ui.prompt("Press Enter for status, s to stop forwarding, or q to quit:")
+		if err != nil {
+			return err
+		}
+		switch answer {
+		case "":
+			ui.printStatus(forwarder)
+		case "s":
+			ui.println("Forwarding stopped.")
+			return nil
+		default:
+			ui.println("%q is not a choice.", answer)
+		}
+	}
+}
+
+// printStatus prints the state of a tunnel as plain sentences
+func (ui *AccessibleUI) printStatus(forwarder *PortForwarder) {
+	if !forwarder.IsRunning() {
+		ui.println("The tunnel is closed.")
+		return
+	}
+
+	stats := forwarder.ConnStats()
+	ui.println("The tunnel is up. Active connections: %d. Total connections: %d.", stats.Active, stats.Total)
+	if since, err := forwarder.RemoteDown(); !since.IsZero() {
+		ui.println("Warning: the remote service stopped listening %s ago, so connections fail: %v", time.Since(since).Round(time.Second), err)
+	}
+	if stats.ChannelLimit > 0 {
+		ui.println("%d of %d SSH channels are open.", stats.Channels, stats.ChannelLimit)
+	}
+	if stats.Queued > 0 {
+		ui.println("%d connections are waiting for a free channel.", stats.Queued)
+	}
+	if expiresAt := forwarder.ExpiresAt(); !expiresAt.IsZero() {
+		ui.println("It closes in %s.", formatCountdown(time.Until(expiresAt).Round(time.Second)))
+	}
+	for _, err := range forwarder.Errors() {
+		ui.println("Recent error: %s", err)
+	}
+	for _, hint := range forwarder.LocalHints() {
+		ui.println("Local clients may not reach the tunnel: %s", hint)
+	}
+}
+
+// saveHistory writes the port history, which only matters for later runs
+func (ui *AccessibleUI) saveHistory() {
+	if err := ui.history.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to save port history: %v\n", err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// AgentForwardingMsg is sent when agent forwarding starts or fails to start
+type AgentForwardingMsg struct {
+	Forwarder *AgentForwarder
+	Err       error
+}
+
+// AgentForwarder forwards the local SSH agent socket to a path on the remote host
+type AgentForwarder struct {
+	host       SSHHost
+	localSock  string
+	remotePath string
+	sshCmd     *exec.Cmd
+	isRunning  bool
+	mu         sync.Mutex
+}
+
+// NewAgentForwarder creates an agent forwarder for the agent in SSH_AUTH_SOCK
+func NewAgentForwarder(host SSHHost) (*AgentForwarder, error) {
+	localSock := os.Getenv("SSH_AUTH_SOCK")
+	if localSock == "" {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set, is the SSH agent running?")
+	}
+
+	suffix := make([]byte, 6)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate socket name: %w", err)
+	}
+
+	return &AgentForwarder{
+		host:       host,
+		localSock:  localSock,
+		remotePath: fmt.Sprintf("/tmp/kport-agent-%s.sock", hex.EncodeToString(suffix)),
+	}, nil
+}
+
+// Start starts forwarding the agent socket with an ssh streamlocal remote forward
+func (af *AgentForwarder) Start() error {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+
+	if af.isRunning {
+		return fmt.Errorf("agent forwarding already running")
+	}
+
+	options := []string{
+		"-R", fmt.Sprintf("%s:%s", af.remotePath, af.localSock),
+		"-N",
+		"-o", "StreamLocalBindUnlink=yes", // Replace stale sockets left by earlier sessions
+		"-o", "ServerAliveInterval=30",
+		"-o", "ServerAliveCountMax=3",
+	}
+	af.sshCmd = sshCommand(af.host, append(options, exitOnForwardFailure(af.host)...))
+
+	fmt.Fprintf(os.Stderr, "Debug: Starting agent forwarding: %s\n", af.sshCmd.String())
+
+	if err := af.sshCmd.Start(); err != nil {
+		return fmt.Errorf("failed to start agent forwarding: %w", err)
+	}
+	af.isRunning = true
+
+	go func() {
+		if err := af.sshCmd.Wait(); err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Agent forwarding finished with error: %v\n", err)
+		}
+	}()
+
+	return nil
+}
+
+// Stop stops agent forwarding and removes the socket on the remote host
+func (af *AgentForwarder) Stop() {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+
+	if !af.isRunning {
+		return
+	}
+	af.isRunning = false
+
+	if af.sshCmd != nil && af.sshCmd.Process != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Stopping agent forwarding\n")
+		af.sshCmd.Process.Kill()
+	}
+
+	// sshd does not always unlink remote forward sockets, so clean up explicitly
+	cleanup := sshCommand(af.host, af.host.probeOptions(3), "rm", "-f", af.remotePath)
+	started := time.Now()
+	err := cleanup.Run()
+	recordRemoteCommand(cleanup, "remove agent socket", started, err)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to remove remote agent socket: %v\n", err)
+	}
+}
+
+// Host returns the host the agent is forwarded to
+func (af *AgentForwarder) Host() SSHHost {
+	return af.host
+}
+
+// RemotePath returns the socket path on the remote host
+func (af *AgentForwarder) RemotePath() string {
+	return af.remotePath
+}
+
+// StartAgentForwarding starts forwarding the local SSH agent to host
+func StartAgentForwarding(host SSHHost) tea.Cmd {
+	return func() tea.Msg {
+		host, err := prepareHost(host)
+		if err != nil {
+			return AgentForwardingMsg{Err: err}
+		}
+
+		forwarder, err := NewAgentForwarder(host)
+		if err != nil {
+			return AgentForwardingMsg{Err: err}
+		}
+		if err := forwarder.Start(); err != nil {
+			return AgentForwardingMsg{Err: err}
+		}
+		return AgentForwardingMsg{Forwarder: forwarder}
+	}
+}
+
+// StopAgentForwarding stops agent forwarding in the background
+func StopAgentForwarding(forwarder *AgentForwarder) tea.Cmd {
+	return func() tea.Msg {
+		forwarder.Stop()
+		return nil
+	}
+}
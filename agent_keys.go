@@ -0,0 +1,340 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// AgentKey is a key held by the SSH agent
+type AgentKey struct {
+	Type        string
+	Fingerprint string
+	Comment     string
+
+	// PublicKey is the key's line as ssh-add -L prints it, which ssh matches
+	// to the agent's private key when given as an identity file
+	PublicKey string
+}
+
+// AgentKeysMsg is sent when the keys of the agent have been listed
+type AgentKeysMsg struct {
+	Keys []AgentKey
+	Err  error
+}
+
+// agentKeysMu serializes access to the remembered agent keys
+var agentKeysMu sync.Mutex
+
+// listAgentKeys lists the keys of the agent in SSH_AUTH_SOCK
+func listAgentKeys() ([]AgentKey, error) {
+	output, err := exec.Command("ssh-add", "-L").Output()
+	var exitErr *exec.ExitError
+	switch {
+	// ssh-add exits with 1 for an empty agent and 2 when there is no agent
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return nil, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 2:
+		return nil, fmt.Errorf("no SSH agent is running, start one and load your keys with `ssh-add`")
+	case err != nil:
+		return nil, fmt.Errorf("failed to list the agent's keys: %w", err)
+	}
+	return parseAgentKeys(string(output)), nil
+}
+
+// parseAgentKeys reads the "type base64 comment" lines of ssh-add -L
+func parseAgentKeys(output string) []AgentKey {
+	var keys []AgentKey
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(blob)
+		key := AgentKey{
+			Type:        fields[0],
+			Fingerprint: "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]),
+			PublicKey:   strings.TrimSpace(line),
+		}
+		if len(fields) == 3 {
+			key.Comment = fields[2]
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// ListAgentKeys lists the keys of the agent in the background
+func ListAgentKeys() tea.Cmd {
+	return func() tea.Msg {
+		keys, err := listAgentKeys()
+		return AgentKeysMsg{Keys: keys, Err: err}
+	}
+}
+
+// matches reports whether selector, a fingerprint or comment, names the key
+func (k AgentKey) matches(selector string) bool {
+	return selector == k.Fingerprint || selector == k.Comment
+}
+
+// identityFile writes the public key where ssh can be pointed at it with -i
+// and returns its path
+func (k AgentKey) identityFile() (string, error) {
+	dir, err := kportCacheDir("agent-keys")
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(k.Fingerprint))
+	path := filepath.Join(dir, hex.EncodeToString(sum[:8])+".pub")
+	if err := os.WriteFile(path, []byte(k.PublicKey+"\n"), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write the public key of %s: %w", k.Fingerprint, err)
+	}
+	return path, nil
+}
+
+// resolveAgentKey points host at the agent key picked for it, configured or
+// remembered, so ssh offers only that key. A configured key that the agent
+// doesn't hold fails, a remembered one is dropped.
+func resolveAgentKey(host SSHHost) (SSHHost, error) {
+	selector, remembered := host.AgentKey, false
+	if selector == "" {
+		selector, remembered = rememberedAgentKey(host.Name), true
+	}
+	if selector == "" {
+		return host, nil
+	}
+
+	keys, err := listAgentKeys()
+	if err != nil && !remembered {
+		return host, err
+	}
+	for _, key := range keys {
+		if key.matches(selector) {
+			path, err := key.identityFile()
+			if err != nil {
+				return host, err
+			}
+			host.AgentKeyFile = path
+			return host, nil
+		}
+	}
+	if remembered {
+		fmt.Fprintf(os.Stderr, "Debug: The agent no longer holds key %s remembered for %s\n", selector, host.Name)
+		return host, nil
+	}
+	return host, fmt.Errorf("the agent holds no key %s, set as agent_key for %s. Load it with `ssh-add`", selector, host.Name)
+}
+
+// agentKeysPath returns the file remembering the agent key of each host
+func agentKeysPath() (string, error) {
+	dir, err := kportCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "agent_keys.json"), nil
+}
+
+// loadAgentKeys reads the fingerprints remembered per host
+func loadAgentKeys() map[string]string {
+	keys := make(map[string]string)
+	path, err := agentKeysPath()
+	if err != nil {
+		return keys
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return keys
+	}
+	if err := json.Unmarshal(data, &keys); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to parse remembered agent keys: %v\n", err)
+	}
+	return keys
+}
+
+// rememberedAgentKey returns the fingerprint of the agent key that last
+// authenticated to host, or ""
+func rememberedAgentKey(hostName string) string {
+	agentKeysMu.Lock()
+	defer agentKeysMu.Unlock()
+	return loadAgentKeys()[hostName]
+}
+
+// RememberAgentKey stores the agent key to offer host from now on, or
+// forgets it for an empty fingerprint
+func RememberAgentKey(hostName, fingerprint string) tea.Cmd {
+	return func() tea.Msg {
+		agentKeysMu.Lock()
+		defer agentKeysMu.Unlock()
+
+		keys := loadAgentKeys()
+		if fingerprint == "" {
+			delete(keys, hostName)
+		} else {
+			keys[hostName] = fingerprint
+		}
+		path, err := agentKeysPath()
+		if err == nil {
+			var data []byte
+			data, err = json.MarshalIndent(keys, "", "  ")
+			if err == nil {
+				err = os.WriteFile(path, data, 0o600)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to remember the agent key of %s: %v\n", hostName, err)
+		}
+		return nil
+	}
+}
+
+// showAgentKeys opens the agent key picker for the selected host
+func (m *Model) showAgentKeys() tea.Cmd {
+	m.state = StateAgentKeys
+	m.agentKeys = nil
+	m.agentKeysErr = nil
+	m.agentKeysLoading = true
+	m.pickedAgentKey = agentKeyFor(m.hosts[m.selectedHost])
+	m.cursor = 0
+	return ListAgentKeys()
+}
+
+// updateAgentKeysListed shows the agent's keys once they are listed, with the
+// cursor on the key picked for the host
+func (m *Model) updateAgentKeysListed(msg AgentKeysMsg) (tea.Model, tea.Cmd) {
+	if m.state != StateAgentKeys {
+		return m, nil
+	}
+	m.agentKeysLoading = false
+	m.agentKeys, m.agentKeysErr = msg.Keys, msg.Err
+	for i, key := range m.agentKeys {
+		if m.pickedAgentKey != "" && key.matches(m.pickedAgentKey) {
+			m.cursor = i + 1
+		}
+	}
+	return m, nil
+}
+
+// tooManyKeys reports whether err is a server giving up on the offered keys
+func tooManyKeys(err error) bool {
+	var sshErr *SSHError
+	return errors.As(err, &sshErr) && sshErr.TooManyKeys
+}
+
+// agentKeyFor returns the agent key picked for host this session, configured
+// or remembered
+func agentKeyFor(host SSHHost) string {
+	if host.AgentKey != "" {
+		return host.AgentKey
+	}
+	return rememberedAgentKey(host.Name)
+}
+
+// updateAgentKeys handles the agent key picker. The first row offers every
+// key again, the others pick a single key and connect with it.
+func (m *Model) updateAgentKeys(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.Action(StateAgentKeys, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionUp:
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case ActionDown:
+		if m.cursor < len(m.agentKeys) {
+			m.cursor++
+		}
+	case ActionBack:
+		m.state = StateSelectHost
+		m.cursor = m.selectedHost
+		m.message = ""
+	case ActionSelect:
+		if m.agentKeysLoading || m.agentKeysErr != nil {
+			return m, nil
+		}
+		host := &m.hosts[m.selectedHost]
+		if m.cursor == 0 {
+			// The config's agent_key is kept, only the choice is undone
+			host.AgentKey = m.kportConfig.Host(host.Name).AgentKey
+			delete(m.agentKeyChoices, host.Name)
+			m.untestedAgentKey = ""
+			m.cursor = m.selectedHost
+			m.state = StateSelectHost
+			m.message = ""
+			return m, RememberAgentKey(host.Name, "")
+		}
+		// The key is remembered once it gets through, like relaxed algorithms
+		// it lasts for the session until then
+		host.AgentKey = m.agentKeys[m.cursor-1].Fingerprint
+		m.agentKeyChoices[host.Name] = host.AgentKey
+		m.untestedAgentKey = host.Name
+		m.cursor = m.selectedHost
+		return m, m.connectHost()
+	}
+	return m, nil
+}
+
+// renderAgentKeys renders the agent's keys to pick the one to offer
+func (m *Model) renderAgentKeys() string {
+	var s strings.Builder
+
+	host := m.hosts[m.selectedHost]
+	titleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Bold(true)
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	selectedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF75B7")).Bold(true)
+
+	s.WriteString(titleStyle.Render(fmt.Sprintf("Pick the agent key to offer %s", host.Name)))
+	s.WriteString("\n\n")
+	if m.message != "" {
+		s.WriteString(dimStyle.Render(m.message))
+		s.WriteString("\n\n")
+	}
+	if m.agentKeysLoading {
+		s.WriteString("Listing the agent's keys...\n")
+		return s.String()
+	}
+	if m.agentKeysErr != nil {
+		s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87")).Render(fmt.Sprintf("Error: %v", m.agentKeysErr)))
+		s.WriteString("\n")
+		return s.String()
+	}
+	if len(m.agentKeys) == 0 {
+		s.WriteString("The agent holds no keys. Load one with `ssh-add`.\n")
+		return s.String()
+	}
+
+	rows := []string{"Offer every key, as ssh does by default"}
+	for _, key := range m.agentKeys {
+		row := fmt.Sprintf("%-12s %s  %s", key.Type, key.Fingerprint, key.Comment)
+		if m.pickedAgentKey != "" && key.matches(m.pickedAgentKey) {
+			row += dimStyle.Render("  (picked)")
+		}
+		rows = append(rows, row)
+	}
+	for i, row := range rows {
+		if i == m.cursor {
+			s.WriteString(selectedStyle.Render("> " + row))
+		} else {
+			s.WriteString("  " + row)
+		}
+		s.WriteString("\n")
+	}
+	s.WriteString("\n")
+	s.WriteString(dimStyle.Render(fmt.Sprintf("Servers disconnect after a few rejected keys (MaxAuthTries, 6 by default), and the agent holds %d. The picked key is the only one offered and is remembered once it gets through.", len(m.agentKeys))))
+	s.WriteString("\n")
+	return s.String()
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// algorithmMismatchPattern matches ssh's message for a handshake where the
+// server offered nothing ssh has enabled, with the list it failed on
+var algorithmMismatchPattern = regexp.MustCompile(`no matching (host key type|key exchange method|cipher|MAC) found\. Their offer: (\S+)`)
+
+// algorithmList is one of the algorithm lists ssh negotiates, keyed by how
+// its error names it
+type algorithmList struct {
+	// Title names the list in the diagnostics panel
+	Title string
+
+	// Option is the ssh option setting the list, Query the `ssh -Q` query
+	// listing what ssh supports, and Key the setting `ssh -G` prints it as
+	Option string
+	Query  string
+	Key    string
+}
+
+var algorithmLists = map[string]algorithmList{
+	"host key type":       {"Host key algorithms", "HostKeyAlgorithms", "key-sig", "hostkeyalgorithms"},
+	"key exchange method": {"Key exchange methods", "KexAlgorithms", "kex", "kexalgorithms"},
+	"cipher":              {"Ciphers", "Ciphers", "cipher", "ciphers"},
+	"MAC":                 {"MACs", "MACs", "mac", "macs"},
+}
+
+// AlgorithmMismatch is a handshake that failed because the server offered no
+// algorithm of a list that ssh has enabled
+type AlgorithmMismatch struct {
+	List    algorithmList
+	Offered []string
+}
+
+// parseAlgorithmMismatch reads an algorithm mismatch from a line ssh printed,
+// returning nil when the line is about something else
+func parseAlgorithmMismatch(line string) *AlgorithmMismatch {
+	match := algorithmMismatchPattern.FindStringSubmatch(line)
+	if match == nil {
+		return nil
+	}
+	return &AlgorithmMismatch{List: algorithmLists[match[1]], Offered: strings.Split(match[2], ",")}
+}
+
+// algorithmMismatchOf returns the algorithm mismatch an error was caused by, or nil
+func algorithmMismatchOf(err error) *AlgorithmMismatch {
+	var sshErr *SSHError
+	if errors.As(err, &sshErr) {
+		return sshErr.Mismatch
+	}
+	return nil
+}
+
+// AlgorithmReport compares what a server offered with what the local ssh
+// proposed and supports
+type AlgorithmReport struct {
+	Mismatch AlgorithmMismatch
+
+	// Proposed are the algorithms of the list ssh proposed to the server
+	Proposed []string
+
+	// Enableable are the offered algorithms ssh supports but didn't propose,
+	// so enabling them would let the handshake through
+	Enableable []string
+}
+
+// support describes how the local ssh stands to an algorithm the server offered
+func (r AlgorithmReport) support(name string) string {
+	switch {
+	case slices.Contains(r.Proposed, name):
+		return "proposed"
+	case slices.Contains(r.Enableable, name):
+		return "supported, disabled by default"
+	default:
+		return "not supported by this ssh"
+	}
+}
+
+// relaxedOptions returns the ssh options of the legacy preset with the
+// enableable algorithms added, for retrying the handshake
+func (r AlgorithmReport) relaxedOptions() []string {
+	config := AlgorithmsConfig{Preset: AlgorithmPresetLegacy}
+	added := "+" + strings.Join(r.Enableable, ",")
+	switch r.Mismatch.List.Option {
+	case "HostKeyAlgorithms":
+		config.HostKeyAlgorithms = added
+	case "KexAlgorithms":
+		config.KexAlgorithms = added
+	case "Ciphers":
+		config.Ciphers = added
+	case "MACs":
+		config.MACs = added
+	}
+	return config.options()
+}
+
+// AlgorithmsDiagnosedMsg is sent when a failed handshake has been compared with the local ssh
+type AlgorithmsDiagnosedMsg struct {
+	Host   string
+	Report AlgorithmReport
+	Err    error
+}
+
+// DiagnoseAlgorithms compares the algorithms a host offered with the ones ssh
+// proposes for it, as `ssh -G` resolves them, and the ones `ssh -Q` supports
+func DiagnoseAlgorithms(host SSHHost, mismatch AlgorithmMismatch) tea.Cmd {
+	return func() tea.Msg {
+		report := AlgorithmReport{Mismatch: mismatch}
+		resolved, err := sshResolvedConfig(host)
+		if err != nil {
+			return AlgorithmsDiagnosedMsg{Host: host.Name, Report: report, Err: err}
+		}
+		if values := resolved[mismatch.List.Key]; len(values) > 0 {
+			report.Proposed = strings.Split(values[0], ",")
+		}
+		for _, name := range mismatch.Offered {
+			if !slices.Contains(report.Proposed, name) && sshSupports(mismatch.List.Query, name) {
+				report.Enableable = append(report.Enableable, name)
+			}
+		}
+		return AlgorithmsDiagnosedMsg{Host: host.Name, Report: report}
+	}
+}
+
+// showAlgorithms opens the diagnostics panel for a handshake that failed on
+// an algorithm mismatch
+func (m *Model) showAlgorithms(mismatch AlgorithmMismatch) tea.Cmd {
+	m.state = StateAlgorithms
+	m.algorithmReport = AlgorithmReport{Mismatch: mismatch}
+	m.algorithmsLoading = true
+	m.algorithmsErr = nil
+	return DiagnoseAlgorithms(m.hosts[m.selectedHost], mismatch)
+}
+
+// updateAlgorithmsDiagnosed shows the comparison once it is ready
+func (m *Model) updateAlgorithmsDiagnosed(msg AlgorithmsDiagnosedMsg) (tea.Model, tea.Cmd) {
+	if msg.Host != m.hostNameAt(m.selectedHost) || m.state != StateAlgorithms {
+		return m, nil
+	}
+	m.algorithmsLoading = false
+	m.algorithmReport, m.algorithmsErr = msg.Report, msg.Err
+	return m, nil
+}
+
+// updateAlgorithms handles the algorithm diagnostics panel
+func (m *Model) updateAlgorithms(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.Action(StateAlgorithms, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionBack:
+		m.state = StateSelectHost
+		m.cursor = m.selectedHost
+		m.message = ""
+	case ActionSelect:
+		if m.algorithmsLoading || len(m.algorithmReport.Enableable) == 0 {
+			return m, nil
+		}
+		// The relaxed algorithms last for the session, like a user chosen at connect time
+		host := &m.hosts[m.selectedHost]
+		host.AlgorithmOptions = m.algorithmReport.relaxedOptions()
+		m.relaxedAlgorithms[host.Name] = host.AlgorithmOptions
+		m.cursor = m.selectedHost
+		return m, m.connectHost()
+	}
+	return m, nil
+}
+
+// renderAlgorithms renders what the server offered next to what ssh does with it
+func (m *Model) renderAlgorithms() string {
+	var s strings.Builder
+
+	host := m.hosts[m.selectedHost]
+	report := m.algorithmReport
+	titleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Bold(true)
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+
+	s.WriteString(titleStyle.Render(fmt.Sprintf("ssh couldn't agree on %s with %s", strings.ToLower(report.Mismatch.List.Title), host.Name)))
+	s.WriteString("\n\n")
+	if m.message != "" {
+		s.WriteString(dimStyle.Render(m.message))
+		s.WriteString("\n\n")
+	}
+	if m.algorithmsLoading {
+		s.WriteString("Comparing with the local ssh...\n")
+		return s.String()
+	}
+	if m.algorithmsErr != nil {
+		s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87")).Render(fmt.Sprintf("Error: %v", m.algorithmsErr)))
+		s.WriteString("\n")
+		return s.String()
+	}
+
+	width := len("Server offers")
+	for _, name := range report.Mismatch.Offered {
+		width = max(width, len(name))
+	}
+	s.WriteString(fmt.Sprintf("  %-*s  %s\n", width, "Server offers", "This ssh"))
+	for _, name := range report.Mismatch.Offered {
+		support := report.support(name)
+		style := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+		if slices.Contains(report.Enableable, name) {
+			style = style.Foreground(lipgloss.Color("#FFA500"))
+		}
+		s.WriteString(fmt.Sprintf("  %-*s  %s\n", width, name, style.Render(support)))
+	}
+	s.WriteString("\n")
+	s.WriteString(dimStyle.Render(fmt.Sprintf("ssh proposed: %s", strings.Join(report.Proposed, ", "))))
+	s.WriteString("\n\n")
+
+	if len(report.Enableable) == 0 {
+		s.WriteString(fmt.Sprintf("This ssh supports none of the %s %s offers, so it can't connect until one of them is enabled on the server.\n",
+			strings.ToLower(report.Mismatch.List.Title), host.Name))
+		return s.String()
+	}
+	s.WriteString(fmt.Sprintf("Press %s to retry with %s and the legacy preset enabled for %s until kport exits.\n",
+		m.keys.Label(ActionSelect), strings.Join(report.Enableable, ", "), host.Name))
+	return s.String()
+}
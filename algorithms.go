@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// AlgorithmPresetLegacy enables the algorithms that old network gear needs
+// and OpenSSH disables by default
+const AlgorithmPresetLegacy = "legacy"
+
+// legacyAlgorithms are the algorithms the legacy preset adds to ssh's defaults,
+// keyed by ssh option and by the `ssh -Q` query listing what ssh supports
+var legacyAlgorithms = []struct {
+	option string
+	query  string
+	names  []string
+}{
+	{"KexAlgorithms", "kex", []string{"diffie-hellman-group14-sha1", "diffie-hellman-group-exchange-sha1", "diffie-hellman-group1-sha1"}},
+	{"HostKeyAlgorithms", "key-sig", []string{"ssh-rsa", "ssh-dss"}},
+	{"PubkeyAcceptedAlgorithms", "key-sig", []string{"ssh-rsa"}},
+	{"Ciphers", "cipher", []string{"aes128-cbc", "aes256-cbc", "3des-cbc"}},
+	{"MACs", "mac", []string{"hmac-sha1", "hmac-md5"}},
+}
+
+// AlgorithmsConfig sets the algorithms ssh negotiates with a host. Each list
+// uses ssh's syntax, so "+name" adds to the defaults and "-name" removes.
+type AlgorithmsConfig struct {
+	// Preset is "legacy" to add the algorithms old gear typically needs, such
+	// as ssh-rsa host keys and SHA-1 key exchange. The lists below win over it.
+	Preset string `yaml:"preset"`
+
+	Ciphers                  string `yaml:"ciphers"`
+	KexAlgorithms            string `yaml:"kex_algorithms"`
+	HostKeyAlgorithms        string `yaml:"host_key_algorithms"`
+	PubkeyAcceptedAlgorithms string `yaml:"pubkey_accepted_algorithms"`
+	MACs                     string `yaml:"macs"`
+}
+
+// validate reports an unknown preset
+func (c AlgorithmsConfig) validate() error {
+	if c.Preset != "" && c.Preset != AlgorithmPresetLegacy {
+		return fmt.Errorf("unknown algorithms preset %q, expected %q", c.Preset, AlgorithmPresetLegacy)
+	}
+	return nil
+}
+
+// options returns the ssh options that set the configured algorithms. Passed
+// before the host name, they take precedence over the SSH config.
+func (c AlgorithmsConfig) options() []string {
+	explicit := map[string]string{
+		"Ciphers":                  c.Ciphers,
+		"KexAlgorithms":            c.KexAlgorithms,
+		"HostKeyAlgorithms":        c.HostKeyAlgorithms,
+		"PubkeyAcceptedAlgorithms": c.PubkeyAcceptedAlgorithms,
+		"MACs":                     c.MACs,
+	}
+
+	options := make([]string, 0)
+	for _, legacy := range legacyAlgorithms {
+		value := explicit[legacy.option]
+		if value == "" && c.Preset == AlgorithmPresetLegacy {
+			// ssh refuses an option naming an algorithm it was built without
+			names := slices.DeleteFunc(slices.Clone(legacy.names), func(name string) bool {
+				return !sshSupports(legacy.query, name)
+			})
+			if len(names) > 0 {
+				value = "+" + strings.Join(names, ",")
+			}
+		}
+		if value != "" {
+			options = append(options, "-o", legacy.option+"="+value)
+		}
+	}
+	return options
+}
+
+// describe summarizes the settings for the host information panel
+func (c AlgorithmsConfig) describe() string {
+	parts := make([]string, 0)
+	if c.Preset != "" {
+		parts = append(parts, c.Preset+" preset")
+	}
+	for _, option := range [][2]string{
+		{"Ciphers", c.Ciphers},
+		{"KexAlgorithms", c.KexAlgorithms},
+		{"HostKeyAlgorithms", c.HostKeyAlgorithms},
+		{"PubkeyAcceptedAlgorithms", c.PubkeyAcceptedAlgorithms},
+		{"MACs", c.MACs},
+	} {
+		if option[1] != "" {
+			parts = append(parts, option[0]+" "+option[1])
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+var (
+	sshAlgorithmsOnce sync.Once
+	sshAlgorithms     map[string][]string
+)
+
+// sshSupports reports whether the local ssh knows an algorithm, as listed by
+// `ssh -Q query`. When ssh can't be asked, every algorithm is assumed known.
+func sshSupports(query, name string) bool {
+	sshAlgorithmsOnce.Do(func() {
+		sshAlgorithms = make(map[string][]string)
+		for _, query := range []string{"kex", "key-sig", "cipher", "mac"} {
+			output, err := exec.Command("ssh", "-Q", query).Output()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Debug: Failed to list ssh %s algorithms: %v\n", query, err)
+				continue
+			}
+			sshAlgorithms[query] = strings.Fields(string(output))
+		}
+	})
+
+	supported, ok := sshAlgorithms[query]
+	return !ok || slices.Contains(supported, name)
+}
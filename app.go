@@ -1,7 +1,9 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -20,13 +22,29 @@ func NewApp() *App {
 
 // Run starts the application
 func (a *App) Run() error {
+	// Keep recent debug output around for crash reports
+	restoreStderr, err := captureStderr(true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: %v\n", err)
+	} else {
+		defer restoreStderr()
+	}
+
 	// Create the Bubble Tea program
-	p := tea.NewProgram(a.model, tea.WithAltScreen())
+	p := tea.NewProgram(crashGuard{model: a.model}, tea.WithAltScreen())
 	
-	// Run the program
-	if _, err := p.Run(); err != nil {
+	// Run the program and tear down tunnels however it exits
+	_, err = p.Run()
+	a.model.Cleanup()
+	if errors.Is(err, tea.ErrProgramPanic) && lastCrashReport != "" {
+		return fmt.Errorf("kport crashed, a crash report was written to %s: %w", lastCrashReport, err)
+	}
+	if err != nil {
 		return fmt.Errorf("failed to run TUI application: %w", err)
 	}
+	if notice := a.model.ExitNotice(); notice != "" {
+		fmt.Print(notice)
+	}
 	
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// auditMACField starts the MAC at the end of a chained audit log line
+const auditMACField = `,"mac":"`
+
+// AuditConfig configures the append-only audit log of tunnels opening and closing
+type AuditConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Path is the log file, defaulting to audit.log in kport's cache directory
+	Path string `yaml:"path"`
+
+	// KeyFile holds the secret that chains the entries with HMAC-SHA256, so an
+	// altered, reordered or removed entry is detected
+	KeyFile string `yaml:"key_file"`
+}
+
+// AuditEntry is a line of the audit log
+type AuditEntry struct {
+	Seq        int64     `json:"seq"`
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	User       string    `json:"user"`
+	Host       string    `json:"host"`
+	Profile    string    `json:"profile,omitempty"`
+	RemoteUser string    `json:"remote_user,omitempty"`
+	LocalPort  int       `json:"local_port"`
+	RemotePort int       `json:"remote_port"`
+	Duration   float64   `json:"duration_seconds,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	PID        int       `json:"pid"`
+
+	// Prev is the MAC of the entry before, chaining the entries together
+	Prev string `json:"prev,omitempty"`
+	MAC  string `json:"mac,omitempty"`
+}
+
+var (
+	auditMu     sync.Mutex
+	auditConfig AuditConfig
+)
+
+// setAuditConfig applies the audit settings of a freshly loaded kport config
+func setAuditConfig(config AuditConfig) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	auditConfig = config
+}
+
+// path returns the audit log file
+func (c AuditConfig) path() (string, error) {
+	if c.Path != "" {
+		return expandShellVars(c.Path), nil
+	}
+	dir, err := kportCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "audit.log"), nil
+}
+
+// key reads the HMAC key, or returns nil when the entries aren't chained
+func (c AuditConfig) key() ([]byte, error) {
+	if c.KeyFile == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(expandShellVars(c.KeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit key: %w", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("audit key file %s is empty", c.KeyFile)
+	}
+	return key, nil
+}
+
+// auditTunnel appends an entry about a tunnel opening or closing to the audit
+// log when it is enabled. A closing tunnel gives how long it was open.
+func auditTunnel(event string, host SSHHost, localPort, remotePort int, duration time.Duration, reason string) {
+	auditMu.Lock()
+	config := auditConfig
+	auditMu.Unlock()
+	if !config.Enabled {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:       time.Now().UTC(),
+		Event:      event,
+		User:       localUser(),
+		Host:       host.Name,
+		RemoteUser: host.EffectiveUser(),
+		LocalPort:  localPort,
+		RemotePort: remotePort,
+		Duration:   duration.Round(time.Second).Seconds(),
+		Reason:     reason,
+		PID:        os.Getpid(),
+	}
+	if err := appendAuditEntry(config, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to write audit log: %v\n", err)
+	}
+}
+
+// auditWebhook appends an entry about a webhook call bringing up or tearing
+// down a profile to the audit log when it is enabled
+func auditWebhook(event, profile string, duration time.Duration, reason string) {
+	auditMu.Lock()
+	config := auditConfig
+	auditMu.Unlock()
+	if !config.Enabled {
+		return
+	}
+
+	entry := AuditEntry{
+		Time:     time.Now().UTC(),
+		Event:    event,
+		User:     localUser(),
+		Profile:  profile,
+		Duration: duration.Round(time.Second).Seconds(),
+		Reason:   reason,
+		PID:      os.Getpid(),
+	}
+	if err := appendAuditEntry(config, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to write audit log: %v\n", err)
+	}
+}
+
+// localUser returns the name of the user running kport
+func localUser() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// appendAuditEntry numbers entry after the last one in the log, chains it
+// to that entry when a key is configured and appends it
+func appendAuditEntry(config AuditConfig, entry AuditEntry) error {
+	path, err := config.path()
+	if err != nil {
+		return err
+	}
+	// An entry missing its MAC shows up when the log is verified
+	key, keyErr := config.key()
+	if keyErr != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Writing audit entry without a MAC: %v\n", keyErr)
+	}
+
+	// kport instances and the daemon append to the same log
+	unlock, err := lockFile(path+".lock", "audit log")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	last, err := lastAuditLine(path)
+	if err != nil {
+		return err
+	}
+	entry.Seq = 1
+	if last != nil {
+		var previous AuditEntry
+		if err := json.Unmarshal(last, &previous); err != nil {
+			return fmt.Errorf("failed to parse the last audit entry: %w", err)
+		}
+		entry.Seq = previous.Seq + 1
+		entry.Prev = previous.MAC
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		line = signAuditLine(line, key)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// signAuditLine adds the MAC of an entry's JSON, which already names the MAC
+// of the entry before, as its last field
+func signAuditLine(line, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(line)
+	signed := append([]byte{}, line[:len(line)-1]...)
+	signed = append(signed, auditMACField...)
+	signed = append(signed, hex.EncodeToString(mac.Sum(nil))...)
+	return append(signed, `"}`...)
+}
+
+// maxAuditEntry is the longest audit log line that can be read back
+const maxAuditEntry = 1024 * 1024
+
+// lastAuditLine returns the last line of the audit log, or nil when it is empty
+func lastAuditLine(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read backwards a chunk at a time until the newline before the last entry
+	var tail []byte
+	for offset := info.Size(); offset > 0; {
+		start := max(0, offset-16*1024)
+		chunk := make([]byte, offset-start)
+		if _, err := file.ReadAt(chunk, start); err != nil && err != io.EOF {
+			return nil, err
+		}
+		tail = append(chunk, tail...)
+		offset = start
+
+		if line := bytes.TrimRight(tail, "\n"); bytes.IndexByte(line, '\n') >= 0 {
+			return line[bytes.LastIndexByte(line, '\n')+1:], nil
+		}
+	}
+
+	tail = bytes.TrimRight(tail, "\n")
+	if len(tail) == 0 {
+		return nil, nil
+	}
+	return tail, nil
+}
+
+// readAuditLog returns the raw lines of the audit log with their entries
+func readAuditLog(path string) ([][]byte, []AuditEntry, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer file.Close()
+
+	lines := make([][]byte, 0)
+	entries := make([]AuditEntry, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxAuditEntry)
+	for n := 1; scanner.Scan(); n++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, nil, fmt.Errorf("line %d of %s is not an audit entry: %w", n, path, err)
+		}
+		lines = append(lines, append([]byte{}, line...))
+		entries = append(entries, entry)
+	}
+	return lines, entries, scanner.Err()
+}
+
+// verifyAuditLog checks that the entries are numbered without gaps and, with a
+// key, that each MAC matches its entry and chains to the entry before
+func verifyAuditLog(lines [][]byte, entries []AuditEntry, key []byte) error {
+	prev := ""
+	for i, entry := range entries {
+		if i == 0 && entry.Seq != 1 {
+			return fmt.Errorf("the log starts at entry %d, earlier entries were removed", entry.Seq)
+		}
+		if i > 0 && entry.Seq != entries[i-1].Seq+1 {
+			return fmt.Errorf("entry %d follows entry %d", entry.Seq, entries[i-1].Seq)
+		}
+		if key == nil {
+			continue
+		}
+
+		if entry.MAC == "" {
+			return fmt.Errorf("entry %d has no MAC", entry.Seq)
+		}
+		if entry.Prev != prev {
+			return fmt.Errorf("entry %d doesn't chain to the entry before it", entry.Seq)
+		}
+		line := lines[i]
+		at := bytes.LastIndex(line, []byte(auditMACField))
+		if at < 0 || !bytes.Equal(signAuditLine(append(line[:at:at], '}'), key), line) {
+			return fmt.Errorf("entry %d was altered or signed with another key", entry.Seq)
+		}
+		prev = entry.MAC
+	}
+	return nil
+}
+
+// formatAuditCSV renders audit entries as CSV
+func formatAuditCSV(entries []AuditEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"seq", "time", "event", "user", "host", "profile", "remote_user", "local_port", "remote_port", "duration_seconds", "reason", "pid", "mac"})
+	for _, entry := range entries {
+		w.Write([]string{
+			strconv.FormatInt(entry.Seq, 10), entry.Time.Format(time.RFC3339), entry.Event,
+			entry.User, entry.Host, entry.Profile, entry.RemoteUser,
+			strconv.Itoa(entry.LocalPort), strconv.Itoa(entry.RemotePort),
+			strconv.FormatFloat(entry.Duration, 'f', -1, 64), entry.Reason,
+			strconv.Itoa(entry.PID), entry.MAC,
+		})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// preConnectTimeout bounds how long a pre-connect hook may run, leaving room for SSO logins
+const preConnectTimeout = 2 * time.Minute
+
+// HookCredentials are the credentials minted by a pre-connect hook
+type HookCredentials struct {
+	Identity    string
+	Certificate string
+	User        string
+	Expires     time.Time
+}
+
+// hookCache remembers credentials that are still valid so hooks don't run on every connection
+var hookCache = struct {
+	sync.Mutex
+	entries map[string]HookCredentials
+}{entries: make(map[string]HookCredentials)}
+
+// prepareHost checks the modes of the files ssh reads for host, runs the
+// host's pre-connect hook, if any, and returns a copy of host that passes the
+// minted credentials, the address that answered and the agent key picked for
+// it to ssh
+func prepareHost(host SSHHost) (SSHHost, error) {
+	if err := checkStrictModes(host); err != nil {
+		return host, err
+	}
+	host, err := resolveConnection(host)
+	if err != nil {
+		return host, err
+	}
+	if host.PreConnect == "" {
+		return host, nil
+	}
+
+	hookCache.Lock()
+	creds, ok := hookCache.entries[host.Name]
+	hookCache.Unlock()
+
+	if !ok || !time.Now().Before(creds.Expires) {
+		var err error
+		creds, err = runPreConnectHook(host)
+		if err != nil {
+			return host, err
+		}
+
+		hookCache.Lock()
+		hookCache.entries[host.Name] = creds
+		hookCache.Unlock()
+	}
+
+	host.HookIdentity = creds.Identity
+	host.HookCertificate = creds.Certificate
+	host.HookUser = creds.User
+	return host, nil
+}
+
+// resolveConnection returns a copy of host that ssh reaches on the candidate
+// address that answered, offering the agent key picked for it
+func resolveConnection(host SSHHost) (SSHHost, error) {
+	host, err := resolveAddress(host)
+	if err != nil {
+		return host, err
+	}
+	return resolveAgentKey(host)
+}
+
+// runPreConnectHook runs the hook command and parses the credentials it prints
+func runPreConnectHook(host SSHHost) (HookCredentials, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), preConnectTimeout)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "Debug: Running pre-connect hook for %s: %s\n", host.Name, host.PreConnect)
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", host.PreConnect)
+	cmd.Env = append(os.Environ(),
+		"KPORT_HOST="+host.Name,
+		"KPORT_HOSTNAME="+host.Hostname,
+		"KPORT_USER="+host.EffectiveUser(),
+		"KPORT_PORT="+host.Port,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			return HookCredentials{}, fmt.Errorf("pre-connect hook for %s failed: %w", host.Name, err)
+		}
+		return HookCredentials{}, fmt.Errorf("pre-connect hook for %s failed: %w: %s", host.Name, err, message)
+	}
+
+	return parseHookOutput(output)
+}
+
+// parseHookOutput parses KEY=VALUE lines printed by a pre-connect hook.
+// A hook that prints only a single path is treated as printing the identity file.
+func parseHookOutput(output []byte) (HookCredentials, error) {
+	var creds HookCredentials
+	var lines []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			continue
+		}
+		value = expandShellVars(strings.TrimSpace(value))
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "identityfile":
+			creds.Identity = value
+		case "certificatefile":
+			creds.Certificate = value
+		case "user":
+			creds.User = value
+		case "validfor":
+			validFor, err := time.ParseDuration(value)
+			if err != nil {
+				return creds, fmt.Errorf("invalid ValidFor in pre-connect hook output: %w", err)
+			}
+			creds.Expires = time.Now().Add(validFor)
+		case "expires":
+			expires, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return creds, fmt.Errorf("invalid Expires in pre-connect hook output: %w", err)
+			}
+			creds.Expires = expires
+		}
+	}
+
+	if len(lines) == 1 && !strings.Contains(lines[0], "=") {
+		path := expandShellVars(lines[0])
+		if _, err := os.Stat(path); err == nil {
+			creds.Identity = path
+		}
+	}
+
+	return creds, nil
+}
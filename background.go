@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// backgroundProfile is the daemon profile that tunnels moved out of the TUI run under
+const backgroundProfile = "background"
+
+// HandoffTunnel is a tunnel the TUI hands to the daemon, which opens it again
+// on the same local port once the TUI has closed it
+type HandoffTunnel struct {
+	Host       string         `json:"host"`
+	User       string         `json:"user,omitempty"`
+	LocalPort  int            `json:"local_port"`
+	RemotePort int            `json:"remote_port"`
+	Options    ForwardOptions `json:"options"`
+	Label      string         `json:"label,omitempty"`
+	Note       string         `json:"note,omitempty"`
+}
+
+// BackgroundedMsg is sent when the daemon has taken over the TUI's tunnels,
+// or couldn't, in which case Restore holds the tunnels to start again
+type BackgroundedMsg struct {
+	Tunnels []TunnelStatus
+	Restore []ForwardFailedMsg
+	Err     error
+}
+
+// handoffFor describes a running tunnel for the daemon. A time limit carries
+// over as the time that is left of it.
+func handoffFor(pf *PortForwarder) HandoffTunnel {
+	options := pf.Options()
+	if expiresAt := pf.ExpiresAt(); !expiresAt.IsZero() {
+		options.TTL = max(time.Until(expiresAt), time.Second)
+	}
+	label, note := pf.Label()
+	return HandoffTunnel{
+		Host:       pf.Host().Name,
+		User:       pf.Host().UserOverride,
+		LocalPort:  pf.LocalPort(),
+		RemotePort: pf.RemotePort(),
+		Options:    options,
+		Label:      label,
+		Note:       note,
+	}
+}
+
+// handOff asks the daemon, started if needed, to open tunnels the TUI has
+// just closed. restore describes the same tunnels for starting them again in
+// the TUI when the daemon can't take them.
+func handOff(handoffs []HandoffTunnel, restore []ForwardFailedMsg) tea.Cmd {
+	return func() tea.Msg {
+		resp, err := callDaemon(DaemonRequest{Command: "handoff", Handoff: handoffs}, true)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to move tunnels to the daemon: %v\n", err)
+			err = fmt.Errorf("failed to move tunnels to the daemon: %w", err)
+			for i := range restore {
+				restore[i].Err = err
+			}
+			return BackgroundedMsg{Restore: restore, Err: err}
+		}
+		return BackgroundedMsg{Tunnels: resp.Tunnels}
+	}
+}
+
+// backgroundNotice tells the user where their tunnels went once the TUI has exited
+func backgroundNotice(tunnels []TunnelStatus) string {
+	var s strings.Builder
+	s.WriteString(fmt.Sprintf("✅ Moved %d tunnels to the kport daemon, they keep running after this terminal closes:\n", len(tunnels)))
+	for _, t := range tunnels {
+		s.WriteString(fmt.Sprintf("   %s %s -> %s:%d\n", t.ID, t.LocalAddress(), t.Host, t.RemotePort))
+	}
+	s.WriteString("   kport status             show them\n")
+	s.WriteString("   kport stop <id>          close one\n")
+	s.WriteString(fmt.Sprintf("   kport down %s    close them\n", backgroundProfile))
+	return s.String()
+}
+
+// moveToBackground closes the TUI's tunnels and hands them to the daemon.
+// The TUI quits once the daemon has them.
+func (m *Model) moveToBackground() tea.Cmd {
+	tunnels := m.tunnels.Tunnels()
+	if len(tunnels) == 0 {
+		return nil
+	}
+
+	handoffs := make([]HandoffTunnel, 0, len(tunnels))
+	restore := make([]ForwardFailedMsg, 0, len(tunnels))
+	for _, tunnel := range tunnels {
+		pf := tunnel.Forwarder
+		handoffs = append(handoffs, handoffFor(pf))
+		restore = append(restore, ForwardFailedMsg{Host: pf.Host(), RemotePort: pf.RemotePort(), Options: pf.Options()})
+	}
+
+	// The daemon listens on the same local ports, so the tunnels close first
+	m.tunnels.StopAll()
+	m.forwarder = nil
+	if m.share != nil {
+		m.share.Stop()
+		m.share = nil
+	}
+	m.shareStatus = ""
+	m.state = StateSelectHost
+	m.message = fmt.Sprintf("Moving %d tunnels to the kport daemon...", len(handoffs))
+	return handOff(handoffs, restore)
+}
+
+// updateBackgrounded quits once the daemon has the tunnels, or starts them
+// again in the TUI through the retry queue when it doesn't
+func (m *Model) updateBackgrounded(msg BackgroundedMsg) (tea.Model, tea.Cmd) {
+	if msg.Err == nil {
+		m.exitNotice = backgroundNotice(msg.Tunnels)
+		return m, tea.Quit
+	}
+
+	now := time.Now()
+	for _, tunnel := range msg.Restore {
+		m.retries.Park(tunnel, now)
+	}
+	m.retries.RetryNow(now)
+	m.message = ""
+	return m, tea.Batch(m.startRetryTick(), m.retryDue(), m.notify(toastError, fmt.Sprintf("%v (starting the tunnels here again)", msg.Err)))
+}
+
+// ExitNotice is printed after the TUI exits, such as where its tunnels went
+func (m *Model) ExitNotice() string {
+	return m.exitNotice
+}
+
+// handoff opens the tunnels handed over by a TUI under the background
+// profile. If one of them fails, the ones already opened are closed again so
+// the TUI can take all of them back.
+func (d *Daemon) handoff(handoffs []HandoffTunnel) ([]*Tunnel, error) {
+	sshConfig := NewSSHConfig()
+	if err := sshConfig.LoadConfig(); err != nil {
+		return nil, err
+	}
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		return nil, err
+	}
+	setAuditConfig(kportConfig.Audit)
+	setPolicy(kportConfig.Policy)
+	setStrictModes(kportConfig.StrictModes)
+	setFileLimit(kportConfig.FileLimit)
+	hosts := collectHosts(sshConfig, kportConfig)
+
+	started := make([]*Tunnel, 0, len(handoffs))
+	for _, handoff := range handoffs {
+		forwarder, err := startHandoff(handoff, hosts, kportConfig)
+		if err != nil {
+			for _, tunnel := range started {
+				d.manager.Stop(tunnel.Forwarder)
+			}
+			return nil, fmt.Errorf("localhost:%d -> %s:%d: %w", handoff.LocalPort, handoff.Host, handoff.RemotePort, err)
+		}
+		started = append(started, d.manager.Adopt(backgroundProfile, forwarder))
+	}
+	return started, nil
+}
+
+// startHandoff opens a handed over tunnel on its original local port
+func startHandoff(handoff HandoffTunnel, hosts []SSHHost, kportConfig *KportConfig) (*PortForwarder, error) {
+	host, err := resolveHost(handoff.Host, hosts, kportConfig)
+	if err != nil {
+		return nil, err
+	}
+	host.UserOverride = handoff.User
+
+	// The TUI already asked for any confirmation, but the daemon's policy may deny
+	if err := policyError(currentPolicy().tunnelRisks(handoff.LocalPort, handoff.RemotePort, handoff.Options), true); err != nil {
+		return nil, err
+	}
+
+	if handoff.Options.LocalSocket == "" {
+		if err := reserveExactLocalPort(handoff.LocalPort, host.Name, handoff.RemotePort); err != nil {
+			return nil, err
+		}
+	}
+	host, err = tracedPrepareHost(context.Background(), host)
+	if err != nil {
+		releaseLocalPort(handoff.LocalPort)
+		return nil, err
+	}
+
+	forwarder := NewPortForwarder(host, handoff.LocalPort, handoff.RemotePort, handoff.Options)
+	if err := forwarder.Start(); err != nil {
+		releaseLocalPort(handoff.LocalPort)
+		return nil, err
+	}
+	forwarder.SetLabel(handoff.Label, handoff.Note)
+	return forwarder, nil
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CaptureMode selects how traffic flowing through a tunnel is inspected
+type CaptureMode int
+
+const (
+	CaptureOff CaptureMode = iota
+	CaptureHTTP
+	CapturePcap
+)
+
+// maxRecentRequests is how many HTTP request summaries are kept for the TUI
+const maxRecentRequests = 5
+
+// String returns a human readable name for the capture mode
+func (c CaptureMode) String() string {
+	switch c {
+	case CaptureHTTP:
+		return "HTTP"
+	case CapturePcap:
+		return "pcap"
+	default:
+		return "off"
+	}
+}
+
+// Next returns the mode that follows c when cycling through modes
+func (c CaptureMode) Next() CaptureMode {
+	return (c + 1) % 3
+}
+
+// Capture records the traffic of a single tunnel to a file
+type Capture struct {
+	mode     CaptureMode
+	path     string
+	file     *os.File
+	mu       sync.Mutex
+	closed   bool
+	recent   []string
+	nextPort uint16
+}
+
+// NewCapture creates a capture file for the given tunnel in the kport cache directory
+func NewCapture(mode CaptureMode, hostName string, remotePort int) (*Capture, error) {
+	dir, err := kportCacheDir("captures")
+	if err != nil {
+		return nil, err
+	}
+
+	ext := "log"
+	if mode == CapturePcap {
+		ext = "pcap"
+	}
+	name := fmt.Sprintf("%s-%d-%s.%s", hostName, remotePort, time.Now().Format("20060102-150405"), ext)
+	path := filepath.Join(dir, name)
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create capture file: %w", err)
+	}
+
+	c := &Capture{
+		mode:     mode,
+		path:     path,
+		file:     file,
+		nextPort: 40000,
+	}
+
+	if mode == CapturePcap {
+		if err := c.writePcapHeader(); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write pcap header: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+// Mode returns the capture mode
+func (c *Capture) Mode() CaptureMode {
+	return c.mode
+}
+
+// Path returns the path of the capture file
+func (c *Capture) Path() string {
+	return c.path
+}
+
+// Recent returns summaries of the most recently captured HTTP requests
+func (c *Capture) Recent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.recent...)
+}
+
+// Close stops capturing and closes the capture file
+func (c *Capture) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.file.Close()
+}
+
+// OpenConn starts recording a proxied connection
+func (c *Capture) OpenConn(clientAddr net.Addr, localPort int) *CaptureConn {
+	cc := &CaptureConn{
+		capture:    c,
+		serverPort: uint16(localPort),
+		clientSeq:  1000,
+		serverSeq:  5000,
+	}
+
+	if tcpAddr, ok := clientAddr.(*net.TCPAddr); ok {
+		cc.clientPort = uint16(tcpAddr.Port)
+	} else {
+		c.mu.Lock()
+		cc.clientPort = c.nextPort
+		c.nextPort++
+		c.mu.Unlock()
+	}
+
+	switch c.mode {
+	case CaptureHTTP:
+		pr, pw := io.Pipe()
+		cc.requests = pw
+		go c.parseRequests(pr, clientAddr)
+	case CapturePcap:
+		// Synthesize the TCP handshake so tools like Wireshark reassemble the stream
+		c.writePacket(cc.clientPort, cc.serverPort, cc.clientSeq, 0, tcpSYN, nil)
+		c.writePacket(cc.serverPort, cc.clientPort, cc.serverSeq, cc.clientSeq+1, tcpSYN|tcpACK, nil)
+		cc.clientSeq++
+		cc.serverSeq++
+		c.writePacket(cc.clientPort, cc.serverPort, cc.clientSeq, cc.serverSeq, tcpACK, nil)
+	}
+
+	return cc
+}
+
+// parseRequests reads HTTP requests from the client stream and logs them
+func (c *Capture) parseRequests(r *io.PipeReader, clientAddr net.Addr) {
+	br := bufio.NewReader(r)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			// Not HTTP (or the connection ended), keep draining so the proxy never blocks
+			io.Copy(io.Discard, r)
+			return
+		}
+		bodySize, _ := io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+		c.recordRequest(req, bodySize, clientAddr)
+	}
+}
+
+// recordRequest pretty-prints a request to the capture file and keeps a short summary
+func (c *Capture) recordRequest(req *http.Request, bodySize int64, clientAddr net.Addr) {
+	var s strings.Builder
+
+	now := time.Now()
+	s.WriteString(fmt.Sprintf("=== %s %s\n", now.Format(time.RFC3339), clientAddr))
+	s.WriteString(fmt.Sprintf("%s %s %s\n", req.Method, req.RequestURI, req.Proto))
+	s.WriteString(fmt.Sprintf("Host: %s\n", req.Host))
+	req.Header.Write(&s)
+	s.WriteString(fmt.Sprintf("(body: %d bytes)\n\n", bodySize))
+
+	summary := fmt.Sprintf("%s %s %s (%d bytes)", now.Format("15:04:05"), req.Method, req.RequestURI, bodySize)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.file.WriteString(s.String())
+
+	c.recent = append(c.recent, summary)
+	if len(c.recent) > maxRecentRequests {
+		c.recent = c.recent[len(c.recent)-maxRecentRequests:]
+	}
+}
+
+// CaptureConn records the traffic of a single proxied connection
+type CaptureConn struct {
+	capture    *Capture
+	requests   *io.PipeWriter
+	clientPort uint16
+	serverPort uint16
+	clientSeq  uint32
+	serverSeq  uint32
+	mu         sync.Mutex
+}
+
+// Record captures a chunk of data flowing in one direction
+func (cc *CaptureConn) Record(fromClient bool, data []byte) {
+	switch cc.capture.mode {
+	case CaptureHTTP:
+		if fromClient {
+			cc.requests.Write(data)
+		}
+	case CapturePcap:
+		cc.mu.Lock()
+		defer cc.mu.Unlock()
+
+		for len(data) > 0 {
+			chunk := data
+			if len(chunk) > maxPcapPayload {
+				chunk = chunk[:maxPcapPayload]
+			}
+			data = data[len(chunk):]
+
+			if fromClient {
+				cc.capture.writePacket(cc.clientPort, cc.serverPort, cc.clientSeq, cc.serverSeq, tcpPSH|tcpACK, chunk)
+				cc.clientSeq += uint32(len(chunk))
+			} else {
+				cc.capture.writePacket(cc.serverPort, cc.clientPort, cc.serverSeq, cc.clientSeq, tcpPSH|tcpACK, chunk)
+				cc.serverSeq += uint32(len(chunk))
+			}
+		}
+	}
+}
+
+// Close finishes recording the connection
+func (cc *CaptureConn) Close() {
+	switch cc.capture.mode {
+	case CaptureHTTP:
+		cc.requests.Close()
+	case CapturePcap:
+		cc.mu.Lock()
+		defer cc.mu.Unlock()
+
+		cc.capture.writePacket(cc.clientPort, cc.serverPort, cc.clientSeq, cc.serverSeq, tcpFIN|tcpACK, nil)
+		cc.capture.writePacket(cc.serverPort, cc.clientPort, cc.serverSeq, cc.clientSeq+1, tcpFIN|tcpACK, nil)
+	}
+}
+
+const (
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+
+	// linkTypeIPv4 is the pcap link type for raw IPv4 packets
+	linkTypeIPv4 = 228
+
+	// maxPcapPayload keeps synthesized packets below the IPv4 size limit
+	maxPcapPayload = 65000
+)
+
+// writePcapHeader writes the pcap global header
+func (c *Capture) writePcapHeader() error {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], 65535)
+	binary.LittleEndian.PutUint32(header[20:], linkTypeIPv4)
+	_, err := c.file.Write(header)
+	return err
+}
+
+// writePacket writes a synthesized IPv4/TCP packet between two loopback ports
+func (c *Capture) writePacket(srcPort, dstPort uint16, seq, ack uint32, flags byte, payload []byte) {
+	loopback := []byte{127, 0, 0, 1}
+
+	tcp := make([]byte, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], seq)
+	binary.BigEndian.PutUint32(tcp[8:], ack)
+	tcp[12] = 5 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535)
+	copy(tcp[20:], payload)
+
+	pseudo := make([]byte, 12, 12+len(tcp))
+	copy(pseudo[0:], loopback)
+	copy(pseudo[4:], loopback)
+	pseudo[9] = 6
+	binary.BigEndian.PutUint16(pseudo[10:], uint16(len(tcp)))
+	binary.BigEndian.PutUint16(tcp[16:], internetChecksum(append(pseudo, tcp...)))
+
+	ip := make([]byte, 20, 20+len(tcp))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(tcp)))
+	binary.BigEndian.PutUint16(ip[6:], 0x4000)
+	ip[8] = 64
+	ip[9] = 6
+	copy(ip[12:], loopback)
+	copy(ip[16:], loopback)
+	binary.BigEndian.PutUint16(ip[10:], internetChecksum(ip))
+	packet := append(ip, tcp...)
+
+	now := time.Now()
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(now.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(now.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(packet)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.file.Write(record)
+	c.file.Write(packet)
+}
+
+// internetChecksum computes the RFC 1071 checksum used by IP and TCP headers
+func internetChecksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
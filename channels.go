@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// channelWarnRatio is how full a tunnel's channel limit gets before kport warns
+const channelWarnRatio = 0.8
+
+// channelRefusals are ssh messages for a channel the server refused to open
+var channelRefusals = []string{
+	"open failed: administratively prohibited",
+	"open failed: resource shortage",
+}
+
+// channelLimiter caps the SSH channels a tunnel has open at once. Every proxied
+// connection is a channel on the tunnel's ssh client, and connections over the
+// limit wait for a channel to close instead of failing.
+type channelLimiter struct {
+	mu      sync.Mutex
+	limit   int
+	learned bool
+	open    int
+	queued  int
+	freed   chan struct{}
+}
+
+// newChannelLimiter creates a limiter allowing limit channels, 0 for no limit
+func newChannelLimiter(limit int) *channelLimiter {
+	return &channelLimiter{limit: limit, freed: make(chan struct{})}
+}
+
+// acquire takes a channel, waiting while the limit is reached. It reports
+// whether a channel was taken before stop closed and whether it had to wait.
+func (cl *channelLimiter) acquire(stop <-chan struct{}) (ok bool, waited bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	for cl.limit > 0 && cl.open >= cl.limit {
+		waited = true
+		cl.queued++
+		freed := cl.freed
+		cl.mu.Unlock()
+
+		select {
+		case <-freed:
+			cl.mu.Lock()
+			cl.queued--
+		case <-stop:
+			cl.mu.Lock()
+			cl.queued--
+			return false, waited
+		}
+	}
+
+	cl.open++
+	return true, waited
+}
+
+// release returns a channel and wakes the queued connections
+func (cl *channelLimiter) release() {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.open--
+	close(cl.freed)
+	cl.freed = make(chan struct{})
+}
+
+// refused records the server refusing a channel. When other channels were open
+// at the time, the server has a channel limit, and it becomes the tunnel's limit.
+// It returns the new limit, or 0 when nothing was learned.
+func (cl *channelLimiter) refused() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	// The refused channel is counted as open until its connection closes
+	others := cl.open - 1
+	if others < 1 || (cl.limit > 0 && others >= cl.limit) {
+		return 0
+	}
+	cl.limit = others
+	cl.learned = true
+	return cl.limit
+}
+
+// stats returns the open and queued channels and the limit
+func (cl *channelLimiter) stats() (open, queued, limit int, learned bool) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.open, cl.queued, cl.limit, cl.learned
+}
+
+// nearLimit reports whether open channels have reached the warning threshold of limit
+func nearLimit(open, limit int) bool {
+	return limit > 0 && float64(open) >= float64(limit)*channelWarnRatio
+}
+
+// lineWriter calls fn with every complete line written to it.
+// exec copies a command's output from a single goroutine, so it needs no lock.
+type lineWriter struct {
+	buf []byte
+	fn  func(string)
+}
+
+// Write buffers p and passes on the complete lines
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		lw.fn(strings.TrimRight(string(lw.buf[:i]), "\r"))
+		lw.buf = lw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// handleSSHStderr watches the tunnel's ssh output for channels the server
+// refused and connection failures it can explain
+func (pf *PortForwarder) handleSSHStderr(line string) {
+	fmt.Fprintf(os.Stderr, "Debug: ssh localhost:%d -> %s:%d: %s\n", pf.localPort, pf.host.Name, pf.remotePort, line)
+
+	if sshErr := classifySSHError(pf.host, []byte(line)); sshErr != nil {
+		// ssh follows a specific failure with generic ones, keep the first
+		if pf.sshFailure.CompareAndSwap(nil, sshErr) {
+			pf.errors.Record(sshErr)
+		}
+		return
+	}
+
+	for _, refusal := range channelRefusals {
+		if !strings.Contains(line, refusal) {
+			continue
+		}
+		if limit := pf.channels.refused(); limit > 0 {
+			logEvent("channel limit reached: localhost:%d -> %s:%d: server refused a channel with %d open, queueing connections over %d",
+				pf.localPort, pf.host.Name, pf.remotePort, limit+1, limit)
+			pf.errors.Record(fmt.Errorf("server refused an SSH channel, limiting the tunnel to %d connections and queueing the rest", limit))
+		} else {
+			pf.errors.Record(fmt.Errorf("server refused an SSH channel: %s", line))
+		}
+		return
+	}
+}
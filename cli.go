@@ -0,0 +1,803 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// runCLI runs a profile subcommand and reports whether args named one
+func runCLI(args []string) (bool, error) {
+	switch args[0] {
+	case "up":
+		return true, runUp(args[1:])
+	case "down":
+		return true, runDown(args[1:])
+	case "status":
+		return true, runStatus()
+	case "list":
+		return true, runList(args[1:])
+	case "stop":
+		return true, runStop(args[1:])
+	case "stats":
+		return true, runStats(args[1:])
+	case "throughput":
+		return true, runThroughput(args[1:])
+	case "transcript":
+		return true, runTranscript(args[1:])
+	case "daemon":
+		return true, runDaemonCommand(args[1:])
+	case "forward":
+		return true, runForward(args[1:])
+	case "stdio":
+		return true, runStdio(args[1:])
+	case "profiles":
+		return true, runProfiles(args[1:])
+	case "audit":
+		return true, runAudit(args[1:])
+	case "config":
+		return true, runConfig(args[1:])
+	case "plugins":
+		return true, runPlugins(args[1:])
+	case "lan":
+		return true, runLAN(args[1:])
+	case "lint":
+		return true, runLint(args[1:])
+	case "import-cmd":
+		return true, runImportCmd(args[1:])
+	case "fix-perms":
+		return true, runFixPerms(args[1:])
+	}
+	return false, nil
+}
+
+// runUp brings up profiles through the daemon, starting it if needed.
+// key=value arguments fill in the parameters of profile templates, and
+// --wait blocks until every tunnel passes its health check.
+func runUp(args []string) error {
+	wait, timeout, args, err := parseWaitFlags(args)
+	if err != nil {
+		return err
+	}
+	profiles, params := parseProfileArgs(args)
+	if len(profiles) == 0 {
+		return fmt.Errorf("usage: kport up [--wait] [--timeout duration] <profile>... [param=value]...")
+	}
+
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		return err
+	}
+
+	// Every profile takes the parameters it declares
+	used := make(map[string]bool)
+	requests := make([]DaemonRequest, 0, len(profiles))
+	for _, name := range profiles {
+		profile, ok := kportConfig.Profiles[name]
+		if !ok {
+			return fmt.Errorf("profile %q not found in kport config", name)
+		}
+		profileArgs := make(map[string]string)
+		for key, value := range params {
+			if _, ok := profile.Params[key]; ok {
+				profileArgs[key] = value
+				used[key] = true
+			}
+		}
+		profileArgs, err := promptProfileParams(profile, profileArgs)
+		if err != nil {
+			return err
+		}
+		requests = append(requests, DaemonRequest{Command: "up", Profile: name, Args: profileArgs})
+	}
+	for key := range params {
+		if !used[key] {
+			return fmt.Errorf("no profile takes the parameter %s", key)
+		}
+	}
+
+	// Start the daemon once, so the profiles don't race to start it
+	if _, err := callDaemon(DaemonRequest{Command: "ping"}, true); err != nil {
+		return err
+	}
+
+	// The profiles come up at once, showing the progress of all their tunnels
+	instances := make([]string, len(requests))
+	for i, req := range requests {
+		instances[i] = profileInstanceName(req.Profile, req.Args)
+	}
+	progress := newUpProgress(instances)
+	responses := make([]DaemonResponse, len(requests))
+	errs := make([]error, len(requests))
+	var wg sync.WaitGroup
+	for i, req := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = callDaemonWithProgress(req, false, func(update TunnelProgress) {
+				progress.update(i, update)
+			})
+			progress.finish(i, responses[i].Failures != "", errs[i])
+		}()
+	}
+	wg.Wait()
+	progress.close()
+
+	if len(requests) == 1 && errs[0] != nil {
+		return fmt.Errorf("failed to bring up %s: %w", instances[0], errs[0])
+	}
+	started := make([]TunnelStatus, 0)
+	failed := 0
+	for i, resp := range responses {
+		switch {
+		case errs[i] != nil:
+			failed++
+			fmt.Printf("❌ failed to bring up %s: %v\n", instances[i], errs[i])
+			continue
+		case resp.Failures != "":
+			fmt.Printf("⚠️  %s is partly up:\n%s\n", instances[i], resp.Failures)
+		default:
+			fmt.Printf("✅ %s is up\n", instances[i])
+		}
+		printTunnelStatuses(resp.Tunnels)
+		started = append(started, resp.Tunnels...)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d profiles failed to come up", failed, len(requests))
+	}
+
+	if !wait {
+		return nil
+	}
+	fmt.Printf("⏳ Waiting up to %s for %d tunnels to become healthy\n", timeout, len(started))
+	return waitForHealthy(started, timeout)
+}
+
+// parseWaitFlags takes --wait and --timeout off the arguments of `kport up`.
+// A timeout implies --wait.
+func parseWaitFlags(args []string) (wait bool, timeout time.Duration, rest []string, err error) {
+	timeout = defaultWaitTimeout
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value, hasValue := strings.CutPrefix(arg, "--timeout=")
+		switch {
+		case arg == "--wait":
+			wait = true
+		case arg == "--timeout" || hasValue:
+			if !hasValue {
+				if i+1 == len(args) {
+					return false, 0, nil, fmt.Errorf("--timeout needs a duration, such as 90s")
+				}
+				i++
+				value = args[i]
+			}
+			if timeout, err = time.ParseDuration(value); err != nil || timeout <= 0 {
+				return false, 0, nil, fmt.Errorf("invalid timeout %q, use a duration such as 90s", value)
+			}
+			wait = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return wait, timeout, rest, nil
+}
+
+// runDown stops the tunnels of profiles. Without parameters every instance
+// of a profile template is stopped, with them only the matching one.
+func runDown(args []string) error {
+	names, params := parseProfileArgs(args)
+	if len(names) == 0 {
+		return fmt.Errorf("usage: kport down <profile>... [param=value]...")
+	}
+
+	for _, name := range names {
+		profile := profileInstanceName(name, params)
+		if _, err := callDaemon(DaemonRequest{Command: "down", Profile: profile}, false); err != nil {
+			return fmt.Errorf("failed to bring down %s: %w", profile, err)
+		}
+		fmt.Printf("✅ %s is down\n", profile)
+	}
+	return nil
+}
+
+// runStatus lists the tunnels run by the daemon and by other kport instances
+func runStatus() error {
+	resp, err := callDaemon(DaemonRequest{Command: "status"}, false)
+	switch {
+	case err != nil:
+		fmt.Println("kport daemon is not running")
+	case len(resp.Tunnels) == 0:
+		fmt.Println("No tunnels are up")
+	default:
+		printTunnelStatuses(resp.Tunnels)
+	}
+	if resp.Files != nil {
+		if resp.Files.Near() {
+			fmt.Printf("⚠️  Open files: %d of %d, new connections fail at the limit (raise it with file_limit)\n", resp.Files.Open, resp.Files.Limit)
+		} else {
+			fmt.Printf("Open files: %d of %d\n", resp.Files.Open, resp.Files.Limit)
+		}
+	}
+	if resp.Resources != nil {
+		fmt.Printf("Daemon: %s\n", resp.Resources)
+	}
+
+	daemonPorts := make(map[int]bool)
+	for _, tunnel := range resp.Tunnels {
+		daemonPorts[tunnel.LocalPort] = true
+	}
+	var others []PortReservation
+	for _, reservation := range otherReservations() {
+		if !daemonPorts[reservation.Port] {
+			others = append(others, reservation)
+		}
+	}
+	if len(others) > 0 {
+		fmt.Println("Other kport instances:")
+		for _, reservation := range others {
+			fmt.Printf("   localhost:%d -> %s:%d (pid %d, since %s ago)\n",
+				reservation.Port, reservation.Host, reservation.RemotePort,
+				reservation.PID, formatAge(time.Since(reservation.Since)))
+		}
+	}
+	return nil
+}
+
+// runList prints the inventory of active tunnels as a markdown table, CSV or JSON
+func runList(args []string) error {
+	format := "md"
+	switch {
+	case len(args) == 0:
+	case len(args) == 2 && args[0] == "--format":
+		format = args[1]
+	case len(args) == 1 && strings.HasPrefix(args[0], "--format="):
+		format = strings.TrimPrefix(args[0], "--format=")
+	default:
+		return fmt.Errorf("usage: kport list [--format md|csv|json]")
+	}
+
+	data, err := formatInventory(collectInventory(nil), format, time.Now())
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// runStop closes a single tunnel of the daemon, named by its ID, name or local port
+func runStop(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: kport stop <tunnel>")
+	}
+	resp, err := callDaemon(DaemonRequest{Command: "stop", Tunnel: args[0]}, false)
+	if err != nil {
+		return err
+	}
+	tunnel := resp.Tunnels[0]
+	fmt.Printf("✅ %s %s -> %s:%d is closed\n", tunnel.ID, tunnel.LocalAddress(), tunnel.Host, tunnel.RemotePort)
+	return nil
+}
+
+// runStats prints the connections, traffic and problems of a single tunnel
+// of the daemon, named by its ID, name or local port
+func runStats(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: kport stats <tunnel>")
+	}
+	resp, err := callDaemon(DaemonRequest{Command: "stats", Tunnel: args[0]}, false)
+	if err != nil {
+		return err
+	}
+	tunnel := resp.Tunnels[0]
+
+	fmt.Printf("%s  %s  %s\n", tunnel.ID, tunnel.label(), tunnel.State())
+	fmt.Printf("   %s -> %s:%d (%s)\n", tunnel.LocalAddress(), tunnel.Host, tunnel.RemotePort, strings.Join(tunnel.Listen, ", "))
+	uptime := fmt.Sprintf("   Up for %s", formatAge(time.Since(tunnel.StartedAt)))
+	if !tunnel.ExpiresAt.IsZero() && tunnel.Running {
+		uptime += fmt.Sprintf(", closes in %s", formatCountdown(time.Until(tunnel.ExpiresAt).Round(time.Second)))
+	}
+	fmt.Println(uptime)
+	fmt.Printf("   Connections: %d active, %d total, %d sockets\n", tunnel.ActiveConns, tunnel.TotalConns, tunnel.Sockets)
+	if tunnel.ChannelLimit > 0 {
+		fmt.Printf("   Channels: %d of %d, %d queued\n", tunnel.Channels, tunnel.ChannelLimit, tunnel.Queued)
+	}
+	fmt.Printf("   Traffic: ↓ %s ↑ %s\n", formatBytes(tunnel.BytesIn), formatBytes(tunnel.BytesOut))
+	if tunnel.Health != "" {
+		fmt.Printf("   Health check: %s\n", tunnel.Health)
+	}
+	if tunnel.RemoteDown != "" && tunnel.Running {
+		fmt.Printf("   ❌ remote service %s\n", tunnel.RemoteDown)
+	}
+	for _, err := range tunnel.Errors {
+		fmt.Printf("   ⚠ %s\n", err)
+	}
+	return nil
+}
+
+// runThroughput prints the throughput samples of a daemon tunnel as CSV or JSON
+func runThroughput(args []string) error {
+	usage := fmt.Errorf("usage: kport throughput <tunnel> [--format csv|json]")
+	format := "csv"
+	var selector string
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--format" && i+1 < len(args):
+			i++
+			format = args[i]
+		case strings.HasPrefix(args[i], "--format="):
+			format = strings.TrimPrefix(args[i], "--format=")
+		case selector == "" && !strings.HasPrefix(args[i], "-"):
+			selector = args[i]
+		default:
+			return usage
+		}
+	}
+	if selector == "" {
+		return usage
+	}
+
+	resp, err := callDaemon(DaemonRequest{Command: "throughput", Tunnel: selector}, false)
+	if err != nil {
+		return err
+	}
+	data, err := formatThroughput(*resp.Throughput, format)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// runForward forwards a single port in the foreground until interrupted.
+// The host may be an SSH config host or an inline [user@]host[:port] spec.
+// ssh-style -p, -l and -o options before the host override the SSH config.
+func runForward(args []string) error {
+	overrides, args, err := parseSSHOverrides(args)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: kport forward [-p port] [-l user] [-o option]... <host|user@host[:port]> <remote-port> [local-port|socket-path]")
+	}
+
+	remotePort, err := parsePort(args[1])
+	if err != nil {
+		return err
+	}
+	// A local side with a slash is a Unix socket path
+	localPort, localSocket := 0, ""
+	if len(args) == 3 && strings.Contains(args[2], "/") {
+		localSocket = args[2]
+	} else if len(args) == 3 {
+		if localPort, err = parsePort(args[2]); err != nil {
+			return err
+		}
+	}
+
+	// Inline hosts work without any SSH config, e.g. on CI machines
+	sshConfig := NewSSHConfig()
+	if err := sshConfig.LoadConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		return err
+	}
+	setAuditConfig(kportConfig.Audit)
+	setPolicy(kportConfig.Policy)
+	setStrictModes(kportConfig.StrictModes)
+	setFileLimit(kportConfig.FileLimit)
+
+	host, err := resolveHost(args[0], collectHosts(sshConfig, kportConfig), kportConfig)
+	if err != nil {
+		return err
+	}
+	host = overrides.Apply(host)
+
+	options := forwardOptionsFor(host, kportConfig.HostFor(host), remotePort)
+	risks := currentPolicy().tunnelRisks(localPort, remotePort, options)
+	if err := policyError(risks, true); err != nil {
+		return err
+	}
+	if needsConfirmation(risks) {
+		if err := confirmOnTerminal(host.Name, risks); err != nil {
+			return err
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	manager := NewTunnelManager()
+	profile := ProfileConfig{Tunnels: []TunnelConfig{{Host: host.Name, RemotePort: remotePort, LocalPort: localPort, LocalSocket: localSocket}}}
+	tunnels, err := manager.Up(host.Name, profile, []SSHHost{host}, kportConfig, nil)
+	if err != nil {
+		return err
+	}
+	defer manager.StopAll()
+
+	forwarder := tunnels[0].Forwarder
+	fmt.Printf("✅ Forwarding %s -> %s:%d (Ctrl+C to stop)\n", forwarder.LocalAddress(), host.Name, remotePort)
+
+	select {
+	case <-signals:
+		return nil
+	case <-forwarder.SSHDone():
+		// Ctrl+C reaches ssh too, so give our own signal a moment to arrive
+		select {
+		case <-signals:
+			return nil
+		case <-time.After(200 * time.Millisecond):
+		}
+		return fmt.Errorf("ssh to %s exited", host.Name)
+	}
+}
+
+// runStdio connects stdin and stdout to a port reachable from host, like `ssh -W`.
+// The target is a port on the host itself or a destination:port the host can reach,
+// so it also works as a ProxyCommand, e.g. `ProxyCommand kport stdio bastion %h:%p`.
+func runStdio(args []string) error {
+	overrides, args, err := parseSSHOverrides(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 2 {
+		return fmt.Errorf("usage: kport stdio [-p port] [-l user] [-o option]... <host|user@host[:port]> <[destination:]port>")
+	}
+
+	destination, portArg := "localhost", args[1]
+	if i := strings.LastIndex(args[1], ":"); i >= 0 {
+		destination, portArg = strings.Trim(args[1][:i], "[]"), args[1][i+1:]
+	}
+	port, err := parsePort(portArg)
+	if err != nil {
+		return err
+	}
+	if destination == "" {
+		return fmt.Errorf("invalid destination %q", args[1])
+	}
+
+	sshConfig := NewSSHConfig()
+	if err := sshConfig.LoadConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		return err
+	}
+	host, err := resolveHost(args[0], collectHosts(sshConfig, kportConfig), kportConfig)
+	if err != nil {
+		return err
+	}
+	host = overrides.Apply(host)
+
+	ctx, span := tracer.Start(context.Background(), "kport.stdio", trace.WithAttributes(append(hostAttributes(host),
+		attribute.Int("kport.remote_port", port),
+	)...))
+	host, err = tracedPrepareHost(ctx, host)
+	if err != nil {
+		endSpan(span, err)
+		return err
+	}
+
+	// ssh -W relays stdin and stdout itself, so kport only has to wait for it
+	target := net.JoinHostPort(destination, strconv.Itoa(port))
+	cmd := sshCommand(host, []string{"-W", target})
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("ssh -W %s via %s failed: %w", target, host.Name, err)
+	}
+	return nil
+}
+
+// parsePort parses a TCP port argument
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", value)
+	}
+	return port, nil
+}
+
+// runProfiles lists the available profiles, or pulls the shared profile sources
+func runProfiles(args []string) error {
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(args) == 0:
+		names := kportConfig.ProfileNames()
+		if len(names) == 0 {
+			fmt.Println("No profiles configured")
+			return nil
+		}
+		for _, name := range names {
+			profile := kportConfig.Profiles[name]
+			line := fmt.Sprintf("   %s (%d tunnels) from %s", name, len(profile.Tunnels), profile.Source)
+			if params := profile.ParamNames(); len(params) > 0 {
+				line = fmt.Sprintf("   %s(%s) from %s", name, strings.Join(params, ", "), profile.Source)
+			}
+			if profile.Overrides != "" {
+				line += fmt.Sprintf(", overriding %s", profile.Overrides)
+			}
+			fmt.Println(line)
+		}
+		return nil
+	case len(args) == 1 && args[0] == "pull":
+		return pullProfileSources(kportConfig.ProfileSources)
+	default:
+		return fmt.Errorf("usage: kport profiles [pull]")
+	}
+}
+
+// pullProfileSources refreshes the local copy of every profile source
+func pullProfileSources(sources []ProfileSource) error {
+	if len(sources) == 0 {
+		fmt.Println("No profile sources configured")
+		return nil
+	}
+
+	failed := 0
+	for _, source := range sources {
+		store, err := source.Store()
+		if err == nil {
+			err = store.Pull()
+		}
+		if err != nil {
+			fmt.Printf("❌ %s: %v\n", source.Name, err)
+			failed++
+			continue
+		}
+
+		_, revision, err := store.Load()
+		if err != nil {
+			fmt.Printf("⚠️  %s: pulled, but %v\n", source.Name, err)
+			continue
+		}
+		fmt.Printf("✅ %s: %s\n", source.Name, revision)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d profile sources failed to pull", failed, len(sources))
+	}
+	return nil
+}
+
+// runAudit verifies the audit log, or exports it to stdout as JSON lines or
+// CSV. An export of a log that fails verification is still written, so it
+// can be inspected, but the command fails.
+func runAudit(args []string) error {
+	usage := fmt.Errorf("usage: kport audit verify | kport audit export [--format jsonl|csv] [--since 24h]")
+	if len(args) == 0 || (args[0] != "verify" && args[0] != "export") {
+		return usage
+	}
+
+	format, since := "jsonl", time.Duration(0)
+	for i := 1; i < len(args); i++ {
+		if i+1 == len(args) || args[0] != "export" {
+			return usage
+		}
+		switch args[i] {
+		case "--format":
+			format = args[i+1]
+			if format != "jsonl" && format != "csv" {
+				return fmt.Errorf("unknown audit export format %q, expected jsonl or csv", format)
+			}
+		case "--since":
+			var err error
+			if since, err = time.ParseDuration(args[i+1]); err != nil || since <= 0 {
+				return fmt.Errorf("invalid --since %q, use a duration such as 24h", args[i+1])
+			}
+		default:
+			return usage
+		}
+		i++
+	}
+
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		return err
+	}
+	path, err := kportConfig.Audit.path()
+	if err != nil {
+		return err
+	}
+	key, err := kportConfig.Audit.key()
+	if err != nil {
+		return err
+	}
+	lines, entries, err := readAuditLog(path)
+	if err != nil {
+		return err
+	}
+	verifyErr := verifyAuditLog(lines, entries, key)
+
+	if args[0] == "verify" {
+		if verifyErr != nil {
+			return fmt.Errorf("audit log %s failed verification: %w", path, verifyErr)
+		}
+		if key == nil {
+			fmt.Printf("✅ %d entries in %s, numbered without gaps (no key_file, so MACs aren't checked)\n", len(entries), path)
+		} else {
+			fmt.Printf("✅ %d entries in %s, HMAC chain intact\n", len(entries), path)
+		}
+		return nil
+	}
+
+	// Entries are appended in time order, so the export starts at the first recent one
+	start := 0
+	if since > 0 {
+		cutoff := time.Now().Add(-since)
+		for start < len(entries) && entries[start].Time.Before(cutoff) {
+			start++
+		}
+	}
+	if format == "csv" {
+		data, err := formatAuditCSV(entries[start:])
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(data)
+	} else {
+		for _, line := range lines[start:] {
+			os.Stdout.Write(append(line, '\n'))
+		}
+	}
+
+	if verifyErr != nil {
+		return fmt.Errorf("audit log %s failed verification: %w", path, verifyErr)
+	}
+	return nil
+}
+
+// runDaemonCommand handles `kport daemon stop`
+func runDaemonCommand(args []string) error {
+	if len(args) != 1 || args[0] != "stop" {
+		return fmt.Errorf("usage: kport daemon stop")
+	}
+	if _, err := callDaemon(DaemonRequest{Command: "shutdown"}, false); err != nil {
+		fmt.Println("kport daemon is not running")
+		return nil
+	}
+	fmt.Println("✅ kport daemon stopped")
+	return nil
+}
+
+// printTunnelStatuses prints one line per tunnel
+func printTunnelStatuses(tunnels []TunnelStatus) {
+	for _, tunnel := range tunnels {
+		line := fmt.Sprintf("   %s [%s] %s %s -> %s:%d (%s, %d active connections)",
+			tunnel.ID, tunnel.label(), tunnel.State(), tunnel.LocalAddress(), tunnel.Host, tunnel.RemotePort,
+			strings.Join(tunnel.Listen, ", "), tunnel.ActiveConns)
+		if tunnel.ChannelLimit > 0 {
+			line += fmt.Sprintf(", %d of %d channels", tunnel.Channels, tunnel.ChannelLimit)
+		}
+		if tunnel.Queued > 0 {
+			line += fmt.Sprintf(", %d queued", tunnel.Queued)
+		}
+		if tunnel.Sockets > 0 {
+			line += fmt.Sprintf(", %d sockets", tunnel.Sockets)
+		}
+		if tunnel.Goroutines > 0 {
+			line += fmt.Sprintf(", %d goroutines", tunnel.Goroutines)
+		}
+		if !tunnel.ExpiresAt.IsZero() && tunnel.Running {
+			line += fmt.Sprintf(", closes in %s", formatCountdown(time.Until(tunnel.ExpiresAt).Round(time.Second)))
+		}
+		fmt.Println(line)
+		if tunnel.RemoteDown != "" && tunnel.Running {
+			fmt.Printf("      ❌ remote service %s\n", tunnel.RemoteDown)
+		}
+		for _, err := range tunnel.Errors {
+			fmt.Printf("      ⚠ %s\n", err)
+		}
+	}
+}
+
+// runConfig exports the kport config as a bundle to stdout, merges a bundle
+// into it, or shows the effective configuration of a host. Settings that can
+// hold secrets are left out of exports.
+func runConfig(args []string) error {
+	switch {
+	case len(args) == 2 && args[0] == "show":
+		sshConfig := NewSSHConfig()
+		if err := sshConfig.LoadConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		kportConfig, err := LoadKportConfig()
+		if err != nil {
+			return err
+		}
+		host, err := resolveHost(args[1], collectHosts(sshConfig, kportConfig), kportConfig)
+		if err != nil {
+			return err
+		}
+		showHostConfig(host, kportConfig)
+		return nil
+	case len(args) == 1 && args[0] == "export":
+		bundle, excluded, err := exportConfigBundle()
+		if err != nil {
+			return err
+		}
+		os.Stdout.Write(bundle)
+		for _, setting := range excluded {
+			fmt.Fprintf(os.Stderr, "⚠️  Left out %s to keep secrets out of the bundle\n", setting)
+		}
+		return nil
+	case len(args) == 2 && args[0] == "import":
+		bundle, err := readConfigBundle(args[1])
+		if err != nil {
+			return err
+		}
+		path, backup, err := importConfigBundle(bundle)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Imported %s into %s\n", args[1], path)
+		if backup != "" {
+			fmt.Printf("   The previous config is saved as %s\n", backup)
+		}
+		return nil
+	default:
+		return fmt.Errorf("usage: kport config export > bundle.yaml | kport config import <bundle.yaml|-> | kport config show <host>")
+	}
+}
+
+// runPlugins lists the configured plugins and what each of them can do
+func runPlugins(args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: kport plugins")
+	}
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		return err
+	}
+	lines := describePlugins(kportConfig)
+	if len(lines) == 0 {
+		fmt.Println("No plugins configured")
+		return nil
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// runLAN issues client certificates for the LAN listeners of tunnels
+func runLAN(args []string) error {
+	if len(args) < 2 || len(args) > 3 || args[0] != "issue" {
+		return fmt.Errorf("usage: kport lan issue <name> [dir]")
+	}
+	dir := "."
+	if len(args) == 3 {
+		dir = args[2]
+	}
+	paths, err := issueLANClient(args[1], dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✅ Issued a client certificate for %s\n", args[1])
+	for _, path := range paths {
+		fmt.Printf("   %s\n", path)
+	}
+	fmt.Println("   Copy them to the client machine. The key grants access to every LAN tunnel.")
+	return nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// KportConfig holds kport's own settings, kept separate from the SSH config
+type KportConfig struct {
+	// StrictIdentities stops ssh from trying the default keys in ~/.ssh,
+	// so only explicitly configured identities or the agent are used
+	StrictIdentities bool `yaml:"strict_identities"`
+
+	// IdleTimeout closes proxied connections without traffic for this long (0 disables)
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+
+	// StreamIdleTimeout is the idle timeout for WebSocket and streaming
+	// connections, defaulting to IdleTimeout (0 disables)
+	StreamIdleTimeout *time.Duration `yaml:"stream_idle_timeout"`
+
+	// WatchInterval is how often tunnels check that their remote service is
+	// still listening, defaulting to 30s (0 disables)
+	WatchInterval *time.Duration `yaml:"watch_interval"`
+
+	// DetectTimeout is how long each port detection command may run before
+	// it is given up on, defaulting to 15s
+	DetectTimeout *time.Duration `yaml:"detect_timeout"`
+
+	// Notify shows a desktop notification when a tunnel's remote service goes away
+	Notify bool `yaml:"notify"`
+
+	// ProbeHTTP sends a HEAD request to HTTP-looking ports after detection
+	// and shows the response status and server next to each port
+	ProbeHTTP bool `yaml:"probe_http"`
+
+	// Keys remaps the TUI's keybindings
+	Keys KeysConfig `yaml:"keys"`
+
+	// Teleport lists Teleport nodes alongside the SSH config hosts
+	Teleport TeleportConfig `yaml:"teleport"`
+
+	// VMs lists local development VMs alongside the SSH config hosts
+	VMs VMsConfig `yaml:"vms"`
+
+	// Plugins are external executables that list hosts, detect ports or
+	// carry connections, keyed by the name hosts refer to them by
+	Plugins map[string]PluginConfig `yaml:"plugins"`
+
+	// Prewarm connects to pinned hosts in the background at startup
+	Prewarm PrewarmConfig `yaml:"prewarm"`
+
+	// QuickConnect starts on a screen of recently used hosts with a one-key connect
+	QuickConnect QuickConnectConfig `yaml:"quick_connect"`
+
+	// Share makes tunnels reachable from a public URL through a relay host
+	Share ShareConfig `yaml:"share"`
+
+	// Audit writes an append-only log of tunnels opening and closing
+	Audit AuditConfig `yaml:"audit"`
+
+	// Policy asks for a typed confirmation of, or denies, tunnels that are
+	// reachable from other machines, bind privileged ports or reverse forward
+	// through production hosts
+	Policy PolicyConfig `yaml:"policy"`
+
+	// Environments preset the header color, confirmations and idle timeouts
+	// of hosts tagged prod, staging, dev or a custom environment
+	Environments map[string]EnvironmentConfig `yaml:"environments"`
+
+	// Pprof serves Go's pprof profiles from the daemon on this loopback address
+	Pprof string `yaml:"pprof"`
+
+	// Dashboard serves a read-only web page of the daemon's tunnels on this loopback address
+	Dashboard string `yaml:"dashboard"`
+
+	// Webhook lets authenticated calls, such as from CI, bring up profiles
+	// through the daemon for a limited time
+	Webhook WebhookConfig `yaml:"webhook"`
+
+	// StrictModes warns about config and key files other users may read or
+	// change (the default), refuses to connect with them or, set to off,
+	// doesn't check them
+	StrictModes string `yaml:"strict_modes"`
+
+	// FileLimit raises the limit of open files, which every proxied connection
+	// uses two of, as far as kport is allowed to (0 keeps the limit)
+	FileLimit int `yaml:"file_limit"`
+
+	Hosts map[string]HostConfig `yaml:"hosts"`
+
+	// Profiles are named sets of tunnels brought up together with `kport up`
+	Profiles map[string]ProfileConfig `yaml:"profiles"`
+
+	// ProfileSources are shared locations, such as a team's git repo, whose
+	// profiles are available next to the local ones
+	ProfileSources []ProfileSource `yaml:"profile_sources"`
+}
+
+// HostConfig holds kport settings for a single SSH host
+type HostConfig struct {
+	// Pinned marks a favorite host, connected to ahead of time when prewarming is enabled
+	Pinned bool `yaml:"pinned"`
+
+	// Tags describe the host, such as prod, which the policy's prod_tags and
+	// the environments match
+	Tags []string `yaml:"tags"`
+
+	// Confirm lists the port categories whose tunnels to this host ask for a
+	// typed confirmation, replacing those of the host's environment
+	Confirm []PortCategory `yaml:"confirm"`
+
+	// Destinations maps a remote port to the destination its tunnel forwards
+	// to instead of localhost, as host:port or an SRV name resolved on the host
+	Destinations map[int]string `yaml:"destinations"`
+
+	// Failover maps a remote port to alternative destinations (host:port or
+	// an SRV name) tried in order when the primary destination is unreachable
+	Failover map[int][]string `yaml:"failover"`
+
+	// StrictIdentities overrides the global strict identities setting for this host
+	StrictIdentities *bool `yaml:"strict_identities"`
+
+	// AgentKey is the fingerprint, such as SHA256:..., or comment of the only
+	// agent key offered to the host
+	AgentKey string `yaml:"agent_key"`
+
+	// Addresses are candidates for the host's HostName, such as its office,
+	// VPN and public addresses, used in order of preference
+	Addresses []string `yaml:"addresses"`
+
+	// HTTPS lists remote ports whose local side is served over HTTPS by kport
+	HTTPS []int `yaml:"https"`
+
+	// AccessLog lists remote ports whose tunnels log each HTTP request in the
+	// Common Log Format
+	AccessLog []int `yaml:"access_log"`
+
+	// IdleTimeout and StreamIdleTimeout override the global idle timeouts for this host
+	IdleTimeout       *time.Duration `yaml:"idle_timeout"`
+	StreamIdleTimeout *time.Duration `yaml:"stream_idle_timeout"`
+
+	// IPv6 also listens on [::1] for tunnels to this host, unless set to false
+	IPv6 *bool `yaml:"ipv6"`
+
+	// LAN lists remote ports whose tunnels are also reachable from other
+	// machines, on LANAddress (default 0.0.0.0) over mutual TLS
+	LAN        []int  `yaml:"lan"`
+	LANAddress string `yaml:"lan_address"`
+
+	// ExtraPorts maps a remote port to additional local ports forwarded to it
+	ExtraPorts map[int][]int `yaml:"extra_ports"`
+
+	// MaxChannels caps the connections each tunnel to this host proxies at once,
+	// such as the server's MaxSessions when ControlMaster multiplexing is used
+	MaxChannels int `yaml:"max_channels"`
+
+	// TTL is the default time limit for tunnels to this host
+	TTL *time.Duration `yaml:"ttl"`
+
+	// WatchInterval and Notify override the global remote service watch settings for this host
+	WatchInterval *time.Duration `yaml:"watch_interval"`
+	Notify        *bool          `yaml:"notify"`
+
+	// DetectTimeout overrides the global port detection timeout for this host
+	DetectTimeout *time.Duration `yaml:"detect_timeout"`
+
+	// PreConnect is a command run before connecting that mints credentials,
+	// such as `vault ssh sign` or `tsh login`
+	PreConnect string `yaml:"pre_connect"`
+
+	// SSM reaches the host through AWS SSM Session Manager instead of direct SSH
+	SSM SSMConfig `yaml:"ssm"`
+
+	// Plugin names a plugin that detects the host's ports or carries its
+	// connection, depending on what the plugin can do
+	Plugin string `yaml:"plugin"`
+
+	// Algorithms overrides the ciphers, key exchange, host key and MAC
+	// algorithms ssh negotiates with the host
+	Algorithms AlgorithmsConfig `yaml:"algorithms"`
+}
+
+// NewKportConfig creates an empty kport config
+func NewKportConfig() *KportConfig {
+	return &KportConfig{
+		Hosts: make(map[string]HostConfig),
+	}
+}
+
+// kportConfigPath returns the path of the kport config file
+func kportConfigPath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config directory: %w", err)
+	}
+	return filepath.Join(configDir, "kport", "config.yaml"), nil
+}
+
+// LoadKportConfig loads the kport config from the default location.
+// A missing config file is not an error and yields an empty config.
+func LoadKportConfig() (*KportConfig, error) {
+	path, err := kportConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	return LoadKportConfigFromFile(path)
+}
+
+// LoadKportConfigFromFile loads the kport config from a specific file
+func LoadKportConfigFromFile(path string) (*KportConfig, error) {
+	config := NewKportConfig()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kport config %s: %w", path, err)
+	}
+
+	config, err = parseKportConfig(data, path)
+	if err != nil {
+		return nil, err
+	}
+	config.mergeSharedProfiles()
+
+	return config, nil
+}
+
+// parseKportConfig parses and validates a kport config read from path, without
+// the profiles of its profile sources
+func parseKportConfig(data []byte, path string) (*KportConfig, error) {
+	config := NewKportConfig()
+	// Syntax errors are left to yaml.Unmarshal, which reports them the same way
+	if lintErrs, err := lintYAML(data, kportConfigSchema()); err == nil && len(lintErrs) > 0 {
+		return nil, &ConfigLintError{Path: path, Errors: lintErrs}
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse kport config %s: %w", path, err)
+	}
+	if config.Hosts == nil {
+		config.Hosts = make(map[string]HostConfig)
+	}
+	if _, err := config.Keys.KeyMap(); err != nil {
+		return nil, fmt.Errorf("invalid keys in kport config %s: %w", path, err)
+	}
+	if err := config.Policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy in kport config %s: %w", path, err)
+	}
+	if err := validateStrictModes(config.StrictModes); err != nil {
+		return nil, fmt.Errorf("invalid kport config %s: %w", path, err)
+	}
+	if err := config.validateEnvironments(); err != nil {
+		return nil, fmt.Errorf("invalid kport config %s: %w", path, err)
+	}
+	if err := config.Webhook.validate(); err != nil {
+		return nil, fmt.Errorf("invalid kport config %s: %w", path, err)
+	}
+	for name, hostConfig := range config.Hosts {
+		if err := hostConfig.Algorithms.validate(); err != nil {
+			return nil, fmt.Errorf("invalid algorithms for %s in kport config %s: %w", name, path, err)
+		}
+		if err := validateAddresses(hostConfig.Addresses); err != nil {
+			return nil, fmt.Errorf("invalid addresses for %s in kport config %s: %w", name, path, err)
+		}
+		if _, ok := config.Plugins[hostConfig.Plugin]; hostConfig.Plugin != "" && !ok {
+			return nil, fmt.Errorf("host %s uses plugin %s, which isn't in the plugins of kport config %s", name, hostConfig.Plugin, path)
+		}
+	}
+	for name, plugin := range config.Plugins {
+		if plugin.Command == "" {
+			return nil, fmt.Errorf("plugin %s has no command in kport config %s", name, path)
+		}
+	}
+	return config, nil
+}
+
+// Host returns the settings for a host with global defaults filled in
+func (kc *KportConfig) Host(name string) HostConfig {
+	hostConfig := kc.Hosts[name]
+
+	if hostConfig.IdleTimeout == nil {
+		idleTimeout := kc.IdleTimeout
+		hostConfig.IdleTimeout = &idleTimeout
+	}
+	if hostConfig.StreamIdleTimeout == nil {
+		hostConfig.StreamIdleTimeout = kc.StreamIdleTimeout
+	}
+	if hostConfig.WatchInterval == nil {
+		watchInterval := defaultWatchInterval
+		if kc.WatchInterval != nil {
+			watchInterval = *kc.WatchInterval
+		}
+		hostConfig.WatchInterval = &watchInterval
+	}
+	if hostConfig.Notify == nil {
+		notify := kc.Notify
+		hostConfig.Notify = &notify
+	}
+	if hostConfig.DetectTimeout == nil {
+		detectTimeout := defaultDetectTimeout
+		if kc.DetectTimeout != nil {
+			detectTimeout = *kc.DetectTimeout
+		}
+		hostConfig.DetectTimeout = &detectTimeout
+	}
+
+	return hostConfig
+}
+
+// ApplyTo returns a copy of host with kport's settings for it applied
+func (kc *KportConfig) ApplyTo(host SSHHost) SSHHost {
+	hostConfig := kc.Host(host.Name)
+
+	host.StrictIdentities = kc.StrictIdentities
+	if hostConfig.StrictIdentities != nil {
+		host.StrictIdentities = *hostConfig.StrictIdentities
+	}
+	host.PreConnect = hostConfig.PreConnect
+	host.AgentKey = hostConfig.AgentKey
+	host.Addresses = hostConfig.Addresses
+	host.DetectTimeout = *hostConfig.DetectTimeout
+	host.Tags = hostTags(host, hostConfig)
+	host.Environment = kc.environmentOf(host.Tags)
+	host.AlgorithmOptions = hostConfig.Algorithms.options()
+
+	// Hosts annotated with an instance ID are reached through SSM
+	if host.Transport == "" {
+		if ssm := ssmConfigFor(host, hostConfig); ssm.InstanceID != "" {
+			host.Transport = TransportSSM
+			host.SSM = ssm
+		}
+	}
+
+	// The plugin a host names in the config takes over from the one that listed it
+	if hostConfig.Plugin != "" {
+		plugin, err := kc.hostPlugin(hostConfig.Plugin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to load plugin for %s: %v\n", host.Name, err)
+		} else {
+			host.Plugin = plugin
+		}
+	}
+	if host.Transport == "" && host.Plugin.can(PluginConnect) {
+		host.Transport = TransportPlugin
+	}
+
+	return host
+}
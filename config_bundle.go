@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// exportConfigBundle returns the kport config as a bundle for another machine,
+// leaving out settings that can hold secrets. It also returns the settings it
+// left out, as paths such as hosts.prod.pre_connect.
+func exportConfigBundle() ([]byte, []string, error) {
+	path, err := kportConfigPath()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("there is no kport config at %s to export", path)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read kport config %s: %w", path, err)
+	}
+	if _, err := parseKportConfig(data, path); err != nil {
+		return nil, nil, err
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse kport config %s: %w", path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil, fmt.Errorf("kport config %s is empty", path)
+	}
+	root := doc.Content[0]
+	excluded := stripConfigSecrets(root)
+
+	hostname, _ := os.Hostname()
+	doc.HeadComment = fmt.Sprintf("kport config bundle exported from %s on %s\nImport it with `kport config import <file>`", hostname, time.Now().Format("2006-01-02"))
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode config bundle: %w", err)
+	}
+	encoder.Close()
+	return out.Bytes(), excluded, nil
+}
+
+// stripConfigSecrets removes the settings of a config that can hold secrets:
+// pre-connect commands, which often embed tokens, the audit key file, and
+// credentials in profile source URLs. It returns what it removed.
+func stripConfigSecrets(root *yaml.Node) []string {
+	excluded := make([]string, 0)
+
+	if hosts := mappingValue(root, "hosts"); hosts != nil && hosts.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(hosts.Content); i += 2 {
+			if deleteMappingKey(hosts.Content[i+1], "pre_connect") {
+				excluded = append(excluded, fmt.Sprintf("hosts.%s.pre_connect", hosts.Content[i].Value))
+			}
+		}
+	}
+	if audit := mappingValue(root, "audit"); audit != nil && deleteMappingKey(audit, "key_file") {
+		excluded = append(excluded, "audit.key_file")
+	}
+	if sources := mappingValue(root, "profile_sources"); sources != nil && sources.Kind == yaml.SequenceNode {
+		for i, source := range sources.Content {
+			for _, key := range []string{"url", "git"} {
+				value := mappingValue(source, key)
+				if value == nil || value.Kind != yaml.ScalarNode {
+					continue
+				}
+				// scp-style git remotes such as git@github.com:org/repo don't parse as URLs
+				if u, err := url.Parse(value.Value); err == nil && u.User != nil && (u.Scheme == "http" || u.Scheme == "https") {
+					u.User = nil
+					value.Value = u.String()
+					excluded = append(excluded, fmt.Sprintf("credentials in profile_sources[%d].%s", i, key))
+				}
+			}
+		}
+	}
+	return excluded
+}
+
+// importConfigBundle merges a config bundle into the kport config. Settings in
+// the bundle win, and settings only in the existing config, such as local
+// pre-connect commands, are kept. The previous config is saved next to it
+// with a .bak suffix. It returns the path of the config and of the backup, if
+// there was a config to back up.
+func importConfigBundle(bundle []byte) (path, backup string, err error) {
+	if _, err := parseKportConfig(bundle, "bundle"); err != nil {
+		return "", "", err
+	}
+	var incoming yaml.Node
+	if err := yaml.Unmarshal(bundle, &incoming); err != nil {
+		return "", "", fmt.Errorf("failed to parse config bundle: %w", err)
+	}
+	if len(incoming.Content) == 0 || incoming.Content[0].Kind != yaml.MappingNode {
+		return "", "", fmt.Errorf("config bundle holds no settings")
+	}
+	// The export note describes the bundle, not the merged config
+	incoming.HeadComment = ""
+
+	path, err = kportConfigPath()
+	if err != nil {
+		return "", "", err
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", "", fmt.Errorf("failed to read kport config %s: %w", path, err)
+	}
+
+	doc := &incoming
+	var current yaml.Node
+	if err := yaml.Unmarshal(existing, &current); err != nil {
+		return "", "", fmt.Errorf("failed to parse kport config %s: %w", path, err)
+	}
+	if len(current.Content) > 0 && current.Content[0].Kind == yaml.MappingNode {
+		mergeMappings(current.Content[0], incoming.Content[0])
+		doc = &current
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return "", "", fmt.Errorf("failed to encode kport config: %w", err)
+	}
+	encoder.Close()
+	if _, err := parseKportConfig(out.Bytes(), path); err != nil {
+		return "", "", fmt.Errorf("the imported config would be invalid: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", "", fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if existing != nil {
+		backup = path + ".bak"
+		if err := os.WriteFile(backup, existing, 0o600); err != nil {
+			return "", "", fmt.Errorf("failed to back up kport config: %w", err)
+		}
+	}
+	// Writing a temporary file first keeps a live config reload from seeing half a file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0o600); err != nil {
+		return "", "", fmt.Errorf("failed to write kport config: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", "", fmt.Errorf("failed to write kport config: %w", err)
+	}
+	return path, backup, nil
+}
+
+// mergeMappings merges the keys of src into dst, recursing into mappings both
+// have. Any other value of src, including lists, replaces the one in dst.
+func mergeMappings(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		existing := mappingValue(dst, key.Value)
+		switch {
+		case existing == nil:
+			dst.Content = append(dst.Content, key, value)
+		case existing.Kind == yaml.MappingNode && value.Kind == yaml.MappingNode:
+			mergeMappings(existing, value)
+		default:
+			*existing = *value
+		}
+	}
+}
+
+// mappingValue returns the value of key in a YAML mapping, or nil
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// deleteMappingKey removes key from a YAML mapping and reports whether it was there
+func deleteMappingKey(node *yaml.Node, key string) bool {
+	if node.Kind != yaml.MappingNode {
+		return false
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			node.Content = append(node.Content[:i], node.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// readConfigBundle reads a bundle from a file, or from stdin when path is -
+func readConfigBundle(path string) ([]byte, error) {
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config bundle from stdin: %w", err)
+		}
+		return data, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config bundle: %w", err)
+	}
+	return data, nil
+}
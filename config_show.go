@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// effectiveConfig returns the settings kport resolved for host as "key value"
+// lines in the style of `ssh -G`, followed by kport's own settings for it
+func effectiveConfig(host SSHHost, kc *KportConfig) []string {
+	lines := make([]string, 0)
+	add := func(key string, value any) {
+		if s := fmt.Sprint(value); s != "" {
+			lines = append(lines, key+" "+s)
+		}
+	}
+
+	switch {
+	case host.Inline:
+		lines = append(lines, fmt.Sprintf("# %s, not in any SSH config", host.Name))
+	case len(host.Sources) > 0:
+		lines = append(lines, fmt.Sprintf("# %s, defined at %s", host.Name, strings.Join(host.Sources, ", ")))
+	default:
+		lines = append(lines, "# "+host.Name)
+	}
+
+	add("host", host.Name)
+	add("description", host.Description)
+	for _, note := range host.Notes {
+		add("note", note.Key+": "+note.Value)
+	}
+	add("tags", strings.Join(host.Tags, ","))
+	add("hostname", host.Hostname)
+	add("addresses", strings.Join(host.Addresses, " "))
+	add("canonicalname", host.CanonicalName)
+	user := host.EffectiveUser()
+	if host.UserSource != "" {
+		user += " (from " + host.UserSource + ")"
+	}
+	add("user", user)
+	add("port", host.Port)
+	add("identityfile", host.Identity)
+	add("connecttimeout", host.ConnectTimeout)
+	add("bindaddress", host.BindAddress)
+	add("proxyjump", strings.Join(host.JumpChain, ","))
+	for _, forward := range host.Forwards {
+		kind := "localforward"
+		if forward.Remote {
+			kind = "remoteforward"
+		}
+		add(kind, strings.TrimSpace(forward.Listen+" "+forward.Target))
+	}
+
+	transport := host.Transport
+	if transport == "" {
+		transport = "ssh"
+	}
+	add("transport", transport)
+	if host.Transport == TransportSSM {
+		add("ssm_instance", host.SSM.InstanceID)
+	}
+	add("plugin", host.Plugin.Name)
+	add("strict_identities", host.StrictIdentities)
+	add("agent_key", agentKeyFor(host))
+	add("pre_connect", host.PreConnect)
+
+	hostConfig := kc.HostFor(host)
+	lines = append(lines, "", "# kport settings")
+	if hostConfig.Pinned {
+		add("pinned", true)
+	}
+	add("environment", host.Environment)
+	if len(hostConfig.Confirm) > 0 {
+		add("confirm", joinCategories(hostConfig.Confirm))
+	}
+	add("idle_timeout", *hostConfig.IdleTimeout)
+	if hostConfig.StreamIdleTimeout != nil {
+		add("stream_idle_timeout", *hostConfig.StreamIdleTimeout)
+	}
+	add("watch_interval", *hostConfig.WatchInterval)
+	add("notify", *hostConfig.Notify)
+	add("detect_timeout", *hostConfig.DetectTimeout)
+	if hostConfig.TTL != nil {
+		add("ttl", *hostConfig.TTL)
+	}
+	add("ipv6", hostConfig.IPv6 == nil || *hostConfig.IPv6)
+	if hostConfig.MaxChannels > 0 {
+		add("max_channels", hostConfig.MaxChannels)
+	}
+	if len(hostConfig.HTTPS) > 0 {
+		add("https", hostConfig.HTTPS)
+	}
+	if len(hostConfig.AccessLog) > 0 {
+		add("access_log", hostConfig.AccessLog)
+	}
+	if len(hostConfig.LAN) > 0 {
+		add("lan", hostConfig.LAN)
+		add("lan_address", hostConfig.LANAddress)
+	}
+	if len(host.AlgorithmOptions) > 0 {
+		add("algorithms", hostConfig.Algorithms.describe())
+	}
+	for _, port := range sortedKeys(hostConfig.ExtraPorts) {
+		add("extra_ports", fmt.Sprintf("%d %v", port, hostConfig.ExtraPorts[port]))
+	}
+	for _, port := range sortedKeys(hostConfig.Destinations) {
+		add("destinations", fmt.Sprintf("%d %s", port, hostConfig.Destinations[port]))
+	}
+	for _, port := range sortedKeys(hostConfig.Failover) {
+		add("failover", fmt.Sprintf("%d %s", port, strings.Join(hostConfig.Failover[port], " ")))
+	}
+
+	lines = append(lines, "", "# ssh command", strings.Join(sshCommand(host, nil).Args, " "))
+	return lines
+}
+
+// sortedKeys returns the ports of a per-port setting in order
+func sortedKeys[V any](m map[int]V) []int {
+	keys := make([]int, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// sshResolvedConfig runs `ssh -G` with the options kport passes for host and
+// returns the values of each setting ssh resolved, which include Match blocks
+// and token expansion that kport doesn't evaluate
+func sshResolvedConfig(host SSHHost) (map[string][]string, error) {
+	cmd := sshCommand(host, []string{"-G"})
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("ssh -G failed: %w: %s", err, message)
+		}
+		return nil, fmt.Errorf("ssh -G failed: %w", err)
+	}
+
+	settings := make(map[string][]string)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		settings[key] = append(settings[key], value)
+	}
+	return settings, nil
+}
+
+// configDifferences compares the connection settings kport resolved for host
+// with what `ssh -G` resolves, returning a line for each one they disagree on
+func configDifferences(host SSHHost, resolved map[string][]string) []string {
+	first := func(key string) string {
+		if values := resolved[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+
+	differences := make([]string, 0)
+	differ := func(key, kport, ssh string) {
+		differences = append(differences, fmt.Sprintf("%s %s (kport: %s)", key, ssh, kport))
+	}
+
+	// Inline hosts are handed to ssh by hostname
+	if !host.Inline && host.Hostname != "" && !strings.EqualFold(first("hostname"), host.Hostname) {
+		differ("hostname", host.Hostname, first("hostname"))
+	}
+	if user := host.EffectiveUser(); user != "" && first("user") != user {
+		differ("user", user, first("user"))
+	}
+	if host.Port != "" && first("port") != host.Port {
+		differ("port", host.Port, first("port"))
+	}
+	if host.Identity != "" && !slices.Contains(resolved["identityfile"], host.Identity) {
+		differ("identityfile", host.Identity, strings.Join(resolved["identityfile"], " "))
+	}
+	if host.ConnectTimeout != "" && first("connecttimeout") != host.ConnectTimeout {
+		differ("connecttimeout", host.ConnectTimeout, first("connecttimeout"))
+	}
+	if host.BindAddress != "" && first("bindaddress") != host.BindAddress {
+		differ("bindaddress", host.BindAddress, first("bindaddress"))
+	}
+	if host.ProxyJump != "" && first("proxyjump") != host.ProxyJump {
+		differ("proxyjump", host.ProxyJump, first("proxyjump"))
+	}
+	return differences
+}
+
+// showHostConfig prints the effective configuration of a host, and where
+// ssh itself resolves it differently
+func showHostConfig(host SSHHost, kc *KportConfig) {
+	for _, line := range effectiveConfig(host, kc) {
+		fmt.Println(line)
+	}
+
+	fmt.Println()
+	if _, err := exec.LookPath("ssh"); err != nil {
+		fmt.Println("# ssh isn't installed, so kport's view can't be compared with ssh -G")
+		return
+	}
+	resolved, err := sshResolvedConfig(host)
+	if err != nil {
+		fmt.Printf("# %v\n", err)
+		return
+	}
+	differences := configDifferences(host, resolved)
+	if len(differences) == 0 {
+		fmt.Println("# ssh -G agrees with kport")
+		return
+	}
+	fmt.Println("# ssh -G resolves differently, for example through a Match block")
+	for _, line := range differences {
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// configWatchInterval is how often the config files are checked for changes
+const configWatchInterval = 2 * time.Second
+
+// configCheckedMsg is sent after the config files have been checked for changes
+type configCheckedMsg struct {
+	Generation int
+	Changed    bool
+	Paths      []string
+	Stamp      string
+}
+
+// watchedConfigPaths returns the config files and the directories holding them,
+// so files added to an Include directory are noticed too
+func watchedConfigPaths(files []string) []string {
+	seen := make(map[string]bool)
+	paths := make([]string, 0, len(files)*2)
+	for _, file := range files {
+		for _, path := range []string{file, filepath.Dir(file)} {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// configStamp summarizes the modification times and sizes of paths
+func configStamp(paths []string) string {
+	var s strings.Builder
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(&s, "%s:missing\n", path)
+			continue
+		}
+		fmt.Fprintf(&s, "%s:%d:%d\n", path, info.ModTime().UnixNano(), info.Size())
+	}
+	return s.String()
+}
+
+// watchConfig checks the config files for changes after configWatchInterval
+func watchConfig(generation int, paths []string, stamp string) tea.Cmd {
+	return tea.Tick(configWatchInterval, func(time.Time) tea.Msg {
+		return configCheckedMsg{
+			Generation: generation,
+			Changed:    configStamp(paths) != stamp,
+			Paths:      paths,
+			Stamp:      stamp,
+		}
+	})
+}
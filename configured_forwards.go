@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// configuredForwardsTimeout is how long ssh may take to open the configured forwards
+	configuredForwardsTimeout = 30 * time.Second
+
+	// configuredForwardsGrace is how long ssh must stay up after its local
+	// forwards listen, since remote forwards are confirmed by the server later
+	configuredForwardsGrace = 2 * time.Second
+)
+
+// ConfiguredForward is a LocalForward or RemoteForward line of the SSH config
+type ConfiguredForward struct {
+	// Remote is set for RemoteForward, which listens on the remote host
+	Remote bool
+
+	// Listen is where the forward listens: [bind_address:]port or a socket path
+	Listen string
+
+	// Target is where connections go: host:hostport or a socket path, empty
+	// for a RemoteForward acting as a SOCKS proxy
+	Target string
+
+	// Source is the Host block of a forward inherited from a wildcard block
+	Source string
+}
+
+// parseConfiguredForward parses the value of a LocalForward or RemoteForward line
+func parseConfiguredForward(value string, remote bool) (ConfiguredForward, bool) {
+	fields := strings.Fields(value)
+	switch {
+	case len(fields) == 2:
+		return ConfiguredForward{Remote: remote, Listen: fields[0], Target: fields[1]}, true
+	case len(fields) == 1 && remote:
+		return ConfiguredForward{Remote: true, Listen: fields[0]}, true
+	}
+	return ConfiguredForward{}, false
+}
+
+// String describes the forward, such as "LocalForward 8080 -> db.internal:5432"
+func (cf ConfiguredForward) String() string {
+	if !cf.Remote {
+		return fmt.Sprintf("LocalForward %s -> %s", cf.Listen, cf.Target)
+	}
+	if cf.Target == "" {
+		return fmt.Sprintf("RemoteForward %s (SOCKS proxy)", cf.Listen)
+	}
+	return fmt.Sprintf("RemoteForward %s -> %s", cf.Listen, cf.Target)
+}
+
+// LocalPort returns the local TCP port a LocalForward listens on, or 0
+func (cf ConfiguredForward) LocalPort() int {
+	if cf.Remote {
+		return 0
+	}
+	return listenPort(cf.Listen)
+}
+
+// RemotePort returns the remote TCP port a RemoteForward listens on, or 0,
+// which also means the server picks one
+func (cf ConfiguredForward) RemotePort() int {
+	if !cf.Remote {
+		return 0
+	}
+	return listenPort(cf.Listen)
+}
+
+// listenPort returns the TCP port of a [bind_address:]port, or 0 for a socket path
+func listenPort(listen string) int {
+	if strings.Contains(listen, "/") {
+		return 0
+	}
+	if i := strings.LastIndex(listen, ":"); i >= 0 {
+		listen = listen[i+1:]
+	}
+	port, err := strconv.Atoi(listen)
+	if err != nil {
+		return 0
+	}
+	return port
+}
+
+// LocalAddress returns the local address a LocalForward listens on: its bind
+// address, with "*" and an empty one for every interface, or loopback when it
+// has none, as ssh binds without GatewayPorts
+func (cf ConfiguredForward) LocalAddress() string {
+	i := strings.LastIndex(cf.Listen, ":")
+	if i < 0 {
+		return "127.0.0.1"
+	}
+	switch address := strings.Trim(cf.Listen[:i], "[]"); address {
+	case "", "*":
+		return "0.0.0.0"
+	case "localhost":
+		return "127.0.0.1"
+	default:
+		return address
+	}
+}
+
+// applyPatternBlocks gives each host the forwards of the wildcard blocks
+// matching it or its canonical name, since ssh applies the forwards of every matching block, and
+// the ConnectTimeout and BindAddress of the first matching block that sets one
+func (sc *SSHConfig) applyPatternBlocks() {
+	for i := range sc.Hosts {
+		host := &sc.Hosts[i]
+		if isHostPattern(host.Name) {
+			continue
+		}
+		// ssh takes the first value it reads, which is one set before any Host line
+		if sc.bindAddress != "" {
+			host.BindAddress = sc.bindAddress
+		}
+		for _, block := range sc.Hosts {
+			if !blockApplies(block, *host) {
+				continue
+			}
+			for _, forward := range block.Forwards {
+				if forward.Source == "" {
+					forward.Source = "Host " + block.Name
+				}
+				host.Forwards = append(host.Forwards, forward)
+			}
+			if host.ConnectTimeout == "" {
+				host.ConnectTimeout = block.ConnectTimeout
+			}
+			if host.BindAddress == "" {
+				host.BindAddress = block.BindAddress
+			}
+		}
+	}
+}
+
+// forwardsRequested reports whether ssh options ask for port forwarding
+func forwardsRequested(options []string) bool {
+	return slices.ContainsFunc(options, func(option string) bool {
+		return option == "-L" || option == "-R" || option == "-D"
+	})
+}
+
+// exitOnForwardFailure makes ssh exit when one of kport's forwards fails. ssh
+// also opens the host's configured forwards, which fail while kport holds
+// their ports, so for such hosts kport notices failures on its own.
+func exitOnForwardFailure(host SSHHost) []string {
+	if len(host.Forwards) > 0 {
+		return nil
+	}
+	return []string{"-o", "ExitOnForwardFailure=yes"}
+}
+
+// ConfiguredForwardsMsg is sent when the configured forwards of a host are up or failed to start
+type ConfiguredForwardsMsg struct {
+	Host      string
+	Forwarder *ConfiguredForwarder
+	Err       error
+}
+
+// ConfiguredForwarder keeps an ssh connection open that establishes the
+// LocalForward and RemoteForward lines of a host's SSH config
+type ConfiguredForwarder struct {
+	host      SSHHost
+	sshCmd    *exec.Cmd
+	sshDone   chan struct{}
+	lastError string
+	isRunning bool
+	mu        sync.Mutex
+}
+
+// NewConfiguredForwarder creates a forwarder for the configured forwards of host
+func NewConfiguredForwarder(host SSHHost) *ConfiguredForwarder {
+	return &ConfiguredForwarder{host: host, sshDone: make(chan struct{})}
+}
+
+// Start connects to the host and waits until its configured forwards are open
+func (cf *ConfiguredForwarder) Start() error {
+	// ssh only says a remote forward failed, not who holds its port, so taken ports are looked up first
+	var remotePorts []int
+	for _, forward := range cf.host.Forwards {
+		if port := forward.RemotePort(); port > 0 {
+			remotePorts = append(remotePorts, port)
+		}
+	}
+	if err := checkRemotePorts(context.Background(), cf.host, remotePorts...); err != nil {
+		return fmt.Errorf("%w; change the port of its RemoteForward in the SSH config", err)
+	}
+
+	cf.mu.Lock()
+	if cf.isRunning {
+		cf.mu.Unlock()
+		return fmt.Errorf("configured forwards already running")
+	}
+
+	// A taken port usually means an ssh session to the host already holds the forwards
+	for _, forward := range cf.host.Forwards {
+		if port := forward.LocalPort(); port > 0 && !isAddressAvailable(forward.LocalAddress(), port) {
+			cf.mu.Unlock()
+			return fmt.Errorf("local port %d of %s is already in use, is another ssh session to %s open?", port, forward, cf.host.Name)
+		}
+	}
+
+	// ssh reads the forwards from the SSH config itself, so none are passed here.
+	// ClearAllForwardings=no wins over the yes sshCommand adds for forward-less commands.
+	cf.sshCmd = sshCommand(cf.host, []string{
+		"-o", "ClearAllForwardings=no",
+		"-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-o", "ServerAliveInterval=30",
+		"-o", "ServerAliveCountMax=3",
+	})
+	cf.sshCmd.Stderr = &lineWriter{fn: cf.handleStderr}
+
+	fmt.Fprintf(os.Stderr, "Debug: Starting configured forwards: %s\n", cf.sshCmd.String())
+
+	if err := cf.sshCmd.Start(); err != nil {
+		cf.mu.Unlock()
+		return fmt.Errorf("failed to start configured forwards: %w", err)
+	}
+	cf.isRunning = true
+	cf.mu.Unlock()
+
+	go func() {
+		if err := cf.sshCmd.Wait(); err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Configured forwards finished with error: %v\n", err)
+		}
+		close(cf.sshDone)
+	}()
+
+	if err := cf.waitEstablished(); err != nil {
+		cf.Stop()
+		return err
+	}
+	return nil
+}
+
+// waitEstablished waits until ssh listens on every local forward port and has
+// stayed up long enough for the server to reject a remote forward
+func (cf *ConfiguredForwarder) waitEstablished() error {
+	deadline := time.After(configuredForwardsTimeout)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !cf.localPortsHeld() {
+		select {
+		case <-cf.sshDone:
+			return cf.exitError()
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for the configured forwards of %s", cf.host.Name)
+		case <-ticker.C:
+		}
+	}
+
+	select {
+	case <-cf.sshDone:
+		return cf.exitError()
+	case <-time.After(configuredForwardsGrace):
+		return nil
+	}
+}
+
+// localPortsHeld reports whether every local TCP forward port is taken
+func (cf *ConfiguredForwarder) localPortsHeld() bool {
+	for _, forward := range cf.host.Forwards {
+		if port := forward.LocalPort(); port > 0 && isAddressAvailable(forward.LocalAddress(), port) {
+			return false
+		}
+	}
+	return true
+}
+
+// exitError describes why ssh exited before the forwards were established
+func (cf *ConfiguredForwarder) exitError() error {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	if cf.lastError != "" {
+		return fmt.Errorf("ssh to %s exited: %s", cf.host.Name, cf.lastError)
+	}
+	return fmt.Errorf("ssh to %s exited before the configured forwards were established", cf.host.Name)
+}
+
+// handleStderr keeps the last line ssh printed to explain a failure
+func (cf *ConfiguredForwarder) handleStderr(line string) {
+	fmt.Fprintf(os.Stderr, "Debug: ssh configured forwards %s: %s\n", cf.host.Name, line)
+	cf.mu.Lock()
+	cf.lastError = line
+	cf.mu.Unlock()
+}
+
+// Stop closes the ssh connection and with it the configured forwards
+func (cf *ConfiguredForwarder) Stop() {
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+
+	if !cf.isRunning {
+		return
+	}
+	cf.isRunning = false
+
+	if cf.sshCmd != nil && cf.sshCmd.Process != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Stopping configured forwards of %s\n", cf.host.Name)
+		cf.sshCmd.Process.Kill()
+	}
+}
+
+// IsRunning reports whether the ssh connection holding the forwards is still up
+func (cf *ConfiguredForwarder) IsRunning() bool {
+	select {
+	case <-cf.sshDone:
+		return false
+	default:
+	}
+	cf.mu.Lock()
+	defer cf.mu.Unlock()
+	return cf.isRunning
+}
+
+// Host returns the host whose forwards are established
+func (cf *ConfiguredForwarder) Host() SSHHost {
+	return cf.host
+}
+
+// StartConfiguredForwards establishes the configured forwards of host
+func StartConfiguredForwards(host SSHHost) tea.Cmd {
+	return func() tea.Msg {
+		host, err := prepareHost(host)
+		if err != nil {
+			return ConfiguredForwardsMsg{Host: host.Name, Err: err}
+		}
+
+		forwarder := NewConfiguredForwarder(host)
+		if err := forwarder.Start(); err != nil {
+			return ConfiguredForwardsMsg{Host: host.Name, Err: err}
+		}
+		return ConfiguredForwardsMsg{Host: host.Name, Forwarder: forwarder}
+	}
+}
+
+// StopConfiguredForwards closes configured forwards in the background
+func StopConfiguredForwards(forwarder *ConfiguredForwarder) tea.Cmd {
+	return func() tea.Msg {
+		forwarder.Stop()
+		return nil
+	}
+}
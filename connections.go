@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// streamingThreshold is how long a connection must stay open to count as streaming
+const streamingThreshold = 30 * time.Second
+
+// idleCheckInterval is how often proxied connections are checked for idle timeouts
+const idleCheckInterval = time.Second
+
+// copyBufferSize is the size of the buffers proxied data is copied through
+const copyBufferSize = 32 * 1024
+
+// copyBuffers are reused across connections, so a burst of short requests
+// doesn't allocate two fresh buffers per connection
+var copyBuffers = sync.Pool{New: func() any {
+	buf := make([]byte, copyBufferSize)
+	return &buf
+}}
+
+// Markers in the start of a response that tell upgraded and event stream connections apart
+var (
+	upgradeStatus     = []byte("HTTP/1.1 101")
+	eventStreamHeader = []byte("content-type: text/event-stream")
+	endOfHeaders      = []byte("\r\n\r\n")
+)
+
+// ConnKind classifies a proxied connection by how it is used
+type ConnKind int
+
+const (
+	ConnShort ConnKind = iota
+	ConnStreaming
+	ConnWebSocket
+)
+
+// String returns a human readable name for the connection kind
+func (k ConnKind) String() string {
+	switch k {
+	case ConnStreaming:
+		return "streaming"
+	case ConnWebSocket:
+		return "WebSocket"
+	default:
+		return "short"
+	}
+}
+
+// TrackedConn holds the state and diagnostics of a single proxied connection
+type TrackedConn struct {
+	client       net.Conn
+	remote       net.Conn
+	started      time.Time
+	lastActivity atomic.Int64
+	bytesIn      atomic.Int64
+	bytesOut     atomic.Int64
+	upgraded     atomic.Bool
+	eventStream  atomic.Bool
+	sniffOnce    sync.Once
+}
+
+// newTrackedConn starts tracking a proxied connection
+func newTrackedConn(client, remote net.Conn) *TrackedConn {
+	tc := &TrackedConn{
+		client:  client,
+		remote:  remote,
+		started: time.Now(),
+	}
+	tc.lastActivity.Store(tc.started.UnixNano())
+	return tc
+}
+
+// record updates the connection statistics with data flowing in one direction
+func (tc *TrackedConn) record(fromClient bool, data []byte) {
+	tc.lastActivity.Store(time.Now().UnixNano())
+
+	if fromClient {
+		tc.bytesOut.Add(int64(len(data)))
+		return
+	}
+	tc.bytesIn.Add(int64(len(data)))
+
+	// The start of the response tells upgraded and event stream connections apart
+	tc.sniffOnce.Do(func() {
+		if bytes.HasPrefix(data, upgradeStatus) {
+			tc.upgraded.Store(true)
+		}
+		headers := data
+		if end := bytes.Index(data, endOfHeaders); end >= 0 {
+			headers = data[:end]
+		}
+		if containsFold(headers, eventStreamHeader) {
+			tc.eventStream.Store(true)
+		}
+	})
+}
+
+// containsFold reports whether substr is within s, ignoring ASCII case, without
+// allocating a lowercased copy of s
+func containsFold(s, substr []byte) bool {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if bytes.EqualFold(s[i:i+len(substr)], substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Kind classifies the connection from its upgrade status, content type and duration
+func (tc *TrackedConn) Kind(now time.Time) ConnKind {
+	if tc.upgraded.Load() {
+		return ConnWebSocket
+	}
+	if tc.eventStream.Load() || now.Sub(tc.started) >= streamingThreshold {
+		return ConnStreaming
+	}
+	return ConnShort
+}
+
+// Idle returns how long no data has flowed in either direction
+func (tc *TrackedConn) Idle(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, tc.lastActivity.Load()))
+}
+
+// Close closes both sides of the connection
+func (tc *TrackedConn) Close() {
+	tc.client.Close()
+	tc.remote.Close()
+}
+
+// proxyCopy copies one direction of a proxied connection through a pooled
+// buffer, recording the data for statistics, capture and the access log on the way
+func proxyCopy(dst io.Writer, src io.Reader, tc *TrackedConn, cc *CaptureConn, ac *AccessConn, fromClient bool) {
+	bufp := copyBuffers.Get().(*[]byte)
+	defer copyBuffers.Put(bufp)
+	buf := *bufp
+
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			tc.record(fromClient, buf[:n])
+			if cc != nil {
+				cc.Record(fromClient, buf[:n])
+			}
+			if ac != nil {
+				ac.Record(fromClient, buf[:n])
+			}
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// ConnStats summarizes the proxied connections of a tunnel
+type ConnStats struct {
+	Active    int
+	Short     int
+	Streaming int
+	WebSocket int
+	Total     int64
+	BytesIn   int64
+	BytesOut  int64
+
+	// Channels are the SSH channels open on the tunnel's ssh client, and Queued
+	// the connections waiting for one because ChannelLimit was reached
+	Channels     int
+	Queued       int
+	ChannelLimit int
+	LimitLearned bool
+}
+
+// NearChannelLimit reports whether the open channels are close to the limit
+func (s ConnStats) NearChannelLimit() bool {
+	return nearLimit(s.Channels, s.ChannelLimit)
+}
+
+// ConnStats returns a summary of the tunnel's proxied connections
+func (pf *PortForwarder) ConnStats() ConnStats {
+	pf.connMu.Lock()
+	defer pf.connMu.Unlock()
+
+	now := time.Now()
+	stats := ConnStats{
+		Active:   len(pf.conns),
+		Total:    pf.totalConns,
+		BytesIn:  pf.doneBytesIn,
+		BytesOut: pf.doneBytesOut,
+	}
+	stats.Channels, stats.Queued, stats.ChannelLimit, stats.LimitLearned = pf.channels.stats()
+
+	for tc := range pf.conns {
+		switch tc.Kind(now) {
+		case ConnWebSocket:
+			stats.WebSocket++
+		case ConnStreaming:
+			stats.Streaming++
+		default:
+			stats.Short++
+		}
+		stats.BytesIn += tc.bytesIn.Load()
+		stats.BytesOut += tc.bytesOut.Load()
+	}
+
+	return stats
+}
+
+// idleTimeoutFor returns the idle timeout that applies to a connection of the given kind
+func (pf *PortForwarder) idleTimeoutFor(kind ConnKind) time.Duration {
+	if kind == ConnShort {
+		return pf.options.IdleTimeout
+	}
+	return pf.options.StreamIdleTimeout
+}
+
+// monitorIdle closes proxied connections that exceed their idle timeout
+func (pf *PortForwarder) monitorIdle() {
+	defer pf.wg.Done()
+
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pf.stopChan:
+			return
+		case now := <-ticker.C:
+			pf.connMu.Lock()
+			for tc := range pf.conns {
+				timeout := pf.idleTimeoutFor(tc.Kind(now))
+				if timeout > 0 && tc.Idle(now) >= timeout {
+					tc.Close()
+				}
+			}
+			pf.connMu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// containerNamePattern matches docker container names and IDs, which are safe to pass to a remote shell
+var containerNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// listContainersCommand lists the running containers as tab-separated ID, name, image and ports
+const listContainersCommand = `docker ps --format '{{.ID}}\t{{.Names}}\t{{.Image}}\t{{.Ports}}'`
+
+// containerPortsCommand lists the TCP sockets inside a container. /proc works in
+// images without netstat or ss.
+const containerPortsCommand = `docker exec %s cat /proc/net/tcp /proc/net/tcp6 2>/dev/null`
+
+// containerRelayScript prints how connections can be relayed into the container's
+// network: socat or nc inside the container, or nsenter and socat on the host
+const containerRelayScript = `c=%s
+if docker exec "$c" sh -c 'command -v socat' >/dev/null 2>&1; then echo socat
+elif docker exec "$c" sh -c 'command -v nc' >/dev/null 2>&1; then echo nc
+elif command -v nsenter >/dev/null 2>&1 && command -v socat >/dev/null 2>&1 && sudo -n true 2>/dev/null; then echo nsenter
+fi`
+
+// Container is a running docker container on a remote host
+type Container struct {
+	ID    string
+	Name  string
+	Image string
+
+	// Published maps container ports to the host ports they are published on
+	Published map[int]int
+}
+
+// ContainersListedMsg is sent when the containers of a host have been listed
+type ContainersListedMsg struct {
+	Host       string
+	Containers []Container
+	Err        error
+}
+
+// ContainerPortsDetectedMsg is sent when the ports inside a container have been detected
+type ContainerPortsDetectedMsg struct {
+	Host      string
+	Container string
+	Ports     []int
+	Err       error
+}
+
+// ListContainers lists the running docker containers on host
+func ListContainers(host SSHHost) tea.Cmd {
+	return func() tea.Msg {
+		ctx, span := tracer.Start(context.Background(), "kport.list_containers", trace.WithAttributes(hostAttributes(host)...))
+
+		host, err := tracedPrepareHost(ctx, host)
+		if err != nil {
+			endSpan(span, err)
+			return ContainersListedMsg{Host: host.Name, Err: err}
+		}
+
+		sshCmd := sshCommand(host, host.probeOptions(10), listContainersCommand)
+		output, err := tracedOutput(ctx, "docker ps", sshCmd)
+		endSpan(span, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Listing containers failed for %s: %v\n", host.Name, err)
+			return ContainersListedMsg{Host: host.Name, Err: fmt.Errorf("failed to list containers (is docker installed and usable by your user?): %w", err)}
+		}
+
+		return ContainersListedMsg{Host: host.Name, Containers: parseContainers(output)}
+	}
+}
+
+// parseContainers parses the output of listContainersCommand
+func parseContainers(output []byte) []Container {
+	containers := make([]Container, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 3 || !containerNamePattern.MatchString(fields[1]) {
+			continue
+		}
+		container := Container{ID: fields[0], Name: fields[1], Image: fields[2], Published: make(map[int]int)}
+		if len(fields) > 3 {
+			container.Published = parsePublishedPorts(fields[3])
+		}
+		containers = append(containers, container)
+	}
+
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Name < containers[j].Name })
+	return containers
+}
+
+// parsePublishedPorts parses docker's port column, such as
+// "0.0.0.0:8080->80/tcp, :::8080->80/tcp, 5432/tcp", into container to host ports
+func parsePublishedPorts(column string) map[int]int {
+	published := make(map[int]int)
+	for _, entry := range strings.Split(column, ",") {
+		hostAddr, containerAddr, ok := strings.Cut(strings.TrimSpace(entry), "->")
+		if !ok || !strings.HasSuffix(containerAddr, "/tcp") {
+			continue
+		}
+		containerPort, err := strconv.Atoi(strings.TrimSuffix(containerAddr, "/tcp"))
+		if err != nil {
+			continue
+		}
+		hostPort, err := strconv.Atoi(hostAddr[strings.LastIndex(hostAddr, ":")+1:])
+		if err != nil {
+			continue
+		}
+		published[containerPort] = hostPort
+	}
+	return published
+}
+
+// DetectContainerPorts detects the ports listening inside a container on host
+func DetectContainerPorts(host SSHHost, container string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, span := tracer.Start(context.Background(), "kport.detect_container_ports", trace.WithAttributes(append(hostAttributes(host),
+			attribute.String("kport.container", container),
+		)...))
+
+		host, err := tracedPrepareHost(ctx, host)
+		if err != nil {
+			endSpan(span, err)
+			return ContainerPortsDetectedMsg{Host: host.Name, Container: container, Err: err}
+		}
+
+		sshCmd := sshCommand(host, host.probeOptions(10), fmt.Sprintf(containerPortsCommand, container))
+		output, err := tracedOutput(ctx, "docker exec", sshCmd)
+		endSpan(span, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Detecting ports in container %s on %s failed: %v\n", container, host.Name, err)
+			return ContainerPortsDetectedMsg{Host: host.Name, Container: container, Err: fmt.Errorf("failed to detect ports in %s: %w", container, err)}
+		}
+
+		return ContainerPortsDetectedMsg{Host: host.Name, Container: container, Ports: parseProcNetTCP(output)}
+	}
+}
+
+// parseProcNetTCP returns the listening ports in the contents of /proc/net/tcp and tcp6
+func parseProcNetTCP(output []byte) []int {
+	ports := make([]int, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		// sl local_address rem_address st ...; state 0A is LISTEN
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || fields[3] != "0A" {
+			continue
+		}
+		_, portHex, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		if port, err := strconv.ParseInt(portHex, 16, 32); err == nil && port > 0 {
+			ports = append(ports, int(port))
+		}
+	}
+
+	ports = removeDuplicates(ports)
+	sort.Ints(ports)
+	return ports
+}
+
+// containerRelay picks how connections are relayed to port inside container and
+// returns the remote command that relays one connection over its stdin and stdout
+func containerRelay(ctx context.Context, host SSHHost, container string, port int) (string, error) {
+	if !containerNamePattern.MatchString(container) {
+		return "", fmt.Errorf("invalid container name: %s", container)
+	}
+
+	sshCmd := sshCommand(host, host.probeOptions(10), fmt.Sprintf(containerRelayScript, container))
+	output, err := tracedOutput(ctx, "container relay", sshCmd)
+	if err != nil {
+		return "", fmt.Errorf("failed to check container %s: %w", container, err)
+	}
+
+	switch strings.TrimSpace(string(output)) {
+	case "socat":
+		return fmt.Sprintf("docker exec -i %s socat - TCP:127.0.0.1:%d", container, port), nil
+	case "nc":
+		return fmt.Sprintf("docker exec -i %s nc 127.0.0.1 %d", container, port), nil
+	case "nsenter":
+		return fmt.Sprintf(`sudo -n nsenter -t "$(docker inspect -f '{{.State.Pid}}' %s)" -n socat - TCP:127.0.0.1:%d`, container, port), nil
+	default:
+		return "", fmt.Errorf("container %s has neither socat nor nc, and the host can't use nsenter with socat through passwordless sudo", container)
+	}
+}
+
+// execConn is a connection over the stdin and stdout of a command
+type execConn struct {
+	cmd       *exec.Cmd
+	started   time.Time
+	stdin     io.WriteCloser
+	stdout    io.ReadCloser
+	closeOnce sync.Once
+}
+
+// dialExec starts cmd and returns a connection to it
+func dialExec(cmd *exec.Cmd) (*execConn, error) {
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &execConn{cmd: cmd, started: time.Now(), stdin: stdin, stdout: stdout}, nil
+}
+
+// Read reads from the command's stdout
+func (ec *execConn) Read(p []byte) (int, error) {
+	return ec.stdout.Read(p)
+}
+
+// Write writes to the command's stdin
+func (ec *execConn) Write(p []byte) (int, error) {
+	return ec.stdin.Write(p)
+}
+
+// CloseWrite closes the command's stdin so it sees EOF
+func (ec *execConn) CloseWrite() error {
+	return ec.stdin.Close()
+}
+
+// Close stops the command
+func (ec *execConn) Close() error {
+	ec.closeOnce.Do(func() {
+		ec.stdin.Close()
+		if ec.cmd.Process != nil {
+			ec.cmd.Process.Kill()
+		}
+		ec.cmd.Wait()
+		// The command ends when kport closes the connection, which isn't a failure
+		recordRemoteCommand(ec.cmd, "relay connection", ec.started, nil)
+	})
+	return nil
+}
+
+// execAddr is the address of an execConn
+type execAddr struct{}
+
+func (execAddr) Network() string { return "exec" }
+func (execAddr) String() string  { return "exec" }
+
+// LocalAddr and RemoteAddr satisfy net.Conn
+func (ec *execConn) LocalAddr() net.Addr  { return execAddr{} }
+func (ec *execConn) RemoteAddr() net.Addr { return execAddr{} }
+
+// Deadlines are not supported on commands
+func (ec *execConn) SetDeadline(time.Time) error      { return nil }
+func (ec *execConn) SetReadDeadline(time.Time) error  { return nil }
+func (ec *execConn) SetWriteDeadline(time.Time) error { return nil }
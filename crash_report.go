@@ -0,0 +1,213 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"gopkg.in/yaml.v3"
+)
+
+// logRingSize is how much recent log output is kept for crash reports
+const logRingSize = 64 * 1024
+
+// ringBuffer keeps the most recent bytes written to it
+type ringBuffer struct {
+	mu   sync.Mutex
+	data []byte
+	size int
+}
+
+// logRing holds recent debug output and events for crash reports
+var logRing = &ringBuffer{size: logRingSize}
+
+// Write appends p, dropping the oldest output beyond the buffer size
+func (rb *ringBuffer) Write(p []byte) (int, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.data = append(rb.data, p...)
+	if len(rb.data) > rb.size {
+		rb.data = append([]byte(nil), rb.data[len(rb.data)-rb.size:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the buffered output
+func (rb *ringBuffer) String() string {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	return string(rb.data)
+}
+
+// captureStderr copies everything written to os.Stderr into logRing, and
+// also to the original stderr when echo is set. The returned function restores it.
+func captureStderr(echo bool) (func(), error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to capture stderr: %w", err)
+	}
+
+	original := os.Stderr
+	os.Stderr = w
+
+	done := make(chan struct{})
+	go func() {
+		var sink io.Writer = logRing
+		if echo {
+			sink = io.MultiWriter(original, logRing)
+		}
+		io.Copy(sink, r)
+		close(done)
+	}()
+
+	return func() {
+		os.Stderr = original
+		w.Close()
+		<-done
+	}, nil
+}
+
+// crashReportsDir returns the directory crash reports are written to
+func crashReportsDir() (string, error) {
+	return kportCacheDir("reports")
+}
+
+// writeCrashReport writes a diagnostic bundle to the reports directory and returns its path.
+// stack is the stack of the failing goroutine and may be nil.
+func writeCrashReport(reason string, stack []byte) (string, error) {
+	dir, err := crashReportsDir()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("kport-%s.txt", now.Format("20060102-150405.000")))
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "kport crash report\n")
+	fmt.Fprintf(&s, "Time: %s\n", now.Format(time.RFC3339))
+	fmt.Fprintf(&s, "Reason: %s\n", reason)
+	fmt.Fprintf(&s, "Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if info, ok := debug.ReadBuildInfo(); ok {
+		fmt.Fprintf(&s, "Version: %s\n", info.Main.Version)
+	}
+
+	if len(stack) > 0 {
+		fmt.Fprintf(&s, "\n== Stack ==\n%s\n", stack)
+	}
+
+	fmt.Fprintf(&s, "\n== Goroutines ==\n%s\n", allGoroutines())
+	fmt.Fprintf(&s, "\n== Recent log ==\n%s\n", logRing.String())
+	fmt.Fprintf(&s, "\n== Config ==\n%s\n", configSummary())
+
+	if err := os.WriteFile(path, []byte(s.String()), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write crash report: %w", err)
+	}
+	return path, nil
+}
+
+// allGoroutines returns the stacks of all goroutines
+func allGoroutines() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+}
+
+// configSummary describes the loaded hosts and kport config with secrets redacted
+func configSummary() string {
+	var s strings.Builder
+
+	if hosts, err := loadHostSnapshot(); err == nil {
+		fmt.Fprintf(&s, "Hosts (%d):\n", len(hosts))
+		for _, host := range hosts {
+			transport := host.Transport
+			if transport == "" {
+				transport = "ssh"
+			}
+			fmt.Fprintf(&s, "  %s: %s@%s:%s via %s\n", host.Name, host.User, host.Hostname, host.Port, transport)
+		}
+	} else {
+		fmt.Fprintf(&s, "Hosts: unavailable (%v)\n", err)
+	}
+
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		fmt.Fprintf(&s, "kport config: unavailable (%v)\n", err)
+		return s.String()
+	}
+
+	// Pre-connect hooks can embed tokens, so only record whether one is set
+	redacted := *kportConfig
+	redacted.Hosts = make(map[string]HostConfig, len(kportConfig.Hosts))
+	for name, hostConfig := range kportConfig.Hosts {
+		if hostConfig.PreConnect != "" {
+			hostConfig.PreConnect = "[redacted]"
+		}
+		redacted.Hosts[name] = hostConfig
+	}
+
+	data, err := yaml.Marshal(&redacted)
+	if err != nil {
+		fmt.Fprintf(&s, "kport config: unavailable (%v)\n", err)
+		return s.String()
+	}
+	fmt.Fprintf(&s, "kport config:\n%s", data)
+	return s.String()
+}
+
+// lastCrashReport is the path of the report written for a TUI panic
+var lastCrashReport string
+
+// crashGuard wraps a model and writes a crash report when it panics.
+// The panic is re-raised so Bubble Tea still restores the terminal.
+type crashGuard struct {
+	model tea.Model
+}
+
+// recoverWithReport writes a crash report for a panic and panics again
+func recoverWithReport() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	path, err := writeCrashReport(fmt.Sprintf("panic: %v", r), debug.Stack())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to write crash report: %v\n", err)
+	} else {
+		lastCrashReport = path
+	}
+	panic(r)
+}
+
+// Init initializes the wrapped model
+func (cg crashGuard) Init() tea.Cmd {
+	defer recoverWithReport()
+	return cg.model.Init()
+}
+
+// Update updates the wrapped model
+func (cg crashGuard) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	defer recoverWithReport()
+	model, cmd := cg.model.Update(msg)
+	return crashGuard{model: model}, cmd
+}
+
+// View renders the wrapped model
+func (cg crashGuard) View() string {
+	defer recoverWithReport()
+	return cg.model.View()
+}
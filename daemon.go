@@ -0,0 +1,525 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// daemonStartTimeout bounds how long a client waits for an auto-started daemon
+const daemonStartTimeout = 5 * time.Second
+
+// DaemonRequest is a command sent to the daemon over its socket
+type DaemonRequest struct {
+	Command string `json:"command"`
+	Profile string `json:"profile,omitempty"`
+
+	// Args fill in the parameters of a profile template
+	Args map[string]string `json:"args,omitempty"`
+
+	// Handoff lists the tunnels a TUI moves to the background
+	Handoff []HandoffTunnel `json:"handoff,omitempty"`
+
+	// Progress asks for a response per step of the tunnels of a profile
+	// coming up, ahead of the final one
+	Progress bool `json:"progress,omitempty"`
+
+	// Tunnel selects a tunnel by its ID, name or local port
+	Tunnel string `json:"tunnel,omitempty"`
+}
+
+// DaemonResponse is the daemon's reply to a request
+type DaemonResponse struct {
+	Error   string         `json:"error,omitempty"`
+	Tunnels []TunnelStatus `json:"tunnels,omitempty"`
+
+	// Failures are the tunnels of a profile that failed while the rest came up
+	Failures string `json:"failures,omitempty"`
+
+	// Progress is set on the responses streamed while a profile comes up
+	Progress *TunnelProgress `json:"progress,omitempty"`
+
+	// Files is the daemon's open files and their limit, where they can be read
+	Files *FileUsage `json:"files,omitempty"`
+
+	// Resources is the daemon's memory and goroutines
+	Resources *ResourceUsage `json:"resources,omitempty"`
+
+	// Throughput is the traffic of a tunnel sampled over its lifetime
+	Throughput *TunnelThroughput `json:"throughput,omitempty"`
+}
+
+// TunnelStatus describes a tunnel run by the daemon
+type TunnelStatus struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name,omitempty"`
+	Profile      string    `json:"profile"`
+	Host         string    `json:"host"`
+	LocalPort    int       `json:"local_port"`
+	Socket       string    `json:"socket,omitempty"`
+	RemotePort   int       `json:"remote_port"`
+	Listen       []string  `json:"listen"`
+	Running      bool      `json:"running"`
+	ActiveConns  int       `json:"active_conns"`
+	TotalConns   int64     `json:"total_conns"`
+	BytesIn      int64     `json:"bytes_in"`
+	BytesOut     int64     `json:"bytes_out"`
+	StartedAt    time.Time `json:"started_at"`
+	Channels     int       `json:"channels"`
+	Queued       int       `json:"queued,omitempty"`
+	ChannelLimit int       `json:"channel_limit,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+	Errors       []string  `json:"errors,omitempty"`
+	Health       string    `json:"health,omitempty"`
+	RemoteDown   string    `json:"remote_down,omitempty"`
+	Sockets      int       `json:"sockets"`
+	Goroutines   int       `json:"goroutines,omitempty"`
+}
+
+// LocalAddress is where local clients connect to the tunnel
+func (s TunnelStatus) LocalAddress() string {
+	if s.Socket != "" {
+		return s.Socket
+	}
+	return fmt.Sprintf("localhost:%d", s.LocalPort)
+}
+
+// label is the tunnel's profile, followed by its name when it has one
+func (s TunnelStatus) label() string {
+	if s.Name != "" {
+		return s.Profile + "/" + s.Name
+	}
+	return s.Profile
+}
+
+// State describes how the tunnel is doing: up, remote down or closed
+func (s TunnelStatus) State() string {
+	switch {
+	case !s.Running:
+		return "closed"
+	case s.RemoteDown != "":
+		return "remote down"
+	}
+	return "up"
+}
+
+// Daemon runs profile tunnels in the background and serves requests on a unix socket
+type Daemon struct {
+	manager  *TunnelManager
+	listener net.Listener
+
+	// webhook is set when the daemon accepts webhook calls
+	webhook *webhookServer
+}
+
+// daemonPath returns the path of a daemon file in kport's cache directory
+func daemonPath(name string) (string, error) {
+	dir, err := kportCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// RunDaemon runs the daemon in the foreground until it is signalled or asked to shut down
+func RunDaemon() error {
+	releaseLock, err := acquireDaemonLock()
+	if err != nil {
+		return err
+	}
+	defer releaseLock()
+
+	socketPath, err := daemonPath("daemon.sock")
+	if err != nil {
+		return err
+	}
+
+	// Holding the lock means any existing socket was left by a daemon that died
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	defer os.Remove(socketPath)
+	os.Chmod(socketPath, 0o600)
+
+	d := &Daemon{
+		manager:  NewTunnelManager(),
+		listener: listener,
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	// Profiling, the dashboard and webhooks are set up once; changing them takes a daemon restart
+	if kportConfig, err := LoadKportConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to load kport config: %v\n", err)
+	} else {
+		setFileLimit(kportConfig.FileLimit)
+		if kportConfig.Pprof != "" {
+			stopPprof, err := startPprof(kportConfig.Pprof)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Debug: %v\n", err)
+			} else {
+				defer stopPprof()
+				fmt.Fprintf(os.Stderr, "Debug: Serving pprof on http://%s/debug/pprof/\n", kportConfig.Pprof)
+			}
+		}
+		if kportConfig.Dashboard != "" {
+			stopDashboard, err := startDashboard(kportConfig.Dashboard, d.manager)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Debug: %v\n", err)
+			} else {
+				defer stopDashboard()
+				fmt.Fprintf(os.Stderr, "Debug: Serving dashboard on http://%s/\n", kportConfig.Dashboard)
+			}
+		}
+		if kportConfig.Webhook.Listen != "" {
+			setAuditConfig(kportConfig.Audit)
+			stopWebhook, err := startWebhook(kportConfig.Webhook, d)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Debug: %v\n", err)
+			} else {
+				defer stopWebhook()
+				fmt.Fprintf(os.Stderr, "Debug: Accepting webhook calls on http://%s/\n", kportConfig.Webhook.Listen)
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Debug: Daemon listening on %s (pid %d)\n", socketPath, os.Getpid())
+	logEvent("daemon started (pid %d)", os.Getpid())
+
+	stopWatch := make(chan struct{})
+	defer close(stopWatch)
+	go watchFileUsage(stopWatch)
+
+	d.serve()
+
+	d.manager.StopAll()
+	logEvent("daemon stopped (pid %d)", os.Getpid())
+	return nil
+}
+
+// serve handles requests until the listener is closed
+func (d *Daemon) serve() {
+	for {
+		conn, err := d.listener.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(conn)
+	}
+}
+
+// handle answers a single request
+func (d *Daemon) handle(conn net.Conn) {
+	defer conn.Close()
+
+	var req DaemonRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		json.NewEncoder(conn).Encode(DaemonResponse{Error: fmt.Sprintf("invalid request: %v", err)})
+		return
+	}
+
+	encoder := json.NewEncoder(conn)
+	var progress func(TunnelProgress)
+	if req.Progress {
+		var mu sync.Mutex
+		progress = func(update TunnelProgress) {
+			mu.Lock()
+			defer mu.Unlock()
+			encoder.Encode(DaemonResponse{Progress: &update})
+		}
+	}
+
+	resp := d.dispatch(req, progress)
+	if err := encoder.Encode(resp); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to send daemon response: %v\n", err)
+	}
+
+	if req.Command == "shutdown" {
+		d.listener.Close()
+	}
+}
+
+// dispatch runs a request and builds the response, passing the progress of
+// bringing up a profile to progress when it isn't nil
+func (d *Daemon) dispatch(req DaemonRequest, progress func(TunnelProgress)) DaemonResponse {
+	switch req.Command {
+	case "ping", "shutdown":
+		return DaemonResponse{}
+	case "status":
+		resp := DaemonResponse{Tunnels: tunnelStatuses(d.manager.Tunnels())}
+		if usage, ok := currentFileUsage(); ok {
+			resp.Files = &usage
+		}
+		resources := currentResourceUsage()
+		resp.Resources = &resources
+		return resp
+	case "up":
+		tunnels, err := d.up(req.Profile, req.Args, progress)
+		switch {
+		case err != nil && len(tunnels) == 0:
+			return DaemonResponse{Error: err.Error()}
+		case err != nil:
+			return DaemonResponse{Tunnels: tunnelStatuses(tunnels), Failures: err.Error()}
+		}
+		return DaemonResponse{Tunnels: tunnelStatuses(tunnels)}
+	case "handoff":
+		tunnels, err := d.handoff(req.Handoff)
+		if err != nil {
+			return DaemonResponse{Error: err.Error()}
+		}
+		return DaemonResponse{Tunnels: tunnelStatuses(tunnels)}
+	case "throughput":
+		tunnel, err := d.manager.Find(req.Tunnel)
+		if err != nil {
+			return DaemonResponse{Error: err.Error()}
+		}
+		throughput := tunnel.Forwarder.Throughput()
+		return DaemonResponse{Throughput: &throughput}
+	case "stats":
+		tunnel, err := d.manager.Find(req.Tunnel)
+		if err != nil {
+			return DaemonResponse{Error: err.Error()}
+		}
+		return DaemonResponse{Tunnels: tunnelStatuses([]*Tunnel{tunnel})}
+	case "stop":
+		tunnel, err := d.manager.Find(req.Tunnel)
+		if err != nil {
+			return DaemonResponse{Error: err.Error()}
+		}
+		d.manager.Stop(tunnel.Forwarder)
+		return DaemonResponse{Tunnels: tunnelStatuses([]*Tunnel{tunnel})}
+	case "down":
+		if d.webhook != nil {
+			d.webhook.forget(req.Profile)
+		}
+		if d.manager.Down(req.Profile) == 0 {
+			return DaemonResponse{Error: fmt.Sprintf("profile %q is not up", req.Profile)}
+		}
+		return DaemonResponse{}
+	default:
+		return DaemonResponse{Error: fmt.Sprintf("unknown command %q", req.Command)}
+	}
+}
+
+// up brings up a profile using freshly loaded configs
+func (d *Daemon) up(name string, args map[string]string, progress func(TunnelProgress)) ([]*Tunnel, error) {
+	sshConfig := NewSSHConfig()
+	if err := sshConfig.LoadConfig(); err != nil {
+		return nil, err
+	}
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		return nil, err
+	}
+	setAuditConfig(kportConfig.Audit)
+	setPolicy(kportConfig.Policy)
+	setStrictModes(kportConfig.StrictModes)
+	setFileLimit(kportConfig.FileLimit)
+
+	profile, err := kportConfig.Profile(name, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.manager.Up(profileInstanceName(name, args), profile, collectHosts(sshConfig, kportConfig), kportConfig, progress)
+}
+
+// tunnelStatuses describes tunnels for a daemon response
+func tunnelStatuses(tunnels []*Tunnel) []TunnelStatus {
+	statuses := make([]TunnelStatus, 0, len(tunnels))
+	resources := currentResourceUsage()
+	for _, tunnel := range tunnels {
+		pf := tunnel.Forwarder
+		stats := pf.ConnStats()
+		tunnelErrors := make([]string, 0)
+		for _, err := range pf.Errors() {
+			tunnelErrors = append(tunnelErrors, err.String())
+		}
+		var remoteDown string
+		if since, err := pf.RemoteDown(); !since.IsZero() {
+			remoteDown = fmt.Sprintf("not listening since %s: %v", since.Format(time.Kitchen), err)
+		}
+		statuses = append(statuses, TunnelStatus{
+			ID:           tunnel.ID,
+			Name:         tunnel.Name,
+			Profile:      tunnel.Profile,
+			Host:         pf.Host().Name,
+			LocalPort:    pf.LocalPort(),
+			Socket:       pf.LocalSocket(),
+			RemotePort:   pf.RemotePort(),
+			Listen:       pf.ListenAddrs(),
+			Running:      pf.IsRunning(),
+			ActiveConns:  stats.Active,
+			TotalConns:   stats.Total,
+			BytesIn:      stats.BytesIn,
+			BytesOut:     stats.BytesOut,
+			StartedAt:    pf.StartedAt(),
+			Channels:     stats.Channels,
+			Queued:       stats.Queued,
+			ChannelLimit: stats.ChannelLimit,
+			ExpiresAt:    pf.ExpiresAt(),
+			Errors:       tunnelErrors,
+			Health:       tunnel.Health,
+			RemoteDown:   remoteDown,
+			Sockets:      pf.OpenSockets(),
+			Goroutines:   resources.TunnelGoroutines[pf.LocalPort()],
+		})
+	}
+	return statuses
+}
+
+// acquireDaemonLock creates the daemon lock file holding this process's pid.
+// A lock held by a process that no longer exists is taken over.
+func acquireDaemonLock() (func(), error) {
+	path, err := daemonPath("daemon.lock")
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to create daemon lock: %w", err)
+		}
+
+		if pid, ok := lockOwner(path); ok && processAlive(pid) {
+			return nil, fmt.Errorf("daemon already running (pid %d)", pid)
+		}
+		os.Remove(path)
+	}
+
+	return nil, fmt.Errorf("failed to acquire daemon lock %s", path)
+}
+
+// lockOwner returns the pid recorded in a lock file
+func lockOwner(path string) (int, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, false
+	}
+	return pid, true
+}
+
+// dialDaemon connects to the daemon's socket
+func dialDaemon() (net.Conn, error) {
+	socketPath, err := daemonPath("daemon.sock")
+	if err != nil {
+		return nil, err
+	}
+	return net.Dial("unix", socketPath)
+}
+
+// startDaemon starts the daemon as a detached background process
+func startDaemon() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate kport executable: %w", err)
+	}
+
+	logPath, err := daemonPath("daemon.log")
+	if err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open daemon log: %w", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, "--daemon")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start daemon: %w", err)
+	}
+	return cmd.Process.Release()
+}
+
+// connectDaemon connects to the daemon, starting it first when autoStart is set
+func connectDaemon(autoStart bool) (net.Conn, error) {
+	conn, err := dialDaemon()
+	if err == nil || !autoStart {
+		return conn, err
+	}
+
+	if err := startDaemon(); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(daemonStartTimeout)
+	for time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+		if conn, err = dialDaemon(); err == nil {
+			return conn, nil
+		}
+	}
+
+	logPath, _ := daemonPath("daemon.log")
+	return nil, fmt.Errorf("daemon did not start within %s, see %s", daemonStartTimeout, logPath)
+}
+
+// callDaemon sends a request to the daemon and waits for the response
+func callDaemon(req DaemonRequest, autoStart bool) (DaemonResponse, error) {
+	return callDaemonWithProgress(req, autoStart, nil)
+}
+
+// callDaemonWithProgress sends a request to the daemon and waits for the
+// response, passing the progress the daemon streams ahead of it to progress
+func callDaemonWithProgress(req DaemonRequest, autoStart bool, progress func(TunnelProgress)) (DaemonResponse, error) {
+	conn, err := connectDaemon(autoStart)
+	if err != nil {
+		return DaemonResponse{}, err
+	}
+	defer conn.Close()
+
+	req.Progress = progress != nil
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return DaemonResponse{}, fmt.Errorf("failed to send request to daemon: %w", err)
+	}
+
+	decoder := json.NewDecoder(conn)
+	for {
+		var resp DaemonResponse
+		if err := decoder.Decode(&resp); err != nil {
+			return DaemonResponse{}, fmt.Errorf("failed to read daemon response: %w", err)
+		}
+		if resp.Progress != nil {
+			progress(*resp.Progress)
+			continue
+		}
+		if resp.Error != "" {
+			return resp, errors.New(resp.Error)
+		}
+		return resp, nil
+	}
+}
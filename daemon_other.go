@@ -0,0 +1,19 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachedProcAttr returns no special attributes on platforms without sessions
+func detachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// processAlive reports whether a process with the given pid exists
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
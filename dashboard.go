@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"time"
+)
+
+// dashboardRefresh is how often the dashboard page reloads itself, in seconds
+const dashboardRefresh = 5
+
+// dashboardPage lists the daemon's tunnels and the tunnels of other kport instances
+var dashboardPage = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"age": func(t time.Time) string {
+		return formatAge(time.Since(t))
+	},
+	"countdown": func(t time.Time) string {
+		return formatCountdown(time.Until(t).Round(time.Second))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>kport tunnels</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { text-align: left; padding: 0.4em 0.8em; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { background: #f4f2fd; }
+.up { color: #04803f; font-weight: bold; }
+.down { color: #c4214f; font-weight: bold; }
+.dim { color: #777; }
+ul { margin: 0; padding-left: 1.2em; }
+</style>
+</head>
+<body>
+<h1>kport tunnels</h1>
+<p class="dim">Updated {{.Now.Format "15:04:05"}}, refreshing every {{.Refresh}} seconds. <a href="/api/tunnels">JSON</a></p>
+
+<h2>Daemon</h2>
+{{if .Tunnels}}
+<table>
+<tr><th>ID</th><th>Profile</th><th>Tunnel</th><th>State</th><th>Up for</th><th>Connections</th><th>Traffic</th><th>Problems</th></tr>
+{{range .Tunnels}}
+<tr>
+<td>{{.ID}}</td>
+<td>{{.Profile}}{{if .Name}}<br><span class="dim">{{.Name}}</span>{{end}}</td>
+<td>{{.LocalAddress}} &rarr; {{.Host}}:{{.RemotePort}}{{if .Health}}<br><span class="dim">health {{.Health}}</span>{{end}}</td>
+<td class="{{if eq .State "up"}}up{{else}}down{{end}}">{{.State}}</td>
+<td>{{age .StartedAt}}{{if and .Running (not .ExpiresAt.IsZero)}}<br><span class="dim">closes in {{countdown .ExpiresAt}}</span>{{end}}</td>
+<td>{{.ActiveConns}} active, {{.TotalConns}} total<br><span class="dim">{{.Sockets}} sockets</span>{{if .ChannelLimit}}<br><span class="dim">{{.Channels}} of {{.ChannelLimit}} channels{{if .Queued}}, {{.Queued}} queued{{end}}</span>{{end}}</td>
+<td>&darr; {{bytes .BytesIn}} &uarr; {{bytes .BytesOut}}</td>
+<td>{{if or .RemoteDown .Errors}}<ul>{{if .RemoteDown}}<li>remote service {{.RemoteDown}}</li>{{end}}{{range .Errors}}<li>{{.}}</li>{{end}}</ul>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No tunnels are up.</p>
+{{end}}
+
+{{if .Others}}
+<h2>Other kport instances</h2>
+<table>
+<tr><th>Tunnel</th><th>PID</th><th>Up for</th></tr>
+{{range .Others}}
+<tr><td>localhost:{{.Port}} &rarr; {{.Host}}:{{.RemotePort}}</td><td>{{.PID}}</td><td>{{age .Since}}</td></tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+// startDashboard serves a read-only web page of the daemon's tunnels on addr,
+// which must be a loopback address. It returns a function that stops the server.
+func startDashboard(addr string, manager *TunnelManager) (func(), error) {
+	listener, err := listenLoopback(addr, "dashboard")
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		data := struct {
+			Now     time.Time
+			Refresh int
+			Tunnels []TunnelStatus
+			Others  []PortReservation
+		}{time.Now(), dashboardRefresh, tunnelStatuses(manager.Tunnels()), otherReservations()}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardPage.Execute(w, data); err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to render dashboard: %v\n", err)
+		}
+	})
+	mux.HandleFunc("GET /api/tunnels", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tunnelStatuses(manager.Tunnels()))
+	})
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "Debug: Dashboard server stopped: %v\n", err)
+		}
+	}()
+
+	return func() { server.Close() }, nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// demoEnv holds the demo directory in demo mode. The ssh on the PATH is then
+// kport itself, which answers from the canned hosts instead of connecting.
+const demoEnv = "KPORT_DEMO"
+
+// demoTrafficInterval is how often demo mode sends a request through each of its tunnels
+const demoTrafficInterval = 2 * time.Second
+
+// demoPort is a service listening on a demo host
+type demoPort struct {
+	Port    int
+	Address string
+	Process string
+
+	// Protocol is what the service speaks: http, redis, or a line echo when empty
+	Protocol string
+
+	// Title and Headers are the page title and extra response headers of an
+	// http service, the title defaulting to the process and host
+	Title   string
+	Headers map[string]string
+
+	// Banner is sent to each new connection of a line echo service
+	Banner string
+}
+
+// demoContainer is a docker container running on a demo host
+type demoContainer struct {
+	ID        string
+	Name      string
+	Image     string
+	Published string
+	Ports     []demoPort
+}
+
+// demoHost is a canned host of demo mode
+type demoHost struct {
+	Name      string
+	Hostname  string
+	User      string
+	ProxyJump string
+
+	// Notes are the "key: value" comments above the host's Host block
+	Notes []string
+
+	OS         string
+	Kernel     string
+	Uptime     string
+	Ports      []demoPort
+	Containers []demoContainer
+
+	// Unreachable is what ssh fails with instead of connecting
+	Unreachable string
+
+	// HostKeys are the only host key algorithms the host offers when they are
+	// legacy ones, so ssh only connects once they are enabled
+	HostKeys []string
+}
+
+// demoHostKeyAlgorithms are the host key algorithms demo ssh proposes by default
+const demoHostKeyAlgorithms = "ssh-ed25519,ecdsa-sha2-nistp256,rsa-sha2-512,rsa-sha2-256"
+
+// demoHosts are the hosts demo mode lists, with the services running on them
+var demoHosts = []demoHost{
+	{
+		Name:     "web-1",
+		Hostname: "10.0.1.11",
+		User:     "deploy",
+		Notes:    []string{"desc: Storefront web server", "owner: web-team"},
+		OS:       "Ubuntu 24.04.1 LTS",
+		Kernel:   "Linux 6.8.0-45-generic",
+		Uptime:   "10:32:01 up 41 days,  3:12,  1 user,  load average: 0.31, 0.27, 0.22",
+		Ports: []demoPort{
+			{Port: 22, Address: "0.0.0.0", Process: "sshd", Banner: "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13.5"},
+			{Port: 80, Address: "0.0.0.0", Process: "nginx", Protocol: "http"},
+			{Port: 3000, Address: "127.0.0.1", Process: "node", Protocol: "http"},
+			{Port: 5432, Address: "127.0.0.1", Process: "postgres"},
+			{Port: 6379, Address: "127.0.0.1", Process: "redis-server", Protocol: "redis"},
+			{Port: 9090, Address: "0.0.0.0", Process: "prometheus", Protocol: "http", Title: "Prometheus Time Series Collection and Processing Server"},
+		},
+		Containers: []demoContainer{
+			{
+				ID:        "3f2a9c1e7b44",
+				Name:      "api",
+				Image:     "ghcr.io/acme/api:1.4.2",
+				Published: "0.0.0.0:8081->8080/tcp, :::8081->8080/tcp",
+				Ports:     []demoPort{{Port: 8080, Process: "api", Protocol: "http"}},
+			},
+			{
+				ID:    "91bd04c2aa10",
+				Name:  "worker",
+				Image: "ghcr.io/acme/worker:1.4.2",
+				Ports: []demoPort{{Port: 9000, Process: "worker", Protocol: "http"}},
+			},
+		},
+	},
+	{
+		Name:     "api-prod",
+		Hostname: "10.0.2.20",
+		User:     "deploy",
+		Notes:    []string{"desc: Production API", "owner: platform", "env: prod"},
+		OS:       "Debian GNU/Linux 12 (bookworm)",
+		Kernel:   "Linux 6.1.0-25-amd64",
+		Uptime:   "10:32:01 up 118 days, 22:40,  0 users,  load average: 1.84, 1.62, 1.55",
+		Ports: []demoPort{
+			{Port: 22, Address: "0.0.0.0", Process: "sshd", Banner: "SSH-2.0-OpenSSH_9.2p1 Debian-2+deb12u3"},
+			{Port: 443, Address: "0.0.0.0", Process: "envoy", Protocol: "http"},
+			{Port: 8080, Address: "127.0.0.1", Process: "java", Protocol: "http", Title: "Dashboard [Jenkins]", Headers: map[string]string{"X-Jenkins": "2.462.3"}},
+			{Port: 8500, Address: "127.0.0.1", Process: "consul", Protocol: "http"},
+			{Port: 9100, Address: "[::]", Process: "node_exporter", Protocol: "http"},
+		},
+	},
+	{
+		Name:     "data-1",
+		Hostname: "10.0.3.5",
+		User:     "analyst",
+		Notes:    []string{"desc: Analytics database"},
+		OS:       "Rocky Linux 9.4 (Blue Onyx)",
+		Kernel:   "Linux 5.14.0-427.13.1.el9_4.x86_64",
+		Uptime:   "10:32:01 up 9 days,  1:05,  2 users,  load average: 3.12, 2.98, 2.41",
+		Ports: []demoPort{
+			{Port: 22, Address: "0.0.0.0", Process: "sshd", Banner: "SSH-2.0-OpenSSH_8.7"},
+			{Port: 3000, Address: "0.0.0.0", Process: "grafana", Protocol: "http", Title: "Grafana"},
+			{Port: 5432, Address: "127.0.0.1", Process: "postgres"},
+			{Port: 8123, Address: "127.0.0.1", Process: "clickhouse-server", Protocol: "http"},
+			{Port: 9000, Address: "127.0.0.1", Process: "clickhouse-server"},
+		},
+	},
+	{
+		Name:     "bastion",
+		Hostname: "203.0.113.10",
+		User:     "jump",
+		Notes:    []string{"desc: Jump host for staging"},
+		OS:       "Alpine Linux v3.20",
+		Kernel:   "Linux 6.6.49-0-virt",
+		Uptime:   "10:32:01 up 63 days,  7:51,  0 users,  load average: 0.02, 0.03, 0.00",
+		Ports: []demoPort{
+			{Port: 22, Address: "0.0.0.0", Process: "sshd", Banner: "SSH-2.0-OpenSSH_9.7"},
+		},
+	},
+	{
+		Name:      "staging",
+		Hostname:  "10.1.0.8",
+		User:      "dev",
+		ProxyJump: "bastion",
+		Notes:     []string{"desc: Staging app, behind the bastion"},
+		OS:        "Ubuntu 22.04.4 LTS",
+		Kernel:    "Linux 5.15.0-119-generic",
+		Uptime:    "10:32:01 up 2 days,  4:18,  3 users,  load average: 0.54, 0.61, 0.58",
+		Ports: []demoPort{
+			{Port: 22, Address: "0.0.0.0", Process: "sshd", Banner: "SSH-2.0-OpenSSH_8.9p1 Ubuntu-3ubuntu0.10"},
+			{Port: 5173, Address: "127.0.0.1", Process: "node", Protocol: "http"},
+			{Port: 8000, Address: "0.0.0.0", Process: "uvicorn", Protocol: "http"},
+			{Port: 8025, Address: "127.0.0.1", Process: "mailhog", Protocol: "http", Title: "MailHog"},
+		},
+	},
+	{
+		Name:     "core-switch",
+		Hostname: "10.0.9.2",
+		User:     "admin",
+		Notes:    []string{"desc: Core switch with an old SSH server"},
+		OS:       "OpenWrt 19.07.10",
+		Kernel:   "Linux 4.14.275",
+		Uptime:   "10:32:01 up 412 days,  6:02,  0 users,  load average: 0.08, 0.04, 0.01",
+		Ports: []demoPort{
+			{Port: 22, Address: "0.0.0.0", Process: "dropbear", Banner: "SSH-2.0-dropbear_2017.75"},
+			{Port: 80, Address: "0.0.0.0", Process: "uhttpd", Protocol: "http", Title: "Switch Management"},
+		},
+		HostKeys: []string{"ssh-rsa", "ssh-dss"},
+	},
+	{
+		Name:        "legacy-router",
+		Hostname:    "10.0.9.1",
+		User:        "admin",
+		Notes:       []string{"desc: Decommissioned, kept to show connection errors"},
+		Unreachable: "ssh: connect to host 10.0.9.1 port 22: Connection timed out",
+	},
+}
+
+// demoKportConfig is the kport config of demo mode
+const demoKportConfig = `probe_http: true
+prewarm:
+  enabled: true
+  persist: 10m
+hosts:
+  web-1:
+    pinned: true
+profiles:
+  storefront:
+    tunnels:
+      - host: web-1
+        remote_port: 3000
+      - host: web-1
+        remote_port: 5432
+  analytics:
+    tunnels:
+      - host: data-1
+        remote_port: 3000
+      - host: data-1
+        remote_port: 8123
+`
+
+// findDemoHost returns the demo host ssh was pointed at, by name or address
+func findDemoHost(destination string) (demoHost, bool) {
+	// ssh takes user@host, and the user doesn't matter here
+	if i := strings.LastIndex(destination, "@"); i >= 0 {
+		destination = destination[i+1:]
+	}
+	for _, host := range demoHosts {
+		if host.Name == destination || host.Hostname == destination {
+			return host, true
+		}
+	}
+	return demoHost{}, false
+}
+
+// port returns the service listening on port, if any
+func (h demoHost) port(port int) (demoPort, bool) {
+	for _, p := range h.Ports {
+		if p.Port == port {
+			return p, true
+		}
+	}
+	return demoPort{}, false
+}
+
+// container returns the container called name, if any
+func (h demoHost) container(name string) (demoContainer, bool) {
+	for _, c := range h.Containers {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return demoContainer{}, false
+}
+
+// demoSSHConfig returns the SSH config listing the demo hosts
+func demoSSHConfig() string {
+	var s strings.Builder
+	for i, host := range demoHosts {
+		if i > 0 {
+			s.WriteString("\n")
+		}
+		for _, note := range host.Notes {
+			fmt.Fprintf(&s, "# %s\n", note)
+		}
+		fmt.Fprintf(&s, "Host %s\n    HostName %s\n    User %s\n", host.Name, host.Hostname, host.User)
+		if host.ProxyJump != "" {
+			fmt.Fprintf(&s, "    ProxyJump %s\n", host.ProxyJump)
+		}
+		if host.Unreachable != "" {
+			s.WriteString("    ConnectTimeout 3\n")
+		}
+	}
+	return s.String()
+}
+
+// defaultDemoDir returns where demo mode keeps its state, shared by the
+// commands and daemon of one user's demo
+func defaultDemoDir() string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("kport-demo-%d", os.Getuid()))
+}
+
+// startDemo switches this process, and every process it starts, to demo
+// mode: the home and config directories move to dir, which gets the demo SSH
+// and kport configs, and ssh is replaced by kport answering from the canned
+// hosts. Tunnels opened by this process then see requests every few seconds.
+func startDemo(dir string) error {
+	home := filepath.Join(dir, "home")
+	bin := filepath.Join(dir, "bin")
+	for _, path := range []string{filepath.Join(home, ".ssh"), bin} {
+		if err := os.MkdirAll(path, 0o700); err != nil {
+			return fmt.Errorf("failed to create demo directory: %w", err)
+		}
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	name := "ssh"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	shim := filepath.Join(bin, name)
+	os.Remove(shim)
+	if err := os.Symlink(executable, shim); err != nil {
+		return fmt.Errorf("failed to put the demo ssh on the PATH: %w", err)
+	}
+
+	env := map[string]string{
+		"HOME":            home,
+		"USERPROFILE":     home,
+		"XDG_CONFIG_HOME": filepath.Join(dir, "config"),
+		"XDG_CACHE_HOME":  filepath.Join(dir, "cache"),
+		"PATH":            bin + string(os.PathListSeparator) + os.Getenv("PATH"),
+		demoEnv:           dir,
+	}
+	for key, value := range env {
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "config"), []byte(demoSSHConfig()), 0o600); err != nil {
+		return fmt.Errorf("failed to write the demo SSH config: %w", err)
+	}
+	configDir, err := kportConfigDir()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.yaml"), []byte(demoKportConfig), 0o600); err != nil {
+		return fmt.Errorf("failed to write the demo kport config: %w", err)
+	}
+
+	go demoTraffic()
+	return nil
+}
+
+// demoTraffic keeps the tunnels of this process busy with a request through
+// each one every demoTrafficInterval, so their statistics have something to show
+func demoTraffic() {
+	for range time.Tick(demoTrafficInterval) {
+		var ports []int
+		err := updatePortRegistry(func(reservations []PortReservation) ([]PortReservation, error) {
+			for _, reservation := range reservations {
+				if reservation.PID == os.Getpid() {
+					ports = append(ports, reservation.Port)
+				}
+			}
+			return reservations, nil
+		})
+		if err != nil {
+			continue
+		}
+		for _, port := range ports {
+			go demoRequest(port)
+		}
+	}
+}
+
+// demoRequest sends one request to a local tunnel port and reads the answer
+func demoRequest(port int) {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), time.Second)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: localhost:%d\r\nUser-Agent: kport-demo\r\nConnection: close\r\n\r\n", port)
+	io.Copy(io.Discard, conn)
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// demoMasterEnv is set for the demo ssh master that runs detached when
+// ControlPersist keeps it open
+const demoMasterEnv = "KPORT_DEMO_MASTER"
+
+// demoSSHValueFlags are the ssh options that take a value
+const demoSSHValueFlags = "BbcDEeFIiJLlmOoPpQRSWw"
+
+// demoSSHArgs is an ssh command line as the demo ssh understands it
+type demoSSHArgs struct {
+	destination string
+	command     string
+	user        string
+	local       []string
+	remote      []string
+	stdio       string
+	controlPath string
+	master      bool
+	query       string
+	control     string
+	persist     time.Duration
+	hostKeys    string
+	printConfig bool
+	version     bool
+	verbose     bool
+}
+
+// parseDemoSSHArgs parses ssh's options, the destination and the remote command
+func parseDemoSSHArgs(args []string) demoSSHArgs {
+	var a demoSSHArgs
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || arg[0] != '-' {
+			a.destination = arg
+			a.command = strings.Join(args[i+1:], " ")
+			break
+		}
+
+		flag := arg[1]
+		if !strings.ContainsRune(demoSSHValueFlags, rune(flag)) {
+			for _, f := range arg[1:] {
+				switch f {
+				case 'M':
+					a.master = true
+				case 'G':
+					a.printConfig = true
+				case 'V':
+					a.version = true
+				case 'v':
+					a.verbose = true
+				}
+			}
+			continue
+		}
+
+		value := arg[2:]
+		if value == "" && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		switch flag {
+		case 'L':
+			a.local = append(a.local, value)
+		case 'R':
+			a.remote = append(a.remote, value)
+		case 'W':
+			a.stdio = value
+		case 'S':
+			a.controlPath = value
+		case 'Q':
+			a.query = value
+		case 'O':
+			a.control = value
+		case 'o':
+			key, setting, _ := strings.Cut(value, "=")
+			switch {
+			case strings.EqualFold(key, "ControlPersist"):
+				seconds, _ := strconv.Atoi(setting)
+				a.persist = time.Duration(seconds) * time.Second
+			case strings.EqualFold(key, "HostKeyAlgorithms") && a.hostKeys == "":
+				a.hostKeys = setting
+			}
+		case 'l':
+			// ssh uses the first user it is given
+			if a.user == "" {
+				a.user = value
+			}
+		}
+	}
+	return a
+}
+
+// runDemoSSH stands in for ssh in demo mode, answering kport's commands and
+// serving its forwards from the canned hosts. It returns ssh's exit status.
+func runDemoSSH(args []string) int {
+	a := parseDemoSSHArgs(args)
+	switch {
+	case a.version:
+		fmt.Fprintln(os.Stderr, "OpenSSH_9.6p1 (kport demo)")
+		return 0
+	case a.query != "":
+		fmt.Fprintln(os.Stderr, "kport demo: ssh -Q isn't available")
+		return 1
+	}
+
+	host, ok := findDemoHost(a.destination)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ssh: Could not resolve hostname %s: Name or service not known\n", a.destination)
+		return 255
+	}
+	if a.printConfig {
+		printDemoConfig(host, a)
+		return 0
+	}
+	if host.Unreachable != "" {
+		time.Sleep(time.Second)
+		fmt.Fprintln(os.Stderr, host.Unreachable)
+		return 255
+	}
+	if len(host.HostKeys) > 0 && !slices.ContainsFunc(host.HostKeys, func(key string) bool {
+		return slices.Contains(demoProposedHostKeys(a.hostKeys), key)
+	}) {
+		fmt.Fprintf(os.Stderr, "Unable to negotiate with %s port 22: no matching host key type found. Their offer: %s\n",
+			host.Hostname, strings.Join(host.HostKeys, ","))
+		return 255
+	}
+
+	switch {
+	case a.control != "":
+		return demoControl(a.control, a.controlPath)
+	case a.stdio != "":
+		destination, portStr, err := net.SplitHostPort(a.stdio)
+		port, _ := strconv.Atoi(portStr)
+		service, ok := demoService(host, destination, port)
+		if err != nil || !ok {
+			fmt.Fprintf(os.Stderr, "channel 0: open failed: connect failed: Connection refused\nstdio forwarding failed\n")
+			return 255
+		}
+		serveDemo(demoStdio(), host, service)
+		return 0
+	case a.command != "":
+		demoHandshake(host, a)
+		return runDemoCommand(host, a.command)
+	}
+
+	// Without a command the connection holds its forwards until it is stopped
+	for _, spec := range a.local {
+		if err := demoForwardLocal(host, spec); err != nil {
+			fmt.Fprintf(os.Stderr, "bind [%s]: %v\nCould not request local forwarding.\n", spec, err)
+			return 255
+		}
+	}
+	for _, spec := range a.remote {
+		demoForwardRemote(spec)
+	}
+	exit := make(chan struct{})
+	var persist <-chan time.Time
+	if a.master && a.controlPath != "" {
+		// Like ssh, a persistent master goes to the background once it is connected
+		if a.persist > 0 && os.Getenv(demoMasterEnv) == "" {
+			return detachDemoMaster(args, a.controlPath)
+		}
+		if listener, err := net.Listen("unix", a.controlPath); err == nil {
+			defer listener.Close()
+			go serveDemoControl(listener, exit)
+		}
+		if a.persist > 0 {
+			persist = time.After(a.persist)
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case <-signals:
+	case <-exit:
+	case <-persist:
+	}
+	return 0
+}
+
+// demoHandshake pauses between the steps of connecting to host like a real
+// handshake, printing the debug lines of ssh -v on the way when verbose. A
+// master that is up on the control path is reused without a handshake.
+func demoHandshake(host demoHost, a demoSSHArgs) {
+	if a.controlPath != "" {
+		if _, err := os.Stat(a.controlPath); err == nil {
+			if a.verbose {
+				fmt.Fprintln(os.Stderr, "debug1: mux_client_request_session: master session id: 2")
+			}
+			return
+		}
+	}
+
+	steps := []string{
+		fmt.Sprintf("Connecting to %s [%s] port 22.", host.Name, host.Hostname),
+		"Connection established.",
+		"Remote protocol version 2.0, remote software version OpenSSH_9.6p1",
+		"SSH2_MSG_KEXINIT sent",
+		"SSH2_MSG_NEWKEYS received",
+		"Authentications that can continue: publickey",
+		fmt.Sprintf("Authenticated to %s ([%s]:22) using \"publickey\".", host.Name, host.Hostname),
+		"channel 0: new session [client-session] (inactive timeout: 0)",
+		"Sending command: " + a.command,
+	}
+	for _, step := range steps {
+		if a.verbose {
+			fmt.Fprintf(os.Stderr, "debug1: %s\n", step)
+		}
+		time.Sleep(time.Duration(20+rand.Intn(40)) * time.Millisecond)
+	}
+}
+
+// detachDemoMaster runs the demo ssh master in its own session and returns
+// once its control socket is up
+func detachDemoMaster(args []string, controlPath string) int {
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 255
+	}
+	cmd := exec.Command(executable, args...)
+	cmd.Args[0] = "ssh"
+	cmd.Env = append(os.Environ(), demoMasterEnv+"=1")
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 255
+	}
+	cmd.Process.Release()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		if _, err := os.Stat(controlPath); err == nil {
+			return 0
+		}
+	}
+	fmt.Fprintf(os.Stderr, "ControlSocket %s was not created\n", controlPath)
+	return 255
+}
+
+// serveDemoControl answers the -O commands sent to a demo master, closing
+// exit when it is told to exit
+func serveDemoControl(listener net.Listener, exit chan struct{}) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		command, _ := bufio.NewReader(conn).ReadString('\n')
+		fmt.Fprintf(conn, "Master running (pid=%d)\n", os.Getpid())
+		conn.Close()
+		if strings.TrimSpace(command) == "exit" {
+			close(exit)
+			return
+		}
+	}
+}
+
+// demoControl sends an -O command to the demo master on the control socket at path
+func demoControl(command, path string) int {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Control socket connect(%s): %v\n", path, err)
+		return 255
+	}
+	defer conn.Close()
+	fmt.Fprintln(conn, command)
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	if command == "check" {
+		fmt.Fprint(os.Stderr, reply)
+	} else {
+		fmt.Fprintf(os.Stderr, "Exit request sent.\n")
+	}
+	return 0
+}
+
+// printDemoConfig prints a demo host's settings the way `ssh -G` does
+func printDemoConfig(host demoHost, a demoSSHArgs) {
+	user := a.user
+	if user == "" {
+		user = host.User
+	}
+	fmt.Printf("host %s\nhostname %s\nuser %s\nport 22\n", host.Name, host.Hostname, user)
+	if host.Unreachable != "" {
+		fmt.Println("connecttimeout 3")
+	} else {
+		fmt.Println("connecttimeout none")
+	}
+	if host.ProxyJump != "" {
+		fmt.Printf("proxyjump %s\n", host.ProxyJump)
+	}
+	fmt.Println("identityfile ~/.ssh/id_ed25519")
+	fmt.Printf("hostkeyalgorithms %s\n", strings.Join(demoProposedHostKeys(a.hostKeys), ","))
+}
+
+// demoProposedHostKeys resolves a HostKeyAlgorithms option against the demo
+// defaults, where "+names" adds to them like it does for ssh
+func demoProposedHostKeys(option string) []string {
+	switch {
+	case option == "":
+		return strings.Split(demoHostKeyAlgorithms, ",")
+	case strings.HasPrefix(option, "+"):
+		return strings.Split(demoHostKeyAlgorithms+","+option[1:], ",")
+	default:
+		return strings.Split(option, ",")
+	}
+}
+
+// formatPrefix returns the part of a format string before its first verb, for
+// recognizing commands kport builds from it
+func formatPrefix(format string) string {
+	if i := strings.Index(format, "%"); i >= 0 {
+		return format[:i]
+	}
+	return format
+}
+
+// runDemoCommand answers a remote command kport runs
+func runDemoCommand(host demoHost, command string) int {
+	switch {
+	case command == hostFactsScript:
+		fmt.Printf("@os\n%s\n@kernel\n%s\n@uptime\n%s\n", host.OS, host.Kernel, host.Uptime)
+		fmt.Printf("@disk\n/dev/root        78G   31G   47G  40%% /\n@ports\n%d\n", len(host.Ports))
+	case strings.HasPrefix(command, "netstat -tlnp"), strings.HasPrefix(command, "ss -tlnp"), strings.HasPrefix(command, "lsof -i"):
+		for _, p := range host.Ports {
+			fmt.Printf("%d %s %s\n", p.Port, p.Address, p.Process)
+		}
+	case command == listContainersCommand:
+		for _, c := range host.Containers {
+			fmt.Printf("%s\t%s\t%s\t%s\n", c.ID, c.Name, c.Image, c.Published)
+		}
+	case strings.HasPrefix(command, "docker exec -i "):
+		// A relay into a container: docker exec -i <name> socat - TCP:127.0.0.1:<port>
+		fields := strings.Fields(command)
+		container, ok := host.container(fields[3])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error response from daemon: No such container: %s\n", fields[3])
+			return 1
+		}
+		port, _ := strconv.Atoi(fields[len(fields)-1][strings.LastIndex(fields[len(fields)-1], ":")+1:])
+		for _, service := range container.Ports {
+			if service.Port == port {
+				serveDemo(demoStdio(), host, service)
+				return 0
+			}
+		}
+		fmt.Fprintf(os.Stderr, "socat: connect(5, AF=2 127.0.0.1:%d, 16): Connection refused\n", port)
+		return 1
+	case strings.HasPrefix(command, formatPrefix(containerPortsCommand)):
+		container, ok := host.container(strings.Fields(command)[2])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Error response from daemon: No such container: %s\n", strings.Fields(command)[2])
+			return 1
+		}
+		fmt.Println("  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode")
+		for i, p := range container.Ports {
+			fmt.Printf("   %d: 00000000:%04X 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 %d 1\n", i, p.Port, 40000+i)
+		}
+	case strings.HasPrefix(command, formatPrefix(containerRelayScript)):
+		fmt.Println("socat")
+	case strings.HasPrefix(command, formatPrefix(httpProbeScript)):
+		list, _, _ := strings.Cut(command[strings.LastIndex(command, "for p in ")+len("for p in "):], ";")
+		for _, field := range strings.Fields(list) {
+			port, _ := strconv.Atoi(field)
+			fmt.Printf("@port %d\n", port)
+			if service, ok := host.port(port); ok && service.Protocol == "http" {
+				request, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1/", nil)
+				var response bytes.Buffer
+				demoHTTPResponse(host, service, request, 1).Write(&response)
+				os.Stdout.Write(response.Bytes())
+				fmt.Println()
+			}
+		}
+	case command == "true":
+	case strings.HasPrefix(command, "echo "):
+		fmt.Println(strings.TrimPrefix(command, "echo "))
+	default:
+		fmt.Fprintf(os.Stderr, "kport demo: %s has no canned answer for this command\n", host.Name)
+		return 127
+	}
+	return 0
+}
+
+// demoService returns the service a forward to destination:port reaches from
+// host: one of its own, or one of another demo host
+func demoService(host demoHost, destination string, port int) (demoPort, bool) {
+	destination = strings.Trim(destination, "[]")
+	if ip := net.ParseIP(destination); destination == "localhost" || ip != nil && (ip.IsLoopback() || ip.IsUnspecified()) {
+		return host.port(port)
+	}
+	if other, ok := findDemoHost(destination); ok && other.Unreachable == "" {
+		return other.port(port)
+	}
+	return demoPort{}, false
+}
+
+// demoForwardLocal listens on the local side of an -L forward, given as
+// [bind:]port:destination:port, and serves the destination's canned service
+func demoForwardLocal(host demoHost, spec string) error {
+	i := strings.LastIndex(spec, ":")
+	port, err := strconv.Atoi(spec[i+1:])
+	if err != nil {
+		return fmt.Errorf("invalid forward %s", spec)
+	}
+	j := strings.LastIndex(spec[:i], ":")
+	if strings.HasSuffix(spec[:i], "]") {
+		j = strings.LastIndex(spec[:i], "[") - 1
+	}
+	if j < 0 {
+		return fmt.Errorf("invalid forward %s", spec)
+	}
+	listen, destination := spec[:j], spec[j+1:i]
+	if !strings.Contains(listen, ":") {
+		listen = "127.0.0.1:" + listen
+	}
+
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	service, ok := demoService(host, destination, port)
+	go func() {
+		for channel := 2; ; channel++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if !ok {
+				fmt.Fprintf(os.Stderr, "channel %d: open failed: connect failed: Connection refused\n", channel)
+				conn.Close()
+				continue
+			}
+			go func() {
+				defer conn.Close()
+				serveDemo(conn, host, service)
+			}()
+		}
+	}()
+	return nil
+}
+
+// demoForwardRemote reports the port of an -R forward, given as
+// [bind:]port:destination:port, that asks the server to pick one
+func demoForwardRemote(spec string) {
+	fields := strings.Split(spec, ":")
+	if len(fields) < 3 || fields[len(fields)-3] != "0" {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Allocated port %d for remote forward to %s:%s\n",
+		30000+rand.Intn(10000), fields[len(fields)-2], fields[len(fields)-1])
+}
+
+// demoStdio is ssh's stdin and stdout as one connection
+func demoStdio() io.ReadWriter {
+	return struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}
+}
+
+// serveDemo serves a canned service on one connection until the client closes it
+func serveDemo(rw io.ReadWriter, host demoHost, service demoPort) {
+	reader := bufio.NewReader(rw)
+	switch service.Protocol {
+	case "http":
+		for n := 1; ; n++ {
+			request, err := http.ReadRequest(reader)
+			if err != nil {
+				return
+			}
+			io.Copy(io.Discard, request.Body)
+			if err := demoHTTPResponse(host, service, request, n).Write(rw); err != nil || request.Close {
+				return
+			}
+		}
+	case "redis":
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if strings.EqualFold(strings.TrimSpace(line), "PING") {
+				io.WriteString(rw, "+PONG\r\n")
+			} else {
+				io.WriteString(rw, "-ERR the kport demo only answers PING\r\n")
+			}
+		}
+	default:
+		if service.Banner != "" {
+			io.WriteString(rw, service.Banner+"\r\n")
+		}
+		io.Copy(rw, reader)
+	}
+}
+
+// demoHTTPResponse is the canned page of an HTTP service, for the nth request of a connection
+func demoHTTPResponse(host demoHost, service demoPort, request *http.Request, n int) *http.Response {
+	title := service.Title
+	if title == "" {
+		title = fmt.Sprintf("%s on %s", service.Process, host.Name)
+	}
+	body := fmt.Sprintf("<!doctype html>\n<title>%s</title>\n<h1>%s on %s</h1>\n<p>%s %s, request %d of this connection, answered by the kport demo.</p>\n",
+		title, service.Process, host.Name, request.Method, request.URL.Path, n)
+	header := http.Header{
+		"Server":       {service.Process},
+		"Content-Type": {"text/html; charset=utf-8"},
+	}
+	for name, value := range service.Headers {
+		header.Set(name, value)
+	}
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       request,
+		Close:         request.Close,
+	}
+}
+
+// isDemoSSH reports whether this process was started as the ssh of demo mode
+func isDemoSSH() bool {
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	return name == "ssh" && os.Getenv(demoEnv) != ""
+}
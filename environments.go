@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// EnvironmentConfig presets how kport treats the hosts of an environment,
+// such as prod, which hosts join through their tags
+type EnvironmentConfig struct {
+	// Tags mark a host as part of the environment, defaulting to its name
+	Tags []string `yaml:"tags"`
+
+	// Color is the color of the header and host list badge, such as "#FF5F87"
+	Color string `yaml:"color"`
+
+	// Confirm lists the port categories whose tunnels ask for a typed
+	// confirmation, such as database. Hosts may set their own.
+	Confirm []PortCategory `yaml:"confirm"`
+
+	// IdleTimeout and StreamIdleTimeout replace the global idle timeouts for
+	// the environment's hosts, unless a host sets its own
+	IdleTimeout       *time.Duration `yaml:"idle_timeout"`
+	StreamIdleTimeout *time.Duration `yaml:"stream_idle_timeout"`
+}
+
+// builtinEnvironments are the presets environments of the same name in the
+// kport config change, setting by setting
+var builtinEnvironments = map[string]EnvironmentConfig{
+	"prod": {
+		Tags:              []string{"prod", "production"},
+		Color:             "#FF5F87",
+		Confirm:           []PortCategory{CategoryDatabase},
+		IdleTimeout:       durationPtr(10 * time.Minute),
+		StreamIdleTimeout: durationPtr(time.Hour),
+	},
+	"staging": {
+		Tags:  []string{"staging", "stage"},
+		Color: "#FFA500",
+	},
+	"dev": {
+		Tags:  []string{"dev", "development"},
+		Color: "#04B575",
+	},
+}
+
+// builtinEnvironmentOrder is the order hosts are matched against the built-in
+// presets, strictest first, ahead of the other environments by name
+var builtinEnvironmentOrder = []string{"prod", "staging", "dev"}
+
+// durationPtr returns a pointer to d, for the optional durations of presets
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
+
+// Environment returns the settings of an environment, with the built-in
+// preset of the same name filling in what the kport config leaves unset
+func (kc *KportConfig) Environment(name string) (EnvironmentConfig, bool) {
+	builtin, isBuiltin := builtinEnvironments[name]
+	env, configured := kc.Environments[name]
+	if !isBuiltin && !configured {
+		return EnvironmentConfig{}, false
+	}
+
+	if env.Tags == nil {
+		env.Tags = builtin.Tags
+	}
+	if env.Tags == nil {
+		env.Tags = []string{name}
+	}
+	if env.Color == "" {
+		env.Color = builtin.Color
+	}
+	if env.Confirm == nil {
+		env.Confirm = builtin.Confirm
+	}
+	if env.IdleTimeout == nil {
+		env.IdleTimeout = builtin.IdleTimeout
+	}
+	if env.StreamIdleTimeout == nil {
+		env.StreamIdleTimeout = builtin.StreamIdleTimeout
+	}
+	return env, true
+}
+
+// environmentNames lists the environments in the order hosts are matched against them
+func (kc *KportConfig) environmentNames() []string {
+	names := slices.Clone(builtinEnvironmentOrder)
+	custom := make([]string, 0, len(kc.Environments))
+	for name := range kc.Environments {
+		if !slices.Contains(names, name) {
+			custom = append(custom, name)
+		}
+	}
+	sort.Strings(custom)
+	return append(names, custom...)
+}
+
+// environmentOf returns the first environment one of tags marks a host as part of
+func (kc *KportConfig) environmentOf(tags []string) string {
+	for _, name := range kc.environmentNames() {
+		env, _ := kc.Environment(name)
+		for _, tag := range tags {
+			if slices.ContainsFunc(env.Tags, func(envTag string) bool { return strings.EqualFold(envTag, tag) }) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// HostFor returns the settings for a host like Host, with the presets of
+// the host's environment between its own settings and the global defaults
+func (kc *KportConfig) HostFor(host SSHHost) HostConfig {
+	hostConfig := kc.Host(host.Name)
+	env, ok := kc.Environment(host.Environment)
+	if !ok {
+		return hostConfig
+	}
+
+	own := kc.Hosts[host.Name]
+	if own.IdleTimeout == nil && env.IdleTimeout != nil {
+		hostConfig.IdleTimeout = env.IdleTimeout
+	}
+	if own.StreamIdleTimeout == nil && env.StreamIdleTimeout != nil {
+		hostConfig.StreamIdleTimeout = env.StreamIdleTimeout
+	}
+	if own.Confirm == nil {
+		hostConfig.Confirm = env.Confirm
+	}
+	return hostConfig
+}
+
+// validateEnvironments checks the colors and port categories of environments
+// and hosts
+func (kc *KportConfig) validateEnvironments() error {
+	for name, env := range kc.Environments {
+		if env.Color != "" && !validColor(env.Color) {
+			return fmt.Errorf("environment %s has color %q, expected a hex color such as #FF5F87", name, env.Color)
+		}
+		if err := validateCategories(env.Confirm); err != nil {
+			return fmt.Errorf("environment %s: %w", name, err)
+		}
+	}
+	for name, hostConfig := range kc.Hosts {
+		if err := validateCategories(hostConfig.Confirm); err != nil {
+			return fmt.Errorf("host %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validColor reports whether color is a #RGB or #RRGGBB hex color
+func validColor(color string) bool {
+	hex := strings.TrimPrefix(color, "#")
+	if hex == color || (len(hex) != 3 && len(hex) != 6) {
+		return false
+	}
+	return strings.Trim(strings.ToLower(hex), "0123456789abcdef") == ""
+}
+
+// validateCategories checks that confirm only lists known port categories
+func validateCategories(categories []PortCategory) error {
+	for _, category := range categories {
+		if !slices.Contains(portCategories, category) {
+			return fmt.Errorf("confirm lists unknown port category %q, expected one of %s", category, joinCategories(portCategories))
+		}
+	}
+	return nil
+}
+
+// joinCategories lists categories for messages
+func joinCategories(categories []PortCategory) string {
+	names := make([]string, 0, len(categories))
+	for _, category := range categories {
+		names = append(names, string(category))
+	}
+	return strings.Join(names, ", ")
+}
+
+// confirmRisk returns the typed confirmation the host's confirm setting asks
+// for before forwarding remotePort, judged by the port's well-known category
+func confirmRisk(host SSHHost, hostConfig HostConfig, remotePort int) *Risk {
+	category := categorizePort(remotePort, "")
+	if !slices.Contains(hostConfig.Confirm, category) {
+		return nil
+	}
+	risk := &Risk{
+		Rule:    "confirm",
+		Action:  PolicyConfirm,
+		Reason:  fmt.Sprintf("forwards %s port %d of %s", category, remotePort, host.Name),
+		Setting: fmt.Sprintf("hosts.%s.confirm", host.Name),
+	}
+	if host.Environment != "" {
+		risk.Reason = fmt.Sprintf("forwards %s port %d of %s host %s", category, remotePort, host.Environment, host.Name)
+		risk.Setting = fmt.Sprintf("environments.%s.confirm", host.Environment)
+	}
+	return risk
+}
+
+// environmentBadge renders the environment of a host in its color, or nothing
+// for hosts without one
+func (kc *KportConfig) environmentBadge(host SSHHost) string {
+	env, ok := kc.Environment(host.Environment)
+	if !ok {
+		return ""
+	}
+	style := lipgloss.NewStyle().Bold(true)
+	if env.Color != "" {
+		style = style.Foreground(lipgloss.Color(env.Color))
+	}
+	return style.Render(" [" + host.Environment + "]")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// errorWindow is how long repeated errors are coalesced into one entry
+const errorWindow = 2 * time.Minute
+
+// AggregatedError is an error message and how often it occurred within the window
+type AggregatedError struct {
+	Message string
+	Count   int
+	First   time.Time
+	Last    time.Time
+}
+
+// String formats the error as "message (x17 in last 2m)" when it repeated
+func (ae AggregatedError) String() string {
+	if ae.Count == 1 {
+		return ae.Message
+	}
+	return fmt.Sprintf("%s (x%d in last %s)", ae.Message, ae.Count, formatAge(time.Since(ae.First)))
+}
+
+// errorEntry tracks an error message and how much of it has been logged
+type errorEntry struct {
+	AggregatedError
+	loggedAt    time.Time
+	loggedCount int
+}
+
+// ErrorAggregator coalesces identical errors within a window so a flapping
+// tunnel doesn't flood the log or the UI
+type ErrorAggregator struct {
+	window  time.Duration
+	logf    func(message string)
+	entries map[string]*errorEntry
+	mu      sync.Mutex
+}
+
+// NewErrorAggregator creates an aggregator that reports to logf: the first
+// occurrence immediately, then at most one summary per window
+func NewErrorAggregator(window time.Duration, logf func(message string)) *ErrorAggregator {
+	return &ErrorAggregator{
+		window:  window,
+		logf:    logf,
+		entries: make(map[string]*errorEntry),
+	}
+}
+
+// Record adds an occurrence of err
+func (ea *ErrorAggregator) Record(err error) {
+	message := err.Error()
+	now := time.Now()
+
+	ea.mu.Lock()
+	entry, ok := ea.entries[message]
+	if !ok || now.Sub(entry.Last) > ea.window {
+		entry = &errorEntry{
+			AggregatedError: AggregatedError{Message: message, First: now},
+			loggedAt:        now,
+			loggedCount:     1,
+		}
+		ea.entries[message] = entry
+		ok = false
+	}
+	entry.Count++
+	entry.Last = now
+
+	var summary string
+	if ok && now.Sub(entry.loggedAt) >= ea.window {
+		summary = fmt.Sprintf("%s (x%d in last %s)", message, entry.Count-entry.loggedCount, formatAge(now.Sub(entry.loggedAt)))
+		entry.loggedAt = now
+		entry.loggedCount = entry.Count
+	}
+	ea.prune(now)
+	ea.mu.Unlock()
+
+	if !ok {
+		ea.logf(message)
+	} else if summary != "" {
+		ea.logf(summary)
+	}
+}
+
+// prune drops errors that have not occurred within the window
+func (ea *ErrorAggregator) prune(now time.Time) {
+	for message, entry := range ea.entries {
+		if now.Sub(entry.Last) > ea.window {
+			delete(ea.entries, message)
+		}
+	}
+}
+
+// Recent returns the errors that occurred within the window, most recent first
+func (ea *ErrorAggregator) Recent() []AggregatedError {
+	ea.mu.Lock()
+	defer ea.mu.Unlock()
+
+	ea.prune(time.Now())
+
+	recent := make([]AggregatedError, 0, len(ea.entries))
+	for _, entry := range ea.entries {
+		recent = append(recent, entry.AggregatedError)
+	}
+	sort.Slice(recent, func(i, j int) bool {
+		return recent[i].Last.After(recent[j].Last)
+	})
+	return recent
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// eventLogMu serializes writes to the event log
+var eventLogMu sync.Mutex
+
+// eventLogPath returns the path of kport's event log
+func eventLogPath() (string, error) {
+	dir, err := kportCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "kport.log"), nil
+}
+
+// logEvent appends a timestamped line about a tunnel lifecycle event to kport's event log
+func logEvent(format string, args ...any) {
+	path, err := eventLogPath()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to locate event log: %v\n", err)
+		return
+	}
+
+	eventLogMu.Lock()
+	defer eventLogMu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to open event log: %v\n", err)
+		return
+	}
+	defer file.Close()
+
+	line := fmt.Sprintf("%s %s\n", time.Now().Format(time.RFC3339), fmt.Sprintf(format, args...))
+	file.WriteString(line)
+	logRing.Write([]byte(line))
+}
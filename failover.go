@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// failoverProbeInterval is how often the destinations of a failover tunnel are probed
+const failoverProbeInterval = 15 * time.Second
+
+// quickFailureWindow is how soon a connection must close without data to count as a failed connect
+const quickFailureWindow = 2 * time.Second
+
+// destinationHostPattern restricts destination hosts to characters safe to pass to a remote shell
+var destinationHostPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]+$`)
+
+// Destination is a remote address a tunnel can forward to
+type Destination struct {
+	Host    string
+	Port    int
+	Healthy bool
+	Checked time.Time
+	sshPort int
+
+	// Service is the SRV name the destination was configured as, whose
+	// records fill in Host and Port when the tunnel starts
+	Service string
+
+	// Addresses are the addresses a name resolved to on the remote host, when
+	// there are several, and Via is the one new connections are forwarded to
+	Addresses []DestinationAddress
+	Via       int
+}
+
+// Address returns the destination as host:port
+func (d *Destination) Address() string {
+	return net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+}
+
+// isLoopbackHost reports whether host is localhost or a loopback address
+func isLoopbackHost(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "localhost" || (ip != nil && ip.IsLoopback())
+}
+
+// parseDestination parses a host:port destination or an SRV name
+func parseDestination(addr string) (*Destination, error) {
+	if isServiceName(addr) {
+		return &Destination{Service: strings.TrimSuffix(addr, "."), Healthy: true}, nil
+	}
+
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid destination %s: %w", addr, err)
+	}
+
+	if !destinationHostPattern.MatchString(host) {
+		return nil, fmt.Errorf("invalid destination host: %s", host)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid destination port: %s", portStr)
+	}
+
+	return &Destination{Host: host, Port: port, Healthy: true}, nil
+}
+
+// buildDestinations returns the primary destination, followed by the
+// failover destinations. The primary is the configured destination, or else
+// the remote port on remoteHost or localhost when it is empty.
+func buildDestinations(remoteHost string, remotePort int, destination string, failover []string) ([]*Destination, error) {
+	if remoteHost == "" {
+		remoteHost = "localhost"
+	}
+	destinations := []*Destination{{Host: remoteHost, Port: remotePort, Healthy: true}}
+	if destination != "" {
+		dest, err := parseDestination(destination)
+		if err != nil {
+			return nil, err
+		}
+		destinations[0] = dest
+	}
+
+	for _, addr := range failover {
+		dest, err := parseDestination(addr)
+		if err != nil {
+			return nil, err
+		}
+		destinations = append(destinations, dest)
+	}
+
+	return destinations, nil
+}
+
+// monitorFailover periodically probes destinations so the tunnel fails over and back
+func (pf *PortForwarder) monitorFailover() {
+	defer pf.wg.Done()
+
+	ticker := time.NewTicker(failoverProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pf.stopChan:
+			return
+		case <-ticker.C:
+			pf.probeDestinations()
+		case <-pf.probeNow:
+			pf.probeDestinations()
+		}
+	}
+}
+
+// probesDestinations reports whether the tunnel has anything to probe in the
+// background: destinations to fail over between, addresses to race, or SRV
+// records to follow
+func (pf *PortForwarder) probesDestinations() bool {
+	return len(pf.destinations) > 1 || pf.racesAddresses() || pf.followsServices()
+}
+
+// requestProbe asks the failover monitor to probe destinations as soon as possible
+func (pf *PortForwarder) requestProbe() {
+	if !pf.probesDestinations() {
+		return
+	}
+	select {
+	case pf.probeNow <- struct{}{}:
+	default:
+	}
+}
+
+// probeDestinations checks every destination from the remote host and selects
+// the first healthy one. Destinations with several addresses race them, and
+// connections go to the address that answered first.
+func (pf *PortForwarder) probeDestinations() {
+	var script strings.Builder
+	for i, dest := range pf.destinations {
+		if len(dest.Addresses) > 1 {
+			script.WriteString(happyEyeballsScript(i, dest))
+			continue
+		}
+		script.WriteString(fmt.Sprintf("timeout 2 bash -c '</dev/tcp/%s/%d' 2>/dev/null && echo '%d up %s' || echo '%d down %s'; ", dest.Host, dest.Port, i, dest.Host, i, dest.Host))
+	}
+	services := pf.services()
+	for i, service := range services {
+		script.WriteString(srvLookupScript(i, service))
+	}
+
+	// Stopping the tunnel waits for the monitor, so it kills a probe in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-pf.stopChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	sshCmd := sshCommandContext(ctx, pf.host, pf.host.probeOptions(5), script.String())
+	output, err := tracedOutput(ctx, "failover probe", sshCmd)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		// The SSH connection itself is unavailable, so there is nothing to fail over to
+		pf.errors.Record(fmt.Errorf("failover probe failed: %w", err))
+		return
+	}
+
+	results := parseRaceOutput(output)
+	pf.checkServices(services, parseSRVOutput(output))
+
+	pf.destMu.Lock()
+	defer pf.destMu.Unlock()
+
+	now := time.Now()
+	for i, dest := range pf.destinations {
+		result, ok := results[i]
+		if !ok {
+			continue
+		}
+		dest.Healthy = result.winner != ""
+		dest.Checked = now
+
+		if len(dest.Addresses) < 2 {
+			continue
+		}
+		if err := result.err(dest); err != nil {
+			pf.errors.Record(err)
+			continue
+		}
+		if via := slices.IndexFunc(dest.Addresses, func(a DestinationAddress) bool { return a.IP == result.winner }); via >= 0 && via != dest.Via {
+			fmt.Fprintf(os.Stderr, "Debug: Switching %s of %s:%d from %s to %s\n",
+				dest.Host, pf.host.Name, pf.remotePort, dest.Addresses[dest.Via].IP, result.winner)
+			dest.Via = via
+		}
+	}
+
+	// Prefer destinations in their configured order, which also fails back to the primary
+	for i, dest := range pf.destinations {
+		if dest.Healthy {
+			if i != pf.active {
+				fmt.Fprintf(os.Stderr, "Debug: Switching %s:%d from %s to %s\n",
+					pf.host.Name, pf.remotePort, pf.destinations[pf.active].Address(), dest.Address())
+				pf.active = i
+			}
+			return
+		}
+	}
+}
+
+// activeDestination returns the destination new connections are forwarded to
+func (pf *PortForwarder) activeDestination() *Destination {
+	pf.destMu.Lock()
+	defer pf.destMu.Unlock()
+	return pf.destinations[pf.active]
+}
+
+// healthyDestinations returns the destinations that answered their last probe
+func (pf *PortForwarder) healthyDestinations() []*Destination {
+	pf.destMu.Lock()
+	defer pf.destMu.Unlock()
+
+	healthy := make([]*Destination, 0, len(pf.destinations))
+	for _, dest := range pf.destinations {
+		if dest.Healthy {
+			healthy = append(healthy, dest)
+		}
+	}
+	return healthy
+}
+
+// Destinations returns a snapshot of the tunnel destinations and the index of the active one
+func (pf *PortForwarder) Destinations() ([]Destination, int) {
+	pf.destMu.Lock()
+	defer pf.destMu.Unlock()
+
+	destinations := make([]Destination, len(pf.destinations))
+	for i, dest := range pf.destinations {
+		destinations[i] = *dest
+		destinations[i].Addresses = slices.Clone(dest.Addresses)
+	}
+	return destinations, pf.active
+}
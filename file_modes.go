@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// StrictModes values of the kport config, named after OpenSSH's StrictModes
+const (
+	StrictModesWarn   = "warn"
+	StrictModesRefuse = "refuse"
+	StrictModesOff    = "off"
+)
+
+// defaultIdentityFiles are the keys in ~/.ssh that ssh tries when a host has no IdentityFile
+var defaultIdentityFiles = []string{"id_rsa", "id_ecdsa", "id_ecdsa_sk", "id_ed25519", "id_ed25519_sk", "id_dsa"}
+
+// FileModeIssue is a config or key file that other users may read or change
+type FileModeIssue struct {
+	Path string
+	Kind string
+	Mode fs.FileMode
+
+	// Want is the mode that fixes the issue, which is Mode when only the
+	// owner is wrong
+	Want fs.FileMode
+
+	// Owner is set when the file belongs to another user, which chmod can't fix
+	Owner bool
+}
+
+func (i FileModeIssue) String() string {
+	if i.Owner {
+		return fmt.Sprintf("%s %s is owned by another user", i.Kind, shortenHome(i.Path))
+	}
+	return fmt.Sprintf("%s %s is %04o, should be %04o", i.Kind, shortenHome(i.Path), i.Mode, i.Want)
+}
+
+// checkedFile is a file whose mode is checked, with who may access it
+type checkedFile struct {
+	Path string
+	Kind string
+
+	// Private files, such as keys, are for the owner alone. Other files may
+	// be read by anyone but only changed by the owner.
+	Private bool
+}
+
+// fileModeIssue checks a file like ssh does: keys must not be accessible to
+// anyone else, configs not writable by anyone else and neither may belong to
+// another user. Missing files are fine.
+func fileModeIssue(file checkedFile) (FileModeIssue, bool) {
+	info, err := os.Stat(file.Path)
+	if err != nil || !info.Mode().IsRegular() {
+		return FileModeIssue{}, false
+	}
+
+	mode := info.Mode().Perm()
+	issue := FileModeIssue{Path: file.Path, Kind: file.Kind, Mode: mode, Want: mode &^ 0o022}
+	if file.Private {
+		issue.Want = mode &^ 0o077
+	}
+	issue.Owner = fileOwnedByOther(info)
+	return issue, issue.Owner || issue.Want != mode
+}
+
+// fileModeIssues checks files, skipping repeated paths, where file modes mean something
+func fileModeIssues(files []checkedFile) []FileModeIssue {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	issues := make([]FileModeIssue, 0)
+	seen := make(map[string]bool)
+	for _, file := range files {
+		if file.Path == "" || seen[file.Path] {
+			continue
+		}
+		seen[file.Path] = true
+		if issue, ok := fileModeIssue(file); ok {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// kportFiles are kport's own config and keys: the config file, the audit
+// log's key, the webhook token and the local CA's key
+func kportFiles(kportConfig *KportConfig) []checkedFile {
+	files := make([]checkedFile, 0, 4)
+	if path, err := kportConfigPath(); err == nil {
+		files = append(files, checkedFile{Path: path, Kind: "kport config"})
+	}
+	if kportConfig != nil && kportConfig.Audit.KeyFile != "" {
+		files = append(files, checkedFile{Path: expandShellVars(kportConfig.Audit.KeyFile), Kind: "audit key", Private: true})
+	}
+	if kportConfig != nil && kportConfig.Webhook.TokenFile != "" {
+		files = append(files, checkedFile{Path: expandShellVars(kportConfig.Webhook.TokenFile), Kind: "webhook token", Private: true})
+	}
+	if configDir, err := os.UserConfigDir(); err == nil {
+		files = append(files, checkedFile{Path: filepath.Join(configDir, "kport", "ca", "kport-ca-key.pem"), Kind: "kport CA key", Private: true})
+	}
+	return files
+}
+
+// identityFiles are the private keys ssh may use for host: its IdentityFile,
+// or the default keys when it has none
+func identityFiles(host SSHHost) []checkedFile {
+	if host.Identity != "" {
+		if strings.EqualFold(host.Identity, "none") {
+			return nil
+		}
+		return []checkedFile{{Path: expandShellVars(host.Identity), Kind: "identity file", Private: true}}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	files := make([]checkedFile, 0, len(defaultIdentityFiles))
+	for _, name := range defaultIdentityFiles {
+		files = append(files, checkedFile{Path: filepath.Join(home, ".ssh", name), Kind: "identity file", Private: true})
+	}
+	return files
+}
+
+// hostFiles are the files connecting to host reads: the SSH config files
+// defining it, its keys and the kport config
+func hostFiles(host SSHHost) []checkedFile {
+	files := make([]checkedFile, 0)
+	for _, source := range host.Sources {
+		// Sources are file:line
+		path := source
+		if i := strings.LastIndex(source, ":"); i >= 0 {
+			path = source[:i]
+		}
+		files = append(files, checkedFile{Path: path, Kind: "SSH config"})
+	}
+	files = append(files, identityFiles(host)...)
+	if path, err := kportConfigPath(); err == nil {
+		files = append(files, checkedFile{Path: path, Kind: "kport config"})
+	}
+	return files
+}
+
+// allFiles are every file kport checks: its own, the SSH config files and
+// the keys of all hosts
+func allFiles(sshFiles []string, hosts []SSHHost, kportConfig *KportConfig) []checkedFile {
+	files := kportFiles(kportConfig)
+	for _, path := range sshFiles {
+		files = append(files, checkedFile{Path: path, Kind: "SSH config"})
+	}
+	for _, host := range hosts {
+		files = append(files, identityFiles(host)...)
+	}
+	// The default keys count even when every host names its own
+	files = append(files, identityFiles(SSHHost{})...)
+	return files
+}
+
+var (
+	strictModesMu sync.Mutex
+	strictModes   string
+
+	// warnedModes holds the paths warned about, so each is only mentioned once
+	warnedModes = make(map[string]bool)
+)
+
+// setStrictModes applies the strict_modes setting of a freshly loaded kport config
+func setStrictModes(mode string) {
+	strictModesMu.Lock()
+	defer strictModesMu.Unlock()
+	strictModes = mode
+}
+
+// checkStrictModes checks the files connecting to host reads. It warns about
+// loose modes on stderr, or refuses to connect with strict_modes: refuse.
+func checkStrictModes(host SSHHost) error {
+	strictModesMu.Lock()
+	defer strictModesMu.Unlock()
+	if strictModes == StrictModesOff {
+		return nil
+	}
+
+	issues := fileModeIssues(hostFiles(host))
+	if len(issues) == 0 {
+		return nil
+	}
+	if strictModes == StrictModesRefuse {
+		return fmt.Errorf("refusing to connect to %s: %s, run `kport fix-perms` to fix it", host.Name, issues[0])
+	}
+	for _, issue := range issues {
+		if !warnedModes[issue.Path] {
+			warnedModes[issue.Path] = true
+			fmt.Fprintf(os.Stderr, "⚠️  %s, run `kport fix-perms` to fix it\n", issue)
+		}
+	}
+	return nil
+}
+
+// validateStrictModes checks the strict_modes setting
+func validateStrictModes(mode string) error {
+	if mode != "" && !slices.Contains([]string{StrictModesWarn, StrictModesRefuse, StrictModesOff}, mode) {
+		return fmt.Errorf("strict_modes must be %s, %s or %s, not %q", StrictModesWarn, StrictModesRefuse, StrictModesOff, mode)
+	}
+	return nil
+}
+
+// runFixPerms tightens the modes of kport's config and keys, the SSH config
+// files and the keys of every host. --dry-run only lists what it would change.
+func runFixPerms(args []string) error {
+	dryRun := false
+	for _, arg := range args {
+		switch arg {
+		case "-n", "--dry-run":
+			dryRun = true
+		default:
+			return fmt.Errorf("usage: kport fix-perms [--dry-run]")
+		}
+	}
+
+	sshConfig := NewSSHConfig()
+	if err := sshConfig.LoadConfig(); err != nil {
+		return err
+	}
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		// A config kport can't parse still has a mode to fix
+		fmt.Fprintf(os.Stderr, "⚠️  %v\n", err)
+		kportConfig = NewKportConfig()
+	}
+
+	issues := fileModeIssues(allFiles(sshConfig.Files, collectHosts(sshConfig, kportConfig), kportConfig))
+	if len(issues) == 0 {
+		fmt.Println("✅ No config or key files are accessible to other users")
+		return nil
+	}
+
+	var errs []error
+	for _, issue := range issues {
+		switch {
+		case issue.Owner:
+			errs = append(errs, fmt.Errorf("%s, change its owner with chown", issue))
+		case dryRun:
+			fmt.Printf("Would change %s from %04o to %04o\n", shortenHome(issue.Path), issue.Mode, issue.Want)
+		default:
+			if err := os.Chmod(issue.Path, issue.Want); err != nil {
+				errs = append(errs, fmt.Errorf("failed to change the mode of %s: %w", shortenHome(issue.Path), err))
+				continue
+			}
+			fmt.Printf("🔒 Changed %s from %04o to %04o\n", shortenHome(issue.Path), issue.Mode, issue.Want)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// renderModeIssues warns on the host list about files with loose modes
+func (m *Model) renderModeIssues() string {
+	if len(m.modeIssues) == 0 {
+		return ""
+	}
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+
+	var s strings.Builder
+	s.WriteString("\n\n")
+	s.WriteString(warningStyle.Render(fmt.Sprintf("⚠️  %d config or key files are accessible to other users, run `kport fix-perms`", len(m.modeIssues))))
+	for _, issue := range m.modeIssues {
+		s.WriteString("\n" + dimStyle.Render("   "+issue.String()))
+	}
+	return s.String()
+}
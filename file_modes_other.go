@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "io/fs"
+
+// fileOwnedByOther reports whether a file belongs to someone other than the
+// user, which kport can't tell on this platform
+func fileOwnedByOther(info fs.FileInfo) bool {
+	return false
+}
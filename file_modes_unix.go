@@ -0,0 +1,16 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// fileOwnedByOther reports whether a file belongs to someone other than the
+// user or root, who ssh trusts with config files too
+func fileOwnedByOther(info fs.FileInfo) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && stat.Uid != 0 && int(stat.Uid) != os.Getuid()
+}
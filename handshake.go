@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// HandshakeStage is a step of ssh connecting to a host
+type HandshakeStage int
+
+const (
+	// StageDialing is ssh starting to connect, before any step is done
+	StageDialing HandshakeStage = iota
+	StageTCP
+	StageVersion
+	StageKex
+	StageAuth
+	StageSession
+)
+
+// handshakeStages are the steps of a handshake in order, with what they are called
+var handshakeStages = []struct {
+	Stage HandshakeStage
+	Name  string
+}{
+	{StageTCP, "TCP connected"},
+	{StageVersion, "Version exchanged"},
+	{StageKex, "Key exchange done"},
+	{StageAuth, "Authenticated"},
+	{StageSession, "Session opened"},
+}
+
+// handshakePatterns are what the debug1 lines of ssh -v start with once a
+// stage is reached
+var handshakePatterns = []struct {
+	Stage  HandshakeStage
+	Prefix string
+}{
+	{StageTCP, "Connection established."},
+	{StageVersion, "Remote protocol version"},
+	{StageKex, "SSH2_MSG_NEWKEYS received"},
+	{StageAuth, "Authenticated to "},
+	{StageAuth, "Authentication succeeded"},
+	{StageSession, "channel 0: new"},
+	{StageSession, "Sending command:"},
+	{StageSession, "Entering interactive session."},
+}
+
+// handshakeReusedPrefix is logged by ssh -v when it runs the command over a
+// prewarmed master instead of connecting
+const handshakeReusedPrefix = "mux_client_request_session: master session id"
+
+// HandshakeMsg is sent when ssh reaches a stage of connecting to a host, or
+// with Reused set when it skipped the handshake for a prewarmed connection
+type HandshakeMsg struct {
+	Host   string
+	Stage  HandshakeStage
+	At     time.Time
+	Reused bool
+}
+
+var (
+	handshakeMu      sync.Mutex
+	handshakeHandler = func(HandshakeMsg) {}
+)
+
+// setHandshakeHandler routes handshake progress to a front end, which nothing
+// listens to by default
+func setHandshakeHandler(handler func(HandshakeMsg)) {
+	handshakeMu.Lock()
+	defer handshakeMu.Unlock()
+	handshakeHandler = handler
+}
+
+// notifyHandshake passes handshake progress to the current handler
+func notifyHandshake(msg HandshakeMsg) {
+	handshakeMu.Lock()
+	handler := handshakeHandler
+	handshakeMu.Unlock()
+	handler(msg)
+}
+
+// waitForHandshake delivers the next handshake progress to the TUI
+func waitForHandshake(progress <-chan HandshakeMsg) tea.Cmd {
+	return func() tea.Msg {
+		return <-progress
+	}
+}
+
+// handshakeWatcher follows the stderr of an ssh -v command, reporting the
+// stages of its handshake and keeping the lines that aren't debug output for
+// the errors ssh explains
+type handshakeWatcher struct {
+	host   string
+	stderr []byte
+}
+
+// newHandshakeWatcher reports that ssh starts connecting to host and returns
+// a watcher of its stderr
+func newHandshakeWatcher(host string) *handshakeWatcher {
+	notifyHandshake(HandshakeMsg{Host: host, Stage: StageDialing, At: time.Now()})
+	return &handshakeWatcher{host: host}
+}
+
+// line handles a line of ssh's stderr
+func (w *handshakeWatcher) line(line string) {
+	message, ok := strings.CutPrefix(line, "debug1: ")
+	if !ok {
+		if !strings.HasPrefix(line, "debug") {
+			w.stderr = append(w.stderr, line+"\n"...)
+		}
+		return
+	}
+
+	if strings.HasPrefix(message, handshakeReusedPrefix) {
+		notifyHandshake(HandshakeMsg{Host: w.host, Stage: StageSession, At: time.Now(), Reused: true})
+		return
+	}
+	for _, pattern := range handshakePatterns {
+		if strings.HasPrefix(message, pattern.Prefix) {
+			notifyHandshake(HandshakeMsg{Host: w.host, Stage: pattern.Stage, At: time.Now()})
+			return
+		}
+	}
+}
+
+// HandshakeProgress is how far connecting to a host has come
+type HandshakeProgress struct {
+	Host    string
+	Started time.Time
+
+	// Reached holds when each stage was reached. A stage ssh doesn't log,
+	// such as the TCP connection of a ProxyCommand, counts as reached along
+	// with a later one.
+	Reached map[HandshakeStage]time.Time
+	Reused  bool
+
+	// Watched is set once an ssh -v command reports on the handshake, which
+	// commands through jump hosts and plugins don't
+	Watched bool
+}
+
+// newHandshakeProgress starts following the handshake with host
+func newHandshakeProgress(host string) *HandshakeProgress {
+	return &HandshakeProgress{Host: host, Started: time.Now(), Reached: make(map[HandshakeStage]time.Time)}
+}
+
+// update records a stage of the handshake
+func (p *HandshakeProgress) update(msg HandshakeMsg) {
+	if msg.Stage == StageDialing && len(p.Reached) == 0 {
+		p.Started = msg.At
+	}
+	p.Watched = true
+	if msg.Reused {
+		p.Reused = true
+	}
+	for _, step := range handshakeStages {
+		if step.Stage > msg.Stage {
+			break
+		}
+		if _, ok := p.Reached[step.Stage]; !ok {
+			p.Reached[step.Stage] = msg.At
+		}
+	}
+}
+
+// updateHandshake records handshake progress with the host being connected to
+func (m *Model) updateHandshake(msg HandshakeMsg) tea.Cmd {
+	if m.handshake != nil && m.handshake.Host == msg.Host {
+		m.handshake.update(msg)
+	}
+	return waitForHandshake(m.handshakes)
+}
+
+// renderHandshake renders the steps of connecting to host, with how long each took
+func (m *Model) renderHandshake(host string) string {
+	progress := m.handshake
+	if progress == nil || progress.Host != host || !progress.Watched {
+		return ""
+	}
+
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	doneStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575"))
+
+	var s strings.Builder
+	if progress.Reused {
+		s.WriteString(doneStyle.Render("✓ Reusing the prewarmed connection") + "\n\n")
+		return s.String()
+	}
+
+	previous := progress.Started
+	current := true
+	for _, step := range handshakeStages {
+		at, ok := progress.Reached[step.Stage]
+		switch {
+		case ok:
+			s.WriteString(doneStyle.Render("✓ "+step.Name) + dimStyle.Render(fmt.Sprintf("  %s", at.Sub(previous).Round(time.Millisecond))))
+			previous = at
+		case current:
+			s.WriteString("⋯ " + step.Name)
+			current = false
+		default:
+			s.WriteString(dimStyle.Render("· " + step.Name))
+		}
+		s.WriteString("\n")
+	}
+	s.WriteString("\n")
+	return s.String()
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// happyEyeballsDelay is how long an attempt to one address of a destination
+// runs before the next address is tried alongside it, as in RFC 8305
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// happyEyeballsTimeout is how long each attempt may take
+const happyEyeballsTimeout = 2 * time.Second
+
+// DestinationAddress is one of the addresses a destination's name resolves to on the remote host
+type DestinationAddress struct {
+	IP      string
+	sshPort int
+}
+
+// raceResult is what the remote host reported for a raced destination
+type raceResult struct {
+	// addrs are the addresses tried, in the order they were started
+	addrs []string
+
+	// winner is the first address that accepted a connection, empty when none did
+	winner string
+
+	// errs holds why each address that failed did
+	errs map[string]string
+}
+
+// needsRacing reports whether a destination host is a name that may resolve
+// to several addresses. Loopback names are left to ssh, since a refused
+// loopback connect fails immediately.
+func needsRacing(host string) bool {
+	return net.ParseIP(host) == nil && !isLoopbackHost(host)
+}
+
+// happyEyeballsScript is a remote shell snippet that starts a connection
+// attempt to each address of a destination, each one happyEyeballsDelay after
+// the previous, and reports them as "<index> addr|up|down <ip> [reason]" lines.
+// Without known addresses the name is resolved on the remote host, with IPv6
+// and IPv4 addresses interleaved.
+func happyEyeballsScript(index int, dest *Destination) string {
+	step := happyEyeballsDelay.Seconds()
+	var producer string
+	if len(dest.Addresses) == 0 {
+		producer = fmt.Sprintf(`getent ahosts %s 2>/dev/null | awk -v d=%g '!seen[$1]++ { if (index($1, ":")) v6[n6++] = $1; else v4[n4++] = $1 } END { for (i = 0; i < n6 || i < n4; i++) { if (i < n6) a[n++] = v6[i]; if (i < n4) a[n++] = v4[i] } for (i = 0; i < n; i++) print a[i], i * d }'`, dest.Host, step)
+	} else {
+		lines := make([]string, 0, len(dest.Addresses))
+		for i, addr := range dest.Addresses {
+			lines = append(lines, fmt.Sprintf("%s %g", addr.IP, float64(i)*step))
+		}
+		producer = fmt.Sprintf("printf '%%s\\n' %s", strings.Join(quoteAll(lines), " "))
+	}
+
+	attempt := fmt.Sprintf(`out=$(timeout %d bash -c "</dev/tcp/$a/%d" 2>&1); rc=$?; if [ $rc -eq 0 ]; then echo "%d up $a"; elif [ $rc -eq 124 ]; then echo "%d down $a timed out"; else echo "%d down $a ${out##*: }"; fi`,
+		int(happyEyeballsTimeout.Seconds()), dest.Port, index, index, index)
+	return fmt.Sprintf(`%s | { while read a d; do echo "%d addr $a"; ( sleep "$d"; %s ) & done; wait; }; `, producer, index, attempt)
+}
+
+// quoteAll single-quotes each value for the remote shell
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = shellQuote(value)
+	}
+	return quoted
+}
+
+// parseRaceOutput reads the lines of happyEyeballsScript snippets, keyed by destination index
+func parseRaceOutput(output []byte) map[int]*raceResult {
+	results := make(map[int]*raceResult)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		result := results[index]
+		if result == nil {
+			result = &raceResult{errs: make(map[string]string)}
+			results[index] = result
+		}
+
+		ip := fields[2]
+		switch fields[1] {
+		case "addr":
+			result.addrs = append(result.addrs, ip)
+		case "up":
+			if result.winner == "" {
+				result.winner = ip
+			}
+		case "down":
+			result.errs[ip] = strings.Join(fields[3:], " ")
+		}
+	}
+	return results
+}
+
+// err combines why every address failed, or returns nil when one connected
+func (r *raceResult) err(dest *Destination) error {
+	if r.winner != "" || len(r.addrs) == 0 {
+		return nil
+	}
+	failures := make([]string, 0, len(r.addrs))
+	for _, ip := range r.addrs {
+		reason := r.errs[ip]
+		if reason == "" {
+			reason = "no answer"
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", net.JoinHostPort(ip, strconv.Itoa(dest.Port)), reason))
+	}
+	return fmt.Errorf("all %d addresses of %s failed: %s", len(r.addrs), dest.Address(), strings.Join(failures, "; "))
+}
+
+// resolveDestinations resolves the named destinations of a tunnel on the
+// remote host and races their addresses, so ssh can forward each address and
+// new connections start on the one that connected first. Destinations that
+// resolve to a single address, or can't be resolved, stay forwarded by name.
+func (pf *PortForwarder) resolveDestinations(ctx context.Context, destinations []*Destination) {
+	var script strings.Builder
+	for i, dest := range destinations {
+		if needsRacing(dest.Host) {
+			script.WriteString(happyEyeballsScript(i, dest))
+		}
+	}
+	if script.Len() == 0 {
+		return
+	}
+
+	output, err := tracedOutput(ctx, "resolve", sshCommand(pf.host, pf.host.probeOptions(10), script.String()))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to resolve destinations of %s: %v\n", pf.host.Name, err)
+		return
+	}
+
+	for i, result := range parseRaceOutput(output) {
+		if i >= len(destinations) || len(result.addrs) < 2 {
+			continue
+		}
+		dest := destinations[i]
+		dest.Addresses = make([]DestinationAddress, len(result.addrs))
+		for j, ip := range result.addrs {
+			dest.Addresses[j] = DestinationAddress{IP: ip}
+		}
+		if result.winner != "" {
+			dest.Via = slices.Index(result.addrs, result.winner)
+		}
+		if err := result.err(dest); err != nil {
+			pf.errors.Record(err)
+		}
+		fmt.Fprintf(os.Stderr, "Debug: %s resolves to %s on %s, using %s\n",
+			dest.Host, strings.Join(result.addrs, ", "), pf.host.Name, dest.Addresses[dest.Via].IP)
+	}
+}
+
+// racesAddresses reports whether any destination has several addresses to race
+func (pf *PortForwarder) racesAddresses() bool {
+	for _, dest := range pf.destinations {
+		if len(dest.Addresses) > 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// sshPortOf returns the loopback port ssh forwards a destination on: the port
+// of its address that connected first, or of its name when it has no addresses
+func (pf *PortForwarder) sshPortOf(dest *Destination) int {
+	pf.destMu.Lock()
+	defer pf.destMu.Unlock()
+	if dest.Via < len(dest.Addresses) {
+		return dest.Addresses[dest.Via].sshPort
+	}
+	return dest.sshPort
+}
+
+// listensIPv6 reports whether the tunnel has a listener on [::1]. The caller holds pf.mu.
+func (pf *PortForwarder) listensIPv6() bool {
+	for _, listener := range pf.listeners {
+		if addr, ok := listener.Addr().(*net.TCPAddr); ok && addr.IP.Equal(net.IPv6loopback) {
+			return true
+		}
+	}
+	return false
+}
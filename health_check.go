@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultWaitTimeout is how long `kport up --wait` waits for tunnels to become healthy
+	defaultWaitTimeout = 60 * time.Second
+
+	// healthCheckInterval is the pause between health checks of a tunnel that isn't healthy yet
+	healthCheckInterval = 500 * time.Millisecond
+
+	// healthCheckTimeout bounds a single health check
+	healthCheckTimeout = 3 * time.Second
+
+	// healthCloseWindow is how long a connection must stay open for the remote
+	// service to count as reachable. ssh closes the forwarded connection right
+	// away when it can't connect to the service.
+	healthCloseWindow = 500 * time.Millisecond
+)
+
+// healthAddress returns what health checks connect to for a tunnel: its local
+// socket, or its local port on the loopback address
+func healthAddress(localPort int, localSocket string) string {
+	if localSocket != "" {
+		return localSocket
+	}
+	return fmt.Sprintf("127.0.0.1:%d", localPort)
+}
+
+// dialHealth connects to the local listener of a tunnel, over a Unix socket
+// when address is a path
+func dialHealth(ctx context.Context, address string) (net.Conn, error) {
+	network := "tcp"
+	if strings.HasPrefix(address, "/") {
+		network = "unix"
+	}
+	dialer := net.Dialer{Timeout: healthCheckTimeout}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// checkTunnelHealth checks the remote service behind a tunnel through its local
+// listener, with an HTTP GET of health when it is a path and a TCP check otherwise
+func checkTunnelHealth(address string, health string) error {
+	if strings.HasPrefix(health, "/") {
+		return checkHTTPHealth(address, health)
+	}
+	return checkTCPHealth(address)
+}
+
+// checkTCPHealth connects through the tunnel and fails when the connection is
+// closed before the service had a chance to answer
+func checkTCPHealth(address string) error {
+	conn, err := dialHealth(context.Background(), address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return checkConnOpen(conn)
+}
+
+// checkConnOpen fails when a connection through ssh is closed before the
+// remote service had a chance to answer
+func checkConnOpen(conn net.Conn) error {
+	conn.SetReadDeadline(time.Now().Add(healthCloseWindow))
+	_, err := conn.Read(make([]byte, 1))
+	// Services that wait for the client to speak first are healthy too
+	var netErr net.Error
+	if err == nil || errors.As(err, &netErr) && netErr.Timeout() {
+		return nil
+	}
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("connection closed, the remote service isn't accepting connections")
+	}
+	return err
+}
+
+// checkHTTPHealth sends a GET for path through the tunnel and expects a 2xx or 3xx response
+func checkHTTPHealth(address string, path string) error {
+	client := &http.Client{
+		Timeout: healthCheckTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialHealth(ctx, address)
+			},
+		},
+		// A redirect already shows the service is up, and may point elsewhere
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	host := address
+	if strings.HasPrefix(address, "/") {
+		host = "localhost"
+	}
+	resp, err := client.Get(fmt.Sprintf("http://%s%s", host, path))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	client.CloseIdleConnections()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("GET %s returned %s", path, resp.Status)
+	}
+	return nil
+}
+
+// waitTunnelHealthy checks a tunnel until it passes its health check or the
+// deadline passes, returning the last check's error
+func waitTunnelHealthy(address string, health string, deadline time.Time) error {
+	for {
+		err := checkTunnelHealth(address, health)
+		if err == nil || time.Now().Add(healthCheckInterval).After(deadline) {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Debug: %s not healthy yet: %v\n", address, err)
+		time.Sleep(healthCheckInterval)
+	}
+}
+
+// waitForHealthy checks the tunnels until every one of them passes its health
+// check or the timeout runs out, printing each tunnel's status as it settles
+func waitForHealthy(tunnels []TunnelStatus, timeout time.Duration) error {
+	type result struct {
+		tunnel  TunnelStatus
+		err     error
+		elapsed time.Duration
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	results := make(chan result, len(tunnels))
+	for _, tunnel := range tunnels {
+		go func() {
+			err := waitTunnelHealthy(healthAddress(tunnel.LocalPort, tunnel.Socket), tunnel.Health, deadline)
+			results <- result{tunnel: tunnel, err: err, elapsed: time.Since(start)}
+		}()
+	}
+
+	failed := 0
+	for range tunnels {
+		r := <-results
+		target := fmt.Sprintf("%s -> %s:%d", r.tunnel.LocalAddress(), r.tunnel.Host, r.tunnel.RemotePort)
+		if r.err != nil {
+			failed++
+			fmt.Printf("   ❌ [%s] %s unhealthy after %s: %v\n", r.tunnel.Profile, target, timeout, r.err)
+			continue
+		}
+		fmt.Printf("   ✅ [%s] %s healthy after %s\n", r.tunnel.Profile, target, r.elapsed.Round(100*time.Millisecond))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tunnels did not become healthy within %s", failed, len(tunnels), timeout)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultHookTimeout is how long a lifecycle hook may run when its timeout isn't set
+const defaultHookTimeout = 30 * time.Second
+
+// Hook events, named as in the config
+const (
+	HookPreUp    = "pre_up"
+	HookPostUp   = "post_up"
+	HookPreDown  = "pre_down"
+	HookPostDown = "post_down"
+)
+
+// Hooks are local commands run as a tunnel comes up and goes down. They are
+// templates, so {{.LocalPort}} and the other fields of HookVars can be used.
+type Hooks struct {
+	// PreUp runs before the tunnel opens, which is abandoned when it fails
+	PreUp string `yaml:"pre_up"`
+
+	// PostUp runs once the tunnel is open
+	PostUp string `yaml:"post_up"`
+
+	// PreDown and PostDown run before and after the tunnel closes
+	PreDown  string `yaml:"pre_down"`
+	PostDown string `yaml:"post_down"`
+
+	// Timeout kills a hook that runs longer, defaulting to 30s
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// HookVars are the template variables of a hook
+type HookVars struct {
+	Profile    string
+	Tunnel     string
+	Host       string
+	LocalPort  int
+	RemotePort int
+}
+
+// inherit fills in the hooks of a tunnel that it doesn't set from its profile
+func (h Hooks) inherit(profile Hooks) Hooks {
+	if h.PreUp == "" {
+		h.PreUp = profile.PreUp
+	}
+	if h.PostUp == "" {
+		h.PostUp = profile.PostUp
+	}
+	if h.PreDown == "" {
+		h.PreDown = profile.PreDown
+	}
+	if h.PostDown == "" {
+		h.PostDown = profile.PostDown
+	}
+	if h.Timeout == 0 {
+		h.Timeout = profile.Timeout
+	}
+	return h
+}
+
+// set reports whether any hook is set
+func (h Hooks) set() bool {
+	return h.PreUp != "" || h.PostUp != "" || h.PreDown != "" || h.PostDown != ""
+}
+
+// command returns the hook of an event
+func (h Hooks) command(event string) string {
+	switch event {
+	case HookPreUp:
+		return h.PreUp
+	case HookPostUp:
+		return h.PostUp
+	case HookPreDown:
+		return h.PreDown
+	case HookPostDown:
+		return h.PostDown
+	}
+	return ""
+}
+
+// validate checks that every hook is a template of known variables
+func (h Hooks) validate() error {
+	for _, event := range []string{HookPreUp, HookPostUp, HookPreDown, HookPostDown} {
+		if _, err := h.render(event, HookVars{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// render fills in the variables of the hook of an event
+func (h Hooks) render(event string, vars HookVars) (string, error) {
+	tmpl, err := template.New(event).Option("missingkey=error").Parse(h.command(event))
+	if err != nil {
+		return "", fmt.Errorf("invalid %s hook: %w", event, err)
+	}
+	var rendered strings.Builder
+	if err := tmpl.Execute(&rendered, vars); err != nil {
+		return "", fmt.Errorf("invalid %s hook: %w", event, err)
+	}
+	return rendered.String(), nil
+}
+
+// run runs the hook of an event, if any, and writes its output to the event log
+func (h Hooks) run(event string, vars HookVars) error {
+	if h.command(event) == "" {
+		return nil
+	}
+	command, err := h.render(event, vars)
+	if err != nil {
+		return err
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	fmt.Fprintf(os.Stderr, "Debug: Running %s hook of %s: %s\n", event, vars.Tunnel, command)
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"KPORT_PROFILE="+vars.Profile,
+		"KPORT_TUNNEL="+vars.Tunnel,
+		"KPORT_HOST="+vars.Host,
+		"KPORT_LOCAL_PORT="+strconv.Itoa(vars.LocalPort),
+		"KPORT_REMOTE_PORT="+strconv.Itoa(vars.RemotePort),
+	)
+	// Background processes the hook started mustn't hold up kport after the timeout
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.CombinedOutput()
+	for _, line := range strings.Split(string(bytes.TrimSpace(output)), "\n") {
+		if line != "" {
+			logEvent("%s hook of %s: %s", event, vars.Tunnel, line)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		logEvent("%s hook of %s failed: %v", event, vars.Tunnel, err)
+		return fmt.Errorf("%s hook failed: %w", event, err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// addressProbeTimeout is how long a candidate address has to accept a
+	// connection before the next one is used
+	addressProbeTimeout = time.Second
+
+	// addressCacheTTL is how long the address picked for a host is used before
+	// the candidates are probed again, such as after joining the VPN
+	addressCacheTTL = time.Minute
+)
+
+// pickedAddress is the candidate address a host was reached on
+type pickedAddress struct {
+	address string
+	at      time.Time
+}
+
+// addressCache holds the address picked for each host and its candidates,
+// so editing the candidates probes them again
+var addressCache = struct {
+	sync.Mutex
+	entries map[string]pickedAddress
+}{entries: make(map[string]pickedAddress)}
+
+// probesAddresses reports whether kport picks among the host's candidate
+// addresses. Hosts reached through a jump host or another transport can't be
+// probed from here.
+func (h SSHHost) probesAddresses() bool {
+	return len(h.Addresses) > 0 && h.ProxyJump == "" && h.Transport == "" && !h.Inline
+}
+
+// validateAddresses checks that candidate addresses are plain host names or
+// IP addresses, since ssh takes the port from the host's config
+func validateAddresses(addresses []string) error {
+	for _, address := range addresses {
+		if address == "" || strings.ContainsAny(address, " \t/@") {
+			return fmt.Errorf("%q is not a host name or IP address", address)
+		}
+		if _, _, err := net.SplitHostPort(address); err == nil {
+			return fmt.Errorf("%q has a port, the host's Port is used for every address", address)
+		}
+	}
+	return nil
+}
+
+// resolveAddress points host at the first of its candidate addresses that
+// accepts a connection on its SSH port
+func resolveAddress(host SSHHost) (SSHHost, error) {
+	if !host.probesAddresses() {
+		return host, nil
+	}
+
+	key := host.Name + "\x00" + strings.Join(host.Addresses, ",")
+	addressCache.Lock()
+	picked, ok := addressCache.entries[key]
+	addressCache.Unlock()
+	if ok && time.Since(picked.at) < addressCacheTTL {
+		host.Address = picked.address
+		return host, nil
+	}
+
+	port := host.Port
+	if host.PortOverride != "" {
+		port = host.PortOverride
+	}
+	if port == "" {
+		port = "22"
+	}
+	address, err := probeAddresses(host.Addresses, port)
+	if err != nil {
+		return host, fmt.Errorf("%s is unreachable on all of its addresses: %w", host.Name, err)
+	}
+	fmt.Fprintf(os.Stderr, "Debug: Reaching %s on %s\n", host.Name, address)
+
+	addressCache.Lock()
+	addressCache.entries[key] = pickedAddress{address: address, at: time.Now()}
+	addressCache.Unlock()
+	host.Address = address
+	return host, nil
+}
+
+// probeAddresses dials every candidate at once and returns the first one in
+// order that accepted, so a candidate is only waited on while the ones before
+// it may still answer
+func probeAddresses(candidates []string, port string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), addressProbeTimeout)
+	defer cancel()
+
+	results := make([]chan error, len(candidates))
+	for i, candidate := range candidates {
+		results[i] = make(chan error, 1)
+		go func() {
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(candidate, port))
+			if err == nil {
+				conn.Close()
+			}
+			results[i] <- err
+		}()
+	}
+
+	failures := make([]string, 0, len(candidates))
+	for i, candidate := range candidates {
+		err := <-results[i]
+		if err == nil {
+			return candidate, nil
+		}
+		var opErr *net.OpError
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			err = fmt.Errorf("no answer within %s", addressProbeTimeout)
+		} else if errors.As(err, &opErr) {
+			// The address and port are already in the message
+			err = opErr.Err
+		}
+		failures = append(failures, fmt.Sprintf("%s (%v)", candidate, err))
+	}
+	return "", fmt.Errorf("%s", strings.Join(failures, ", "))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// hostFactsScript prints each fact after an @name marker line
+const hostFactsScript = `echo @os; (. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME") || uname -s
+echo @kernel; uname -sr
+echo @uptime; uptime
+echo @disk; df -h / 2>/dev/null | tail -n 1
+echo @ports; (ss -tln 2>/dev/null || netstat -an 2>/dev/null) | grep -c LISTEN`
+
+// HostFacts are live facts about a remote host gathered over SSH
+type HostFacts struct {
+	OS             string
+	Kernel         string
+	Uptime         string
+	Load           string
+	Disk           string
+	ListeningPorts int
+	FetchedAt      time.Time
+}
+
+// HostInfoMsg is sent when host facts have been gathered
+type HostInfoMsg struct {
+	Host  string
+	Facts *HostFacts
+	Err   error
+}
+
+// FetchHostInfo gathers facts about host over SSH
+func FetchHostInfo(host SSHHost) tea.Cmd {
+	return func() tea.Msg {
+		ctx, span := tracer.Start(context.Background(), "kport.host_info", trace.WithAttributes(hostAttributes(host)...))
+
+		host, err := tracedPrepareHost(ctx, host)
+		if err != nil {
+			endSpan(span, err)
+			return HostInfoMsg{Host: host.Name, Err: err}
+		}
+
+		sshCmd := sshCommand(host, host.probeOptions(10), hostFactsScript)
+		output, err := tracedOutput(ctx, "host facts", sshCmd)
+		endSpan(span, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to gather facts for %s: %v\n", host.Name, err)
+			if sshErr := sshConnectionError(host, nil, err); sshErr != nil {
+				return HostInfoMsg{Host: host.Name, Err: sshErr}
+			}
+			return HostInfoMsg{Host: host.Name, Err: fmt.Errorf("failed to gather host facts: %w", err)}
+		}
+
+		return HostInfoMsg{Host: host.Name, Facts: parseHostFacts(output)}
+	}
+}
+
+// parseHostFacts parses the output of hostFactsScript
+func parseHostFacts(output []byte) *HostFacts {
+	facts := &HostFacts{FetchedAt: time.Now()}
+
+	var section string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "@") {
+			section = line[1:]
+			continue
+		}
+		if line == "" {
+			continue
+		}
+
+		switch section {
+		case "os":
+			facts.OS = line
+		case "kernel":
+			facts.Kernel = line
+		case "uptime":
+			facts.Uptime, facts.Load = parseUptime(line)
+		case "disk":
+			facts.Disk = parseDisk(line)
+		case "ports":
+			facts.ListeningPorts, _ = strconv.Atoi(line)
+		}
+	}
+
+	return facts
+}
+
+// parseUptime splits uptime output into the time up and the load averages
+func parseUptime(line string) (string, string) {
+	before, load, found := strings.Cut(line, "load average")
+	if !found {
+		return line, ""
+	}
+	load = strings.TrimSpace(strings.TrimLeft(load, "s:"))
+
+	// "12:01:02 up 3 days,  4:05,  2 users," -> "3 days, 4:05"
+	up := before
+	if _, after, ok := strings.Cut(before, "up "); ok {
+		up = after
+	}
+	parts := strings.Split(up, ",")
+	kept := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" || strings.HasSuffix(part, "user") || strings.HasSuffix(part, "users") {
+			continue
+		}
+		kept = append(kept, part)
+	}
+	return strings.Join(kept, ", "), load
+}
+
+// parseDisk summarizes a df -h line for the root filesystem
+func parseDisk(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 5 {
+		return line
+	}
+	// Filesystem Size Used Avail Use% ...
+	return fmt.Sprintf("%s used of %s (%s free, %s)", fields[2], fields[1], fields[3], fields[4])
+}
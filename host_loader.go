@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// HostsLoadedMsg is sent when the SSH and kport configs have been parsed
+type HostsLoadedMsg struct {
+	SSHConfig   *SSHConfig
+	KportConfig *KportConfig
+	Hosts       []SSHHost
+	Err         error
+
+	// ConfigPaths and ConfigStamp describe the files the hosts were loaded
+	// from, so the TUI can reload when they change
+	ConfigPaths []string
+	ConfigStamp string
+
+	// FileModeIssues are config and key files other users may read or change
+	FileModeIssues []FileModeIssue
+}
+
+// LoadHosts parses the SSH config and kport config in the background and
+// refreshes the host snapshot used for the next startup
+func LoadHosts() tea.Cmd {
+	return func() tea.Msg {
+		sshConfig := NewSSHConfig()
+		sshErr := sshConfig.LoadConfig()
+
+		// Watch the files even when parsing fails so fixing them triggers a reload
+		files := sshConfig.Files
+		if path, err := kportConfigPath(); err == nil {
+			files = append(files, path)
+		}
+		paths := watchedConfigPaths(files)
+		stamp := configStamp(paths)
+
+		if sshErr != nil {
+			return HostsLoadedMsg{Err: sshErr, ConfigPaths: paths, ConfigStamp: stamp}
+		}
+
+		kportConfig, err := LoadKportConfig()
+		if err != nil {
+			return HostsLoadedMsg{Err: err, ConfigPaths: paths, ConfigStamp: stamp}
+		}
+
+		hosts := collectHosts(sshConfig, kportConfig)
+
+		if err := saveHostSnapshot(hosts); err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to save host snapshot: %v\n", err)
+		}
+
+		var issues []FileModeIssue
+		if kportConfig.StrictModes != StrictModesOff {
+			issues = fileModeIssues(allFiles(sshConfig.Files, hosts, kportConfig))
+		}
+
+		return HostsLoadedMsg{
+			SSHConfig:      sshConfig,
+			KportConfig:    kportConfig,
+			Hosts:          hosts,
+			ConfigPaths:    paths,
+			ConfigStamp:    stamp,
+			FileModeIssues: issues,
+		}
+	}
+}
+
+// collectHosts returns the SSH config hosts, any Teleport nodes, local VMs
+// and the hosts of plugins with kport's settings applied
+func collectHosts(sshConfig *SSHConfig, kportConfig *KportConfig) []SSHHost {
+	hosts := append([]SSHHost{}, sshConfig.GetHosts()...)
+	forgetPluginDescriptions()
+
+	// Teleport nodes are listed after the SSH config hosts
+	if kportConfig.Teleport.Enabled {
+		teleportHosts, err := loadTeleportHosts(kportConfig.Teleport)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to load Teleport nodes: %v\n", err)
+		}
+		hosts = append(hosts, teleportHosts...)
+	}
+
+	// Local VMs come next, unless the SSH config already has a host of the same name
+	if kportConfig.VMs.enabled() {
+		vmHosts, err := loadVMHosts()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to load local VMs: %v\n", err)
+		}
+		for _, host := range vmHosts {
+			if !slices.ContainsFunc(hosts, func(other SSHHost) bool { return other.Name == host.Name }) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	// Plugin hosts come last, in the order of the plugin names
+	hosts = append(hosts, loadPluginHosts(kportConfig)...)
+
+	for i := range hosts {
+		hosts[i] = kportConfig.ApplyTo(hosts[i])
+	}
+
+	return hosts
+}
+
+// hostSnapshotPath returns the path of the cached host list
+func hostSnapshotPath() (string, error) {
+	dir, err := kportCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "hosts.json"), nil
+}
+
+// loadHostSnapshot reads the host list cached by the previous run
+func loadHostSnapshot() ([]SSHHost, error) {
+	path, err := hostSnapshotPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []SSHHost
+	if err := json.Unmarshal(data, &hosts); err != nil {
+		return nil, fmt.Errorf("failed to parse host snapshot %s: %w", path, err)
+	}
+	return hosts, nil
+}
+
+// saveHostSnapshot caches the host list so the next run can show it immediately
+func saveHostSnapshot(hosts []SSHHost) error {
+	path, err := hostSnapshotPath()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(hosts)
+	if err != nil {
+		return fmt.Errorf("failed to encode host snapshot: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated snapshot
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write host snapshot: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
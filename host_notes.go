@@ -0,0 +1,56 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// hostNotePattern matches the "# key: value" comments that annotate the Host block below them
+var hostNotePattern = regexp.MustCompile(`^#+\s*([A-Za-z][A-Za-z0-9_-]*)\s*:\s*(\S.*)$`)
+
+// HostNote is a "# key: value" comment above a Host block, such as "# owner: infra"
+type HostNote struct {
+	Key   string
+	Value string
+}
+
+// parseHostNote parses a comment line as a host note
+func parseHostNote(comment string) (HostNote, bool) {
+	match := hostNotePattern.FindStringSubmatch(comment)
+	if match == nil {
+		return HostNote{}, false
+	}
+	return HostNote{Key: strings.ToLower(match[1]), Value: strings.TrimSpace(match[2])}, true
+}
+
+// isDescriptionNote reports whether a note key holds the host's description
+func isDescriptionNote(key string) bool {
+	return key == "desc" || key == "description"
+}
+
+// addNotes attaches the notes above a Host block to host. A key seen earlier,
+// above another block of the same host, keeps its first value like the
+// options do.
+func (h *SSHHost) addNotes(notes []HostNote) {
+	for _, note := range notes {
+		if isDescriptionNote(note.Key) {
+			if h.Description == "" {
+				h.Description = note.Value
+			}
+			continue
+		}
+		if h.Note(note.Key) == "" {
+			h.Notes = append(h.Notes, note)
+		}
+	}
+}
+
+// Note returns the value of the host note key, or "" when there is none
+func (h SSHHost) Note(key string) string {
+	for _, note := range h.Notes {
+		if note.Key == key {
+			return note.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// nonHTTPPorts are well-known ports of services that don't speak HTTP, so they are not probed
+var nonHTTPPorts = []int{
+	21, 22, 23, 25, 53, 110, 143, 389, 445, 465, 587, 636, 993, 995,
+	1433, 1521, 2181, 3306, 5432, 5672, 6379, 9042, 9092, 11211, 27017,
+}
+
+// httpProbeScript requests the front page of each port from the remote host
+// itself, following a few redirects to reach the page that names the app. It
+// uses curl when available and falls back to bash's /dev/tcp. Only the start
+// of each response is kept, which holds the headers and the page's <head>.
+const httpProbeScript = `probe() {
+  if command -v curl >/dev/null 2>&1; then
+    curl -s -i -L --max-redirs 3 -m 3 "http://127.0.0.1:$1/"
+  elif command -v bash >/dev/null 2>&1; then
+    timeout 3 bash -c 'exec 3<>/dev/tcp/127.0.0.1/$0 && printf "GET / HTTP/1.0\r\nHost: localhost\r\nConnection: close\r\n\r\n" >&3 && cat <&3' "$1"
+  fi
+}
+for p in %s; do echo "@port $p"; probe "$p" 2>/dev/null | head -c 32768; echo; done`
+
+// webAppHeaders are response headers only a particular app sends
+var webAppHeaders = map[string]string{
+	"X-Jenkins": "Jenkins",
+	"Kbn-Name":  "Kibana",
+}
+
+// webAppIcons are apps whose name shows up in the path of their favicon,
+// and the word to look for
+var webAppIcons = []struct {
+	word string
+	app  string
+}{
+	{"grafana", "Grafana"},
+	{"fav32.png", "Grafana"},
+	{"jenkins", "Jenkins"},
+	{"gitlab", "GitLab"},
+	{"gitea", "Gitea"},
+	{"kibana", "Kibana"},
+	{"jupyter", "Jupyter"},
+	{"portainer", "Portainer"},
+	{"pgadmin", "pgAdmin"},
+	{"sonarqube", "SonarQube"},
+}
+
+// maxTitleLength is how much of a page title is shown next to a port
+const maxTitleLength = 40
+
+var (
+	titlePattern    = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	iconLinkPattern = regexp.MustCompile(`(?is)<link\b[^>]*\brel=["']?(?:shortcut )?icon\b[^>]*>`)
+	hrefPattern     = regexp.MustCompile(`(?is)\bhref=["']?([^"' >]+)`)
+)
+
+// HTTPProbe is the response of a port to an HTTP HEAD request
+type HTTPProbe struct {
+	// Status is the status of the response, such as "200 OK", empty when the port didn't answer HTTP
+	Status string
+
+	// Server is the Server header of the response
+	Server string
+
+	// App is the app serving the port, guessed from its headers, favicon or
+	// page title, empty when the page doesn't name it
+	App string
+}
+
+// String describes the probe result for the port list
+func (hp HTTPProbe) String() string {
+	if hp.Status == "" {
+		return "no HTTP response"
+	}
+	if hp.Server == "" {
+		return hp.Status
+	}
+	return fmt.Sprintf("%s · %s", hp.Status, hp.Server)
+}
+
+// Label returns the name shown next to the port, such as "— Jenkins", or "" without one
+func (hp HTTPProbe) Label() string {
+	if hp.App == "" {
+		return ""
+	}
+	return "— " + hp.App
+}
+
+// HTTPProbedMsg is sent when the detected ports of a host have been probed
+type HTTPProbedMsg struct {
+	Host    string
+	Results map[int]HTTPProbe
+	Err     error
+}
+
+// httpCandidates returns the ports that may be serving HTTP
+func httpCandidates(ports []int) []int {
+	candidates := make([]int, 0, len(ports))
+	for _, port := range ports {
+		if !slices.Contains(nonHTTPPorts, port) {
+			candidates = append(candidates, port)
+		}
+	}
+	return candidates
+}
+
+// ProbeHTTP sends a HEAD request to each HTTP-looking port from the remote host,
+// so a live app can be told apart from a stale process holding the socket
+func ProbeHTTP(host SSHHost, ports []int) tea.Cmd {
+	return func() tea.Msg {
+		candidates := httpCandidates(ports)
+		if len(candidates) == 0 {
+			return HTTPProbedMsg{Host: host.Name, Results: map[int]HTTPProbe{}}
+		}
+
+		ctx, span := tracer.Start(context.Background(), "kport.http_probe", trace.WithAttributes(append(hostAttributes(host),
+			attribute.Int("kport.ports_probed", len(candidates)),
+		)...))
+
+		host, err := tracedPrepareHost(ctx, host)
+		if err != nil {
+			endSpan(span, err)
+			return HTTPProbedMsg{Host: host.Name, Err: err}
+		}
+
+		portList := make([]string, 0, len(candidates))
+		for _, port := range candidates {
+			portList = append(portList, strconv.Itoa(port))
+		}
+		script := fmt.Sprintf(httpProbeScript, strings.Join(portList, " "))
+
+		sshCmd := sshCommand(host, host.probeOptions(10), script)
+		output, err := tracedOutput(ctx, "http probe", sshCmd)
+		endSpan(span, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: HTTP probe failed for %s: %v\n", host.Name, err)
+			return HTTPProbedMsg{Host: host.Name, Err: fmt.Errorf("failed to probe ports: %w", err)}
+		}
+
+		return HTTPProbedMsg{Host: host.Name, Results: parseHTTPProbes(output)}
+	}
+}
+
+// parseHTTPProbes parses the output of httpProbeScript
+func parseHTTPProbes(output []byte) map[int]HTTPProbe {
+	results := make(map[int]HTTPProbe)
+
+	port := 0
+	var response strings.Builder
+	flush := func() {
+		if port != 0 {
+			results[port] = parseHTTPResponse(response.String())
+		}
+		response.Reset()
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if value, ok := strings.CutPrefix(line, "@port "); ok {
+			flush()
+			port, _ = strconv.Atoi(value)
+			continue
+		}
+		response.WriteString(line + "\n")
+	}
+	flush()
+
+	return results
+}
+
+// parseHTTPResponse reads the status and server of the first response to a
+// probe, and the app from the last one, which a redirect may have led to
+func parseHTTPResponse(response string) HTTPProbe {
+	var probe HTTPProbe
+
+	rest := strings.TrimLeft(response, "\n")
+	var finalStatus string
+	var header http.Header
+	for strings.HasPrefix(rest, "HTTP/") {
+		var head string
+		head, rest, _ = strings.Cut(rest, "\n\n")
+		lines := strings.Split(head, "\n")
+		// "HTTP/1.1 200 OK" -> "200 OK"
+		_, finalStatus, _ = strings.Cut(lines[0], " ")
+		if probe.Status == "" {
+			probe.Status = finalStatus
+		}
+
+		header = make(http.Header)
+		for _, line := range lines[1:] {
+			if name, value, ok := strings.Cut(line, ":"); ok {
+				header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+			}
+		}
+		if probe.Server == "" {
+			probe.Server = header.Get("Server")
+		}
+		rest = strings.TrimLeft(rest, "\n")
+	}
+	if probe.Status == "" {
+		return probe
+	}
+
+	probe.App = guessWebApp(header, rest, strings.HasPrefix(finalStatus, "2"))
+	return probe
+}
+
+// guessWebApp names the app behind a page by a header only it sends, a
+// favicon path with its name, or else the title of a successful page
+func guessWebApp(header http.Header, body string, successful bool) string {
+	for name, app := range webAppHeaders {
+		if header.Get(name) != "" {
+			return app
+		}
+	}
+
+	if link := iconLinkPattern.FindString(body); link != "" {
+		if href := hrefPattern.FindStringSubmatch(link); href != nil {
+			path := strings.ToLower(href[1])
+			for _, icon := range webAppIcons {
+				if strings.Contains(path, icon.word) {
+					return icon.app
+				}
+			}
+		}
+	}
+
+	if !successful {
+		return ""
+	}
+	match := titlePattern.FindStringSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	title := strings.Join(strings.Fields(html.UnescapeString(match[1])), " ")
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength-1]) + "…"
+	}
+	return title
+}
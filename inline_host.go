@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// parseInlineHost builds a host from a [user@]host[:port] spec given on the
+// command line. The user defaults to the local user and the port to 22;
+// authentication uses ssh's defaults (agent and default keys).
+func parseInlineHost(spec string) (SSHHost, error) {
+	host := SSHHost{
+		Name:   spec,
+		Port:   "22",
+		Inline: true,
+	}
+
+	address := spec
+	if at := strings.LastIndex(spec, "@"); at >= 0 {
+		host.User = spec[:at]
+		address = spec[at+1:]
+		if host.User == "" {
+			return SSHHost{}, fmt.Errorf("invalid host %q: empty user", spec)
+		}
+	}
+
+	// host:port and [ipv6]:port carry a port, a bare IPv6 address does not
+	if strings.HasPrefix(address, "[") || strings.Count(address, ":") == 1 {
+		hostname, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return SSHHost{}, fmt.Errorf("invalid host %q: %w", spec, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return SSHHost{}, fmt.Errorf("invalid host %q: bad port %q", spec, port)
+		}
+		address = hostname
+		host.Port = port
+	}
+	if address == "" {
+		return SSHHost{}, fmt.Errorf("invalid host %q: empty hostname", spec)
+	}
+	host.Hostname = address
+
+	if host.User == "" {
+		host.User = currentUsername()
+		host.UserSource = "local user"
+	}
+
+	return host, nil
+}
+
+// resolveHost returns the configured host named name, or an inline host parsed
+// from it when no config defines it
+func resolveHost(name string, hosts []SSHHost, kportConfig *KportConfig) (SSHHost, error) {
+	if host, err := findHost(hosts, name); err == nil {
+		return host, nil
+	}
+
+	host, err := parseInlineHost(name)
+	if err != nil {
+		return SSHHost{}, err
+	}
+	return kportConfig.ApplyTo(host), nil
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// inventoryFormats are the formats the tunnel inventory can be exported in
+var inventoryFormats = []string{"md", "csv", "json"}
+
+// TunnelInventory describes an active tunnel in an exported inventory
+type TunnelInventory struct {
+	Host       string    `json:"host"`
+	LocalPort  int       `json:"local_port"`
+	Socket     string    `json:"socket,omitempty"`
+	RemotePort int       `json:"remote_port"`
+	Profile    string    `json:"profile,omitempty"`
+	Label      string    `json:"label,omitempty"`
+	Note       string    `json:"note,omitempty"`
+	Owner      string    `json:"owner"`
+	StartedAt  time.Time `json:"started_at"`
+
+	// Traffic is only known for tunnels of this process and the daemon
+	Traffic *TunnelTraffic `json:"traffic,omitempty"`
+}
+
+// LocalAddress is where local clients connect to the tunnel
+func (ti TunnelInventory) LocalAddress() string {
+	if ti.Socket != "" {
+		return ti.Socket
+	}
+	return fmt.Sprintf("localhost:%d", ti.LocalPort)
+}
+
+// TunnelTraffic is the connection and byte counts of a tunnel
+type TunnelTraffic struct {
+	ActiveConns int   `json:"active_conns"`
+	TotalConns  int64 `json:"total_conns"`
+	BytesIn     int64 `json:"bytes_in"`
+	BytesOut    int64 `json:"bytes_out"`
+}
+
+// InventoryExportedMsg is sent when the TUI has written a tunnel inventory
+type InventoryExportedMsg struct {
+	Path  string
+	Count int
+	Err   error
+}
+
+// collectInventory lists the active tunnels of this process, the daemon and
+// any other running kport instances
+func collectInventory(local []*Tunnel) []TunnelInventory {
+	inventory := make([]TunnelInventory, 0)
+	// Tunnels on a local socket have no port, so they are told apart by address
+	covered := make(map[string]bool)
+
+	for _, tunnel := range local {
+		pf := tunnel.Forwarder
+		if !pf.IsRunning() {
+			continue
+		}
+		stats := pf.ConnStats()
+		label, note := pf.Label()
+		inventory = append(inventory, TunnelInventory{
+			Host:       pf.Host().Name,
+			LocalPort:  pf.LocalPort(),
+			Socket:     pf.LocalSocket(),
+			RemotePort: pf.RemotePort(),
+			Profile:    tunnel.Profile,
+			Label:      label,
+			Note:       note,
+			Owner:      fmt.Sprintf("kport (pid %d)", os.Getpid()),
+			StartedAt:  pf.StartedAt(),
+			Traffic: &TunnelTraffic{
+				ActiveConns: stats.Active,
+				TotalConns:  stats.Total,
+				BytesIn:     stats.BytesIn,
+				BytesOut:    stats.BytesOut,
+			},
+		})
+		covered[pf.LocalAddress()] = true
+	}
+
+	if resp, err := callDaemon(DaemonRequest{Command: "status"}, false); err == nil {
+		for _, tunnel := range resp.Tunnels {
+			if !tunnel.Running || covered[tunnel.LocalAddress()] {
+				continue
+			}
+			inventory = append(inventory, TunnelInventory{
+				Host:       tunnel.Host,
+				LocalPort:  tunnel.LocalPort,
+				Socket:     tunnel.Socket,
+				RemotePort: tunnel.RemotePort,
+				Profile:    tunnel.Profile,
+				Owner:      "daemon",
+				StartedAt:  tunnel.StartedAt,
+				Traffic: &TunnelTraffic{
+					ActiveConns: tunnel.ActiveConns,
+					TotalConns:  tunnel.TotalConns,
+					BytesIn:     tunnel.BytesIn,
+					BytesOut:    tunnel.BytesOut,
+				},
+			})
+			covered[tunnel.LocalAddress()] = true
+		}
+	}
+
+	// Other instances only share their port reservations
+	for _, reservation := range otherReservations() {
+		if covered[fmt.Sprintf("localhost:%d", reservation.Port)] {
+			continue
+		}
+		inventory = append(inventory, TunnelInventory{
+			Host:       reservation.Host,
+			LocalPort:  reservation.Port,
+			RemotePort: reservation.RemotePort,
+			Owner:      fmt.Sprintf("kport (pid %d)", reservation.PID),
+			StartedAt:  reservation.Since,
+		})
+	}
+
+	sort.Slice(inventory, func(i, j int) bool {
+		if inventory[i].LocalPort != inventory[j].LocalPort {
+			return inventory[i].LocalPort < inventory[j].LocalPort
+		}
+		return inventory[i].Socket < inventory[j].Socket
+	})
+	return inventory
+}
+
+// formatInventory renders the inventory as a markdown table, CSV or JSON
+func formatInventory(inventory []TunnelInventory, format string, now time.Time) ([]byte, error) {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(inventory, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case "csv":
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"host", "local_port", "socket", "remote_port", "profile", "owner", "started_at", "uptime_seconds", "active_conns", "total_conns", "bytes_in", "bytes_out", "label", "note"})
+		for _, tunnel := range inventory {
+			row := []string{
+				tunnel.Host, strconv.Itoa(tunnel.LocalPort), tunnel.Socket, strconv.Itoa(tunnel.RemotePort), tunnel.Profile, tunnel.Owner,
+				tunnel.StartedAt.Format(time.RFC3339), strconv.Itoa(int(now.Sub(tunnel.StartedAt).Seconds())),
+				"", "", "", "",
+				tunnel.Label, tunnel.Note,
+			}
+			if traffic := tunnel.Traffic; traffic != nil {
+				row[8] = strconv.Itoa(traffic.ActiveConns)
+				row[9] = strconv.FormatInt(traffic.TotalConns, 10)
+				row[10] = strconv.FormatInt(traffic.BytesIn, 10)
+				row[11] = strconv.FormatInt(traffic.BytesOut, 10)
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	case "md":
+		var s strings.Builder
+		s.WriteString("| Host | Local | Remote | Label | Profile | Owner | Uptime | Connections | Traffic in / out |\n")
+		s.WriteString("|------|-------|--------|-------|---------|-------|--------|-------------|------------------|\n")
+		for _, tunnel := range inventory {
+			connections, traffic := "-", "-"
+			if t := tunnel.Traffic; t != nil {
+				connections = fmt.Sprintf("%d active, %d total", t.ActiveConns, t.TotalConns)
+				traffic = fmt.Sprintf("%s / %s", formatBytes(t.BytesIn), formatBytes(t.BytesOut))
+			}
+			profile := tunnel.Profile
+			if profile == "" {
+				profile = "-"
+			}
+			label := tunnel.Label
+			switch {
+			case label != "" && tunnel.Note != "":
+				label += ": " + tunnel.Note
+			case label == "" && tunnel.Note != "":
+				label = tunnel.Note
+			case label == "":
+				label = "-"
+			}
+			fmt.Fprintf(&s, "| %s | %s | %d | %s | %s | %s | %s | %s | %s |\n",
+				markdownEscape(tunnel.Host), markdownEscape(tunnel.LocalAddress()), tunnel.RemotePort, markdownEscape(label), markdownEscape(profile),
+				tunnel.Owner, formatAge(now.Sub(tunnel.StartedAt)), connections, traffic)
+		}
+		return []byte(s.String()), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q, use one of %s", format, strings.Join(inventoryFormats, ", "))
+	}
+}
+
+// markdownEscape keeps a value from breaking a markdown table cell
+func markdownEscape(value string) string {
+	return strings.ReplaceAll(value, "|", `\|`)
+}
+
+// formatBytes formats a byte count with a binary unit, such as "1.5 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for v := n / unit; v >= unit; v /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ExportInventory writes the inventory of active tunnels as a markdown file in
+// kport's cache directory, ready to paste into incident docs and runbooks
+func ExportInventory(local []*Tunnel) tea.Cmd {
+	return func() tea.Msg {
+		inventory := collectInventory(local)
+		now := time.Now()
+		data, err := formatInventory(inventory, "md", now)
+		if err != nil {
+			return InventoryExportedMsg{Err: err}
+		}
+
+		dir, err := kportCacheDir("exports")
+		if err != nil {
+			return InventoryExportedMsg{Err: err}
+		}
+		path := filepath.Join(dir, fmt.Sprintf("tunnels-%s.md", now.Format("20060102-150405")))
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			return InventoryExportedMsg{Err: fmt.Errorf("failed to write tunnel inventory: %w", err)}
+		}
+		return InventoryExportedMsg{Path: path, Count: len(inventory)}
+	}
+}
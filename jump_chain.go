@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// jumpCheckTimeout is the connect timeout of each hop's check in seconds
+const jumpCheckTimeout = 5
+
+// HopStatus is what is known about reaching one hop of a jump chain
+type HopStatus struct {
+	Name     string
+	Checking bool
+	Checked  bool
+	Latency  time.Duration
+	Err      error
+}
+
+// JumpHopCheckedMsg is sent when a hop of a host's jump chain was checked
+type JumpHopCheckedMsg struct {
+	Host    string
+	Hop     int
+	Latency time.Duration
+	Err     error
+}
+
+// applyJumpChains resolves the jump hosts each host is reached through. The
+// first ProxyJump obtained wins, and the first jump host may itself be behind
+// jump hosts of its own, which ssh goes through first.
+func (sc *SSHConfig) applyJumpChains() {
+	for i := range sc.Hosts {
+		host := &sc.Hosts[i]
+		if isHostPattern(host.Name) {
+			continue
+		}
+		if host.ProxyJump == "" {
+			for _, block := range sc.Hosts {
+				if block.ProxyJump != "" && blockApplies(block, *host) {
+					host.ProxyJump = block.ProxyJump
+					break
+				}
+			}
+		}
+	}
+	for i := range sc.Hosts {
+		if !isHostPattern(sc.Hosts[i].Name) {
+			sc.Hosts[i].JumpChain = sc.jumpChain(sc.Hosts[i], map[string]bool{sc.Hosts[i].Name: true})
+		}
+	}
+}
+
+// jumpChain returns the hops to host in the order ssh connects through them
+func (sc *SSHConfig) jumpChain(host SSHHost, visited map[string]bool) []string {
+	if host.ProxyJump == "" || strings.EqualFold(host.ProxyJump, "none") {
+		return nil
+	}
+	hops := strings.Split(host.ProxyJump, ",")
+	chain := make([]string, 0, len(hops))
+	for _, block := range sc.Hosts {
+		if block.Name == hops[0] && !visited[block.Name] {
+			visited[block.Name] = true
+			chain = append(chain, sc.jumpChain(block, visited)...)
+			break
+		}
+	}
+	for _, hop := range hops {
+		chain = append(chain, strings.TrimSpace(hop))
+	}
+	return chain
+}
+
+// jumpDestination returns how a hop is passed to ssh, which only takes a port
+// in a destination written as an ssh:// URI
+func jumpDestination(hop string) string {
+	if strings.Contains(hop, ":") && !strings.Contains(hop, "[") {
+		return "ssh://" + hop
+	}
+	return hop
+}
+
+// CheckJumpHop connects to a jump host of target's chain through the hops
+// before it, timing how long it took
+func CheckJumpHop(target string, hop SSHHost, through []string) tea.Cmd {
+	return func() tea.Msg {
+		options := hop.probeOptions(jumpCheckTimeout)
+		if len(through) > 0 {
+			options = append(options, "-J", strings.Join(through, ","))
+		}
+
+		start := time.Now()
+		cmd := sshCommand(hop, options, "true")
+		output, err := cmd.CombinedOutput()
+		latency := time.Since(start)
+		recordRemoteCommand(cmd, "jump host check", start, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Jump host %s of %s is unreachable: %v\n", hop.Name, target, err)
+			if sshErr := sshConnectionError(hop, output, err); sshErr != nil {
+				err = sshErr
+			} else if lines := strings.Split(strings.TrimSpace(string(output)), "\n"); lines[len(lines)-1] != "" {
+				err = fmt.Errorf("%s", lines[len(lines)-1])
+			}
+		}
+		return JumpHopCheckedMsg{Host: target, Hop: len(through), Latency: latency, Err: err}
+	}
+}
+
+// startJumpCheck checks the jump hosts of host one after the other, so the
+// first hop that can't be reached is the one reported
+func (m *Model) startJumpCheck(host SSHHost) tea.Cmd {
+	if len(host.JumpChain) == 0 {
+		return nil
+	}
+	chain := make([]HopStatus, 0, len(host.JumpChain)+1)
+	for _, hop := range host.JumpChain {
+		chain = append(chain, HopStatus{Name: hop})
+	}
+	// The target's status comes from port detection
+	chain = append(chain, HopStatus{Name: host.Name})
+	m.jumpChains[host.Name] = chain
+	return m.checkJumpHop(host.Name, 0)
+}
+
+// checkJumpHop starts the check of the i-th hop of a host's jump chain
+func (m *Model) checkJumpHop(target string, i int) tea.Cmd {
+	chain := m.jumpChains[target]
+	chain[i].Checking = true
+
+	hop := SSHHost{Name: jumpDestination(chain[i].Name)}
+	if containsHost(m.hosts, chain[i].Name) {
+		hop = m.hosts[m.hostIndex(chain[i].Name, 0)]
+	}
+	return CheckJumpHop(target, hop, m.jumpHops(target, i))
+}
+
+// jumpHops returns the jump hosts before the i-th hop of a host's chain
+func (m *Model) jumpHops(target string, i int) []string {
+	through := make([]string, 0, i)
+	for _, hop := range m.jumpChains[target][:i] {
+		through = append(through, hop.Name)
+	}
+	return through
+}
+
+// updateJumpHopChecked records a hop's check and moves on to the next jump host
+func (m *Model) updateJumpHopChecked(msg JumpHopCheckedMsg) tea.Cmd {
+	chain := m.jumpChains[msg.Host]
+	// A reconnect may have restarted the checks in the meantime
+	if msg.Hop >= len(chain) || !chain[msg.Hop].Checking {
+		return nil
+	}
+	chain[msg.Hop] = HopStatus{Name: chain[msg.Hop].Name, Checked: true, Latency: msg.Latency, Err: msg.Err}
+	if msg.Err != nil || msg.Hop+1 >= len(chain)-1 {
+		return nil
+	}
+	return m.checkJumpHop(msg.Host, msg.Hop+1)
+}
+
+// setJumpTarget records whether the target of a jump chain was reached, as
+// told by port detection
+func (m *Model) setJumpTarget(host string, err error) {
+	chain := m.jumpChains[host]
+	if len(chain) == 0 {
+		return
+	}
+	// Past a jump host that can't be reached the target is unknown
+	for _, hop := range chain[:len(chain)-1] {
+		if hop.Err != nil {
+			return
+		}
+	}
+	chain[len(chain)-1] = HopStatus{Name: host, Checked: true, Err: err}
+}
+
+// renderJumpChain renders the hops from this machine to host with the status
+// of each, and why the first unreachable hop failed
+func (m *Model) renderJumpChain(host string) string {
+	chain := m.jumpChains[host]
+	if len(chain) == 0 {
+		return ""
+	}
+
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	upStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575"))
+	downStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+
+	hops := []string{"localhost"}
+	var failed *HopStatus
+	for i, hop := range chain {
+		switch {
+		case hop.Checked && hop.Err != nil:
+			hops = append(hops, downStyle.Render(hop.Name+" ✗"))
+			if failed == nil {
+				failed = &chain[i]
+			}
+		case hop.Checked && hop.Latency > 0:
+			hops = append(hops, upStyle.Render(hop.Name+" ✓")+dimStyle.Render(" "+hop.Latency.Round(time.Millisecond).String()))
+		case hop.Checked:
+			hops = append(hops, upStyle.Render(hop.Name+" ✓"))
+		case hop.Checking:
+			hops = append(hops, hop.Name+dimStyle.Render(" ..."))
+		default:
+			hops = append(hops, dimStyle.Render(hop.Name))
+		}
+	}
+
+	var s strings.Builder
+	s.WriteString("Route: " + strings.Join(hops, dimStyle.Render(" → ")) + "\n")
+	if failed != nil {
+		s.WriteString(downStyle.Render(fmt.Sprintf("  ✗ %s: %v", failed.Name, failed.Err)) + "\n")
+	}
+	s.WriteString("\n")
+	return s.String()
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Action is something a key can be bound to in the TUI
+type Action string
+
+const (
+	ActionQuit         Action = "quit"
+	ActionUp           Action = "up"
+	ActionDown         Action = "down"
+	ActionSelect       Action = "select"
+	ActionBack         Action = "back"
+	ActionHelp         Action = "help"
+	ActionReload       Action = "reload"
+	ActionInfo         Action = "info"
+	ActionEditUser     Action = "edit_user"
+	ActionManualPort   Action = "manual_port"
+	ActionForwardHTTPS Action = "forward_https"
+	ActionAgent        Action = "agent"
+	ActionAgentKeys    Action = "agent_keys"
+	ActionTTL          Action = "ttl"
+	ActionProbeHTTP    Action = "probe_http"
+	ActionCapture      Action = "capture"
+	ActionContainers   Action = "containers"
+	ActionExport       Action = "export"
+	ActionThroughput   Action = "export_throughput"
+	ActionForwards     Action = "configured_forwards"
+	ActionLabel        Action = "label"
+	ActionShare        Action = "share"
+	ActionRetry        Action = "retry"
+	ActionDropPending  Action = "drop_pending"
+	ActionBackground   Action = "background"
+	ActionNextSection  Action = "next_section"
+
+	ActionFilterAll       Action = "filter_all"
+	ActionFilterWeb       Action = "filter_web"
+	ActionFilterDatabase  Action = "filter_database"
+	ActionFilterCache     Action = "filter_cache"
+	ActionFilterMessaging Action = "filter_messaging"
+	ActionFilterSystem    Action = "filter_system"
+)
+
+// filterActions are the actions filtering the port list, matching portCategories
+var filterActions = []Action{ActionFilterWeb, ActionFilterDatabase, ActionFilterCache, ActionFilterMessaging, ActionFilterSystem}
+
+// keyPresets are the built-in keymaps selected with keys.preset
+var keyPresets = map[string]map[Action][]string{
+	// default accepts both arrow keys and vim-style j/k
+	"default": {
+		ActionQuit:         {"q", "ctrl+c"},
+		ActionUp:           {"up", "k"},
+		ActionDown:         {"down", "j"},
+		ActionSelect:       {"enter", " "},
+		ActionBack:         {"esc"},
+		ActionHelp:         {"?"},
+		ActionReload:       {"r"},
+		ActionInfo:         {"i"},
+		ActionEditUser:     {"u"},
+		ActionManualPort:   {"m"},
+		ActionForwardHTTPS: {"s"},
+		ActionAgent:        {"a"},
+		ActionAgentKeys:    {"K"},
+		ActionTTL:          {"t"},
+		ActionProbeHTTP:    {"h"},
+		ActionCapture:      {"c"},
+		ActionContainers:   {"d"},
+		ActionExport:       {"e"},
+		ActionThroughput:   {"T"},
+		ActionForwards:     {"f"},
+		ActionLabel:        {"n"},
+		ActionShare:        {"S"},
+		ActionRetry:        {"R"},
+		ActionDropPending:  {"x"},
+		ActionBackground:   {"b"},
+		ActionNextSection:  {"tab"},
+
+		ActionFilterAll:       {"0"},
+		ActionFilterWeb:       {"1"},
+		ActionFilterDatabase:  {"2"},
+		ActionFilterCache:     {"3"},
+		ActionFilterMessaging: {"4"},
+		ActionFilterSystem:    {"5"},
+	},
+	// arrows navigates with the arrow keys only, leaving letters for actions
+	"arrows": {
+		ActionQuit:         {"q", "ctrl+c"},
+		ActionUp:           {"up"},
+		ActionDown:         {"down"},
+		ActionSelect:       {"enter", "right"},
+		ActionBack:         {"esc", "left"},
+		ActionHelp:         {"?"},
+		ActionReload:       {"r"},
+		ActionInfo:         {"i"},
+		ActionEditUser:     {"u"},
+		ActionManualPort:   {"m"},
+		ActionForwardHTTPS: {"s"},
+		ActionAgent:        {"a"},
+		ActionAgentKeys:    {"K"},
+		ActionTTL:          {"t"},
+		ActionProbeHTTP:    {"h"},
+		ActionCapture:      {"c"},
+		ActionContainers:   {"d"},
+		ActionExport:       {"e"},
+		ActionThroughput:   {"T"},
+		ActionForwards:     {"f"},
+		ActionLabel:        {"n"},
+		ActionShare:        {"S"},
+		ActionRetry:        {"R"},
+		ActionDropPending:  {"x"},
+		ActionBackground:   {"b"},
+		ActionNextSection:  {"tab"},
+
+		ActionFilterAll:       {"0"},
+		ActionFilterWeb:       {"1"},
+		ActionFilterDatabase:  {"2"},
+		ActionFilterCache:     {"3"},
+		ActionFilterMessaging: {"4"},
+		ActionFilterSystem:    {"5"},
+	},
+	// vim navigates with h/j/k/l, so probing HTTP moves to p
+	"vim": {
+		ActionQuit:         {"q", "ctrl+c"},
+		ActionUp:           {"k"},
+		ActionDown:         {"j"},
+		ActionSelect:       {"l", "enter"},
+		ActionBack:         {"h", "esc"},
+		ActionHelp:         {"?"},
+		ActionReload:       {"r"},
+		ActionInfo:         {"i"},
+		ActionEditUser:     {"u"},
+		ActionManualPort:   {"m"},
+		ActionForwardHTTPS: {"s"},
+		ActionAgent:        {"a"},
+		ActionAgentKeys:    {"K"},
+		ActionTTL:          {"t"},
+		ActionProbeHTTP:    {"p"},
+		ActionCapture:      {"c"},
+		ActionContainers:   {"d"},
+		ActionExport:       {"e"},
+		ActionThroughput:   {"T"},
+		ActionForwards:     {"f"},
+		ActionLabel:        {"n"},
+		ActionShare:        {"S"},
+		ActionRetry:        {"R"},
+		ActionDropPending:  {"x"},
+		ActionBackground:   {"b"},
+		ActionNextSection:  {"tab"},
+
+		ActionFilterAll:       {"0"},
+		ActionFilterWeb:       {"1"},
+		ActionFilterDatabase:  {"2"},
+		ActionFilterCache:     {"3"},
+		ActionFilterMessaging: {"4"},
+		ActionFilterSystem:    {"5"},
+	},
+}
+
+// binding is an action available in a view, with its description there
+type binding struct {
+	action Action
+	help   string
+}
+
+// stateBindings lists the actions of each view in the order they are shown.
+// States that take free text, like editing the user, handle their keys directly.
+var stateBindings = map[AppState][]binding{
+	StateQuickConnect: {
+		{ActionUp, "Move up"},
+		{ActionDown, "Move down"},
+		{ActionSelect, "Connect"},
+		{ActionBack, "Show all hosts"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+	StateSelectHost: {
+		{ActionUp, "Move up"},
+		{ActionDown, "Move down"},
+		{ActionSelect, "Select host"},
+		{ActionInfo, "Host info"},
+		{ActionManualPort, "Manual port"},
+		{ActionEditUser, "Connect as user"},
+		{ActionAgentKeys, "Pick agent key"},
+		{ActionReload, "Reload config"},
+		{ActionExport, "Export tunnel inventory"},
+		{ActionRetry, "Retry pending tunnels now"},
+		{ActionDropPending, "Drop pending tunnels"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+	StateHostInfo: {
+		{ActionSelect, "Select host"},
+		{ActionReload, "Refresh facts"},
+		{ActionBack, "Back"},
+		{ActionInfo, "Close host info"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+	StateConnecting: {
+		{ActionBack, "Cancel and go back"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+	StateSelectPort: {
+		{ActionUp, "Move up"},
+		{ActionDown, "Move down"},
+		{ActionSelect, "Forward"},
+		{ActionNextSection, "Next section"},
+		{ActionForwardHTTPS, "Forward over HTTPS"},
+		{ActionManualPort, "Manual port"},
+		{ActionProbeHTTP, "Probe HTTP"},
+		{ActionTTL, "Change time limit"},
+		{ActionAgent, "Toggle SSH agent forwarding"},
+		{ActionForwards, "Toggle configured forwards"},
+		{ActionContainers, "Ports inside containers"},
+		{ActionReload, "Refresh ports"},
+		{ActionFilterWeb, "Show web ports"},
+		{ActionFilterDatabase, "Show database ports"},
+		{ActionFilterCache, "Show cache ports"},
+		{ActionFilterMessaging, "Show messaging ports"},
+		{ActionFilterSystem, "Show system ports"},
+		{ActionFilterAll, "Show all ports"},
+		{ActionBack, "Back"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+	StateSelectContainer: {
+		{ActionUp, "Move up"},
+		{ActionDown, "Move down"},
+		{ActionSelect, "Detect ports in container"},
+		{ActionBack, "Back to the host's ports"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+	StateManualPort: {
+		{ActionUp, "Previous suggestion"},
+		{ActionDown, "Next suggestion"},
+		{ActionSelect, "Start forwarding"},
+		{ActionTTL, "Change time limit"},
+		{ActionBack, "Back"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+	StateAgentKeys: {
+		{ActionUp, "Move up"},
+		{ActionDown, "Move down"},
+		{ActionSelect, "Connect offering this key"},
+		{ActionBack, "Back to hosts"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+	StateAlgorithms: {
+		{ActionSelect, "Retry with the offered algorithms enabled"},
+		{ActionBack, "Back to hosts"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+	StateStartingForward: {
+		{ActionBack, "Cancel"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+	StateForwarding: {
+		{ActionCapture, "Cycle capture (off/HTTP/pcap)"},
+		{ActionLabel, "Label the tunnel"},
+		{ActionShare, "Toggle a public share link"},
+		{ActionAgent, "Toggle SSH agent forwarding"},
+		{ActionForwards, "Toggle configured forwards"},
+		{ActionExport, "Export tunnel inventory"},
+		{ActionThroughput, "Export throughput samples"},
+		{ActionBackground, "Move tunnels to the daemon and quit"},
+		{ActionBack, "Stop forwarding and return"},
+		{ActionHelp, "Help"},
+		{ActionQuit, "Quit"},
+	},
+}
+
+// KeysConfig customizes the TUI's keybindings
+type KeysConfig struct {
+	// Preset is the keymap to start from: default, arrows or vim
+	Preset string `yaml:"preset"`
+
+	// Bindings replaces the keys of individual actions
+	Bindings map[Action][]string `yaml:"bindings"`
+}
+
+// KeyMap maps keys to actions
+type KeyMap struct {
+	Preset   string
+	bindings map[Action][]string
+}
+
+// DefaultKeyMap returns the keymap used when the config doesn't customize keys
+func DefaultKeyMap() KeyMap {
+	return KeyMap{Preset: "default", bindings: keyPresets["default"]}
+}
+
+// KeyMap builds the keymap from the preset and bindings, rejecting unknown
+// actions and keys bound to two actions of the same view
+func (kc KeysConfig) KeyMap() (KeyMap, error) {
+	preset := kc.Preset
+	if preset == "" {
+		preset = "default"
+	}
+	base, ok := keyPresets[preset]
+	if !ok {
+		return KeyMap{}, fmt.Errorf("unknown keys preset %q, expected default, arrows or vim", preset)
+	}
+
+	bindings := make(map[Action][]string, len(base))
+	for action, keys := range base {
+		bindings[action] = keys
+	}
+	for action, keys := range kc.Bindings {
+		if _, ok := base[action]; !ok {
+			return KeyMap{}, fmt.Errorf("unknown key action %q, expected one of %s", action, strings.Join(actionNames(), ", "))
+		}
+		normalized := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if key == "space" {
+				key = " "
+			}
+			if key == "" {
+				return KeyMap{}, fmt.Errorf("empty key bound to %s", action)
+			}
+			normalized = append(normalized, key)
+		}
+		bindings[action] = normalized
+	}
+	if len(bindings[ActionQuit]) == 0 {
+		return KeyMap{}, fmt.Errorf("quit must be bound to at least one key")
+	}
+
+	km := KeyMap{Preset: preset, bindings: bindings}
+	return km, km.checkConflicts()
+}
+
+// checkConflicts reports a key bound to more than one action in the same view
+func (km KeyMap) checkConflicts() error {
+	for _, bindings := range stateBindings {
+		seen := make(map[string]Action)
+		for _, b := range bindings {
+			for _, key := range km.bindings[b.action] {
+				if other, ok := seen[key]; ok && other != b.action {
+					return fmt.Errorf("key %s is bound to both %s and %s", keyLabel(key), other, b.action)
+				}
+				seen[key] = b.action
+			}
+		}
+	}
+	return nil
+}
+
+// Action returns the action msg triggers in state, or "" if it isn't bound there
+func (km KeyMap) Action(state AppState, msg tea.KeyMsg) Action {
+	key := msg.String()
+	for _, b := range stateBindings[state] {
+		if slices.Contains(km.bindings[b.action], key) {
+			return b.action
+		}
+	}
+	return ""
+}
+
+// Label returns how the first key of action is shown in hints
+func (km KeyMap) Label(action Action) string {
+	keys := km.bindings[action]
+	if len(keys) == 0 {
+		return "unbound"
+	}
+	return keyLabel(keys[0])
+}
+
+// keyLabel returns how a key is shown to the user
+func keyLabel(key string) string {
+	switch key {
+	case "up":
+		return "↑"
+	case "down":
+		return "↓"
+	case "left":
+		return "←"
+	case "right":
+		return "→"
+	case " ":
+		return "Space"
+	case "enter", "esc", "tab", "backspace":
+		return strings.ToUpper(key[:1]) + key[1:]
+	}
+	if rest, ok := strings.CutPrefix(key, "ctrl+"); ok {
+		return "Ctrl+" + strings.ToUpper(rest)
+	}
+	return key
+}
+
+// hint is a key and what it does, as shown in the help bar and overlay
+type hint struct {
+	keys string
+	help string
+}
+
+// textInputHints are the keys of views that take typed text or digits, which work
+// outside the keymap. They are shown before the view's bindings.
+var textInputHints = map[AppState][]hint{
+	StateQuickConnect:  {{"1-9", "Connect to host"}},
+	StateManualPort:    {{"0-9", "Enter digits"}, {"←/→", "Move cursor"}, {"Backspace", "Delete"}},
+	StateEditUser:      {{"Enter", "Save"}, {"←/→", "Move cursor"}, {"Backspace", "Delete"}, {"Esc", "Cancel"}},
+	StateEditLabel:     {{"Tab", "Switch field"}, {"Enter", "Save"}, {"←/→", "Move cursor"}, {"Backspace", "Delete"}, {"Esc", "Cancel"}},
+	StateConfirmPolicy: {{"Enter", "Confirm"}, {"←/→", "Move cursor"}, {"Backspace", "Delete"}, {"Esc", "Cancel"}},
+}
+
+// barHints returns the hints of state from the first key of each action, limited
+// to only when given. Moving up and down is merged into a single Navigate hint,
+// and the category filters into a single Filter hint.
+func (km KeyMap) barHints(state AppState, only []Action) []hint {
+	hints := append([]hint{}, textInputHints[state]...)
+	for _, b := range stateBindings[state] {
+		if len(only) > 0 && !slices.Contains(only, b.action) {
+			continue
+		}
+		switch b.action {
+		case ActionUp:
+			hints = append(hints, hint{fmt.Sprintf("%s/%s", km.Label(ActionUp), km.Label(ActionDown)), "Navigate"})
+			continue
+		case ActionFilterWeb:
+			hints = append(hints, hint{fmt.Sprintf("%s-%s", km.Label(ActionFilterWeb), km.Label(ActionFilterSystem)), "Filter by category"})
+			hints = append(hints, hint{km.Label(ActionFilterAll), "All"})
+			continue
+		case ActionDown, ActionFilterDatabase, ActionFilterCache, ActionFilterMessaging, ActionFilterSystem, ActionFilterAll:
+			continue
+		}
+		hints = append(hints, hint{km.Label(b.action), b.help})
+	}
+	return hints
+}
+
+// actionsWithout returns the actions of state except the given ones
+func actionsWithout(state AppState, except ...Action) []Action {
+	actions := make([]Action, 0, len(stateBindings[state]))
+	for _, b := range stateBindings[state] {
+		if !slices.Contains(except, b.action) {
+			actions = append(actions, b.action)
+		}
+	}
+	return actions
+}
+
+// Bar renders the help bar of state, wrapped to width columns. only limits the
+// bar to some actions, for views where the others do nothing.
+func (km KeyMap) Bar(state AppState, width int, only ...Action) string {
+	keyStyle := lipgloss.NewStyle().Bold(true)
+	helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#999999"))
+	ruleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#444444"))
+
+	var s strings.Builder
+	s.WriteString(ruleStyle.Render(strings.Repeat("─", width)))
+	s.WriteString("\n")
+
+	// Wrap on the plain text, since the styles add escape codes
+	line, lineWidth := "", 0
+	for _, h := range km.barHints(state, only) {
+		hintWidth := lipgloss.Width(h.keys) + 2 + lipgloss.Width(h.help)
+		if lineWidth > 0 && lineWidth+hintWidth+2 > width {
+			s.WriteString(line + "\n")
+			line, lineWidth = "", 0
+		}
+		if lineWidth > 0 {
+			line += "  "
+			lineWidth += 2
+		}
+		line += keyStyle.Render(h.keys) + helpStyle.Render(": "+h.help)
+		lineWidth += hintWidth
+	}
+	if line != "" {
+		s.WriteString(line + "\n")
+	}
+	return s.String()
+}
+
+// Help renders every binding of state with all of its keys
+func (km KeyMap) Help(state AppState) string {
+	var s strings.Builder
+
+	rows := make([][2]string, 0, len(stateBindings[state]))
+	width := 0
+	for _, h := range textInputHints[state] {
+		width = max(width, len([]rune(h.keys)))
+		rows = append(rows, [2]string{h.keys, h.help})
+	}
+	for _, b := range stateBindings[state] {
+		labels := make([]string, 0, len(km.bindings[b.action]))
+		for _, key := range km.bindings[b.action] {
+			labels = append(labels, keyLabel(key))
+		}
+		keys := strings.Join(labels, ", ")
+		if keys == "" {
+			keys = "unbound"
+		}
+		width = max(width, len([]rune(keys)))
+		rows = append(rows, [2]string{keys, b.help})
+	}
+	for _, row := range rows {
+		padding := strings.Repeat(" ", width-len([]rune(row[0])))
+		s.WriteString(fmt.Sprintf("  %s%s  %s\n", row[0], padding, row[1]))
+	}
+	return s.String()
+}
+
+// actionNames returns the names of all actions
+func actionNames() []string {
+	names := make([]string, 0, len(keyPresets["default"]))
+	for action := range keyPresets["default"] {
+		names = append(names, string(action))
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// defaultLANAddress is the address LAN listeners bind when lan_address isn't set
+const defaultLANAddress = "0.0.0.0"
+
+// tlsHandshakeTimeout is how long a client of a TLS listener has to finish the handshake
+const tlsHandshakeTimeout = 10 * time.Second
+
+// clientNamePattern limits client certificate names to ones that make safe file names
+var clientNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// issueLANClient writes a client certificate and key for name, and the kport
+// CA certificate that LAN listeners present, to dir. It returns the written paths.
+func issueLANClient(name, dir string) ([]string, error) {
+	if !clientNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid client name %q: use letters, digits, dots, dashes and underscores", name)
+	}
+
+	ca, err := LoadOrCreateLocalCA()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kport CA: %w", err)
+	}
+	certPEM, keyPEM, err := ca.IssueClientCert(name)
+	if err != nil {
+		return nil, err
+	}
+	caPEM, err := os.ReadFile(ca.CertPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kport CA certificate: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	files := []struct {
+		name string
+		data []byte
+		mode os.FileMode
+	}{
+		{name + ".pem", certPEM, 0o644},
+		{name + "-key.pem", keyPEM, 0o600},
+		{"kport-ca.pem", caPEM, 0o644},
+	}
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		path := filepath.Join(dir, file.name)
+		if err := os.WriteFile(path, file.data, file.mode); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		paths = append(paths, path)
+	}
+
+	logEvent("issued LAN client certificate %s", name)
+	return paths, nil
+}
@@ -0,0 +1,605 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schema is the part of JSON Schema that kport's config schemas use
+type Schema struct {
+	Schema      string `json:"$schema,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	Type                 schemaType         `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties any                `json:"additionalProperties,omitempty"`
+	PropertyNames        *Schema            `json:"propertyNames,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Format               string             `json:"format,omitempty"`
+}
+
+// schemaType is the JSON types a value may have, written as a single string
+// when there is only one
+type schemaType []string
+
+// MarshalJSON writes a single type as a string and several as a list
+func (t schemaType) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+// schemaFieldEnums are the values of plain string fields that only take a few,
+// keyed by type and field name
+var schemaFieldEnums = map[string]func() []string{
+	"KeysConfig.Preset": func() []string {
+		presets := make([]string, 0, len(keyPresets))
+		for preset := range keyPresets {
+			presets = append(presets, preset)
+		}
+		sort.Strings(presets)
+		return presets
+	},
+	"AlgorithmsConfig.Preset": func() []string { return []string{AlgorithmPresetLegacy} },
+	"KportConfig.StrictModes": func() []string { return []string{StrictModesWarn, StrictModesRefuse, StrictModesOff} },
+}
+
+var (
+	durationType     = reflect.TypeOf(time.Duration(0))
+	actionType       = reflect.TypeOf(Action(""))
+	policyActionType = reflect.TypeOf(PolicyAction(""))
+	portCategoryType = reflect.TypeOf(PortCategory(""))
+	tunnelType       = reflect.TypeOf(TunnelConfig{})
+)
+
+// kportConfigSchema is the schema of the kport config
+var kportConfigSchema = sync.OnceValue(func() *Schema {
+	schema := schemaFor(reflect.TypeOf(KportConfig{}), false)
+	schema.Schema = "https://json-schema.org/draft/2020-12/schema"
+	schema.Title = "kport config"
+	return schema
+})
+
+// sharedProfilesSchema is the schema of a shared profiles file
+var sharedProfilesSchema = sync.OnceValue(func() *Schema {
+	schema := schemaFor(reflect.TypeOf(sharedProfiles{}), false)
+	schema.Schema = "https://json-schema.org/draft/2020-12/schema"
+	schema.Title = "kport shared profiles"
+	return schema
+})
+
+// schemaFor describes the YAML kport decodes into t. Numbers in profile
+// tunnels may also be ${param} references, which templated is set for.
+func schemaFor(t reflect.Type, templated bool) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == durationType:
+		return &Schema{Type: schemaType{"string", "integer"}, Format: "duration", Description: "a duration such as 30s or 5m"}
+	case t == actionType:
+		return &Schema{Type: schemaType{"string"}, Enum: actionNames()}
+	case t == policyActionType:
+		return &Schema{Type: schemaType{"string"}, Enum: []string{string(PolicyAllow), string(PolicyConfirm), string(PolicyDeny)}}
+	case t == portCategoryType:
+		categories := make([]string, 0, len(portCategories))
+		for _, category := range portCategories {
+			categories = append(categories, string(category))
+		}
+		return &Schema{Type: schemaType{"string"}, Enum: categories}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		schema := &Schema{Type: schemaType{"object"}, Properties: make(map[string]*Schema), AdditionalProperties: false}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if !field.IsExported() || name == "-" || name == "" {
+				continue
+			}
+			property := schemaFor(field.Type, templated || t == tunnelType)
+			if enum, ok := schemaFieldEnums[t.Name()+"."+field.Name]; ok {
+				property.Enum = enum()
+			}
+			schema.Properties[name] = property
+		}
+		return schema
+	case reflect.Map:
+		schema := &Schema{Type: schemaType{"object"}, AdditionalProperties: schemaFor(t.Elem(), templated)}
+		switch {
+		case t.Key() == actionType:
+			schema.PropertyNames = schemaFor(t.Key(), false)
+		case t.Key().Kind() == reflect.Int:
+			schema.PropertyNames = &Schema{Pattern: "^[0-9]+$", Description: "a port number"}
+		}
+		return schema
+	case reflect.Slice:
+		return &Schema{Type: schemaType{"array"}, Items: schemaFor(t.Elem(), templated)}
+	case reflect.Bool:
+		return &Schema{Type: schemaType{"boolean"}}
+	case reflect.Int, reflect.Int64:
+		if templated {
+			return &Schema{
+				AnyOf:       []*Schema{{Type: schemaType{"integer"}}, {Type: schemaType{"string"}, Pattern: profileVarPattern.String()}},
+				Description: "an integer or a ${param} reference",
+			}
+		}
+		return &Schema{Type: schemaType{"integer"}}
+	}
+	return &Schema{Type: schemaType{"string"}}
+}
+
+// LintError is a problem found in a config file, at a line and column
+type LintError struct {
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+// String formats the problem as line:column: path: message
+func (e LintError) String() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+	}
+	return fmt.Sprintf("%d:%d: %s: %s", e.Line, e.Column, e.Path, e.Message)
+}
+
+// ConfigLintError lists the problems found in a config file
+type ConfigLintError struct {
+	Path   string
+	Errors []LintError
+}
+
+// Error lists every problem as path:line:column: message, one per line
+func (e *ConfigLintError) Error() string {
+	lines := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		lines = append(lines, e.Path+":"+err.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// lintYAML checks a YAML document against a schema. Syntax errors are
+// returned as an error, since yaml already reports them with their line.
+func lintYAML(data []byte, schema *Schema) ([]LintError, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	var lintErrs []LintError
+	lintNode(doc.Content[0], schema, "", &lintErrs)
+	sort.SliceStable(lintErrs, func(i, j int) bool {
+		if lintErrs[i].Line != lintErrs[j].Line {
+			return lintErrs[i].Line < lintErrs[j].Line
+		}
+		return lintErrs[i].Column < lintErrs[j].Column
+	})
+	return lintErrs, nil
+}
+
+// lintNode checks node and its children against schema, adding problems to lintErrs
+func lintNode(node *yaml.Node, schema *Schema, path string, lintErrs *[]LintError) {
+	report := func(at *yaml.Node, format string, args ...any) {
+		*lintErrs = append(*lintErrs, LintError{Line: at.Line, Column: at.Column, Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	// A key without a value leaves the setting at its default
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	if len(schema.AnyOf) > 0 {
+		for _, alternative := range schema.AnyOf {
+			var alternativeErrs []LintError
+			lintNode(node, alternative, path, &alternativeErrs)
+			if len(alternativeErrs) == 0 {
+				return
+			}
+		}
+		report(node, "expected %s, got %s", schema.Description, describeYAMLNode(node))
+		return
+	}
+
+	if len(schema.Type) > 0 && !slices.ContainsFunc(schema.Type, func(t string) bool { return yamlNodeIs(node, t) }) {
+		expected := schema.Description
+		if expected == "" {
+			expected = describeSchemaType(schema.Type[0])
+		}
+		report(node, "expected %s, got %s", expected, describeYAMLNode(node))
+		return
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			// Merge keys bring in the settings of an anchored mapping
+			if key.Value == "<<" {
+				lintNode(value, schema, path, lintErrs)
+				continue
+			}
+
+			keyPath := key.Value
+			if path != "" {
+				keyPath = path + "." + key.Value
+			}
+			if property, ok := schema.Properties[key.Value]; ok {
+				lintNode(value, property, keyPath, lintErrs)
+				continue
+			}
+			if additional, ok := schema.AdditionalProperties.(*Schema); ok {
+				if schema.PropertyNames != nil {
+					lintNode(key, schema.PropertyNames, keyPath, lintErrs)
+				}
+				lintNode(value, additional, keyPath, lintErrs)
+				continue
+			}
+			names := make([]string, 0, len(schema.Properties))
+			for name := range schema.Properties {
+				names = append(names, name)
+			}
+			if suggestion := closestName(key.Value, names); suggestion != "" {
+				report(key, "unknown field %s, did you mean %s?", key.Value, suggestion)
+			} else {
+				report(key, "unknown field %s", key.Value)
+			}
+		}
+	case yaml.SequenceNode:
+		if schema.Items == nil {
+			return
+		}
+		for i, item := range node.Content {
+			lintNode(item, schema.Items, fmt.Sprintf("%s[%d]", path, i), lintErrs)
+		}
+	case yaml.ScalarNode:
+		if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, node.Value) {
+			if suggestion := closestName(node.Value, schema.Enum); suggestion != "" {
+				report(node, "unknown value %q, did you mean %s?", node.Value, suggestion)
+			} else {
+				report(node, "%q must be one of %s", node.Value, strings.Join(schema.Enum, ", "))
+			}
+		}
+		if schema.Pattern != "" && !regexp.MustCompile(schema.Pattern).MatchString(node.Value) {
+			if schema.Description != "" {
+				report(node, "%q is not %s", node.Value, schema.Description)
+			} else {
+				report(node, "%q doesn't match %s", node.Value, schema.Pattern)
+			}
+		}
+		if schema.Format == "duration" && node.Tag == "!!str" {
+			if _, err := time.ParseDuration(node.Value); err != nil {
+				report(node, "invalid duration %q, use a duration such as 30s or 5m", node.Value)
+			}
+		}
+	}
+}
+
+// yamlNodeIs reports whether node holds a value of a JSON Schema type
+func yamlNodeIs(node *yaml.Node, t string) bool {
+	switch t {
+	case "object":
+		return node.Kind == yaml.MappingNode
+	case "array":
+		return node.Kind == yaml.SequenceNode
+	case "string":
+		return node.Kind == yaml.ScalarNode
+	case "integer":
+		return node.Kind == yaml.ScalarNode && node.Tag == "!!int"
+	case "number":
+		return node.Kind == yaml.ScalarNode && (node.Tag == "!!int" || node.Tag == "!!float")
+	case "boolean":
+		// yaml also decodes YAML 1.1's yes, no, on and off into bools
+		return node.Kind == yaml.ScalarNode && (node.Tag == "!!bool" || slices.Contains(yaml11Bools, node.Value))
+	}
+	return false
+}
+
+// yaml11Bools are the YAML 1.1 booleans yaml decodes into bool fields
+var yaml11Bools = []string{"y", "Y", "yes", "Yes", "YES", "n", "N", "no", "No", "NO", "on", "On", "ON", "off", "Off", "OFF"}
+
+// describeSchemaType names a JSON Schema type the way YAML users know it
+func describeSchemaType(t string) string {
+	switch t {
+	case "object":
+		return "a mapping"
+	case "array":
+		return "a list"
+	case "integer":
+		return "an integer"
+	case "number":
+		return "a number"
+	case "boolean":
+		return "true or false"
+	}
+	return "a " + t
+}
+
+// describeYAMLNode describes the value a node holds for error messages
+func describeYAMLNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	return fmt.Sprintf("%q", node.Value)
+}
+
+// closestName returns the name a misspelled one most likely meant, if any is close
+func closestName(key string, names []string) string {
+	best, bestDistance := "", 3
+	for _, name := range names {
+		distance := editDistance(key, name)
+		if distance < bestDistance || (distance == bestDistance && best != "" && name < best) {
+			best, bestDistance = name, distance
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// lintProfiles checks what the schema can't: the order of each profile's
+// tunnels and their hooks. Templates are checked once they are filled in.
+func lintProfiles(profiles map[string]ProfileConfig) []error {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	problems := make([]error, 0)
+	for _, name := range names {
+		profile := profiles[name]
+		if len(profile.Params) > 0 {
+			continue
+		}
+		if _, err := profile.dependencies(); err != nil {
+			problems = append(problems, fmt.Errorf("profile %s: %w", name, err))
+		}
+		for _, tunnel := range profile.Tunnels {
+			if err := tunnel.Hooks.inherit(profile.Hooks).validate(); err != nil {
+				problems = append(problems, fmt.Errorf("profile %s, tunnel %s: %w", name, tunnel.ID(), err))
+			}
+		}
+	}
+	return problems
+}
+
+// ignoredCommands lists the profiles of a shared profiles file whose hooks
+// and prepare commands are ignored because their source doesn't set allow_hooks
+func ignoredCommands(data []byte) []string {
+	var shared sharedProfiles
+	if err := yaml.Unmarshal(data, &shared); err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(shared.Profiles))
+	for name := range shared.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	warnings := make([]string, 0)
+	for _, name := range names {
+		profile := shared.Profiles[name]
+		// The tunnels of templates are only decoded once filled in
+		if profile.dropCommands() || setsCommands(&profile.template) {
+			warnings = append(warnings, fmt.Sprintf("profile %s sets hooks or prepare commands, which are ignored unless the source sets allow_hooks", name))
+		}
+	}
+	return warnings
+}
+
+// setsCommands reports whether a profile template sets hooks or prepare commands
+func setsCommands(node *yaml.Node) bool {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i].Value; key == "hooks" || key == "prepare" {
+				return true
+			}
+		}
+	}
+	for _, child := range node.Content {
+		if setsCommands(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// lintConfigFile checks a kport config, or a shared profiles file when
+// profiles is set, and returns every problem found in it
+func lintConfigFile(name string, data []byte, profiles bool) []error {
+	if !profiles {
+		config, err := parseKportConfig(data, name)
+		var lintErr *ConfigLintError
+		switch {
+		case errors.As(err, &lintErr):
+			return positionedErrors(name, lintErr.Errors)
+		case err != nil:
+			return []error{err}
+		}
+		return prefixErrors(name, lintProfiles(config.Profiles))
+	}
+
+	lintErrs, err := lintYAML(data, sharedProfilesSchema())
+	if err != nil {
+		return []error{fmt.Errorf("%s: %w", name, err)}
+	}
+	if len(lintErrs) > 0 {
+		return positionedErrors(name, lintErrs)
+	}
+	var shared sharedProfiles
+	if err := yaml.Unmarshal(data, &shared); err != nil {
+		return []error{fmt.Errorf("%s: %w", name, err)}
+	}
+	return prefixErrors(name, lintProfiles(shared.Profiles))
+}
+
+// positionedErrors turns the problems found in a file into errors naming the file
+func positionedErrors(name string, lintErrs []LintError) []error {
+	problems := make([]error, 0, len(lintErrs))
+	for _, problem := range lintErrs {
+		problems = append(problems, errors.New(name+":"+problem.String()))
+	}
+	return problems
+}
+
+// prefixErrors names the file each problem was found in
+func prefixErrors(name string, problems []error) []error {
+	for i, problem := range problems {
+		problems[i] = fmt.Errorf("%s: %w", name, problem)
+	}
+	return problems
+}
+
+// runLint checks the kport config and the pulled profile sources, or the
+// given files, against kport's schemas. Files named like a shared profiles
+// file, or all of them with --profiles, are checked as shared profiles.
+// --schema prints the schema instead.
+func runLint(args []string) error {
+	var files []string
+	profiles, printSchema := false, false
+	for _, arg := range args {
+		switch {
+		case arg == "--profiles":
+			profiles = true
+		case arg == "--schema":
+			printSchema = true
+		case strings.HasPrefix(arg, "-"):
+			return fmt.Errorf("usage: kport lint [--profiles] [file...] | kport lint --schema [--profiles]")
+		default:
+			files = append(files, arg)
+		}
+	}
+
+	if printSchema {
+		schema := kportConfigSchema()
+		if profiles {
+			schema = sharedProfilesSchema()
+		}
+		data, err := json.MarshalIndent(schema, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	type target struct {
+		name     string
+		data     []byte
+		profiles bool
+
+		// warnings are shown along with the problems without failing the lint
+		warnings []string
+	}
+	targets := make([]target, 0)
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target{name: file, data: data, profiles: profiles || filepath.Base(file) == defaultSharedProfilesPath})
+	}
+	if len(files) == 0 {
+		path, err := kportConfigPath()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no kport config at %s to lint", path)
+		}
+		if err != nil {
+			return err
+		}
+		targets = append(targets, target{name: path, data: data})
+
+		// Sources are only checked once the config itself parses
+		if config, err := parseKportConfig(data, path); err == nil {
+			for _, source := range config.ProfileSources {
+				store, err := source.Store()
+				if err != nil {
+					continue
+				}
+				if data, _, err := store.Load(); err == nil {
+					t := target{name: "profile source " + source.Name, data: data, profiles: true}
+					if !source.AllowHooks {
+						t.warnings = ignoredCommands(data)
+					}
+					targets = append(targets, t)
+				}
+			}
+		}
+	}
+
+	problems := 0
+	for _, t := range targets {
+		errs := lintConfigFile(t.name, t.data, t.profiles)
+		for _, warning := range t.warnings {
+			fmt.Printf("⚠️  %s: %s\n", t.name, warning)
+		}
+		if len(errs) == 0 {
+			fmt.Printf("✅ %s\n", t.name)
+			continue
+		}
+		for _, err := range errs {
+			fmt.Println(err)
+		}
+		problems += len(errs)
+	}
+	switch {
+	case problems == 1:
+		return fmt.Errorf("found 1 problem")
+	case problems > 1:
+		return fmt.Errorf("found %d problems", problems)
+	}
+	return nil
+}
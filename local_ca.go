@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// localCAValidity is how long the generated kport CA is valid for
+	localCAValidity = 10 * 365 * 24 * time.Hour
+
+	// localCertValidity is how long certificates issued for tunnels are valid for
+	localCertValidity = 30 * 24 * time.Hour
+
+	// localClientCertValidity is how long client certificates for LAN listeners are valid for
+	localClientCertValidity = 90 * 24 * time.Hour
+)
+
+// LocalCA is kport's local certificate authority used to terminate HTTPS for tunnels
+type LocalCA struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certPath string
+}
+
+// LoadOrCreateLocalCA loads the kport CA from the config directory, creating it on first use
+func LoadOrCreateLocalCA() (*LocalCA, error) {
+	dir, err := kportConfigDir("ca")
+	if err != nil {
+		return nil, err
+	}
+
+	certPath := filepath.Join(dir, "kport-ca.pem")
+	keyPath := filepath.Join(dir, "kport-ca-key.pem")
+
+	ca, err := loadLocalCA(certPath, keyPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createLocalCA(certPath, keyPath)
+	}
+	return ca, err
+}
+
+// loadLocalCA reads an existing CA certificate and key
+func loadLocalCA(certPath, keyPath string) (*LocalCA, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("invalid CA certificate %s", certPath)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate %s: %w", certPath, err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("invalid CA key %s", keyPath)
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key %s: %w", keyPath, err)
+	}
+
+	return &LocalCA{cert: cert, key: key, certPath: certPath}, nil
+}
+
+// createLocalCA generates a new CA and writes it to disk
+func createLocalCA(certPath, keyPath string) (*LocalCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	hostName, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"kport local CA"},
+			CommonName:   fmt.Sprintf("kport local CA (%s)", hostName),
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(localCAValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode CA key: %w", err)
+	}
+
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write CA key: %w", err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write CA certificate: %w", err)
+	}
+
+	return &LocalCA{cert: cert, key: key, certPath: certPath}, nil
+}
+
+// CertPath returns the path of the CA certificate, which users add to their trust store
+func (ca *LocalCA) CertPath() string {
+	return ca.certPath
+}
+
+// TLSConfig issues a certificate for the loopback names and returns a server TLS config using it
+func (ca *LocalCA) TLSConfig() (*tls.Config, error) {
+	cert, err := ca.issue("localhost", []string{"localhost"}, []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}, x509.ExtKeyUsageServerAuth, localCertValidity)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// MutualTLSConfig returns a server TLS config for listeners reachable from
+// other machines. Its certificate names this machine and every address it
+// has, and clients must present a certificate issued by the kport CA.
+func (ca *LocalCA) MutualTLSConfig() (*tls.Config, error) {
+	dnsNames := []string{"localhost"}
+	if hostName, err := os.Hostname(); err == nil && hostName != "" {
+		dnsNames = append(dnsNames, hostName)
+	}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+	}
+
+	cert, err := ca.issue(dnsNames[len(dnsNames)-1], dnsNames, ips, x509.ExtKeyUsageServerAuth, localCertValidity)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// IssueClientCert issues a client certificate for name that LAN listeners
+// accept, and returns it and its key PEM encoded
+func (ca *LocalCA) IssueClientCert(name string) (certPEM, keyPEM []byte, err error) {
+	cert, err := ca.issue(name, nil, nil, x509.ExtKeyUsageClientAuth, localClientCertValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode client key: %w", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// issue creates a key and a certificate for it signed by the CA, chained to the CA certificate
+func (ca *LocalCA) issue(commonName string, dnsNames []string, ips []net.IP, usage x509.ExtKeyUsage, validity time.Duration) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate certificate key: %w", err)
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"kport tunnel certificate"},
+			CommonName:   commonName,
+		},
+		DNSNames:    dnsNames,
+		IPAddresses: ips,
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(validity),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to issue certificate: %w", err)
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{der, ca.cert.Raw},
+		PrivateKey:  key,
+	}, nil
+}
+
+// randomSerial returns a random certificate serial number
+func randomSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serial, nil
+}
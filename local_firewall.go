@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// loopbackCheckTimeout is how long a test connection to a local listener may take
+const loopbackCheckTimeout = 2 * time.Second
+
+// localListenerHints checks whether a tunnel listening on port can actually be
+// reached from this machine and returns guidance for each problem found
+func localListenerHints(port int, ipv6 bool) []string {
+	hints := make([]string, 0)
+
+	if err := checkLoopback(); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Loopback check failed: %v\n", err)
+		hints = append(hints, fmt.Sprintf("Connections to 127.0.0.1 are blocked on this machine (%v). %s", err, firewallGuidance()))
+	}
+
+	// Clients that try only the first address of localhost, such as Node.js,
+	// can't reach a tunnel listening on 127.0.0.1 alone
+	if !ipv6 && localhostPrefersIPv6() {
+		hints = append(hints, fmt.Sprintf("localhost resolves to ::1 first here, but the tunnel only listens on 127.0.0.1. Use 127.0.0.1:%d, or free [::1]:%d and don't set ipv6: false for the host", port, port))
+	}
+
+	return hints
+}
+
+// checkLoopback makes a test connection over 127.0.0.1 to see whether local
+// firewall or security software lets kport's connections through
+func checkLoopback() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+
+	conn, err := net.DialTimeout("tcp", listener.Addr().String(), loopbackCheckTimeout)
+	if err != nil {
+		return err
+	}
+	conn.Close()
+
+	// Some filters complete the handshake and drop the connection before it is handed over
+	select {
+	case err := <-accepted:
+		return err
+	case <-time.After(loopbackCheckTimeout):
+		return fmt.Errorf("connection was never accepted")
+	}
+}
+
+// localhostPrefersIPv6 reports whether localhost resolves to an IPv6 address first
+func localhostPrefersIPv6() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), loopbackCheckTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, "localhost")
+	if err != nil || len(addrs) == 0 {
+		return false
+	}
+	return addrs[0].IP.To4() == nil
+}
+
+// firewallGuidance tells the user where to allow kport on this platform
+func firewallGuidance() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "Allow kport in System Settings > Network > Firewall > Options, and in any filter such as LuLu or Little Snitch"
+	case "windows":
+		return "Allow kport in Windows Security > Firewall & network protection > Allow an app through firewall, or in your antivirus"
+	default:
+		return "Check iptables or nftables for rules that drop traffic on the lo interface"
+	}
+}
+
+// listenErrorHint explains common reasons a local port can't be bound, or returns ""
+func listenErrorHint(port int, err error) string {
+	if !errors.Is(err, syscall.EACCES) && !strings.Contains(err.Error(), "forbidden by its access permissions") {
+		return ""
+	}
+	switch {
+	case runtime.GOOS == "windows":
+		return fmt.Sprintf("Windows may reserve port %d for Hyper-V or WSL, see `netsh interface ipv4 show excludedportrange protocol=tcp`", port)
+	case port < 1024:
+		return fmt.Sprintf("port %d is privileged, forward it to a local port of 1024 or above", port)
+	default:
+		return "security software may be blocking kport from listening"
+	}
+}
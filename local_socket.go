@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// listenSocket listens on a Unix socket at path for a tunnel. Its directory is
+// created when missing, a socket left behind by a kport that didn't exit
+// cleanly is replaced, and only the user may connect.
+func listenSocket(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create the directory of %s: %w", path, err)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and isn't a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is already in use by another process", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict %s to the user: %w", path, err)
+	}
+	return listener, nil
+}
+
+// localSocketPath expands ~, $HOME and $USER in the local socket of
+// a tunnel and makes it absolute
+func localSocketPath(path string) (string, error) {
+	return filepath.Abs(expandShellVars(path))
+}
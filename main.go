@@ -1,15 +1,48 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
-	"os/exec"
 	"os/user"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// testConnectTimeout is the ssh ConnectTimeout of --test-connect for hosts that don't set one
+const testConnectTimeout = 10
+
 func main() {
+	// In demo mode ssh is kport itself, answering from the canned hosts
+	if isDemoSSH() {
+		os.Exit(runDemoSSH(os.Args[1:]))
+	}
+
+	// ssh runs kport as its SSH_ASKPASS to report security key prompts
+	if os.Getenv(askpassSocketEnv) != "" && len(os.Args) == 2 {
+		os.Exit(runAskpass(os.Args[1]))
+	}
+
+	// Spans are exported only when an OTLP endpoint is configured
+	shutdownTracing := initTracing()
+	defer shutdownTracing()
+
+	// Demo mode swaps the SSH and kport configs, and ssh itself, for canned ones.
+	// Everything after --demo runs as usual, such as --accessible or `up`.
+	if len(os.Args) > 1 && os.Args[1] == "--demo" {
+		if err := startDemo(defaultDemoDir()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error starting demo: %v\n", err)
+			shutdownTracing()
+			os.Exit(1)
+		}
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	// Check for test mode
 	if len(os.Args) > 1 && os.Args[1] == "--test" {
 		testMode()
@@ -18,7 +51,10 @@ func main() {
 	
 	// Check for connection test mode
 	if len(os.Args) > 2 && os.Args[1] == "--test-connect" {
-		testConnection(os.Args[2])
+		if err := testConnection(os.Args[2:]); err != nil {
+			shutdownTracing()
+			os.Exit(1)
+		}
 		return
 	}
 	
@@ -28,10 +64,48 @@ func main() {
 		return
 	}
 	
+	// Daemon mode runs profile tunnels in the background, usually auto-started by `kport up`
+	if len(os.Args) > 1 && os.Args[1] == "--daemon" {
+		if err := RunDaemon(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error running daemon: %v\n", err)
+			shutdownTracing()
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Profile commands talk to the daemon
+	if len(os.Args) > 1 {
+		if handled, err := runCLI(os.Args[1:]); handled {
+			if err != nil {
+				// stdout carries the tunnel's data in stdio mode, and exports of the audit log and config
+				out := os.Stdout
+				if os.Args[1] == "stdio" || os.Args[1] == "audit" || os.Args[1] == "config" {
+					out = os.Stderr
+				}
+				fmt.Fprintf(out, "❌ %v\n", err)
+				shutdownTracing()
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	
+	// The accessible front end prints plain lines instead of drawing a full-screen TUI
+	if (len(os.Args) > 1 && os.Args[1] == "--accessible") || os.Getenv("TERM") == "dumb" {
+		if err := NewAccessibleUI().Run(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			shutdownTracing()
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Initialize the application
 	app := NewApp()
 	if err := app.Run(); err != nil {
 		fmt.Printf("Error running application: %v\n", err)
+		shutdownTracing()
 		os.Exit(1)
 	}
 }
@@ -68,28 +142,53 @@ func testMode() {
 	fmt.Println("To run the interactive TUI, use: ./kport")
 	fmt.Println("Note: TUI requires a proper terminal environment")
 	fmt.Println("")
-	fmt.Println("To test connection to a specific host: ./kport --test-connect <hostname>")
+	fmt.Println("To test connection to a specific host: ./kport --test-connect [--batch] <hostname>")
 	fmt.Println("To test port mapping logic: ./kport --test-port <port>")
 }
 
-// testConnection tests connecting to a specific host
-func testConnection(hostName string) {
+// testConnection tests connecting to a specific host the way kport connects to it.
+// With --batch ssh never prompts, so it can run unattended in CI smoke tests.
+// It returns an error when the connection fails.
+func testConnection(args []string) error {
+	batch := false
+	names := make([]string, 0, 1)
+	for _, arg := range args {
+		if arg == "--batch" {
+			batch = true
+			continue
+		}
+		names = append(names, arg)
+	}
+	if len(names) != 1 {
+		fmt.Println("Usage: kport --test-connect [--batch] <host|user@host[:port]>")
+		return fmt.Errorf("expected one host")
+	}
+	hostName := names[0]
+
 	fmt.Printf("Testing connection to host: %s\n", hostName)
 	fmt.Println("=====================================")
 	
-	// Load SSH config
+	// Inline hosts work without any SSH config, e.g. on CI machines
 	config := NewSSHConfig()
-	if err := config.LoadConfig(); err != nil {
+	if err := config.LoadConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
 		fmt.Printf("❌ Failed to load SSH config: %v\n", err)
-		return
+		return err
 	}
-	
-	// Find the host
-	host, err := config.GetHostByName(hostName)
+
+	// Apply kport's own settings for the host
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		fmt.Printf("❌ Failed to load kport config: %v\n", err)
+		return err
+	}
+
+	// Find the host among the SSH config and Teleport hosts, or parse it as an inline host
+	resolved, err := resolveHost(hostName, collectHosts(config, kportConfig), kportConfig)
 	if err != nil {
 		fmt.Printf("❌ Host not found: %v\n", err)
-		return
+		return err
 	}
+	host := &resolved
 	
 	fmt.Printf("Found host configuration:\n")
 	fmt.Printf("  Name: %s\n", host.Name)
@@ -99,6 +198,17 @@ func testConnection(hostName string) {
 	if host.Identity != "" {
 		fmt.Printf("  Identity: %s\n", host.Identity)
 	}
+	if host.StrictIdentities {
+		fmt.Printf("  Strict identities: only configured identities and the SSH agent are used\n")
+	}
+	if host.ConnectTimeout != "" {
+		fmt.Printf("  Connect timeout: %ss (SSH config)\n", host.ConnectTimeout)
+	} else {
+		fmt.Printf("  Connect timeout: %ds (kport default)\n", testConnectTimeout)
+	}
+	if batch {
+		fmt.Printf("  Batch mode: ssh fails instead of prompting for passwords or host keys\n")
+	}
 	fmt.Println("")
 	
 	// Expand shell variables in the host config
@@ -116,12 +226,51 @@ func testConnection(hostName string) {
 		fmt.Println("")
 	}
 	
+	// Mint credentials before dialing if the host has a pre-connect hook
+	if expandedHost.PreConnect != "" {
+		fmt.Printf("Running pre-connect hook: %s\n", expandedHost.PreConnect)
+		expandedHost, err = prepareHost(expandedHost)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return err
+		}
+		if expandedHost.HookIdentity != "" {
+			fmt.Printf("Hook identity: %s\n", expandedHost.HookIdentity)
+		}
+		if expandedHost.HookCertificate != "" {
+			fmt.Printf("Hook certificate: %s\n", expandedHost.HookCertificate)
+		}
+		fmt.Println("")
+	}
+	
 	// Test SSH connection using ssh command (supports all SSH features)
 	fmt.Println("Testing SSH connection...")
-	fmt.Printf("Running: ssh -o ConnectTimeout=10 -o BatchMode=yes %s echo 'connection test'\n", expandedHost.Name)
+	// Without --batch ssh may prompt, as it would for a tunnel started from this terminal
+	options := expandedHost.probeOptions(testConnectTimeout)
+	if !batch {
+		options = nil
+		if expandedHost.ConnectTimeout == "" {
+			options = []string{"-o", fmt.Sprintf("ConnectTimeout=%d", testConnectTimeout)}
+		}
+	}
+	sshCmd := sshCommand(expandedHost, options, "echo", "connection test")
+	if !batch {
+		sshCmd.Stdin = os.Stdin
+	}
+	var stderr bytes.Buffer
+	sshCmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	fmt.Printf("Running: %s\n", sshCmd.String())
 	
-	sshCmd := exec.Command("ssh", "-o", "ConnectTimeout=10", "-o", "BatchMode=yes", expandedHost.Name, "echo", "connection test")
+	started := time.Now()
 	output, err := sshCmd.Output()
+	recordRemoteCommand(sshCmd, "connection test", started, err)
+	if sshErr := sshConnectionError(expandedHost, stderr.Bytes(), err); sshErr != nil {
+		fmt.Printf("❌ SSH connection failed: %v\n", sshErr)
+		fmt.Println("")
+		fmt.Println("Try running the SSH command manually:")
+		fmt.Printf("  ssh %s\n", expandedHost.destination())
+		return fmt.Errorf("ssh connection failed: %w", err)
+	}
 	if err != nil {
 		fmt.Printf("❌ SSH connection failed: %v\n", err)
 		fmt.Println("")
@@ -133,8 +282,8 @@ func testConnection(hostName string) {
 		fmt.Println("- ProxyCommand or other SSH config issues")
 		fmt.Println("")
 		fmt.Println("Try running the SSH command manually:")
-		fmt.Printf("  ssh %s\n", expandedHost.Name)
-		return
+		fmt.Printf("  ssh %s\n", expandedHost.destination())
+		return fmt.Errorf("ssh connection failed: %w", err)
 	}
 	
 	if strings.TrimSpace(string(output)) == "connection test" {
@@ -146,7 +295,7 @@ func testConnection(hostName string) {
 	
 	// Test port detection
 	fmt.Println("Testing port detection...")
-	ports, err := detectRemotePorts(expandedHost)
+	ports, _, _, err := detectRemotePorts(context.Background(), expandedHost)
 	if err != nil {
 		fmt.Printf("❌ Port detection failed: %v\n", err)
 		fmt.Println("")
@@ -161,6 +310,7 @@ func testConnection(hostName string) {
 	
 	fmt.Println("")
 	fmt.Println("You can still use manual port forwarding in the TUI even if port detection fails.")
+	return nil
 }
 
 // expandShellVars expands shell variables in SSH config values
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// fileWarnRatio is how close to the open files limit kport gets before it warns
+const fileWarnRatio = 0.8
+
+// fileUsageInterval is how long a count of open files is reused
+const fileUsageInterval = time.Second
+
+// FileUsage is how many files kport has open, nearly all of them sockets of
+// proxied connections, and the limit of the process (RLIMIT_NOFILE)
+type FileUsage struct {
+	Open  int    `json:"open"`
+	Limit uint64 `json:"limit"`
+}
+
+// Near reports whether the open files reached the warning threshold of the limit
+func (u FileUsage) Near() bool {
+	return u.Limit > 0 && float64(u.Open) >= float64(u.Limit)*fileWarnRatio
+}
+
+var (
+	fileUsageMu   sync.Mutex
+	fileUsage     FileUsage
+	fileUsageAt   time.Time
+	fileUsageNear bool
+
+	// appliedFileLimit is the file_limit last applied, so a config reload
+	// doesn't set it again
+	appliedFileLimit int
+)
+
+// currentFileUsage returns the open files of the process and its limit, and
+// false where they can't be read. Crossing the warning threshold is logged.
+func currentFileUsage() (FileUsage, bool) {
+	fileUsageMu.Lock()
+	defer fileUsageMu.Unlock()
+
+	if time.Since(fileUsageAt) < fileUsageInterval {
+		return fileUsage, fileUsage.Limit > 0
+	}
+	fileUsageAt = time.Now()
+
+	limit, _, err := fileLimit()
+	if err != nil {
+		return FileUsage{}, false
+	}
+	open, err := openFileCount()
+	if tooManyFiles(err) {
+		// Counting takes a file too, so at the limit it fails
+		open, err = int(limit), nil
+	}
+	if err != nil {
+		return FileUsage{}, false
+	}
+	fileUsage = FileUsage{Open: open, Limit: limit}
+
+	if near := fileUsage.Near(); near != fileUsageNear {
+		fileUsageNear = near
+		if near {
+			logEvent("open files near the limit: %d of %d, new connections fail at the limit (raise it with file_limit)", open, limit)
+		} else {
+			logEvent("open files back under the limit: %d of %d", open, limit)
+		}
+	}
+	return fileUsage, true
+}
+
+// watchFileUsage checks the open files every few seconds until stop is closed,
+// so the event log notes when they near the limit while nobody is looking
+func watchFileUsage(stop <-chan struct{}) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			currentFileUsage()
+		}
+	}
+}
+
+// setFileLimit applies the file_limit of a freshly loaded kport config, which
+// raises the limit of open files. The Go runtime already raises the soft limit
+// to the hard limit, so this only helps where kport may raise the hard limit,
+// and a limit below the current one is left alone.
+func setFileLimit(limit int) {
+	fileUsageMu.Lock()
+	defer fileUsageMu.Unlock()
+
+	if limit <= 0 || limit == appliedFileLimit {
+		return
+	}
+	appliedFileLimit = limit
+
+	before, _, err := fileLimit()
+	if err != nil || before >= uint64(limit) {
+		return
+	}
+	after, err := raiseFileLimit(uint64(limit))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to raise the open files limit to %d: %v\n", limit, err)
+		logEvent("failed to raise the open files limit to %d: %v", limit, err)
+		return
+	}
+	if after != before {
+		fmt.Fprintf(os.Stderr, "Debug: Open files limit raised from %d to %d\n", before, after)
+	}
+	if after < uint64(limit) {
+		logEvent("open files limit is %d, the hard limit, instead of file_limit %d; raise the hard limit with ulimit -Hn or launchctl limit maxfiles", after, limit)
+	}
+	fileUsageAt = time.Time{}
+}
+
+// tooManyFiles reports whether err is the process or system running out of files
+func tooManyFiles(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// OpenSockets returns how many sockets the tunnel holds: one per listener, one
+// per client waiting for a channel and two per proxied connection
+func (pf *PortForwarder) OpenSockets() int {
+	stats := pf.ConnStats()
+	return len(pf.ListenAddrs()) + stats.Queued + 2*stats.Active
+}
@@ -0,0 +1,23 @@
+//go:build !linux && !darwin
+
+package main
+
+import "errors"
+
+// errFileLimitUnsupported is returned where kport can't read the open files limit
+var errFileLimitUnsupported = errors.New("open files limit not supported on this platform")
+
+// fileLimit returns the soft and hard limits of open files
+func fileLimit() (soft, hard uint64, err error) {
+	return 0, 0, errFileLimitUnsupported
+}
+
+// raiseFileLimit raises the limit of open files to n
+func raiseFileLimit(n uint64) (uint64, error) {
+	return 0, errFileLimitUnsupported
+}
+
+// openFileCount counts the open files of the process
+func openFileCount() (int, error) {
+	return 0, errFileLimitUnsupported
+}
@@ -0,0 +1,47 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileLimit returns the soft and hard limits of open files
+func fileLimit() (soft, hard uint64, err error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, 0, err
+	}
+	return limit.Cur, limit.Max, nil
+}
+
+// raiseFileLimit raises the limit of open files to n, the hard limit too
+// where the process may, and returns the limit in effect
+func raiseFileLimit(n uint64) (uint64, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, err
+	}
+	hard := limit.Max
+	limit.Cur, limit.Max = n, max(n, hard)
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err == nil {
+		return n, nil
+	}
+	// Raising the hard limit takes privileges, so settle for it
+	limit.Cur, limit.Max = min(n, hard), hard
+	if err := syscall.Setrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, err
+	}
+	return limit.Cur, nil
+}
+
+// openFileCount counts the open files of the process
+func openFileCount() (int, error) {
+	entries, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		return 0, err
+	}
+	// Reading the directory takes a file of its own
+	return len(entries) - 1, nil
+}
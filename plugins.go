@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransportPlugin marks hosts whose ssh connection is carried by a plugin
+const TransportPlugin = "plugin"
+
+// pluginProtocol is the version of the JSON protocol kport speaks with plugins
+const pluginProtocol = 1
+
+// defaultPluginTimeout is how long a plugin call may run when its timeout isn't set
+const defaultPluginTimeout = 30 * time.Second
+
+// Plugin capabilities, as reported by the describe call
+const (
+	// PluginHosts plugins list hosts shown next to the SSH config hosts
+	PluginHosts = "hosts"
+
+	// PluginDetect plugins report the listening ports of their hosts
+	PluginDetect = "detect"
+
+	// PluginConnect plugins carry the ssh connection to their hosts over stdio
+	PluginConnect = "connect"
+)
+
+// PluginConfig configures an external plugin executable
+type PluginConfig struct {
+	// Command is run through sh with the call's name as its last argument
+	Command string `yaml:"command"`
+
+	// Timeout kills a plugin call that runs longer, defaulting to 30s. It
+	// doesn't apply to connect, which lasts as long as the connection.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// HostPlugin is the plugin a host is listed by or reached through
+type HostPlugin struct {
+	Name         string
+	Command      string
+	Timeout      time.Duration
+	Capabilities []string
+}
+
+// pluginHost is a host as sent to and received from plugins
+type pluginHost struct {
+	Name     string `json:"name"`
+	Hostname string `json:"hostname"`
+	User     string `json:"user,omitempty"`
+	Port     string `json:"port,omitempty"`
+	Identity string `json:"identity_file,omitempty"`
+}
+
+// pluginPort is a listening port reported by a plugin's detect call
+type pluginPort struct {
+	Port    int    `json:"port"`
+	Address string `json:"address,omitempty"`
+	Process string `json:"process,omitempty"`
+}
+
+// pluginRequest is written to a plugin's stdin
+type pluginRequest struct {
+	Protocol int         `json:"protocol"`
+	Host     *pluginHost `json:"host,omitempty"`
+}
+
+// pluginResponse is read from a plugin's stdout. Each call fills in its own fields.
+type pluginResponse struct {
+	Protocol     int          `json:"protocol"`
+	Capabilities []string     `json:"capabilities"`
+	Hosts        []pluginHost `json:"hosts"`
+	Ports        []pluginPort `json:"ports"`
+	Error        string       `json:"error"`
+}
+
+// pluginDescriptions caches the describe call of each plugin command until
+// the hosts are loaded again
+var pluginDescriptions = struct {
+	sync.Mutex
+	capabilities map[string][]string
+}{capabilities: make(map[string][]string)}
+
+// callPlugin runs one call of a plugin and decodes its response
+func callPlugin(name string, config PluginConfig, call string, request pluginRequest) (pluginResponse, error) {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	request.Protocol = pluginProtocol
+	input, err := json.Marshal(request)
+	if err != nil {
+		return pluginResponse{}, err
+	}
+
+	fmt.Fprintf(os.Stderr, "Debug: Calling %s of plugin %s\n", call, name)
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", config.Command+` "$@"`, "sh", call)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second
+
+	output, err := cmd.Output()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			return pluginResponse{}, fmt.Errorf("plugin %s failed on %s: %w", name, call, err)
+		}
+		return pluginResponse{}, fmt.Errorf("plugin %s failed on %s: %w: %s", name, call, err, message)
+	}
+
+	var response pluginResponse
+	if err := json.Unmarshal(output, &response); err != nil {
+		return pluginResponse{}, fmt.Errorf("plugin %s printed an invalid %s response: %w", name, call, err)
+	}
+	if response.Error != "" {
+		return pluginResponse{}, fmt.Errorf("plugin %s failed on %s: %s", name, call, response.Error)
+	}
+	return response, nil
+}
+
+// describePlugin returns the capabilities of a plugin, asking it once per command
+func describePlugin(name string, config PluginConfig) ([]string, error) {
+	pluginDescriptions.Lock()
+	capabilities, ok := pluginDescriptions.capabilities[config.Command]
+	pluginDescriptions.Unlock()
+	if ok {
+		return capabilities, nil
+	}
+
+	response, err := callPlugin(name, config, "describe", pluginRequest{})
+	if err != nil {
+		return nil, err
+	}
+	if response.Protocol != pluginProtocol {
+		return nil, fmt.Errorf("plugin %s speaks protocol %d, kport speaks %d", name, response.Protocol, pluginProtocol)
+	}
+
+	pluginDescriptions.Lock()
+	pluginDescriptions.capabilities[config.Command] = response.Capabilities
+	pluginDescriptions.Unlock()
+	return response.Capabilities, nil
+}
+
+// forgetPluginDescriptions makes the next host load describe the plugins again,
+// picking up plugins that were upgraded
+func forgetPluginDescriptions() {
+	pluginDescriptions.Lock()
+	pluginDescriptions.capabilities = make(map[string][]string)
+	pluginDescriptions.Unlock()
+}
+
+// hostPlugin resolves the plugin called name for a host
+func (kc *KportConfig) hostPlugin(name string) (HostPlugin, error) {
+	config, ok := kc.Plugins[name]
+	if !ok {
+		return HostPlugin{}, fmt.Errorf("no plugin named %s in the kport config", name)
+	}
+	capabilities, err := describePlugin(name, config)
+	if err != nil {
+		return HostPlugin{}, err
+	}
+	return HostPlugin{
+		Name:         name,
+		Command:      config.Command,
+		Timeout:      config.Timeout,
+		Capabilities: capabilities,
+	}, nil
+}
+
+// can reports whether the plugin has a capability
+func (p HostPlugin) can(capability string) bool {
+	return slices.Contains(p.Capabilities, capability)
+}
+
+// config returns the settings the plugin was resolved from
+func (p HostPlugin) config() PluginConfig {
+	return PluginConfig{Command: p.Command, Timeout: p.Timeout}
+}
+
+// loadPluginHosts lists the hosts of every plugin with the hosts capability,
+// sorted by name. A plugin that fails is skipped so the others still load.
+func loadPluginHosts(kc *KportConfig) []SSHHost {
+	names := make([]string, 0, len(kc.Plugins))
+	for name := range kc.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hosts := make([]SSHHost, 0)
+	for _, name := range names {
+		plugin, err := kc.hostPlugin(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to load plugin %s: %v\n", name, err)
+			continue
+		}
+		if !plugin.can(PluginHosts) {
+			continue
+		}
+
+		response, err := callPlugin(name, plugin.config(), "hosts", pluginRequest{})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to list hosts of plugin %s: %v\n", name, err)
+			continue
+		}
+
+		listed := make([]SSHHost, 0, len(response.Hosts))
+		for _, h := range response.Hosts {
+			if h.Name == "" || h.Hostname == "" {
+				continue
+			}
+			host := SSHHost{
+				Name:     h.Name,
+				Hostname: h.Hostname,
+				User:     h.User,
+				Port:     h.Port,
+				Identity: h.Identity,
+				Inline:   true,
+				Plugin:   plugin,
+			}
+			if host.Port == "" {
+				host.Port = "22"
+			}
+			listed = append(listed, host)
+		}
+		sort.Slice(listed, func(i, j int) bool {
+			return listed[i].Name < listed[j].Name
+		})
+		hosts = append(hosts, listed...)
+	}
+	return hosts
+}
+
+// detectPluginPorts asks the host's plugin for the ports listening on the host
+func detectPluginPorts(host SSHHost) ([]int, map[int]string, map[int]string, error) {
+	response, err := callPlugin(host.Plugin.Name, host.Plugin.config(), "detect", pluginRequest{
+		Host: &pluginHost{
+			Name:     host.Name,
+			Hostname: host.Hostname,
+			User:     host.EffectiveUser(),
+			Port:     host.Port,
+			Identity: host.Identity,
+		},
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	ports := make([]int, 0, len(response.Ports))
+	processes := make(map[int]string)
+	addresses := make(map[int]string)
+	for _, p := range response.Ports {
+		if p.Port <= 0 || p.Port > 65535 {
+			continue
+		}
+		ports = append(ports, p.Port)
+		if p.Process != "" {
+			processes[p.Port] = p.Process
+		}
+		if address := dialAddress(p.Address); address != "" {
+			addresses[p.Port] = address
+		}
+	}
+
+	ports = removeDuplicates(ports)
+	sort.Ints(ports)
+	return ports, processes, addresses, nil
+}
+
+// pluginProxyCommand returns an ssh ProxyCommand that hands the connection to
+// the host's plugin, which relays it over its stdin and stdout
+func (h SSHHost) pluginProxyCommand() string {
+	// ssh expands % tokens in the ProxyCommand before the shell sees it
+	name := strings.ReplaceAll(h.Name, "%", "%%")
+	return fmt.Sprintf("ProxyCommand=%s connect %s %%h %%p", h.Plugin.Command, shellQuote(name))
+}
+
+// shellQuote quotes value as a single sh word
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// describePlugins lists the configured plugins with their capabilities for `kport plugins`
+func describePlugins(kc *KportConfig) []string {
+	names := make([]string, 0, len(kc.Plugins))
+	for name := range kc.Plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		plugin, err := kc.hostPlugin(name)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("   %s: %v", name, err))
+			continue
+		}
+		capabilities := "no capabilities"
+		if len(plugin.Capabilities) > 0 {
+			capabilities = strings.Join(plugin.Capabilities, ", ")
+		}
+		lines = append(lines, fmt.Sprintf("   %s (%s)", name, capabilities))
+	}
+	return lines
+}
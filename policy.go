@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// PolicyAction is what the policy does with a risky operation
+type PolicyAction string
+
+const (
+	PolicyAllow   PolicyAction = "allow"
+	PolicyConfirm PolicyAction = "confirm"
+	PolicyDeny    PolicyAction = "deny"
+)
+
+// Policy rules, named after their settings in the kport config
+const (
+	RuleNonLoopback         = "non_loopback"
+	RulePrivilegedPorts     = "privileged_ports"
+	RuleProdReverseForwards = "prod_reverse_forwards"
+)
+
+// privilegedPortLimit is the first port that isn't privileged
+const privilegedPortLimit = 1024
+
+// defaultProdTags mark a host as production when the policy doesn't list its own
+var defaultProdTags = []string{"prod", "production"}
+
+// PolicyConfig guards operations that expose more than the local machine or
+// touch production. Each rule allows, asks for a typed confirmation (the
+// default) or denies.
+type PolicyConfig struct {
+	// NonLoopback covers tunnels listening on addresses other machines can
+	// reach, such as LAN sharing on 0.0.0.0
+	NonLoopback PolicyAction `yaml:"non_loopback"`
+
+	// PrivilegedPorts covers local ports below 1024
+	PrivilegedPorts PolicyAction `yaml:"privileged_ports"`
+
+	// ProdReverseForwards covers RemoteForward lines and share links through
+	// hosts tagged as production
+	ProdReverseForwards PolicyAction `yaml:"prod_reverse_forwards"`
+
+	// ProdTags are the tags that mark a host as production, defaulting to prod and production
+	ProdTags []string `yaml:"prod_tags"`
+}
+
+// Risk is an operation a policy rule applies to
+type Risk struct {
+	Rule   string
+	Action PolicyAction
+	Reason string
+
+	// Setting is the config setting behind a risk outside the policy, such as
+	// environments.prod.confirm
+	Setting string
+}
+
+// setting names the config setting that decides about the risk
+func (r Risk) setting() string {
+	if r.Setting != "" {
+		return r.Setting
+	}
+	return "policy." + r.Rule
+}
+
+var (
+	policyMu     sync.Mutex
+	policyConfig PolicyConfig
+)
+
+// setPolicy applies the policy of a freshly loaded kport config
+func setPolicy(config PolicyConfig) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	policyConfig = config
+}
+
+// currentPolicy returns the policy in effect
+func currentPolicy() PolicyConfig {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	return policyConfig
+}
+
+// validate checks that every rule has a known action
+func (p PolicyConfig) validate() error {
+	for rule, action := range map[string]PolicyAction{
+		RuleNonLoopback:         p.NonLoopback,
+		RulePrivilegedPorts:     p.PrivilegedPorts,
+		RuleProdReverseForwards: p.ProdReverseForwards,
+	} {
+		switch action {
+		case "", PolicyAllow, PolicyConfirm, PolicyDeny:
+		default:
+			return fmt.Errorf("policy.%s must be allow, confirm or deny, not %q", rule, action)
+		}
+	}
+	return nil
+}
+
+// action returns what the policy does for a rule
+func (p PolicyConfig) action(rule string) PolicyAction {
+	var action PolicyAction
+	switch rule {
+	case RuleNonLoopback:
+		action = p.NonLoopback
+	case RulePrivilegedPorts:
+		action = p.PrivilegedPorts
+	case RuleProdReverseForwards:
+		action = p.ProdReverseForwards
+	}
+	if action == "" {
+		return PolicyConfirm
+	}
+	return action
+}
+
+// isProd reports whether host carries one of the production tags
+func (p PolicyConfig) isProd(host SSHHost) bool {
+	tags := p.ProdTags
+	if len(tags) == 0 {
+		tags = defaultProdTags
+	}
+	return slices.ContainsFunc(host.Tags, func(tag string) bool {
+		return slices.Contains(tags, strings.ToLower(tag))
+	})
+}
+
+// hostTags collects the tags of a host from its kport settings and from its
+// "tags" (comma-separated) and "env" notes
+func hostTags(host SSHHost, hostConfig HostConfig) []string {
+	tags := slices.Clone(hostConfig.Tags)
+	for _, tag := range strings.Split(host.Note("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if env := host.Note("env"); env != "" {
+		tags = append(tags, env)
+	}
+	return tags
+}
+
+// risk returns the risk of a rule, or false when the policy allows it
+func (p PolicyConfig) risk(rule, reason string) (Risk, bool) {
+	action := p.action(rule)
+	return Risk{Rule: rule, Action: action, Reason: reason}, action != PolicyAllow
+}
+
+// allowsPrivilegedPort reports whether a tunnel may take a privileged local
+// port it wasn't explicitly given, such as the same port as a remote port 80
+func (p PolicyConfig) allowsPrivilegedPort(options ForwardOptions) bool {
+	switch p.action(RulePrivilegedPorts) {
+	case PolicyAllow:
+		return true
+	case PolicyConfirm:
+		return options.Confirmed
+	}
+	return false
+}
+
+// tunnelRisks lists what the policy has to say about a tunnel to remotePort
+// on localPort. Without a local port kport takes the remote port when it can,
+// and when that is privileged, a policy that denies it makes kport pick
+// another port instead.
+func (p PolicyConfig) tunnelRisks(localPort, remotePort int, options ForwardOptions) []Risk {
+	risks := make([]Risk, 0)
+	for _, address := range []string{options.LANAddress, options.BindAddress} {
+		if address != "" && !isLoopbackAddress(address) {
+			if risk, ok := p.risk(RuleNonLoopback, fmt.Sprintf("listens on %s for other machines", net.JoinHostPort(address, fmt.Sprint(remotePort)))); ok {
+				risks = append(risks, risk)
+			}
+		}
+	}
+
+	if options.ConfirmRisk != nil {
+		risks = append(risks, *options.ConfirmRisk)
+	}
+
+	ports := slices.Clone(options.ExtraLocalPorts)
+	switch {
+	case localPort != 0:
+		ports = append(ports, localPort)
+	case remotePort < privilegedPortLimit && p.action(RulePrivilegedPorts) == PolicyConfirm && isPortAvailable(remotePort):
+		ports = append(ports, remotePort)
+	}
+	for _, port := range ports {
+		if port < privilegedPortLimit {
+			if risk, ok := p.risk(RulePrivilegedPorts, fmt.Sprintf("binds privileged local port %d", port)); ok {
+				risks = append(risks, risk)
+			}
+		}
+	}
+	return risks
+}
+
+// configuredForwardRisks lists what the policy has to say about the
+// LocalForward lines of host that bind to an address other machines reach
+func (p PolicyConfig) configuredForwardRisks(host SSHHost) []Risk {
+	risks := make([]Risk, 0)
+	for _, forward := range host.Forwards {
+		if forward.LocalPort() == 0 || isLoopbackAddress(forward.LocalAddress()) {
+			continue
+		}
+		if risk, ok := p.risk(RuleNonLoopback, fmt.Sprintf("%s listens for other machines", forward)); ok {
+			risks = append(risks, risk)
+		}
+	}
+	return risks
+}
+
+// isLoopbackAddress reports whether a local address is only reachable from this machine
+func isLoopbackAddress(address string) bool {
+	if address == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// reverseForwardRisks lists what the policy has to say about opening remote
+// forwards through host
+func (p PolicyConfig) reverseForwardRisks(host SSHHost, what string) []Risk {
+	if !p.isProd(host) {
+		return nil
+	}
+	if risk, ok := p.risk(RuleProdReverseForwards, fmt.Sprintf("opens %s on production host %s", what, host.Name)); ok {
+		return []Risk{risk}
+	}
+	return nil
+}
+
+// policyError returns why risks stop an operation: a rule that denies it, or
+// one that needs a confirmation that wasn't given
+func policyError(risks []Risk, confirmed bool) error {
+	for _, risk := range risks {
+		if risk.Action == PolicyDeny {
+			return fmt.Errorf("denied by %s in the kport config: %s", risk.setting(), risk.Reason)
+		}
+	}
+	if confirmed {
+		return nil
+	}
+	for _, risk := range risks {
+		if risk.Action == PolicyConfirm {
+			return fmt.Errorf("%s in the kport config needs a typed confirmation: %s", risk.setting(), risk.Reason)
+		}
+	}
+	return nil
+}
+
+// needsConfirmation reports whether any risk asks for a typed confirmation
+func needsConfirmation(risks []Risk) bool {
+	return slices.ContainsFunc(risks, func(risk Risk) bool {
+		return risk.Action == PolicyConfirm
+	})
+}
+
+// describeRisks lists risks, one per line
+func describeRisks(risks []Risk) string {
+	lines := make([]string, 0, len(risks))
+	for _, risk := range risks {
+		lines = append(lines, fmt.Sprintf("  • %s (%s)", risk.Reason, risk.setting()))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// confirmOnTerminal asks for a typed confirmation of risks on the terminal by
+// typing the host name. Without a terminal nothing can be confirmed.
+func confirmOnTerminal(hostName string, risks []Risk) error {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return policyError(risks, false)
+	}
+	fmt.Printf("⚠️  The kport policy asks you to confirm this tunnel:\n%s\n", describeRisks(risks))
+	fmt.Printf("Type %s to continue: ", hostName)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	if strings.TrimSpace(line) != hostName {
+		return fmt.Errorf("not confirmed")
+	}
+	return nil
+}
+
+// startForwarding starts a tunnel to port on the selected host once the
+// policy has no objections or the user has confirmed them
+func (m *Model) startForwarding(port int, options ForwardOptions, message string) tea.Cmd {
+	host := m.hosts[m.selectedHost]
+	cmd, err := m.confirmPolicy(host.Name, currentPolicy().tunnelRisks(0, port, options), func(confirmed bool) tea.Cmd {
+		options.Confirmed = confirmed
+		m.state = StateStartingForward
+		m.message = message
+		return m.numberStart(StartPortForwarding(host, port, options))
+	})
+	if err != nil {
+		m.message = fmt.Sprintf("Error: %v", err)
+	}
+	return cmd
+}
+
+// confirmPolicy runs proceed right away when risks need no confirmation, or
+// asks the user to type hostName first. It fails when the policy denies a risk.
+func (m *Model) confirmPolicy(hostName string, risks []Risk, proceed func(confirmed bool) tea.Cmd) (tea.Cmd, error) {
+	if err := policyError(risks, true); err != nil {
+		return nil, err
+	}
+	if !needsConfirmation(risks) {
+		return proceed(false), nil
+	}
+
+	m.confirmRisks = risks
+	m.confirmHost = hostName
+	m.confirmReturn = m.state
+	m.confirmProceed = proceed
+	m.state = StateConfirmPolicy
+	resetTextInput(&m.confirmInput, "")
+	return nil, nil
+}
+
+// updateConfirmPolicy handles typing the host name that confirms a risky operation
+func (m *Model) updateConfirmPolicy(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.state = m.confirmReturn
+		m.confirmProceed = nil
+		return m, m.notify(toastInfo, "Cancelled, nothing was started")
+	case tea.KeyEnter:
+		if strings.TrimSpace(m.confirmInput.Value()) != m.confirmHost {
+			m.confirmInput.Err = fmt.Errorf("type %s exactly to confirm", m.confirmHost)
+			return m, nil
+		}
+		proceed := m.confirmProceed
+		m.state = m.confirmReturn
+		m.confirmProceed = nil
+		return m, proceed(true)
+	default:
+		var cmd tea.Cmd
+		m.confirmInput.Err = nil
+		m.confirmInput, cmd = m.confirmInput.Update(msg)
+		return m, cmd
+	}
+}
+
+// renderConfirmPolicy renders the prompt for the confirmation the policy asks for
+func (m *Model) renderConfirmPolicy() string {
+	var s strings.Builder
+
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500")).Bold(true)
+	hostStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#7D56F4")).
+		Bold(true)
+
+	s.WriteString(warningStyle.Render("⚠️  The kport policy asks you to confirm this:"))
+	s.WriteString("\n\n")
+	s.WriteString(describeRisks(m.confirmRisks))
+	s.WriteString("\n\n")
+	s.WriteString(fmt.Sprintf("Type %s to continue:\n\n", hostStyle.Render(m.confirmHost)))
+	s.WriteString(renderTextInput(m.confirmInput))
+	s.WriteString("\n\n")
+
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	s.WriteString(dimStyle.Render("Set policy: in ~/.config/kport/config.yaml to allow or deny these without asking"))
+	s.WriteString("\n\n")
+
+	return s.String()
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// PortCategory groups listening ports by the kind of service behind them
+type PortCategory string
+
+const (
+	CategoryWeb       PortCategory = "web"
+	CategoryDatabase  PortCategory = "database"
+	CategoryCache     PortCategory = "cache"
+	CategoryMessaging PortCategory = "messaging"
+	CategorySystem    PortCategory = "system"
+	CategoryOther     PortCategory = "other"
+)
+
+// portCategories are the categories that can be filtered on, in the order of their keys
+var portCategories = []PortCategory{CategoryWeb, CategoryDatabase, CategoryCache, CategoryMessaging, CategorySystem}
+
+// categoryColors are the colors the categories are shown in
+var categoryColors = map[PortCategory]lipgloss.Color{
+	CategoryWeb:       lipgloss.Color("#04B575"),
+	CategoryDatabase:  lipgloss.Color("#00BFFF"),
+	CategoryCache:     lipgloss.Color("#FF5F87"),
+	CategoryMessaging: lipgloss.Color("#FFA500"),
+	CategorySystem:    lipgloss.Color("#888888"),
+	CategoryOther:     lipgloss.Color("#666666"),
+}
+
+// processCategories maps process names, or their prefixes, to categories.
+// Process names are checked before port numbers, since dev servers use all kinds of ports.
+var processCategories = []struct {
+	prefix   string
+	category PortCategory
+}{
+	{"nginx", CategoryWeb},
+	{"httpd", CategoryWeb},
+	{"apache", CategoryWeb},
+	{"caddy", CategoryWeb},
+	{"traefik", CategoryWeb},
+	{"envoy", CategoryWeb},
+	{"haproxy", CategoryWeb},
+	{"node_exporter", CategorySystem},
+	{"node", CategoryWeb},
+	{"deno", CategoryWeb},
+	{"bun", CategoryWeb},
+	{"gunicorn", CategoryWeb},
+	{"uvicorn", CategoryWeb},
+	{"puma", CategoryWeb},
+	{"php-fpm", CategoryWeb},
+	{"postgres", CategoryDatabase},
+	{"postmaster", CategoryDatabase},
+	{"mysqld", CategoryDatabase},
+	{"mariadbd", CategoryDatabase},
+	{"mongod", CategoryDatabase},
+	{"mongos", CategoryDatabase},
+	{"clickhouse", CategoryDatabase},
+	{"cockroach", CategoryDatabase},
+	{"influxd", CategoryDatabase},
+	{"sqlservr", CategoryDatabase},
+	{"redis", CategoryCache},
+	{"valkey", CategoryCache},
+	{"keydb", CategoryCache},
+	{"memcached", CategoryCache},
+	{"varnish", CategoryCache},
+	{"beam.smp", CategoryMessaging},
+	{"rabbitmq", CategoryMessaging},
+	{"nats-server", CategoryMessaging},
+	{"mosquitto", CategoryMessaging},
+	{"kafka", CategoryMessaging},
+	{"sshd", CategorySystem},
+	{"systemd", CategorySystem},
+	{"dnsmasq", CategorySystem},
+	{"chronyd", CategorySystem},
+	{"cupsd", CategorySystem},
+	{"rpcbind", CategorySystem},
+	{"exim", CategorySystem},
+	{"containerd", CategorySystem},
+	{"dockerd", CategorySystem},
+}
+
+// wellKnownPorts maps well-known port numbers to categories
+var wellKnownPorts = map[int]PortCategory{
+	80: CategoryWeb, 443: CategoryWeb, 3000: CategoryWeb, 3001: CategoryWeb, 4000: CategoryWeb,
+	4200: CategoryWeb, 5000: CategoryWeb, 5173: CategoryWeb, 8000: CategoryWeb, 8008: CategoryWeb,
+	8080: CategoryWeb, 8081: CategoryWeb, 8443: CategoryWeb, 8888: CategoryWeb, 9000: CategoryWeb,
+
+	1433: CategoryDatabase, 1521: CategoryDatabase, 3306: CategoryDatabase, 5432: CategoryDatabase,
+	5984: CategoryDatabase, 7474: CategoryDatabase, 8086: CategoryDatabase, 9042: CategoryDatabase,
+	9200: CategoryDatabase, 26257: CategoryDatabase, 27017: CategoryDatabase,
+
+	6379: CategoryCache, 11211: CategoryCache,
+
+	1883: CategoryMessaging, 4222: CategoryMessaging, 5672: CategoryMessaging, 9092: CategoryMessaging,
+	15672: CategoryMessaging, 61613: CategoryMessaging, 61616: CategoryMessaging,
+
+	22: CategorySystem, 25: CategorySystem, 53: CategorySystem, 111: CategorySystem, 123: CategorySystem,
+	631: CategorySystem, 2375: CategorySystem, 2376: CategorySystem, 9100: CategorySystem,
+}
+
+// categorizePort returns the category of a listening port from its process name, if known, and number
+func categorizePort(port int, process string) PortCategory {
+	process = strings.ToLower(process)
+	if process != "" {
+		for _, pc := range processCategories {
+			if strings.HasPrefix(process, pc.prefix) {
+				return pc.category
+			}
+		}
+	}
+	if category, ok := wellKnownPorts[port]; ok {
+		return category
+	}
+	return CategoryOther
+}
+
+// Style returns the style the category is rendered in
+func (c PortCategory) Style() lipgloss.Style {
+	return lipgloss.NewStyle().Foreground(categoryColors[c])
+}
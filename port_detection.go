@@ -1,19 +1,34 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // PortsDetectedMsg is sent when ports are detected
 type PortsDetectedMsg struct {
+	Host  string
 	Ports []int
+	Err   error
+
+	// Processes maps ports to the name of the process listening on them, when visible
+	Processes map[int]string
+
+	// Addresses maps ports to the address their service is reached on from the
+	// remote host, which follows the address it is bound to
+	Addresses map[int]string
 }
 
 // ErrorMsg is sent when an error occurs
@@ -21,78 +36,226 @@ type ErrorMsg struct {
 	Error error
 }
 
+// defaultDetectTimeout leaves ssh's own 10 second connect timeout room to
+// report a host it can't reach before the detection command is killed
+const defaultDetectTimeout = 15 * time.Second
+
 // DetectPorts detects open ports on the remote host
 func DetectPorts(host SSHHost) tea.Cmd {
 	return func() tea.Msg {
-		ports, err := detectRemotePorts(host)
+		ctx, span := tracer.Start(context.Background(), "kport.detect_ports", trace.WithAttributes(hostAttributes(host)...))
+
+		host, err := tracedPrepareHost(ctx, host)
+		if err != nil {
+			endSpan(span, err)
+			return PortsDetectedMsg{Host: host.Name, Err: err}
+		}
+
+		ports, processes, addresses, err := detectRemotePorts(ctx, host)
+		span.SetAttributes(attribute.Int("kport.ports_detected", len(ports)))
+		endSpan(span, err)
 		if err != nil {
 			// Log the error for debugging but don't quit the app
 			fmt.Fprintf(os.Stderr, "Debug: Port detection failed for %s: %v\n", host.Name, err)
 			// Return empty ports list so user can still use manual port forwarding
-			return PortsDetectedMsg{Ports: []int{}}
+			return PortsDetectedMsg{Host: host.Name, Ports: []int{}, Err: err}
 		}
 		fmt.Fprintf(os.Stderr, "Debug: Detected %d ports on %s: %v\n", len(ports), host.Name, ports)
-		return PortsDetectedMsg{Ports: ports}
+		return PortsDetectedMsg{Host: host.Name, Ports: ports, Processes: processes, Addresses: addresses}
 	}
 }
 
-// detectRemotePorts connects to the remote host and detects open ports using ssh command
-func detectRemotePorts(host SSHHost) ([]int, error) {
-	// Try different commands to detect listening ports
-	commands := []string{
-		"netstat -tlnp 2>/dev/null | grep LISTEN | awk '{print $4}' | cut -d: -f2 | sort -n | uniq",
-		"ss -tlnp 2>/dev/null | grep LISTEN | awk '{print $4}' | cut -d: -f2 | sort -n | uniq",
-		"lsof -i -P -n 2>/dev/null | grep LISTEN | awk '{print $9}' | cut -d: -f2 | sort -n | uniq",
+// listenCommands list the listening ports of a host. Each prints "port address process",
+// taking the port after the last colon so IPv6 addresses like [::]:80 work too.
+var listenCommands = []string{
+	`netstat -tlnp 2>/dev/null | grep LISTEN | awk '{n=split($4,a,":"); split($7,p,"/"); print a[n], substr($4, 1, length($4)-length(a[n])-1), p[2]}' | sort -n | uniq`,
+	`ss -tlnp 2>/dev/null | grep LISTEN | awk '{n=split($4,a,":"); p=""; if (match($0, /"[^"]+"/)) p=substr($0, RSTART+1, RLENGTH-2); print a[n], substr($4, 1, length($4)-length(a[n])-1), p}' | sort -n | uniq`,
+	`lsof -i -P -n 2>/dev/null | grep LISTEN | awk '{n=split($9,a,":"); print a[n], substr($9, 1, length($9)-length(a[n])-1), $1}' | sort -n | uniq`,
+}
+
+// detectRemotePorts connects to the remote host and detects open ports using ssh command.
+// It also returns the process listening on each port where the remote user may see it,
+// and the address each port is reached on.
+func detectRemotePorts(ctx context.Context, host SSHHost) ([]int, map[int]string, map[int]string, error) {
+	// Plugins that can detect ports replace the commands run over ssh
+	if host.Plugin.can(PluginDetect) {
+		return detectPluginPorts(host)
 	}
 
-	var output []byte
-	var err error
+	timeout := host.DetectTimeout
+	if timeout <= 0 {
+		timeout = defaultDetectTimeout
+	}
 
-	for _, cmd := range commands {
+	// Run the commands at once, each under its own timeout, and take the first
+	// that lists ports, so a hanging lsof doesn't hold up netstat's answer
+	detectCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan detectResult, len(listenCommands))
+	for i, cmd := range listenCommands {
 		fmt.Fprintf(os.Stderr, "Debug: Running command on %s: %s\n", host.Name, cmd)
-		
-		// Use ssh command directly - this supports all SSH features including ProxyCommand
-		sshCmd := exec.Command("ssh", "-o", "ConnectTimeout=10", "-o", "BatchMode=yes", host.Name, cmd)
-		
-		output, err = sshCmd.Output()
-		if err == nil && len(output) > 0 {
-			fmt.Fprintf(os.Stderr, "Debug: Command succeeded, got output\n")
+		// One command is enough to follow the handshake. ssh passes -v on to the
+		// ssh of jump hosts, whose handshakes the jump chain shows instead.
+		watch := i == 0 && len(host.JumpChain) == 0
+		go func() {
+			output, err := runDetectCommand(detectCtx, host, cmd, timeout, watch)
+			results <- detectResult{command: strings.Fields(cmd)[0], output: output, err: err}
+		}()
+	}
+
+	var ports []int
+	var processes, addresses map[int]string
+	for range listenCommands {
+		result := <-results
+		if result.err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: %s failed: %v\n", result.command, result.err)
+			// Other commands won't fare better when ssh itself couldn't connect
+			if sshErr := sshConnectionError(host, nil, result.err); sshErr != nil {
+				return nil, nil, nil, sshErr
+			}
+			continue
+		}
+		ports, processes, addresses = parseListeningPorts(result.output)
+		if len(ports) > 0 {
+			fmt.Fprintf(os.Stderr, "Debug: %s succeeded, cancelling the other commands\n", result.command)
 			break
 		}
-		fmt.Fprintf(os.Stderr, "Debug: Command failed: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Debug: %s listed no ports\n", result.command)
 	}
+	// The commands still running aren't needed anymore
+	cancel()
 
-	if err != nil || len(output) == 0 {
+	if len(ports) == 0 {
 		fmt.Fprintf(os.Stderr, "Debug: All port detection commands failed, trying common ports\n")
 		// Fallback: try common ports
-		return detectCommonPorts(host), nil
+		return detectCommonPorts(ctx, host), nil, nil, nil
+	}
+
+	// ss -p and netstat -p only show processes to root on restricted hosts,
+	// while /proc still reveals the remote user's own
+	if len(processes) == 0 && len(ports) > 0 {
+		fillProcProcesses(ctx, host, ports, processes)
+	}
+
+	return ports, processes, addresses, nil
+}
+
+// detectResult is the output of one port detection command
+type detectResult struct {
+	command string
+	output  []byte
+	err     error
+}
+
+// runDetectCommand runs a port detection command on host, killing it after timeout.
+// With watch set ssh runs verbosely, reporting the stages of its handshake.
+func runDetectCommand(ctx context.Context, host SSHHost, cmd string, timeout time.Duration, watch bool) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	options := host.probeOptions(10)
+	if watch {
+		options = append(options, "-v")
+	}
+	// Use ssh command directly - this supports all SSH features including ProxyCommand
+	sshCmd := sshCommandContext(ctx, host, options, cmd)
+	// Don't wait on a remote command that keeps ssh's output open after it is killed
+	sshCmd.WaitDelay = time.Second
+	var watcher *handshakeWatcher
+	if watch {
+		watcher = newHandshakeWatcher(host.Name)
+		sshCmd.Stderr = &lineWriter{fn: watcher.line}
 	}
 
-	// Parse the output to extract port numbers
+	output, err := tracedOutput(ctx, strings.Fields(cmd)[0], sshCmd)
+	// ssh's errors are explained from its stderr without the debug output
+	var exitErr *exec.ExitError
+	if watcher != nil && errors.As(err, &exitErr) {
+		exitErr.Stderr = watcher.stderr
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return nil, fmt.Errorf("timed out after %s", timeout)
+	}
+	return output, err
+}
+
+// parseListeningPorts extracts the port numbers, addresses and process names
+// from the "port address process" lines of a detection command
+func parseListeningPorts(output []byte) ([]int, map[int]string, map[int]string) {
 	ports := make([]int, 0)
+	processes := make(map[int]string)
+	addresses := make(map[int]string)
 	lines := strings.Split(string(output), "\n")
-	
+
 	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
 			continue
 		}
-		
-		port, err := strconv.Atoi(line)
+
+		port, err := strconv.Atoi(fields[0])
 		if err == nil && port > 0 && port < 65536 {
 			ports = append(ports, port)
+			// A service listening on several addresses is dialed on the most local one
+			if len(fields) > 1 {
+				if address := dialAddress(fields[1]); address != "" && (addresses[port] == "" || addressRank(address) < addressRank(addresses[port])) {
+					addresses[port] = address
+				}
+			}
+			// Processes of other users show up as "-" without root
+			if len(fields) > 2 && fields[2] != "-" {
+				processes[port] = fields[2]
+			}
 		}
 	}
 
 	// Remove duplicates and sort
 	ports = removeDuplicates(ports)
 	sort.Ints(ports)
+	return ports, processes, addresses
+}
+
+// dialAddress returns the address that reaches a service bound to bind from the
+// remote host itself: the loopback address for a wildcard bind and the bind
+// address otherwise. It returns "" when bind isn't an address.
+func dialAddress(bind string) string {
+	if bind == "*" {
+		return "127.0.0.1"
+	}
+	bind = strings.Trim(bind, "[]")
+	// ss adds the interface to addresses bound to one, as in 127.0.0.53%lo
+	if i := strings.IndexByte(bind, '%'); i >= 0 {
+		bind = bind[:i]
+	}
+	ip := net.ParseIP(bind)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.IsUnspecified() && ip.To4() != nil:
+		return "127.0.0.1"
+	case ip.IsUnspecified():
+		return "::1"
+	}
+	return ip.String()
+}
 
-	return ports, nil
+// addressRank orders the addresses a port is reached on, lowest first:
+// 127.0.0.1, other loopback addresses, then the rest
+func addressRank(address string) int {
+	switch ip := net.ParseIP(address); {
+	case address == "127.0.0.1":
+		return 0
+	case ip != nil && ip.IsLoopback():
+		return 1
+	}
+	return 2
 }
 
 // detectCommonPorts tries to detect common ports by testing connections through SSH
-func detectCommonPorts(host SSHHost) []int {
+func detectCommonPorts(ctx context.Context, host SSHHost) []int {
+	ctx, span := tracer.Start(ctx, "kport.detect_common_ports")
+	defer span.End()
+
 	commonPorts := []int{80, 443, 3000, 3001, 4000, 5000, 8000, 8080, 8443, 9000}
 	var openPorts []int
 
@@ -101,9 +264,9 @@ func detectCommonPorts(host SSHHost) []int {
 	for _, port := range commonPorts {
 		// Test if port is open using SSH to run a quick connection test
 		cmd := fmt.Sprintf("timeout 1 bash -c '</dev/tcp/localhost/%d' 2>/dev/null && echo 'open' || echo 'closed'", port)
-		sshCmd := exec.Command("ssh", "-o", "ConnectTimeout=5", "-o", "BatchMode=yes", host.Name, cmd)
+		sshCmd := sshCommand(host, host.probeOptions(5), cmd)
 		
-		output, err := sshCmd.Output()
+		output, err := tracedOutput(ctx, fmt.Sprintf("probe port %d", port), sshCmd)
 		if err == nil && strings.TrimSpace(string(output)) == "open" {
 			openPorts = append(openPorts, port)
 			fmt.Fprintf(os.Stderr, "Debug: Port %d is open\n", port)
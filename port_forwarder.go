@@ -1,46 +1,247 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ForwardingStartedMsg is sent when port forwarding starts
 type ForwardingStartedMsg struct {
-	LocalPort  int
-	RemotePort int
+	LocalPort    int
+	RemotePort   int
+	Forwarder    *PortForwarder
+	OtherTunnels []PortReservation
+
+	// Start tells the TUI which of its starts the tunnel belongs to
+	Start int
+}
+
+// ForwardOptions holds optional settings for a single tunnel
+type ForwardOptions struct {
+	// Destination replaces the primary destination on the remote host, as
+	// host:port or an SRV name such as _postgres._tcp.internal
+	Destination string
+
+	// Failover lists alternative destinations (host:port or an SRV name)
+	// tried in order when the primary destination is unreachable
+	Failover []string
+
+	// HTTPS terminates TLS on the local listener with a certificate from the kport CA
+	HTTPS bool
+
+	// AccessLog appends a line per HTTP request to the tunnel's access log
+	AccessLog bool
+
+	// IdleTimeout closes short-lived connections without traffic for this long (0 disables)
+	IdleTimeout time.Duration
+
+	// StreamIdleTimeout is the idle timeout for WebSocket and streaming connections (0 disables)
+	StreamIdleTimeout time.Duration
+
+	// TTL closes the tunnel automatically after this long (0 keeps it open)
+	TTL time.Duration
+
+	// IPv6 also listens on the IPv6 loopback address [::1], which is the default
+	IPv6 bool
+
+	// LANAddress also listens on this non-loopback address, over mutual TLS
+	// so only clients with a certificate from the kport CA can connect
+	LANAddress string
+
+	// BindAddress is the host's BindAddress from the SSH config, which the
+	// local ports listen on instead of 127.0.0.1 and [::1]
+	BindAddress string
+
+	// LocalPort is the local port to listen on, as a profile's tunnel sets it,
+	// instead of the remote port or a free one
+	LocalPort int
+
+	// LocalSocket is a Unix socket path to listen on instead of a local port,
+	// which takes no port and is only reachable by the local user
+	LocalSocket string
+
+	// ExtraLocalPorts are additional local ports forwarded to the same destination
+	ExtraLocalPorts []int
+
+	// MaxChannels caps the connections proxied at once, queueing the rest (0 is unlimited)
+	MaxChannels int
+
+	// Container forwards to the port inside this docker container's network on
+	// the host, for ports that aren't published to the host
+	Container string
+
+	// RemoteHost is the address the remote port is dialed on from the host,
+	// for services bound to one address only (default localhost)
+	RemoteHost string
+
+	// WatchInterval is how often the tunnel checks that the remote service is still listening (0 disables)
+	WatchInterval time.Duration
+
+	// Notify shows a desktop notification when the remote service goes away or comes back
+	Notify bool
+
+	// ConfirmRisk is set when the host's confirm setting asks for a typed
+	// confirmation of tunnels to the remote port's category
+	ConfirmRisk *Risk
+
+	// Confirmed is set once the user has typed the confirmation the policy asks for
+	Confirmed bool
+}
+
+// forwardOptionsFor derives the tunnel options for a remote port from the
+// host's kport settings and its BindAddress
+func forwardOptionsFor(host SSHHost, hostConfig HostConfig, remotePort int) ForwardOptions {
+	options := ForwardOptions{
+		BindAddress: host.BindAddress,
+
+		Destination: hostConfig.Destinations[remotePort],
+		Failover:    hostConfig.Failover[remotePort],
+		HTTPS:       slices.Contains(hostConfig.HTTPS, remotePort),
+		AccessLog:   slices.Contains(hostConfig.AccessLog, remotePort),
+		IPv6:        hostConfig.IPv6 == nil || *hostConfig.IPv6,
+
+		ExtraLocalPorts: hostConfig.ExtraPorts[remotePort],
+		MaxChannels:     hostConfig.MaxChannels,
+
+		ConfirmRisk: confirmRisk(host, hostConfig, remotePort),
+	}
+
+	if slices.Contains(hostConfig.LAN, remotePort) {
+		options.LANAddress = hostConfig.LANAddress
+		if options.LANAddress == "" {
+			options.LANAddress = defaultLANAddress
+		}
+	}
+
+	if hostConfig.IdleTimeout != nil {
+		options.IdleTimeout = *hostConfig.IdleTimeout
+	}
+	options.StreamIdleTimeout = options.IdleTimeout
+	if hostConfig.StreamIdleTimeout != nil {
+		options.StreamIdleTimeout = *hostConfig.StreamIdleTimeout
+	}
+	if hostConfig.TTL != nil {
+		options.TTL = *hostConfig.TTL
+	}
+	if hostConfig.WatchInterval != nil {
+		options.WatchInterval = *hostConfig.WatchInterval
+	}
+	if hostConfig.Notify != nil {
+		options.Notify = *hostConfig.Notify
+	}
+
+	return options
 }
 
-// PortForwarder manages SSH port forwarding using ssh command
+// PortForwarder manages SSH port forwarding using ssh command.
+// ssh forwards a loopback port that only kport connects to, and kport
+// proxies connections from the user-facing local port so traffic can be inspected.
 type PortForwarder struct {
-	hostName     string
+	host         SSHHost
 	localPort    int
 	remotePort   int
+	options      ForwardOptions
+	destinations []*Destination
+	active       int
+	destMu       sync.Mutex
+	probeNow     chan struct{}
 	sshCmd       *exec.Cmd
+	controlPath  string
+	relay        string
+	listeners    []net.Listener
 	stopChan     chan struct{}
+	sshDone      chan struct{}
 	wg           sync.WaitGroup
 	isRunning    bool
 	mu           sync.Mutex
+	conns        map[*TrackedConn]struct{}
+	channels     *channelLimiter
+	totalConns   int64
+	doneBytesIn  int64
+	doneBytesOut int64
+	throughput   throughputHistory
+	capture      *Capture
+	accessLog    *AccessLog
+	connMu       sync.Mutex
+	caCertPath   string
+	startedAt    time.Time
+	ttlTimer     *time.Timer
+	expired      bool
+	errors       *ErrorAggregator
+	localHints   []string
+	hintsMu      sync.Mutex
+	traceCtx     context.Context
+	label        string
+	note         string
+
+	// remoteDownSince is when the remote service stopped listening, zero while
+	// it is up. The watcher setting it is waited for by stopping the tunnel,
+	// which holds pf.mu, so remoteMu guards it instead.
+	remoteDownSince time.Time
+	remoteErr       error
+	remoteMu        sync.Mutex
+
+	// sshFailure explains why ssh failed to connect, nil until it printed a
+	// known error. It is set from ssh's stderr, which stopping the tunnel waits
+	// for while holding pf.mu, so it has no lock of its own.
+	sshFailure atomic.Pointer[SSHError]
 }
 
 // NewPortForwarder creates a new port forwarder using ssh command
-func NewPortForwarder(hostName string, localPort, remotePort int) *PortForwarder {
-	return &PortForwarder{
-		hostName:   hostName,
+func NewPortForwarder(host SSHHost, localPort, remotePort int, options ForwardOptions) *PortForwarder {
+	pf := &PortForwarder{
+		host:       host,
 		localPort:  localPort,
 		remotePort: remotePort,
+		options:    options,
+		probeNow:   make(chan struct{}, 1),
 		stopChan:   make(chan struct{}),
+		sshDone:    make(chan struct{}),
+		traceCtx:   context.Background(),
+		conns:      make(map[*TrackedConn]struct{}),
+		channels:   newChannelLimiter(options.MaxChannels),
 	}
+
+	// Repeated errors from a flapping tunnel are logged once per window
+	pf.errors = NewErrorAggregator(errorWindow, func(message string) {
+		fmt.Fprintf(os.Stderr, "Debug: %s -> %s:%d: %s\n", pf.LocalAddress(), host.Name, remotePort, message)
+		logEvent("tunnel error: %s -> %s:%d: %s", pf.LocalAddress(), host.Name, remotePort, message)
+	})
+
+	return pf
 }
 
 // Start starts the port forwarding using ssh command
 func (pf *PortForwarder) Start() error {
+	return pf.StartContext(context.Background())
+}
+
+// StartContext starts the port forwarding, tracing the startup and the
+// tunnel's connections under ctx
+func (pf *PortForwarder) StartContext(ctx context.Context) (err error) {
+	ctx, span := tracer.Start(ctx, "kport.tunnel.start", trace.WithAttributes(append(hostAttributes(pf.host),
+		attribute.Int("kport.local_port", pf.localPort),
+		attribute.Int("kport.remote_port", pf.remotePort),
+	)...))
+	defer func() { endSpan(span, err) }()
+
+	// Goroutines started for the tunnel, including ssh's and each connection's, are counted as its own
+	defer labelTunnelGoroutines(ctx, pf.localPort)()
+
 	pf.mu.Lock()
 	defer pf.mu.Unlock()
 
@@ -48,34 +249,199 @@ func (pf *PortForwarder) Start() error {
 		return fmt.Errorf("port forwarding already running")
 	}
 
-	// Use ssh command with -L flag for local port forwarding
-	// Format: ssh -L localport:localhost:remoteport hostname
-	pf.sshCmd = exec.Command("ssh", 
-		"-L", fmt.Sprintf("%d:localhost:%d", pf.localPort, pf.remotePort),
+	destinations, err := buildDestinations(pf.options.RemoteHost, pf.remotePort, pf.options.Destination, pf.options.Failover)
+	if err != nil {
+		return err
+	}
+
+	sshArgs := make([]string, 0)
+	if pf.options.Container != "" {
+		// Container ports aren't reachable with -L, so ssh runs as a master that
+		// each connection's relay command is multiplexed over
+		destinations = destinations[:1]
+		if pf.relay, err = containerRelay(ctx, pf.host, pf.options.Container, pf.remotePort); err != nil {
+			return err
+		}
+		controlDir, err := kportCacheDir("control")
+		if err != nil {
+			return err
+		}
+		pf.controlPath = filepath.Join(controlDir, fmt.Sprintf("%d.sock", pf.localPort))
+		os.Remove(pf.controlPath)
+		sshArgs = append(sshArgs, "-M", "-S", pf.controlPath, "-o", "ControlPersist=no")
+	}
+
+	// SRV names become a destination per record, and names with several
+	// addresses are forwarded once per address, so connections can move to
+	// another address without restarting ssh
+	if pf.options.Container == "" {
+		if destinations, err = pf.resolveServices(ctx, destinations); err != nil {
+			return err
+		}
+		pf.resolveDestinations(ctx, destinations)
+	}
+
+	// Each destination gets its own loopback port forwarded by the same ssh process
+	for _, dest := range destinations {
+		if pf.options.Container != "" {
+			break
+		}
+		sshPort, err := findAvailablePort()
+		if err != nil {
+			return fmt.Errorf("failed to find internal port: %w", err)
+		}
+		dest.sshPort = sshPort
+		// Format: -L 127.0.0.1:sshport:desthost:destport
+		sshArgs = append(sshArgs, "-L", fmt.Sprintf("127.0.0.1:%d:%s", sshPort, dest.Address()))
+
+		for i := range dest.Addresses {
+			sshPort, err := findAvailablePort()
+			if err != nil {
+				return fmt.Errorf("failed to find internal port: %w", err)
+			}
+			dest.Addresses[i].sshPort = sshPort
+			sshArgs = append(sshArgs, "-L", fmt.Sprintf("127.0.0.1:%d:%s", sshPort, net.JoinHostPort(dest.Addresses[i].IP, strconv.Itoa(dest.Port))))
+		}
+	}
+	pf.destinations = destinations
+
+	listeners, err := pf.listen()
+	if err != nil {
+		return err
+	}
+
+	// Terminate HTTPS locally so tools that require https://localhost work
+	if pf.options.HTTPS {
+		ca, err := LoadOrCreateLocalCA()
+		if err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("failed to load kport CA: %w", err)
+		}
+		tlsConfig, err := ca.TLSConfig()
+		if err != nil {
+			closeListeners(listeners)
+			return err
+		}
+		for i, listener := range listeners {
+			listeners[i] = tls.NewListener(listener, tlsConfig)
+		}
+		pf.caCertPath = ca.CertPath()
+	}
+
+	// LAN listeners always use TLS, with their own certificate and client authentication
+	if pf.options.LANAddress != "" && pf.options.LocalSocket == "" {
+		lanListeners, err := pf.listenLAN()
+		if err != nil {
+			closeListeners(listeners)
+			return err
+		}
+		listeners = append(listeners, lanListeners...)
+	}
+	pf.listeners = listeners
+
+	// Use ssh command with -L flags for local port forwarding
+	sshArgs = append(sshArgs,
 		"-N", // Don't execute remote command, just forward ports
-		"-o", "ExitOnForwardFailure=yes", // Exit if port forwarding fails
 		"-o", "ServerAliveInterval=30", // Keep connection alive
-		"-o", "ServerAliveCountMax=3",
-		pf.hostName)
+		"-o", "ServerAliveCountMax=3")
+	sshArgs = append(sshArgs, exitOnForwardFailure(pf.host)...) // Exit if port forwarding fails
+	pf.sshCmd = sshCommand(pf.host, sshArgs)
+	pf.sshCmd.Stderr = &lineWriter{fn: pf.handleSSHStderr}
 
 	fmt.Fprintf(os.Stderr, "Debug: Starting SSH command: %s\n", pf.sshCmd.String())
 
 	// Start the SSH command
 	if err := pf.sshCmd.Start(); err != nil {
+		closeListeners(listeners)
 		return fmt.Errorf("failed to start SSH port forwarding: %w", err)
 	}
 
 	pf.isRunning = true
+	pf.startedAt = time.Now()
+
+	if pf.options.TTL > 0 {
+		pf.ttlTimer = time.AfterFunc(pf.options.TTL, pf.expire)
+		logEvent("tunnel opened: %s -> %s:%d (time limit %s)", pf.LocalAddress(), pf.host.Name, pf.remotePort, pf.options.TTL)
+	} else {
+		logEvent("tunnel opened: %s -> %s:%d", pf.LocalAddress(), pf.host.Name, pf.remotePort)
+	}
+	auditTunnel("open", pf.host, pf.localPort, pf.remotePort, 0, "")
+
+	// The tunnel works without its access log, so a log that can't be opened is only reported
+	if pf.options.AccessLog {
+		if pf.accessLog, err = OpenAccessLog(pf.host.Name, pf.remotePort); err != nil {
+			pf.errors.Record(err)
+		}
+	}
 
 	// Monitor the SSH process
 	pf.wg.Add(1)
 	go pf.monitorSSH()
 
+	// Probing ssh's forward port opens a channel to the destination, so only do it when traced
+	pf.traceCtx = ctx
+	if span.IsRecording() && pf.options.Container == "" {
+		pf.wg.Add(1)
+		go pf.traceSSHReady(ctx, pf.destinations[0].sshPort)
+	}
+
+	// Accept connections on every local listener; they all share the same stats
+	for _, listener := range pf.listeners {
+		pf.wg.Add(1)
+		go pf.acceptConnections(listener)
+	}
+
+	// Check that local clients can reach the listeners, which firewalls and
+	// resolvers don't get in the way of for a local socket
+	if pf.options.LocalSocket == "" {
+		pf.wg.Add(1)
+		go pf.diagnoseListeners(pf.listensIPv6())
+	}
+
+	// Probe failover destinations, the addresses of raced names and SRV records in the background
+	if pf.probesDestinations() {
+		pf.wg.Add(1)
+		go pf.monitorFailover()
+	}
+
+	// Warn when the remote service stops listening. Container relays can't
+	// time out a read, so their tunnels aren't watched.
+	if pf.options.WatchInterval > 0 && pf.options.Container == "" {
+		pf.wg.Add(1)
+		go pf.watchRemote()
+	}
+
+	// Enforce idle timeouts on proxied connections
+	if pf.options.IdleTimeout > 0 || pf.options.StreamIdleTimeout > 0 {
+		pf.wg.Add(1)
+		go pf.monitorIdle()
+	}
+
+	// Sample the traffic for the throughput graph and export
+	pf.wg.Add(1)
+	go pf.recordThroughput()
+
 	return nil
 }
 
 // Stop stops the port forwarding
 func (pf *PortForwarder) Stop() {
+	pf.stop("stopped")
+}
+
+// expire closes the tunnel once its time limit is reached
+func (pf *PortForwarder) expire() {
+	pf.mu.Lock()
+	if pf.isRunning {
+		pf.expired = true
+	}
+	pf.mu.Unlock()
+
+	pf.stop(fmt.Sprintf("time limit of %s reached", pf.options.TTL))
+}
+
+// stop stops the port forwarding and logs why it was closed
+func (pf *PortForwarder) stop(reason string) {
 	pf.mu.Lock()
 	defer pf.mu.Unlock()
 
@@ -85,19 +451,45 @@ func (pf *PortForwarder) Stop() {
 
 	pf.isRunning = false
 	close(pf.stopChan)
+	closeListeners(pf.listeners)
+	releaseLocalPort(pf.localPort)
+	if pf.ttlTimer != nil {
+		pf.ttlTimer.Stop()
+	}
+	logEvent("tunnel closed: %s -> %s:%d (%s)", pf.LocalAddress(), pf.host.Name, pf.remotePort, reason)
+	auditTunnel("close", pf.host, pf.localPort, pf.remotePort, time.Since(pf.startedAt), reason)
 
 	// Kill the SSH process
 	if pf.sshCmd != nil && pf.sshCmd.Process != nil {
 		fmt.Fprintf(os.Stderr, "Debug: Stopping SSH port forwarding\n")
 		pf.sshCmd.Process.Kill()
 	}
+	// A killed master leaves its control socket behind
+	if pf.controlPath != "" {
+		os.Remove(pf.controlPath)
+	}
+
+	// Close proxied connections and any active capture
+	pf.connMu.Lock()
+	for tc := range pf.conns {
+		tc.Close()
+	}
+	if pf.capture != nil {
+		pf.capture.Close()
+		pf.capture = nil
+	}
+	pf.connMu.Unlock()
 
 	pf.wg.Wait()
+	if pf.accessLog != nil {
+		pf.accessLog.Close()
+	}
 }
 
 // monitorSSH monitors the SSH process
 func (pf *PortForwarder) monitorSSH() {
 	defer pf.wg.Done()
+	defer close(pf.sshDone)
 
 	// Wait for the SSH command to finish or be stopped
 	select {
@@ -106,91 +498,521 @@ func (pf *PortForwarder) monitorSSH() {
 		return
 	default:
 		// Wait for SSH command to finish
-		if err := pf.sshCmd.Wait(); err != nil {
+		err := pf.sshCmd.Wait()
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Debug: SSH command finished with error: %v\n", err)
 		} else {
 			fmt.Fprintf(os.Stderr, "Debug: SSH command finished successfully\n")
 		}
+
+		// ssh exiting on its own means the tunnel died underneath the user
+		select {
+		case <-pf.stopChan:
+		default:
+			reason := fmt.Sprintf("tunnel %s -> %s:%d failed: ssh exited: %v", pf.LocalAddress(), pf.host.Name, pf.remotePort, err)
+			if failure := pf.sshFailure.Load(); failure != nil {
+				reason += ": " + failure.Hint
+			}
+			logEvent("%s", reason)
+			if path, reportErr := writeCrashReport(reason, nil); reportErr != nil {
+				fmt.Fprintf(os.Stderr, "Debug: Failed to write crash report: %v\n", reportErr)
+			} else {
+				fmt.Fprintf(os.Stderr, "Debug: Crash report written to %s\n", path)
+			}
+		}
 	}
 }
 
-// StartPortForwarding starts port forwarding for a specific port
-func StartPortForwarding(host SSHHost, remotePort int) tea.Cmd {
-	return func() tea.Msg {
-		fmt.Fprintf(os.Stderr, "Debug: Starting port forwarding for %s:%d\n", host.Name, remotePort)
-		
-		// Try to use the same port locally, fallback to random if unavailable
-		localPort, samePort, err := findPreferredLocalPort(remotePort)
+// listen opens the local listeners for the tunnel: the local port and any
+// extra ports on 127.0.0.1, plus [::1] unless IPv6 is disabled, or on the
+// host's BindAddress alone. A tunnel with a local socket listens on it only.
+func (pf *PortForwarder) listen() ([]net.Listener, error) {
+	if pf.options.LocalSocket != "" {
+		listener, err := listenSocket(pf.options.LocalSocket)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Debug: Failed to find available port: %v\n", err)
-			return ErrorMsg{Error: fmt.Errorf("failed to find available local port: %w", err)}
+			return nil, err
 		}
-		if samePort {
-			fmt.Fprintf(os.Stderr, "Debug: Using same port locally: %d\n", localPort)
-		} else {
-			fmt.Fprintf(os.Stderr, "Debug: Port %d unavailable, using alternative: %d\n", remotePort, localPort)
+		return []net.Listener{listener}, nil
+	}
+
+	ports := append([]int{pf.localPort}, pf.options.ExtraLocalPorts...)
+	listeners := make([]net.Listener, 0, len(ports)*2)
+
+	address := "127.0.0.1"
+	if pf.options.BindAddress != "" {
+		address = pf.options.BindAddress
+	}
+	for _, port := range ports {
+		listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(port)))
+		if err != nil {
+			closeListeners(listeners)
+			if hint := listenErrorHint(port, err); hint != "" {
+				return nil, fmt.Errorf("failed to listen on local port %d: %w (%s)", port, err, hint)
+			}
+			return nil, fmt.Errorf("failed to listen on local port %d: %w", port, err)
 		}
+		listeners = append(listeners, listener)
 
-		// Create and start port forwarder using ssh command
-		forwarder := NewPortForwarder(host.Name, localPort, remotePort)
-		if err := forwarder.Start(); err != nil {
-			fmt.Fprintf(os.Stderr, "Debug: Failed to start port forwarder: %v\n", err)
-			return ErrorMsg{Error: fmt.Errorf("failed to start port forwarding: %w", err)}
+		if pf.options.IPv6 && pf.options.BindAddress == "" {
+			// Not every machine has IPv6 loopback, so the IPv4 listener is enough to carry on
+			listener, err := net.Listen("tcp", fmt.Sprintf("[::1]:%d", port))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Debug: Failed to listen on [::1]:%d: %v\n", port, err)
+				continue
+			}
+			listeners = append(listeners, listener)
 		}
-		fmt.Fprintf(os.Stderr, "Debug: Port forwarder started successfully\n")
+	}
 
-		return ForwardingStartedMsg{
-			LocalPort:  localPort,
-			RemotePort: remotePort,
+	return listeners, nil
+}
+
+// listenLAN opens the tunnel's ports on its LAN address, requiring clients
+// to present a certificate issued by the kport CA
+func (pf *PortForwarder) listenLAN() ([]net.Listener, error) {
+	ca, err := LoadOrCreateLocalCA()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kport CA: %w", err)
+	}
+	tlsConfig, err := ca.MutualTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	ports := append([]int{pf.localPort}, pf.options.ExtraLocalPorts...)
+	listeners := make([]net.Listener, 0, len(ports))
+	for _, port := range ports {
+		listener, err := net.Listen("tcp", net.JoinHostPort(pf.options.LANAddress, strconv.Itoa(port)))
+		if err != nil {
+			closeListeners(listeners)
+			return nil, fmt.Errorf("failed to listen on %s port %d: %w", pf.options.LANAddress, port, err)
 		}
+		listeners = append(listeners, tls.NewListener(listener, tlsConfig))
 	}
+	pf.caCertPath = ca.CertPath()
+	return listeners, nil
 }
 
-// StartManualPortForwarding starts port forwarding for a manually entered port
-func StartManualPortForwarding(host SSHHost, portStr string) tea.Cmd {
-	return func() tea.Msg {
-		fmt.Fprintf(os.Stderr, "Debug: Manual port forwarding requested for %s:%s\n", host.Name, portStr)
-		
-		remotePort, err := strconv.Atoi(portStr)
+// closeListeners closes all listeners
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+// ListenAddrs returns the local addresses the tunnel accepts connections on
+func (pf *PortForwarder) ListenAddrs() []string {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	addrs := make([]string, 0, len(pf.listeners))
+	for _, listener := range pf.listeners {
+		addrs = append(addrs, listener.Addr().String())
+	}
+	return addrs
+}
+
+// acceptConnections accepts local connections until the listener is closed
+func (pf *PortForwarder) acceptConnections(listener net.Listener) {
+	defer pf.wg.Done()
+
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Debug: Invalid port number: %s\n", portStr)
-			return ErrorMsg{Error: fmt.Errorf("invalid port number: %s", portStr)}
+			select {
+			case <-pf.stopChan:
+				return
+			default:
+			}
+			// Out of files, the tunnel keeps listening until connections close and free some
+			if tooManyFiles(err) {
+				pf.errors.Record(fmt.Errorf("failed to accept connection, too many open files: raise file_limit in the kport config"))
+				delay = min(max(2*delay, 5*time.Millisecond), time.Second)
+				select {
+				case <-pf.stopChan:
+					return
+				case <-time.After(delay):
+				}
+				continue
+			}
+			pf.errors.Record(fmt.Errorf("failed to accept connection: %w", err))
+			return
+		}
+		delay = 0
+		go pf.handleConnection(conn)
+	}
+}
+
+// handleConnection proxies a local connection through the ssh forwarded port
+func (pf *PortForwarder) handleConnection(client net.Conn) {
+	defer client.Close()
+
+	// Finish the TLS handshake first, so clients without a valid certificate
+	// are turned away before a channel to the remote service is opened. LAN
+	// clients are known by the name of their certificate.
+	var user string
+	if tlsConn, ok := client.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			pf.errors.Record(fmt.Errorf("rejected connection from %s: %w", client.RemoteAddr(), err))
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			user = certs[0].Subject.CommonName
+		}
+	}
+
+	// Each connection is a channel on the ssh client, so wait while the channel limit is reached
+	ok, queued := pf.channels.acquire(pf.stopChan)
+	if !ok {
+		return
+	}
+	defer pf.channels.release()
+
+	// Span attributes are only built when spans are exported, since every
+	// connection would otherwise pay for them
+	span := trace.SpanFromContext(context.Background())
+	var remote net.Conn
+	var err error
+	if tracingEnabled {
+		var ctx context.Context
+		ctx, span = tracer.Start(pf.traceCtx, "kport.tunnel.connection", trace.WithAttributes(
+			attribute.String("kport.client", client.RemoteAddr().String()),
+			attribute.Bool("kport.queued", queued),
+		))
+		_, dialSpan := tracer.Start(ctx, "kport.tunnel.dial")
+		var dest *Destination
+		dest, remote, err = pf.dialDestination()
+		endSpan(dialSpan, err)
+		span.SetAttributes(attribute.String("kport.destination", dest.Address()))
+	} else {
+		_, remote, err = pf.dialDestination()
+	}
+	if err != nil {
+		pf.errors.Record(fmt.Errorf("failed to connect to SSH forward: %w", err))
+		endSpan(span, err)
+		return
+	}
+	defer remote.Close()
+
+	tc := newTrackedConn(client, remote)
+
+	pf.connMu.Lock()
+	pf.conns[tc] = struct{}{}
+	pf.totalConns++
+	var cc *CaptureConn
+	if pf.capture != nil {
+		cc = pf.capture.OpenConn(client.RemoteAddr(), pf.localPort)
+	}
+	pf.connMu.Unlock()
+
+	defer func() {
+		pf.connMu.Lock()
+		delete(pf.conns, tc)
+		pf.doneBytesIn += tc.bytesIn.Load()
+		pf.doneBytesOut += tc.bytesOut.Load()
+		pf.connMu.Unlock()
+
+		if span.IsRecording() {
+			span.SetAttributes(
+				attribute.Int64("kport.bytes_in", tc.bytesIn.Load()),
+				attribute.Int64("kport.bytes_out", tc.bytesOut.Load()),
+			)
 		}
+		span.End()
 
-		if remotePort <= 0 || remotePort > 65535 {
-			fmt.Fprintf(os.Stderr, "Debug: Port number out of range: %d\n", remotePort)
-			return ErrorMsg{Error: fmt.Errorf("port number must be between 1 and 65535")}
+		// ssh accepts the loopback connection before dialing the destination, so a
+		// destination that is down shows up as a connection closed without any data
+		if tc.bytesIn.Load() == 0 && time.Since(tc.started) < quickFailureWindow {
+			pf.requestProbe()
 		}
+	}()
+
+	if cc != nil {
+		defer cc.Close()
+	}
+	var ac *AccessConn
+	if pf.accessLog != nil {
+		ac = pf.accessLog.OpenConn(client.RemoteAddr(), user)
+		defer func() { ac.Close(tc.bytesIn.Load()) }()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		proxyCopy(remote, client, tc, cc, ac, true)
+		closeWrite(remote)
+		close(done)
+	}()
+	proxyCopy(client, remote, tc, cc, ac, false)
+	closeWrite(client)
+	<-done
+}
+
+// dialDestination connects to the active destination, or when that fails to
+// the other healthy destinations in their configured order. It returns the
+// destination it connected to, or the active one when none answered.
+func (pf *PortForwarder) dialDestination() (*Destination, net.Conn, error) {
+	dest := pf.activeDestination()
+	conn, err := pf.dial(dest)
+	if err == nil {
+		return dest, conn, nil
+	}
+
+	pf.requestProbe()
+	for _, other := range pf.healthyDestinations() {
+		if other == dest {
+			continue
+		}
+		if conn, otherErr := pf.dial(other); otherErr == nil {
+			return other, conn, nil
+		}
+	}
+	return dest, nil, err
+}
 
-		// Try to use the same port locally, fallback to random if unavailable
-		localPort, samePort, err := findPreferredLocalPort(remotePort)
+// dial connects to a destination through ssh: ssh's forwarded loopback port, or
+// for containers a relay command run over the ssh master connection
+func (pf *PortForwarder) dial(dest *Destination) (net.Conn, error) {
+	if pf.options.Container == "" {
+		// A TCPAddr skips parsing an address string for every connection
+		conn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: pf.sshPortOf(dest)})
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	return dialExec(sshCommand(pf.host, []string{"-S", pf.controlPath, "-o", "ControlMaster=no"}, pf.relay))
+}
+
+// closeWrite half-closes a connection so the peer sees EOF
+func closeWrite(conn net.Conn) {
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+}
+
+// CycleCapture switches to the next capture mode and returns the new capture, or nil when off
+func (pf *PortForwarder) CycleCapture() (*Capture, error) {
+	pf.connMu.Lock()
+	defer pf.connMu.Unlock()
+
+	mode := CaptureOff
+	if pf.capture != nil {
+		mode = pf.capture.Mode()
+		pf.capture.Close()
+		pf.capture = nil
+	}
+
+	mode = mode.Next()
+	if mode == CaptureOff {
+		return nil, nil
+	}
+
+	capture, err := NewCapture(mode, pf.host.Name, pf.remotePort)
+	if err != nil {
+		return nil, err
+	}
+	pf.capture = capture
+	return capture, nil
+}
+
+// StartedAt returns when the tunnel was opened
+func (pf *PortForwarder) StartedAt() time.Time {
+	return pf.startedAt
+}
+
+// SetLabel names the tunnel and attaches a free-text note to it
+func (pf *PortForwarder) SetLabel(label, note string) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	pf.label, pf.note = label, note
+}
+
+// Label returns the tunnel's label and note, empty when it has none
+func (pf *PortForwarder) Label() (label, note string) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	return pf.label, pf.note
+}
+
+// ExpiresAt returns when the tunnel will be closed, or the zero time if it has no time limit
+func (pf *PortForwarder) ExpiresAt() time.Time {
+	if pf.options.TTL == 0 {
+		return time.Time{}
+	}
+	return pf.startedAt.Add(pf.options.TTL)
+}
+
+// Expired reports whether the tunnel was closed because its time limit was reached
+func (pf *PortForwarder) Expired() bool {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	return pf.expired
+}
+
+// Errors returns the tunnel's recent errors with repeats coalesced
+func (pf *PortForwarder) Errors() []AggregatedError {
+	return pf.errors.Recent()
+}
+
+// diagnoseListeners looks for local firewall and resolver problems that keep
+// clients from reaching the tunnel, so they aren't mistaken for a broken tunnel
+func (pf *PortForwarder) diagnoseListeners(ipv6 bool) {
+	defer pf.wg.Done()
+
+	hints := localListenerHints(pf.localPort, ipv6)
+	for _, hint := range hints {
+		logEvent("local listener check: localhost:%d -> %s:%d: %s", pf.localPort, pf.host.Name, pf.remotePort, hint)
+	}
+
+	// Stopping the tunnel holds pf.mu while it waits for this check
+	pf.hintsMu.Lock()
+	pf.localHints = hints
+	pf.hintsMu.Unlock()
+}
+
+// LocalHints returns guidance for local problems reaching the tunnel's listeners
+func (pf *PortForwarder) LocalHints() []string {
+	pf.hintsMu.Lock()
+	defer pf.hintsMu.Unlock()
+	return pf.localHints
+}
+
+// SSHDone returns a channel closed once the tunnel's ssh process has exited or the tunnel was stopped
+func (pf *PortForwarder) SSHDone() <-chan struct{} {
+	return pf.sshDone
+}
+
+// Host returns the host the tunnel goes through
+func (pf *PortForwarder) Host() SSHHost {
+	return pf.host
+}
+
+// LocalPort returns the main local port of the tunnel
+func (pf *PortForwarder) LocalPort() int {
+	return pf.localPort
+}
+
+// LocalAddress describes where local clients connect: localhost and the
+// local port, or the local socket
+func (pf *PortForwarder) LocalAddress() string {
+	if pf.options.LocalSocket != "" {
+		return pf.options.LocalSocket
+	}
+	return fmt.Sprintf("localhost:%d", pf.localPort)
+}
+
+// LocalSocket returns the Unix socket the tunnel listens on instead of a
+// local port, or ""
+func (pf *PortForwarder) LocalSocket() string {
+	return pf.options.LocalSocket
+}
+
+// RemotePort returns the remote port the tunnel forwards to
+func (pf *PortForwarder) RemotePort() int {
+	return pf.remotePort
+}
+
+// IsRunning reports whether the tunnel is open
+func (pf *PortForwarder) IsRunning() bool {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	return pf.isRunning
+}
+
+// Options returns the options the tunnel was started with
+func (pf *PortForwarder) Options() ForwardOptions {
+	return pf.options
+}
+
+// CACertPath returns the kport CA certificate path when the tunnel serves HTTPS
+func (pf *PortForwarder) CACertPath() string {
+	return pf.caCertPath
+}
+
+// Capture returns the active capture, or nil when traffic is not being captured
+func (pf *PortForwarder) Capture() *Capture {
+	pf.connMu.Lock()
+	defer pf.connMu.Unlock()
+	return pf.capture
+}
+
+// AccessLog returns the tunnel's access log, or nil when requests aren't logged
+func (pf *PortForwarder) AccessLog() *AccessLog {
+	return pf.accessLog
+}
+
+// StartPortForwarding starts port forwarding for a specific port. Failures to
+// reach the host are sent as a ForwardFailedMsg, so the tunnel can be retried.
+func StartPortForwarding(host SSHHost, remotePort int, options ForwardOptions) tea.Cmd {
+	return func() tea.Msg {
+		// host is replaced by the one with minted credentials, which a retry mints again
+		origHost := host
+		fmt.Fprintf(os.Stderr, "Debug: Starting port forwarding for %s:%d\n", host.Name, remotePort)
+		
+		policy := currentPolicy()
+		if err := policyError(policy.tunnelRisks(options.LocalPort, remotePort, options), options.Confirmed); err != nil {
+			return ErrorMsg{Error: err}
+		}
+
+		// Try to use the same port locally, fallback to random if unavailable,
+		// unless the tunnel asks for a port of its own. The port is reserved
+		// so other kport instances don't pick it as well. Tunnels on a local
+		// socket take no port.
+		localPort, samePort := options.LocalPort, options.LocalPort == remotePort
+		var err error
+		if options.LocalSocket != "" {
+			localPort, samePort = 0, false
+		} else if localPort > 0 {
+			err = reserveExactLocalPort(localPort, host.Name, remotePort)
+		} else {
+			localPort, samePort, err = reserveLocalPort(host.Name, remotePort, policy.allowsPrivilegedPort(options))
+		}
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Debug: Failed to find available port: %v\n", err)
 			return ErrorMsg{Error: fmt.Errorf("failed to find available local port: %w", err)}
 		}
+		ctx, span := tracer.Start(context.Background(), "kport.forward", trace.WithAttributes(append(hostAttributes(host),
+			attribute.Int("kport.local_port", localPort),
+			attribute.Int("kport.remote_port", remotePort),
+		)...))
 		if samePort {
 			fmt.Fprintf(os.Stderr, "Debug: Using same port locally: %d\n", localPort)
 		} else {
 			fmt.Fprintf(os.Stderr, "Debug: Port %d unavailable, using alternative: %d\n", remotePort, localPort)
 		}
 
+		// Mint credentials before dialing if the host has a pre-connect hook
+		host, err := tracedPrepareHost(ctx, host)
+		if err != nil {
+			releaseLocalPort(localPort)
+			endSpan(span, err)
+			return ForwardFailedMsg{Host: origHost, RemotePort: remotePort, Options: options, Err: err}
+		}
+
 		// Create and start port forwarder using ssh command
-		forwarder := NewPortForwarder(host.Name, localPort, remotePort)
-		if err := forwarder.Start(); err != nil {
+		forwarder := NewPortForwarder(host, localPort, remotePort, options)
+		if err := forwarder.StartContext(ctx); err != nil {
+			releaseLocalPort(localPort)
+			endSpan(span, err)
 			fmt.Fprintf(os.Stderr, "Debug: Failed to start port forwarder: %v\n", err)
-			return ErrorMsg{Error: fmt.Errorf("failed to start port forwarding: %w", err)}
+			return ForwardFailedMsg{Host: origHost, RemotePort: remotePort, Options: options, Err: fmt.Errorf("failed to start port forwarding: %w", err)}
 		}
 		fmt.Fprintf(os.Stderr, "Debug: Port forwarder started successfully\n")
+		span.End()
 
 		return ForwardingStartedMsg{
-			LocalPort:  localPort,
-			RemotePort: remotePort,
+			LocalPort:    localPort,
+			RemotePort:   remotePort,
+			Forwarder:    forwarder,
+			OtherTunnels: otherReservations(),
 		}
 	}
 }
 
-
-
 // findPreferredLocalPort tries to use the same port as remote, fallback to random
 func findPreferredLocalPort(remotePort int) (localPort int, samePort bool, err error) {
 	// First try to use the same port as the remote port
@@ -207,9 +1029,16 @@ func findPreferredLocalPort(remotePort int) (localPort int, samePort bool, err e
 	return availablePort, false, nil
 }
 
-// isPortAvailable checks if a specific port is available locally
+// isPortAvailable checks if a specific port is available locally. Like the
+// tunnel's listeners it binds loopback only, since binding every interface
+// makes the macOS and Windows firewalls prompt to allow incoming connections.
 func isPortAvailable(port int) bool {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	return isAddressAvailable("127.0.0.1", port)
+}
+
+// isAddressAvailable checks if a local port can be listened on at address
+func isAddressAvailable(address string, port int) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(port)))
 	if err != nil {
 		return false
 	}
@@ -219,7 +1048,7 @@ func isPortAvailable(port int) bool {
 
 // findAvailablePort finds an available local port
 func findAvailablePort() (int, error) {
-	listener, err := net.Listen("tcp", ":0")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		return 0, err
 	}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestMain runs the test binary as the ssh of demo mode when tunnels start it
+func TestMain(m *testing.M) {
+	if isDemoSSH() {
+		os.Exit(runDemoSSH(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+// TestStopLeavesNothingRunning starts and stops tunnels against the demo
+// hosts and checks that no ssh processes or goroutines outlive them
+func TestStopLeavesNothingRunning(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("finding ssh processes needs /proc")
+	}
+	if err := startDemo(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	startStop := func() {
+		result := StartPortForwarding(SSHHost{Name: "web-1"}, 3000, ForwardOptions{})()
+		msg, ok := result.(ForwardingStartedMsg)
+		if !ok {
+			t.Fatalf("failed to start tunnel: %#v", result)
+		}
+		demoRequest(msg.LocalPort)
+		if len(demoSSHProcesses(t)) == 0 {
+			t.Fatal("no ssh process runs while the tunnel is up")
+		}
+		msg.Forwarder.Stop()
+	}
+
+	// The first tunnel starts what the process keeps for later ones, such as
+	// the askpass listener
+	startStop()
+	waitForNothingRunning(t, runtime.NumGoroutine())
+	before := runtime.NumGoroutine()
+	for range 3 {
+		startStop()
+	}
+	waitForNothingRunning(t, before)
+}
+
+// waitForNothingRunning fails the test unless the demo ssh processes exit and
+// the goroutines drop to before within a few seconds
+func waitForNothingRunning(t *testing.T, before int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		processes, goroutines := demoSSHProcesses(t), runtime.NumGoroutine()
+		if len(processes) == 0 && goroutines <= before {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("ssh processes %v and %d goroutines outlived the tunnels, %d ran before:\n%s",
+				processes, goroutines, before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// demoSSHProcesses returns the pids of the demo ssh processes, which run the
+// test binary under the name ssh
+func demoSSHProcesses(t *testing.T) []string {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := filepath.Glob("/proc/[0-9]*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pids := make([]string, 0)
+	for _, dir := range dirs {
+		exe, err := os.Readlink(filepath.Join(dir, "exe"))
+		if err != nil || exe != executable {
+			continue
+		}
+		cmdline, err := os.ReadFile(filepath.Join(dir, "cmdline"))
+		if err == nil && bytes.HasPrefix(cmdline, []byte("ssh\x00")) {
+			pids = append(pids, filepath.Base(dir))
+		}
+	}
+	return pids
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxPortSuggestions limits how many suggestions the manual port view shows
+const maxPortSuggestions = 8
+
+// PortUse records how often and when a port was used on a host
+type PortUse struct {
+	Port     int       `json:"port"`
+	Count    int       `json:"count"`
+	LastUsed time.Time `json:"last_used"`
+
+	// Label and Note were given to the port's tunnel, and are restored when it is forwarded again
+	Label string `json:"label,omitempty"`
+	Note  string `json:"note,omitempty"`
+}
+
+// HostPortHistory holds the port history of a single host
+type HostPortHistory struct {
+	Forwarded []PortUse `json:"forwarded"`
+	Seen      []PortUse `json:"seen"`
+
+	// Detected is the result of the latest port detection, shown while it re-runs
+	Detected   []int     `json:"detected,omitempty"`
+	DetectedAt time.Time `json:"detected_at,omitempty"`
+}
+
+// PortHistory remembers the ports forwarded and detected on each host
+type PortHistory struct {
+	Hosts map[string]*HostPortHistory `json:"hosts"`
+	mu    sync.Mutex
+}
+
+// PortSuggestion is a port suggested in the manual port view
+type PortSuggestion struct {
+	Port   int
+	Reason string
+}
+
+// portHistoryPath returns the path of the port history file
+func portHistoryPath() (string, error) {
+	dir, err := kportCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "port_history.json"), nil
+}
+
+// LoadPortHistory reads the port history, starting empty when there is none
+func LoadPortHistory() *PortHistory {
+	history := &PortHistory{Hosts: make(map[string]*HostPortHistory)}
+
+	path, err := portHistoryPath()
+	if err != nil {
+		return history
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return history
+	}
+	if err := json.Unmarshal(data, history); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to parse port history: %v\n", err)
+	}
+	if history.Hosts == nil {
+		history.Hosts = make(map[string]*HostPortHistory)
+	}
+	return history
+}
+
+// host returns the history of a host, creating it if needed
+func (ph *PortHistory) host(name string) *HostPortHistory {
+	hostHistory, ok := ph.Hosts[name]
+	if !ok {
+		hostHistory = &HostPortHistory{}
+		ph.Hosts[name] = hostHistory
+	}
+	return hostHistory
+}
+
+// recordUse bumps the use of port in uses
+func recordUse(uses []PortUse, port int, now time.Time) []PortUse {
+	for i := range uses {
+		if uses[i].Port == port {
+			uses[i].Count++
+			uses[i].LastUsed = now
+			return uses
+		}
+	}
+	return append(uses, PortUse{Port: port, Count: 1, LastUsed: now})
+}
+
+// RecordForwarded records that a port on host was forwarded
+func (ph *PortHistory) RecordForwarded(hostName string, port int) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	hostHistory := ph.host(hostName)
+	hostHistory.Forwarded = recordUse(hostHistory.Forwarded, port, time.Now())
+}
+
+// LastForwarded returns the port most recently forwarded on host
+func (ph *PortHistory) LastForwarded(hostName string) (int, bool) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	hostHistory, ok := ph.Hosts[hostName]
+	if !ok || len(hostHistory.Forwarded) == 0 {
+		return 0, false
+	}
+	last := hostHistory.Forwarded[0]
+	for _, use := range hostHistory.Forwarded[1:] {
+		if use.LastUsed.After(last.LastUsed) {
+			last = use
+		}
+	}
+	return last.Port, true
+}
+
+// RecentlyForwarded returns up to limit ports forwarded on host, the most recently used first
+func (ph *PortHistory) RecentlyForwarded(hostName string, limit int) []PortUse {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	hostHistory, ok := ph.Hosts[hostName]
+	if !ok {
+		return nil
+	}
+	recent := sortedByRecent(hostHistory.Forwarded)
+	if len(recent) > limit {
+		recent = recent[:limit]
+	}
+	return recent
+}
+
+// RecentHosts returns the names of the hosts used most recently, newest first.
+// A host counts as used when a port was forwarded or detected on it.
+func (ph *PortHistory) RecentHosts(limit int) []string {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	lastUsed := make(map[string]time.Time, len(ph.Hosts))
+	names := make([]string, 0, len(ph.Hosts))
+	for name, hostHistory := range ph.Hosts {
+		used := hostHistory.DetectedAt
+		for _, use := range hostHistory.Forwarded {
+			if use.LastUsed.After(used) {
+				used = use.LastUsed
+			}
+		}
+		if used.IsZero() {
+			continue
+		}
+		lastUsed[name] = used
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return lastUsed[names[i]].After(lastUsed[names[j]])
+	})
+
+	if len(names) > limit {
+		names = names[:limit]
+	}
+	return names
+}
+
+// RecordLabel remembers the label and note of the tunnel of a forwarded port on host
+func (ph *PortHistory) RecordLabel(hostName string, port int, label, note string) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	hostHistory := ph.host(hostName)
+	for i := range hostHistory.Forwarded {
+		if hostHistory.Forwarded[i].Port == port {
+			hostHistory.Forwarded[i].Label = label
+			hostHistory.Forwarded[i].Note = note
+			return
+		}
+	}
+}
+
+// LabelFor returns the label and note last given to the tunnel of port on host
+func (ph *PortHistory) LabelFor(hostName string, port int) (label, note string) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	hostHistory, ok := ph.Hosts[hostName]
+	if !ok {
+		return "", ""
+	}
+	for _, use := range hostHistory.Forwarded {
+		if use.Port == port {
+			return use.Label, use.Note
+		}
+	}
+	return "", ""
+}
+
+// RecordDetected records the ports detected on host as its latest detection result
+func (ph *PortHistory) RecordDetected(hostName string, ports []int) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	hostHistory := ph.host(hostName)
+	now := time.Now()
+	for _, port := range ports {
+		hostHistory.Seen = recordUse(hostHistory.Seen, port, now)
+	}
+	hostHistory.Detected = append([]int{}, ports...)
+	hostHistory.DetectedAt = now
+}
+
+// LastDetected returns the ports found by the latest detection on host and when it ran
+func (ph *PortHistory) LastDetected(hostName string) ([]int, time.Time, bool) {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	hostHistory, ok := ph.Hosts[hostName]
+	if !ok || len(hostHistory.Detected) == 0 {
+		return nil, time.Time{}, false
+	}
+	return append([]int{}, hostHistory.Detected...), hostHistory.DetectedAt, true
+}
+
+// diffPorts returns the ports in current that are not in previous and the ports
+// in previous that are no longer in current
+func diffPorts(previous, current []int) (added map[int]bool, removed []int) {
+	added = make(map[int]bool)
+	for _, port := range current {
+		if !slices.Contains(previous, port) {
+			added[port] = true
+		}
+	}
+	for _, port := range previous {
+		if !slices.Contains(current, port) {
+			removed = append(removed, port)
+		}
+	}
+	return added, removed
+}
+
+// Suggestions returns ports to suggest for host: previously forwarded ports
+// first, then ports seen before that are not among the detected ports.
+// detected is nil when detection has not run.
+func (ph *PortHistory) Suggestions(hostName string, detected []int) []PortSuggestion {
+	ph.mu.Lock()
+	defer ph.mu.Unlock()
+
+	hostHistory, ok := ph.Hosts[hostName]
+	if !ok {
+		return nil
+	}
+
+	suggestions := make([]PortSuggestion, 0)
+	suggested := make(map[int]bool)
+
+	forwarded := sortedByRecent(hostHistory.Forwarded)
+	for _, use := range forwarded {
+		suggested[use.Port] = true
+		reason := fmt.Sprintf("forwarded %d×, last %s ago", use.Count, formatAge(time.Since(use.LastUsed)))
+		if use.Label != "" {
+			reason = use.Label + " · " + reason
+		}
+		suggestions = append(suggestions, PortSuggestion{Port: use.Port, Reason: reason})
+	}
+
+	seen := sortedByRecent(hostHistory.Seen)
+	for _, use := range seen {
+		if suggested[use.Port] || slices.Contains(detected, use.Port) {
+			continue
+		}
+		suggested[use.Port] = true
+		reason := fmt.Sprintf("seen %s ago", formatAge(time.Since(use.LastUsed)))
+		if detected != nil {
+			reason += ", not detected now"
+		}
+		suggestions = append(suggestions, PortSuggestion{Port: use.Port, Reason: reason})
+	}
+
+	if len(suggestions) > maxPortSuggestions {
+		suggestions = suggestions[:maxPortSuggestions]
+	}
+	return suggestions
+}
+
+// sortedByRecent returns a copy of uses with the most recently used first
+func sortedByRecent(uses []PortUse) []PortUse {
+	sorted := append([]PortUse{}, uses...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].LastUsed.After(sorted[j].LastUsed)
+	})
+	return sorted
+}
+
+// Save writes the port history to disk
+func (ph *PortHistory) Save() error {
+	path, err := portHistoryPath()
+	if err != nil {
+		return err
+	}
+
+	ph.mu.Lock()
+	data, err := json.Marshal(ph)
+	ph.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode port history: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write port history: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// SavePortHistory saves the port history in the background
+func SavePortHistory(history *PortHistory) tea.Cmd {
+	return func() tea.Msg {
+		if err := history.Save(); err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to save port history: %v\n", err)
+		}
+		return nil
+	}
+}
+
+// filterSuggestions keeps the suggestions whose port starts with prefix
+func filterSuggestions(suggestions []PortSuggestion, prefix string) []PortSuggestion {
+	filtered := make([]PortSuggestion, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		if strings.HasPrefix(fmt.Sprint(suggestion.Port), prefix) {
+			filtered = append(filtered, suggestion)
+		}
+	}
+	return filtered
+}
+
+// formatAge formats a duration as a short age such as 5m, 3h or 2d
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
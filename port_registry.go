@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// registryLockTimeout bounds how long an instance waits for another to release the registry
+const registryLockTimeout = 2 * time.Second
+
+// registryLockStale is the age after which a registry lock is assumed to be abandoned
+const registryLockStale = 10 * time.Second
+
+// PortReservation records a local port in use by a kport instance
+type PortReservation struct {
+	Port       int       `json:"port"`
+	PID        int       `json:"pid"`
+	Host       string    `json:"host"`
+	RemotePort int       `json:"remote_port"`
+	Since      time.Time `json:"since"`
+}
+
+// portRegistryPath returns the path of a port registry file
+func portRegistryPath(name string) (string, error) {
+	dir, err := kportCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// lockPortRegistry takes the registry lock file and returns a function releasing it
+func lockPortRegistry() (func(), error) {
+	path, err := portRegistryPath("ports.lock")
+	if err != nil {
+		return nil, err
+	}
+	return lockFile(path, "port registry")
+}
+
+// lockFile takes a lock file shared by kport instances and returns a function
+// releasing it. what names the locked resource in errors.
+func lockFile(path, what string) (func(), error) {
+	deadline := time.Now().Add(registryLockTimeout)
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to lock %s: %w", what, err)
+		}
+
+		// Locks are only held for a moment, so an old lock was left by a crash
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > registryLockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for %s lock %s", what, path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// updatePortRegistry runs update on the live reservations while holding the registry lock
+// and saves what it returns. Reservations of processes that exited are dropped.
+func updatePortRegistry(update func([]PortReservation) ([]PortReservation, error)) error {
+	unlock, err := lockPortRegistry()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path, err := portRegistryPath("ports.json")
+	if err != nil {
+		return err
+	}
+
+	var reservations []PortReservation
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &reservations); err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Ignoring unreadable port registry: %v\n", err)
+		}
+	}
+
+	live := make([]PortReservation, 0, len(reservations))
+	for _, reservation := range reservations {
+		if reservation.PID == os.Getpid() || processAlive(reservation.PID) {
+			live = append(live, reservation)
+		}
+	}
+
+	updated, err := update(live)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(updated)
+	if err != nil {
+		return fmt.Errorf("failed to encode port registry: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write port registry: %w", err)
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// isReserved reports whether port is reserved in reservations
+func isReserved(reservations []PortReservation, port int) (PortReservation, bool) {
+	for _, reservation := range reservations {
+		if reservation.Port == port {
+			return reservation, true
+		}
+	}
+	return PortReservation{}, false
+}
+
+// reserveLocalPort picks a local port for a tunnel to host:remotePort, preferring the
+// remote port itself, and records it so other kport instances don't pick it too.
+// A privileged remote port is only preferred when privileged is set.
+func reserveLocalPort(host string, remotePort int, privileged bool) (localPort int, samePort bool, err error) {
+	err = updatePortRegistry(func(reservations []PortReservation) ([]PortReservation, error) {
+		preferred := remotePort >= privilegedPortLimit || privileged
+		if _, reserved := isReserved(reservations, remotePort); preferred && !reserved && isPortAvailable(remotePort) {
+			localPort, samePort = remotePort, true
+		} else {
+			// A free port may still be reserved by an instance that is about to bind it
+			for attempt := 0; attempt < 10 && localPort == 0; attempt++ {
+				port, err := findAvailablePort()
+				if err != nil {
+					return nil, err
+				}
+				if _, reserved := isReserved(reservations, port); !reserved {
+					localPort = port
+				}
+			}
+			if localPort == 0 {
+				return nil, fmt.Errorf("no unreserved local port available")
+			}
+		}
+
+		return append(reservations, newReservation(localPort, host, remotePort)), nil
+	})
+	return localPort, samePort, err
+}
+
+// reserveExactLocalPort reserves a specific local port, failing if another instance holds it
+func reserveExactLocalPort(localPort int, host string, remotePort int) error {
+	return updatePortRegistry(func(reservations []PortReservation) ([]PortReservation, error) {
+		if reservation, reserved := isReserved(reservations, localPort); reserved {
+			return nil, fmt.Errorf("local port %d is used by kport (pid %d) for %s:%d",
+				localPort, reservation.PID, reservation.Host, reservation.RemotePort)
+		}
+		return append(reservations, newReservation(localPort, host, remotePort)), nil
+	})
+}
+
+// newReservation creates a reservation owned by this process
+func newReservation(localPort int, host string, remotePort int) PortReservation {
+	return PortReservation{
+		Port:       localPort,
+		PID:        os.Getpid(),
+		Host:       host,
+		RemotePort: remotePort,
+		Since:      time.Now(),
+	}
+}
+
+// releaseLocalPort removes this process's reservation of a local port
+func releaseLocalPort(localPort int) {
+	err := updatePortRegistry(func(reservations []PortReservation) ([]PortReservation, error) {
+		kept := make([]PortReservation, 0, len(reservations))
+		for _, reservation := range reservations {
+			if reservation.Port != localPort || reservation.PID != os.Getpid() {
+				kept = append(kept, reservation)
+			}
+		}
+		return kept, nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to release local port %d: %v\n", localPort, err)
+	}
+}
+
+// otherReservations returns the ports reserved by other running kport instances
+func otherReservations() []PortReservation {
+	var others []PortReservation
+	err := updatePortRegistry(func(reservations []PortReservation) ([]PortReservation, error) {
+		for _, reservation := range reservations {
+			if reservation.PID != os.Getpid() {
+				others = append(others, reservation)
+			}
+		}
+		return reservations, nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to read port registry: %v\n", err)
+	}
+
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].Port < others[j].Port
+	})
+	return others
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// maxRecentPorts limits how many recently forwarded ports the port list shows
+const maxRecentPorts = 5
+
+// PortSection is a section of the port list, one per source of ports
+type PortSection string
+
+const (
+	SectionConfigured PortSection = "Configured forwards"
+	SectionRecent     PortSection = "Recently used"
+	SectionDetected   PortSection = "Detected now"
+)
+
+// portSections are the sections of the port list in the order they are shown
+var portSections = []PortSection{SectionConfigured, SectionRecent, SectionDetected}
+
+// PortRow is a selectable row of the port list
+type PortRow struct {
+	Section PortSection
+	Port    int
+
+	// Forward is a LocalForward or RemoteForward line of the SSH config, which
+	// ssh opens together with the host's other configured forwards
+	Forward *ConfiguredForward
+
+	// Profile names the profile a configured tunnel comes from, with the
+	// local port or socket and destination it sets
+	Profile     string
+	LocalPort   int
+	LocalSocket string
+	Destination string
+
+	// Use is the history of a recently used port
+	Use PortUse
+}
+
+// portRows returns the rows of the port list: the host's configured forwards
+// and profile tunnels, its recently forwarded ports and the detected ports in
+// the category filtered on. Inside a container only its ports are listed.
+func (m *Model) portRows() []PortRow {
+	rows := make([]PortRow, 0)
+	if m.container == nil {
+		host := m.hosts[m.selectedHost]
+		for i := range host.Forwards {
+			rows = append(rows, PortRow{Section: SectionConfigured, Forward: &host.Forwards[i]})
+		}
+		if m.kportConfig != nil {
+			for _, name := range m.kportConfig.ProfileNames() {
+				// The tunnels of templates aren't known until their parameters are
+				profile := m.kportConfig.Profiles[name]
+				if len(profile.ParamNames()) > 0 {
+					continue
+				}
+				for _, tunnel := range profile.Tunnels {
+					if tunnel.Host == host.Name {
+						rows = append(rows, PortRow{Section: SectionConfigured, Port: tunnel.RemotePort, Profile: name,
+							LocalPort: tunnel.LocalPort, LocalSocket: tunnel.LocalSocket, Destination: tunnel.Destination})
+					}
+				}
+			}
+		}
+		for _, use := range m.portHistory.RecentlyForwarded(host.Name, maxRecentPorts) {
+			rows = append(rows, PortRow{Section: SectionRecent, Port: use.Port, Use: use})
+		}
+	}
+	for _, port := range m.visiblePorts() {
+		rows = append(rows, PortRow{Section: SectionDetected, Port: port})
+	}
+	return rows
+}
+
+// cursorRow returns the row under the cursor
+func (m *Model) cursorRow() (PortRow, bool) {
+	rows := m.portRows()
+	if m.cursor < 0 || m.cursor >= len(rows) {
+		return PortRow{}, false
+	}
+	return rows[m.cursor], true
+}
+
+// rowIndex returns the index of the row listing the same as row, or -1
+func (m *Model) rowIndex(row PortRow) int {
+	return slices.IndexFunc(m.portRows(), func(other PortRow) bool {
+		if (row.Forward == nil) != (other.Forward == nil) || row.Forward != nil && *row.Forward != *other.Forward {
+			return false
+		}
+		return other.Section == row.Section && other.Port == row.Port && other.Profile == row.Profile
+	})
+}
+
+// sectionStart returns the index of the first row of section, or 0 when it is empty
+func (m *Model) sectionStart(section PortSection) int {
+	return max(0, slices.IndexFunc(m.portRows(), func(row PortRow) bool { return row.Section == section }))
+}
+
+// nextSection moves the cursor to the first row of the next section that has
+// any, wrapping around to the first
+func (m *Model) nextSection() {
+	rows := m.portRows()
+	if len(rows) == 0 {
+		return
+	}
+	current := SectionConfigured
+	if m.cursor < len(rows) {
+		current = rows[m.cursor].Section
+	}
+	for i := m.cursor + 1; i < len(rows); i++ {
+		if rows[i].Section != current {
+			m.cursor = i
+			return
+		}
+	}
+	m.cursor = 0
+}
+
+// forwardRow starts forwarding the port of a row, over HTTPS when https is set.
+// Configured forwards are opened by ssh, all of a host's at once.
+func (m *Model) forwardRow(row PortRow, https bool) tea.Cmd {
+	if row.Forward != nil {
+		if https {
+			return nil
+		}
+		return m.toggleConfiguredForwards()
+	}
+
+	options := m.forwardOptions(row.Port)
+	if row.Profile != "" {
+		options.LocalPort = row.LocalPort
+		if row.LocalSocket != "" {
+			path, err := localSocketPath(row.LocalSocket)
+			if err != nil {
+				m.message = fmt.Sprintf("Error: invalid local socket %s: %v", row.LocalSocket, err)
+				return nil
+			}
+			options.LocalSocket = path
+		}
+		if row.Destination != "" {
+			options.Destination, options.RemoteHost = row.Destination, ""
+		}
+	}
+	if https {
+		options.HTTPS = true
+		return m.startForwarding(row.Port, options, "Starting HTTPS port forwarding...")
+	}
+	return m.startForwarding(row.Port, options, "Starting port forwarding...")
+}
+
+// renderPortRow renders a configured or recently used row of the port list
+func (m *Model) renderPortRow(row PortRow, selected bool) string {
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	cursor := " "
+	style := lipgloss.NewStyle()
+	if selected {
+		cursor = ">"
+		style = style.Foreground(lipgloss.Color("#FF75B7"))
+	}
+
+	if row.Forward != nil {
+		line := fmt.Sprintf("%s %s", cursor, style.Render(row.Forward.String()))
+		if row.Forward.Source != "" {
+			line += dimStyle.Render("  from " + row.Forward.Source)
+		}
+		return line
+	}
+
+	category := categorizePort(row.Port, m.processes[row.Port])
+	line := fmt.Sprintf("%s %s  %s", cursor, style.Render(fmt.Sprintf("Port %-5d", row.Port)), category.Style().Render(fmt.Sprintf("%-9s", category)))
+	switch row.Section {
+	case SectionConfigured:
+		details := []string{"profile " + row.Profile}
+		if row.LocalSocket != "" {
+			details = append(details, "on "+row.LocalSocket)
+		} else if row.LocalPort > 0 && row.LocalPort != row.Port {
+			details = append(details, fmt.Sprintf("on localhost:%d", row.LocalPort))
+		}
+		if row.Destination != "" {
+			details = append(details, "to "+row.Destination)
+		}
+		line += "  " + dimStyle.Render(strings.Join(details, ", "))
+	case SectionRecent:
+		if row.Use.Label != "" {
+			line += "  " + lipgloss.NewStyle().Bold(true).Render(row.Use.Label)
+		}
+		line += "  " + dimStyle.Render(fmt.Sprintf("forwarded %d×, last %s ago", row.Use.Count, formatAge(time.Since(row.Use.LastUsed))))
+		if !m.portsRefreshing && !slices.Contains(m.ports, row.Port) {
+			line += lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500")).Render("  not listening now")
+		}
+	}
+	return line
+}
+
+// renderPortSections renders the configured and recently used sections of
+// the port list, which come before the detected ports
+func (m *Model) renderPortSections(rows []PortRow) string {
+	var s strings.Builder
+	host := m.hosts[m.selectedHost]
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	sectionStyle := lipgloss.NewStyle().Bold(true)
+
+	for _, section := range portSections[:2] {
+		if !slices.ContainsFunc(rows, func(row PortRow) bool { return row.Section == section }) {
+			continue
+		}
+		s.WriteString(sectionStyle.Render(string(section)))
+		if section == SectionConfigured && len(host.Forwards) > 0 {
+			s.WriteString("  " + m.renderForwardsStatus())
+		}
+		s.WriteString("\n")
+		for i, row := range rows {
+			if row.Section == section {
+				s.WriteString(m.renderPortRow(row, i == m.cursor) + "\n")
+			}
+		}
+		if status := m.forwardsStatus[host.Name]; section == SectionConfigured && status != "" && status != forwardsStarting {
+			s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500")).Render("⚠️  " + status))
+			s.WriteString("\n")
+		}
+		s.WriteString("\n")
+	}
+
+	s.WriteString(sectionStyle.Render(string(SectionDetected)))
+	if len(rows) > 0 && rows[0].Section != SectionDetected {
+		s.WriteString(dimStyle.Render(fmt.Sprintf("  %s jumps between sections", m.keys.Label(ActionNextSection))))
+	}
+	s.WriteString("\n")
+	return s.String()
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// portDiffDuration is how long added and removed ports stay highlighted after a refresh
+const portDiffDuration = 5 * time.Second
+
+// portWatchMsg asks for the port list of a host to be detected again
+type portWatchMsg struct {
+	Host       string
+	Generation int
+}
+
+// portDiffExpiredMsg ends the highlighting of the refresh made at At
+type portDiffExpiredMsg struct {
+	At time.Time
+}
+
+// watchPorts re-detects the selected host's ports after its watch interval,
+// replacing any earlier schedule. A zero interval turns the watcher off.
+func (m *Model) watchPorts() tea.Cmd {
+	m.portWatchGen++
+	interval := *m.selectedHostConfig().WatchInterval
+	if interval <= 0 {
+		return nil
+	}
+	msg := portWatchMsg{Host: m.hosts[m.selectedHost].Name, Generation: m.portWatchGen}
+	return tea.Tick(interval, func(time.Time) tea.Msg { return msg })
+}
+
+// updatePortWatch refreshes the port list when the watcher is due. While
+// another view is shown the watcher waits for the port list to come back.
+func (m *Model) updatePortWatch(msg portWatchMsg) (tea.Model, tea.Cmd) {
+	if msg.Generation != m.portWatchGen || msg.Host != m.hostNameAt(m.selectedHost) {
+		return m, nil
+	}
+	if m.state != StateSelectPort || m.container != nil || m.portsRefreshing {
+		return m, m.watchPorts()
+	}
+	return m, m.refreshPorts()
+}
+
+// refreshPorts detects the selected host's ports again, keeping the list shown meanwhile
+func (m *Model) refreshPorts() tea.Cmd {
+	if m.portsRefreshing {
+		return nil
+	}
+	m.portsRefreshing = true
+	return DetectPorts(m.hosts[m.selectedHost])
+}
+
+// diffPortList highlights what changed between the shown port list and
+// detected for a few seconds. Removed ports keep their process, so they can
+// still be shown in place.
+func (m *Model) diffPortList(detected PortsDetectedMsg) tea.Cmd {
+	m.newPorts, m.removedPorts = diffPorts(m.ports, detected.Ports)
+	if len(m.newPorts) == 0 && len(m.removedPorts) == 0 {
+		return nil
+	}
+	for _, port := range m.removedPorts {
+		if process, ok := m.processes[port]; ok && detected.Processes != nil {
+			detected.Processes[port] = process
+		}
+	}
+
+	at := time.Now()
+	m.portDiffAt = at
+	return tea.Tick(portDiffDuration, func(time.Time) tea.Msg { return portDiffExpiredMsg{At: at} })
+}
+
+// updatePortDiffExpired ends highlighting unless a later refresh started its own
+func (m *Model) updatePortDiffExpired(msg portDiffExpiredMsg) (tea.Model, tea.Cmd) {
+	if msg.At.Equal(m.portDiffAt) {
+		m.newPorts, m.removedPorts = nil, nil
+	}
+	return m, nil
+}
+
+// portListRows merges the visible ports with the removed ones that match the
+// filter, in port order. Removed ports aren't selectable, so the cursor only
+// counts listed ones.
+func (m *Model) portListRows() []int {
+	rows := slices.Clone(m.visiblePorts())
+	for _, port := range m.removedPorts {
+		if m.portFilter == "" || m.portCategory(port) == m.portFilter {
+			rows = append(rows, port)
+		}
+	}
+	slices.Sort(rows)
+	return rows
+}
+
+// renderRemovedPort renders a port that the latest refresh no longer found, struck through
+func (m *Model) renderRemovedPort(port int) string {
+	removedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87")).Strikethrough(true)
+	line := fmt.Sprintf("  %s  %-9s", removedStyle.Render(fmt.Sprintf("Port %-5d", port)), "")
+	if process := m.processes[port]; process != "" {
+		line += "  " + removedStyle.Render(process)
+	}
+	return line + lipgloss.NewStyle().Foreground(lipgloss.Color("#666666")).Render("  gone")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+)
+
+// startPprof serves Go's pprof profiles on addr, which must be a loopback
+// address since profiles expose the process's memory. It returns a function
+// that stops the server.
+func startPprof(addr string) (func(), error) {
+	listener, err := listenLoopback(addr, "pprof")
+	if err != nil {
+		return nil, err
+	}
+
+	// A private mux keeps the handlers off http.DefaultServeMux
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "Debug: pprof server stopped: %v\n", err)
+		}
+	}()
+
+	return func() { server.Close() }, nil
+}
+
+// listenLoopback listens on addr for what, refusing addresses other than loopback ones
+func listenLoopback(addr, what string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s address %q: %w", what, addr, err)
+	}
+	if !isLoopbackHost(host) {
+		return nil, fmt.Errorf("%s address %q must be a loopback address", what, addr)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for %s on %s: %w", what, addr, err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultPrewarmConcurrency is how many pinned hosts are connected to at once
+const defaultPrewarmConcurrency = 3
+
+// prewarmReadyTimeout is how long a prewarmed connection may take to come up
+const prewarmReadyTimeout = 20 * time.Second
+
+// PrewarmConfig configures connecting to pinned hosts in the background at startup
+type PrewarmConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Concurrency caps the handshakes running at once, defaulting to 3
+	Concurrency int `yaml:"concurrency"`
+
+	// Persist keeps the connections open this long after kport exits, so the
+	// next kport reattaches to them instead of authenticating again
+	Persist time.Duration `yaml:"persist"`
+}
+
+// HostPrewarmedMsg is sent when a prewarmed connection to a host is up or has failed
+type HostPrewarmedMsg struct {
+	Host string
+	Err  error
+}
+
+// prewarmedMaster is a background ssh master connection other ssh commands reuse
+type prewarmedMaster struct {
+	cmd   *exec.Cmd
+	host  SSHHost
+	path  string
+	ready bool
+
+	// persistent masters run detached from kport and outlive it
+	persistent bool
+}
+
+// Prewarmer keeps ssh master connections open to pinned hosts, so commands such
+// as port detection skip the handshake
+type Prewarmer struct {
+	mu      sync.Mutex
+	ctx     context.Context
+	cancel  context.CancelFunc
+	slots   chan struct{}
+	persist time.Duration
+	masters map[string]*prewarmedMaster
+}
+
+// prewarmed is the running prewarmer, consulted by sshCommand
+var (
+	prewarmedMu sync.Mutex
+	prewarmed   *Prewarmer
+)
+
+// NewPrewarmer creates a prewarmer from the prewarm settings
+func NewPrewarmer(config PrewarmConfig) *Prewarmer {
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultPrewarmConcurrency
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	pw := &Prewarmer{
+		ctx:     ctx,
+		cancel:  cancel,
+		slots:   make(chan struct{}, concurrency),
+		persist: config.Persist,
+		masters: make(map[string]*prewarmedMaster),
+	}
+
+	prewarmedMu.Lock()
+	prewarmed = pw
+	prewarmedMu.Unlock()
+	return pw
+}
+
+// prewarmKey identifies a host and the user it is connected as
+func prewarmKey(host SSHHost) string {
+	return host.Name + "\x00" + host.UserOverride
+}
+
+// Sync prewarms the given hosts and closes the connections to any others.
+// It returns the commands that report each new connection.
+func (pw *Prewarmer) Sync(hosts []SSHHost) []tea.Cmd {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	keys := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		keys = append(keys, prewarmKey(host))
+	}
+	for key, master := range pw.masters {
+		if !slices.Contains(keys, key) {
+			master.stop()
+			delete(pw.masters, key)
+		}
+	}
+
+	cmds := make([]tea.Cmd, 0)
+	for _, host := range hosts {
+		// Pre-connect hooks may prompt for SSO, which can't happen unattended
+		if host.PreConnect != "" {
+			continue
+		}
+		key := prewarmKey(host)
+		if _, ok := pw.masters[key]; ok {
+			continue
+		}
+		master := &prewarmedMaster{host: host}
+		pw.masters[key] = master
+		cmds = append(cmds, pw.start(host, master))
+	}
+	return cmds
+}
+
+// start connects the master for host once a slot is free
+func (pw *Prewarmer) start(host SSHHost, master *prewarmedMaster) tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case pw.slots <- struct{}{}:
+		case <-pw.ctx.Done():
+			return HostPrewarmedMsg{Host: host.Name, Err: pw.ctx.Err()}
+		}
+		defer func() { <-pw.slots }()
+
+		_, span := tracer.Start(pw.ctx, "kport.prewarm", trace.WithAttributes(hostAttributes(host)...))
+		err := pw.connect(host, master)
+		endSpan(span, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Prewarming %s failed: %v\n", host.Name, err)
+		}
+		return HostPrewarmedMsg{Host: host.Name, Err: err}
+	}
+}
+
+// connect starts an ssh master for host and waits for its control socket.
+// A persistent master left by an earlier kport is reattached to instead.
+func (pw *Prewarmer) connect(host SSHHost, master *prewarmedMaster) error {
+	dir, err := kportCacheDir("prewarm")
+	if err != nil {
+		return err
+	}
+	// Unix socket paths are short, so the socket is named by a hash. Persistent
+	// sockets are named by the host alone, so the next kport finds them.
+	persistent := pw.persist > 0
+	hash := fnv.New64a()
+	if persistent {
+		fmt.Fprintf(hash, "persist\x00%s", prewarmKey(host))
+	} else {
+		fmt.Fprintf(hash, "%d\x00%s", os.Getpid(), prewarmKey(host))
+	}
+	path := filepath.Join(dir, fmt.Sprintf("%x.sock", hash.Sum64()))
+
+	if persistent && masterAlive(host, path) {
+		pw.mu.Lock()
+		defer pw.mu.Unlock()
+		if pw.ctx.Err() != nil || pw.masters[prewarmKey(host)] != master {
+			return context.Canceled
+		}
+		master.path = path
+		master.persistent = true
+		master.ready = true
+		logEvent("reattached to the prewarmed connection to %s", host.Name)
+		return nil
+	}
+	os.Remove(path)
+
+	// The master connects and authenticates for every command over it, so
+	// it uses the host's candidate address and picked agent key
+	if host, err = resolveConnection(host); err != nil {
+		return err
+	}
+
+	// A persistent master forks into the background once it is connected
+	controlPersist := "no"
+	if persistent {
+		controlPersist = fmt.Sprint(int(pw.persist.Seconds()))
+	}
+	cmd := sshCommand(host, append([]string{"-M", "-S", path, "-N", "-o", "ControlPersist=" + controlPersist}, host.probeOptions(10)...))
+
+	pw.mu.Lock()
+	if pw.ctx.Err() != nil || pw.masters[prewarmKey(host)] != master {
+		pw.mu.Unlock()
+		return context.Canceled
+	}
+	if err := cmd.Start(); err != nil {
+		pw.mu.Unlock()
+		return fmt.Errorf("failed to start ssh: %w", err)
+	}
+	master.cmd = cmd
+	master.path = path
+	master.persistent = persistent
+	pw.mu.Unlock()
+
+	exited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		exited <- err
+		if persistent && err == nil {
+			return
+		}
+		pw.mu.Lock()
+		master.ready = false
+		pw.mu.Unlock()
+		os.Remove(path)
+	}()
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(prewarmReadyTimeout)
+	for {
+		select {
+		case err := <-exited:
+			if persistent && err == nil && masterAlive(host, path) {
+				pw.mu.Lock()
+				master.ready = true
+				pw.mu.Unlock()
+				logEvent("prewarmed connection to %s", host.Name)
+				return nil
+			}
+			return fmt.Errorf("ssh exited before connecting: %v", err)
+		case <-timeout:
+			pw.mu.Lock()
+			master.stop()
+			pw.mu.Unlock()
+			return fmt.Errorf("timed out connecting")
+		case <-pw.ctx.Done():
+			return pw.ctx.Err()
+		case <-ticker.C:
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			pw.mu.Lock()
+			master.ready = true
+			pw.mu.Unlock()
+			logEvent("prewarmed connection to %s", host.Name)
+			return nil
+		}
+	}
+}
+
+// Ready reports whether a prewarmed connection to host is up
+func (pw *Prewarmer) Ready(host SSHHost) bool {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	master, ok := pw.masters[prewarmKey(host)]
+	return ok && master.ready
+}
+
+// Stop cancels pending handshakes and closes every prewarmed connection
+func (pw *Prewarmer) Stop() {
+	pw.release(true)
+}
+
+// Detach is Stop for when kport exits: persistent connections that are up
+// are left open for the next kport
+func (pw *Prewarmer) Detach() {
+	pw.release(false)
+}
+
+// release cancels pending handshakes and closes the prewarmed connections,
+// the persistent ones only when closePersistent is set
+func (pw *Prewarmer) release(closePersistent bool) {
+	pw.cancel()
+
+	pw.mu.Lock()
+	for key, master := range pw.masters {
+		if closePersistent || !master.persistent || !master.ready {
+			master.stop()
+		}
+		delete(pw.masters, key)
+	}
+	pw.mu.Unlock()
+
+	prewarmedMu.Lock()
+	if prewarmed == pw {
+		prewarmed = nil
+	}
+	prewarmedMu.Unlock()
+}
+
+// stop kills the master's ssh process, which removes its control socket. A
+// persistent master that is up runs detached, so it is asked to exit instead.
+func (pm *prewarmedMaster) stop() {
+	ready := pm.ready
+	pm.ready = false
+	if pm.persistent && ready {
+		exitMaster(pm.host, pm.path)
+		return
+	}
+	if pm.cmd != nil && pm.cmd.Process != nil {
+		pm.cmd.Process.Kill()
+	}
+}
+
+// masterAlive reports whether an ssh master answers on the control socket at path
+func masterAlive(host SSHHost, path string) bool {
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	return sshCommand(host, []string{"-S", path, "-O", "check"}).Run() == nil
+}
+
+// exitMaster asks the ssh master on the control socket at path to close its connection
+func exitMaster(host SSHHost, path string) {
+	if err := sshCommand(host, []string{"-S", path, "-O", "exit"}).Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Closing the persistent connection to %s failed: %v\n", host.Name, err)
+	}
+}
+
+// prewarmedControlPath returns the control socket of a ready prewarmed connection to host, if any
+func prewarmedControlPath(host SSHHost) string {
+	prewarmedMu.Lock()
+	pw := prewarmed
+	prewarmedMu.Unlock()
+	if pw == nil {
+		return ""
+	}
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	master, ok := pw.masters[prewarmKey(host)]
+	if !ok || !master.ready {
+		return ""
+	}
+	return master.path
+}
+
+// multiplexable reports whether an ssh command with options can run over a
+// prewarmed connection. Tunnels and masters manage their own connection.
+func multiplexable(options []string) bool {
+	for _, option := range options {
+		switch option {
+		case "-L", "-R", "-D", "-M", "-S", "-N", "-W", "-O":
+			return false
+		}
+	}
+	return true
+}
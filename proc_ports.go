@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procListenersScript prints "local_address process" for each listening TCP
+// socket whose owner the remote user can see, by matching the socket inodes
+// of /proc/net/tcp and tcp6 against the fds of every readable process. It
+// needs no privileges, so it recovers the processes of the remote user's own
+// services where ss -p and netstat -p show none.
+const procListenersScript = `cat /proc/net/tcp /proc/net/tcp6 2>/dev/null | awk '$4 == "0A" { listening[$10] = $2 } END {
+  cmd = "ls -l /proc/[0-9]*/fd 2>/dev/null"
+  while ((cmd | getline line) > 0) {
+    if (line ~ /^\/proc\//) { split(line, a, "/"); pid = a[3]; continue }
+    if (!match(line, /socket:\[[0-9]+\]/)) continue
+    inode = substr(line, RSTART + 8, RLENGTH - 9)
+    if (!(inode in listening) || (inode in seen)) continue
+    seen[inode] = 1
+    name = ""; file = "/proc/" pid "/comm"
+    getline name < file; close(file)
+    if (name != "") print listening[inode], name
+  }
+}'`
+
+// procProcessNames recovers the processes listening on the remote host's
+// ports from /proc when the port detection commands can't show them
+func procProcessNames(ctx context.Context, host SSHHost) (map[int]string, error) {
+	output, err := tracedOutput(ctx, "proc", sshCommand(host, host.probeOptions(10), procListenersScript))
+	if err != nil {
+		return nil, err
+	}
+	return parseProcListeners(string(output)), nil
+}
+
+// parseProcListeners reads procListenersScript output, where addresses are
+// hex as in /proc/net/tcp, such as 0100007F:1F90 for 127.0.0.1:8080
+func parseProcListeners(output string) map[int]string {
+	processes := make(map[int]string)
+	for _, line := range strings.Split(output, "\n") {
+		address, name, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		i := strings.LastIndex(address, ":")
+		if i < 0 {
+			continue
+		}
+		port, err := strconv.ParseUint(address[i+1:], 16, 16)
+		if err != nil || port == 0 {
+			continue
+		}
+		// A port bound on several addresses keeps the first process found
+		if _, ok := processes[int(port)]; !ok {
+			processes[int(port)] = strings.TrimSpace(name)
+		}
+	}
+	return processes
+}
+
+// fillProcProcesses adds the processes /proc reveals to ports that have none,
+// logging rather than failing when the host has no /proc
+func fillProcProcesses(ctx context.Context, host SSHHost, ports []int, processes map[int]string) {
+	found, err := procProcessNames(ctx, host)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to read processes from /proc on %s: %v\n", host.Name, err)
+		return
+	}
+	for _, port := range ports {
+		if name, ok := found[port]; ok && processes[port] == "" {
+			processes[port] = name
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Debug: Found %d processes in /proc on %s\n", len(found), host.Name)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultSharedProfilesPath is the profiles file looked up in a shared git repo
+const defaultSharedProfilesPath = "kport-profiles.yaml"
+
+// profileFetchTimeout bounds how long pulling a profile source may take
+const profileFetchTimeout = 60 * time.Second
+
+// ProfileSource is a shared location team profiles are pulled from
+type ProfileSource struct {
+	// Name identifies the source in provenance and names its local copy
+	Name string `yaml:"name"`
+
+	// Git is a repository URL holding a profiles file
+	Git string `yaml:"git"`
+
+	// Ref is the branch or tag to pull, defaulting to the remote's default branch
+	Ref string `yaml:"ref"`
+
+	// Path is the profiles file inside the repository
+	Path string `yaml:"path"`
+
+	// URL is an http(s) URL serving a profiles file directly
+	URL string `yaml:"url"`
+
+	// AllowHooks runs the hooks and prepare commands of the source's profiles,
+	// which are ignored otherwise since anyone who can push to the source
+	// could run commands on this machine
+	AllowHooks bool `yaml:"allow_hooks"`
+}
+
+// sharedProfiles is the format of a shared profiles file
+type sharedProfiles struct {
+	Profiles map[string]ProfileConfig `yaml:"profiles"`
+}
+
+// ProfileStore keeps a local, read-only copy of a profile source
+type ProfileStore interface {
+	// Pull refreshes the local copy from the source
+	Pull() error
+
+	// Load reads the local copy and returns it with a description of its revision
+	Load() ([]byte, string, error)
+}
+
+// Store returns the store backing the source
+func (ps ProfileSource) Store() (ProfileStore, error) {
+	if ps.Name == "" || strings.ContainsAny(ps.Name, `/\`) || ps.Name == "." || ps.Name == ".." {
+		return nil, fmt.Errorf("profile source needs a name without slashes, got %q", ps.Name)
+	}
+
+	switch {
+	case ps.Git != "" && ps.URL != "":
+		return nil, fmt.Errorf("profile source %s sets both git and url", ps.Name)
+	case ps.Git != "":
+		dir, err := kportCacheDir("profiles", ps.Name)
+		if err != nil {
+			return nil, err
+		}
+		path := ps.Path
+		if path == "" {
+			path = defaultSharedProfilesPath
+		}
+		return &gitProfileStore{repo: ps.Git, ref: ps.Ref, dir: dir, path: path}, nil
+	case ps.URL != "":
+		dir, err := kportCacheDir("profiles")
+		if err != nil {
+			return nil, err
+		}
+		return &urlProfileStore{url: ps.URL, path: filepath.Join(dir, ps.Name+".yaml")}, nil
+	default:
+		return nil, fmt.Errorf("profile source %s needs a git repository or a url", ps.Name)
+	}
+}
+
+// gitProfileStore keeps a shallow clone of a git repository
+type gitProfileStore struct {
+	repo string
+	ref  string
+	dir  string
+	path string
+}
+
+// Pull clones the repository, or fetches it and resets to the fetched revision.
+// The clone is a cache, so local edits in it are discarded.
+func (gs *gitProfileStore) Pull() error {
+	if _, err := os.Stat(filepath.Join(gs.dir, ".git")); errors.Is(err, os.ErrNotExist) {
+		args := []string{"clone", "--depth", "1"}
+		if gs.ref != "" {
+			args = append(args, "--branch", gs.ref)
+		}
+		return runGit(append(args, gs.repo, gs.dir)...)
+	}
+
+	ref := gs.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if err := runGit("-C", gs.dir, "fetch", "--depth", "1", "origin", ref); err != nil {
+		return err
+	}
+	return runGit("-C", gs.dir, "reset", "--hard", "FETCH_HEAD")
+}
+
+// Load reads the profiles file from the clone
+func (gs *gitProfileStore) Load() ([]byte, string, error) {
+	data, err := os.ReadFile(filepath.Join(gs.dir, gs.path))
+	if err != nil {
+		return nil, "", err
+	}
+
+	revision, err := exec.Command("git", "-C", gs.dir, "rev-parse", "--short", "HEAD").Output()
+	if err != nil {
+		return data, "", nil
+	}
+	return data, strings.TrimSpace(string(revision)), nil
+}
+
+// runGit runs git, including its output in the error when it fails
+func runGit(args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	timer := time.AfterFunc(profileFetchTimeout, func() {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+	})
+	defer timer.Stop()
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// urlProfileStore keeps a downloaded copy of a profiles file
+type urlProfileStore struct {
+	url  string
+	path string
+}
+
+// Pull downloads the profiles file
+func (us *urlProfileStore) Pull() error {
+	client := &http.Client{Timeout: profileFetchTimeout}
+	resp, err := client.Get(us.url)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", us.url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", us.url, resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", us.url, err)
+	}
+
+	// Refuse files that don't parse so a bad upload doesn't replace a working copy
+	if err := yaml.Unmarshal(data, &sharedProfiles{}); err != nil {
+		return fmt.Errorf("invalid profiles file at %s: %w", us.url, err)
+	}
+
+	tmpPath := us.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save profiles: %w", err)
+	}
+	return os.Rename(tmpPath, us.path)
+}
+
+// Load reads the downloaded copy
+func (us *urlProfileStore) Load() ([]byte, string, error) {
+	info, err := os.Stat(us.path)
+	if err != nil {
+		return nil, "", err
+	}
+	data, err := os.ReadFile(us.path)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, "fetched " + info.ModTime().Format("2006-01-02 15:04"), nil
+}
+
+// mergeSharedProfiles adds the profiles of every pulled source. Local profiles
+// override shared ones of the same name, and earlier sources win over later ones.
+func (kc *KportConfig) mergeSharedProfiles() {
+	if kc.Profiles == nil {
+		kc.Profiles = make(map[string]ProfileConfig)
+	}
+	for name, profile := range kc.Profiles {
+		profile.Source = "local"
+		kc.Profiles[name] = profile
+	}
+
+	for _, source := range kc.ProfileSources {
+		store, err := source.Store()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Skipping profile source: %v\n", err)
+			continue
+		}
+		data, revision, err := store.Load()
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Debug: Profile source %s has not been pulled, run `kport profiles pull`\n", source.Name)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to read profile source %s: %v\n", source.Name, err)
+			continue
+		}
+
+		if lintErrs, err := lintYAML(data, sharedProfilesSchema()); err == nil && len(lintErrs) > 0 {
+			fmt.Fprintf(os.Stderr, "Debug: Skipping invalid profile source %s, see `kport lint`: %v\n", source.Name, &ConfigLintError{Path: source.Name, Errors: lintErrs})
+			continue
+		}
+		var shared sharedProfiles
+		if err := yaml.Unmarshal(data, &shared); err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to parse profile source %s: %v\n", source.Name, err)
+			continue
+		}
+
+		provenance := source.Name
+		if revision != "" {
+			provenance = fmt.Sprintf("%s (%s)", source.Name, revision)
+		}
+		for name, profile := range shared.Profiles {
+			if existing, exists := kc.Profiles[name]; exists {
+				if existing.Source == "local" && existing.Overrides == "" {
+					existing.Overrides = provenance
+					kc.Profiles[name] = existing
+				}
+				continue
+			}
+			profile.Source = provenance
+			profile.ignoreCommands = !source.AllowHooks
+			kc.Profiles[name] = profile
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// profileVarPattern matches ${param} and ${param.field} in a profile template
+var profileVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_-]*)(?:\.([A-Za-z_][A-Za-z0-9_-]*))?\}`)
+
+// UnmarshalYAML keeps the tunnels of a profile with parameters as a template,
+// since values such as ${env.port} only become ports once the profile is instantiated
+func (p *ProfileConfig) UnmarshalYAML(value *yaml.Node) error {
+	var raw struct {
+		Tunnels yaml.Node                               `yaml:"tunnels"`
+		Params  map[string]map[string]map[string]string `yaml:"params"`
+		Hooks   Hooks                                   `yaml:"hooks"`
+
+		ExitOnForwardFailure *bool `yaml:"exit_on_forward_failure"`
+	}
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+
+	p.Hooks = raw.Hooks
+	p.Params = raw.Params
+	p.ExitOnForwardFailure = raw.ExitOnForwardFailure
+	if len(p.Params) > 0 {
+		p.template = raw.Tunnels
+		return nil
+	}
+	if raw.Tunnels.Kind == 0 {
+		return nil
+	}
+	return raw.Tunnels.Decode(&p.Tunnels)
+}
+
+// ParamNames returns the names of the profile's parameters in sorted order
+func (p ProfileConfig) ParamNames() []string {
+	names := make([]string, 0, len(p.Params))
+	for name := range p.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Choices returns the values a parameter can take in sorted order, or nil when any value goes
+func (p ProfileConfig) Choices(param string) []string {
+	choices := make([]string, 0, len(p.Params[param]))
+	for choice := range p.Params[param] {
+		choices = append(choices, choice)
+	}
+	sort.Strings(choices)
+	if len(choices) == 0 {
+		return nil
+	}
+	return choices
+}
+
+// Instantiate fills in the profile's parameters with args and returns the resulting tunnels
+func (p ProfileConfig) Instantiate(args map[string]string) (ProfileConfig, error) {
+	for name := range args {
+		if _, ok := p.Params[name]; !ok {
+			if len(p.Params) == 0 {
+				return ProfileConfig{}, fmt.Errorf("profile takes no parameters, got %s", name)
+			}
+			return ProfileConfig{}, fmt.Errorf("unknown parameter %s, the profile takes %s", name, strings.Join(p.ParamNames(), ", "))
+		}
+	}
+	if len(p.Params) == 0 {
+		return p, nil
+	}
+
+	for _, name := range p.ParamNames() {
+		value, ok := args[name]
+		if !ok {
+			return ProfileConfig{}, fmt.Errorf("missing parameter %s", name)
+		}
+		if choices := p.Choices(name); choices != nil && !slices.Contains(choices, value) {
+			return ProfileConfig{}, fmt.Errorf("invalid %s %q, choose one of %s", name, value, strings.Join(choices, ", "))
+		}
+	}
+
+	template := cloneNode(&p.template)
+	if err := p.substitute(template, args); err != nil {
+		return ProfileConfig{}, err
+	}
+
+	instance := p
+	instance.Tunnels = nil
+	if err := template.Decode(&instance.Tunnels); err != nil {
+		return ProfileConfig{}, fmt.Errorf("invalid tunnels after filling in parameters: %w", err)
+	}
+	return instance, nil
+}
+
+// substitute replaces the variables in every scalar of node
+func (p ProfileConfig) substitute(node *yaml.Node, args map[string]string) error {
+	if node.Kind == yaml.ScalarNode {
+		var err error
+		value := profileVarPattern.ReplaceAllStringFunc(node.Value, func(match string) string {
+			groups := profileVarPattern.FindStringSubmatch(match)
+			param, field := groups[1], groups[2]
+			value, ok := args[param]
+			if !ok {
+				err = fmt.Errorf("unknown parameter %s in %s", param, match)
+				return match
+			}
+			if field == "" {
+				return value
+			}
+			fieldValue, ok := p.Params[param][value][field]
+			if !ok {
+				err = fmt.Errorf("%s %q doesn't set %s", param, value, field)
+				return match
+			}
+			return fieldValue
+		})
+		if err != nil {
+			return err
+		}
+		if value != node.Value {
+			// Let the filled-in value resolve on its own, so a port becomes an int again
+			node.Value, node.Tag, node.Style = value, "", 0
+		}
+		return nil
+	}
+
+	for _, child := range node.Content {
+		if err := p.substitute(child, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloneNode deep-copies a YAML node so a template can be filled in more than once
+func cloneNode(node *yaml.Node) *yaml.Node {
+	clone := *node
+	clone.Content = make([]*yaml.Node, len(node.Content))
+	for i, child := range node.Content {
+		clone.Content[i] = cloneNode(child)
+	}
+	return &clone
+}
+
+// profileInstanceName names an instance of a profile by its arguments, such as db-tunnel(env=staging)
+func profileInstanceName(name string, args map[string]string) string {
+	if len(args) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(args))
+	for key := range args {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+args[key])
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(pairs, ","))
+}
+
+// isProfileInstance reports whether a running profile is the named profile or one of its instances
+func isProfileInstance(running, name string) bool {
+	return running == name || strings.HasPrefix(running, name+"(")
+}
+
+// parseProfileArgs splits command line arguments into profile names and key=value parameters
+func parseProfileArgs(args []string) (profiles []string, params map[string]string) {
+	params = make(map[string]string)
+	for _, arg := range args {
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			params[key] = value
+			continue
+		}
+		profiles = append(profiles, arg)
+	}
+	return profiles, params
+}
+
+// promptProfileParams asks for the parameters a profile needs that weren't
+// given on the command line, when stdin is a terminal
+func promptProfileParams(profile ProfileConfig, params map[string]string) (map[string]string, error) {
+	missing := make([]string, 0)
+	for _, name := range profile.ParamNames() {
+		if _, ok := params[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return params, nil
+	}
+
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return params, nil
+	}
+
+	filled := make(map[string]string, len(params))
+	for key, value := range params {
+		filled[key] = value
+	}
+	in := bufio.NewReader(os.Stdin)
+	for _, name := range missing {
+		question := name
+		if choices := profile.Choices(name); choices != nil {
+			question = fmt.Sprintf("%s (%s)", name, strings.Join(choices, ", "))
+		}
+		fmt.Printf("%s: ", question)
+		line, err := in.ReadString('\n')
+		if err != nil && line == "" {
+			return nil, fmt.Errorf("no value given for %s", name)
+		}
+		filled[name] = strings.TrimSpace(line)
+	}
+	return filled, nil
+}
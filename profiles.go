@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ProfileConfig is a named set of tunnels brought up together
+type ProfileConfig struct {
+	Tunnels []TunnelConfig `yaml:"tunnels"`
+
+	// Hooks are the hooks of every tunnel of the profile that doesn't set its own
+	Hooks Hooks `yaml:"hooks"`
+
+	// Params makes the profile a template. Each parameter maps its values to
+	// fields the tunnels refer to as ${param.field}, or to nothing when any
+	// value goes and it is used as ${param}.
+	Params map[string]map[string]map[string]string `yaml:"params"`
+
+	// ExitOnForwardFailure makes a single failing tunnel close the whole
+	// profile, like ssh's option of the same name, unless set to false. The
+	// other tunnels then stay up and the failures are only reported.
+	ExitOnForwardFailure *bool `yaml:"exit_on_forward_failure"`
+
+	// template holds the tunnels of a profile with parameters until they are filled in
+	template yaml.Node
+
+	// Source is where the profile came from: "local" or a profile source and its revision
+	Source string `yaml:"-"`
+
+	// Overrides names the shared profile a local profile of the same name replaces
+	Overrides string `yaml:"-"`
+
+	// ignoreCommands drops the hooks and prepare commands of a shared profile
+	// whose source doesn't allow them
+	ignoreCommands bool
+}
+
+// TunnelConfig describes one tunnel of a profile
+type TunnelConfig struct {
+	// Host is the SSH host name the tunnel goes through
+	Host string `yaml:"host"`
+
+	// RemotePort is the port forwarded on the remote side
+	RemotePort int `yaml:"remote_port"`
+
+	// LocalPort is the local port to listen on, defaulting to the remote port
+	// or a free port when that is taken
+	LocalPort int `yaml:"local_port"`
+
+	// LocalSocket is a Unix socket path to listen on instead of a local port,
+	// such as ~/.kport/staging-db.sock
+	LocalSocket string `yaml:"local_socket"`
+
+	// Destination is where the host connects the tunnel to, as host:port or an
+	// SRV name, instead of the remote port on its own loopback
+	Destination string `yaml:"destination"`
+
+	// Health is an HTTP path `kport up --wait` checks, such as /healthz.
+	// Without it the wait checks that the remote service accepts connections.
+	Health string `yaml:"health"`
+
+	// Name identifies the tunnel in After lists, defaulting to host:remote_port
+	Name string `yaml:"name"`
+
+	// After lists the tunnels that must be healthy before this one is opened
+	After []string `yaml:"after"`
+
+	// Prepare is a command run on the host before the tunnel is opened, which must succeed
+	Prepare string `yaml:"prepare"`
+
+	// Hooks are local commands run as the tunnel comes up and goes down
+	Hooks Hooks `yaml:"hooks"`
+}
+
+// ID returns the name other tunnels of the profile refer to the tunnel by
+func (tc TunnelConfig) ID() string {
+	if tc.Name != "" {
+		return tc.Name
+	}
+	return fmt.Sprintf("%s:%d", tc.Host, tc.RemotePort)
+}
+
+// exitOnForwardFailure reports whether a failing tunnel closes the whole profile
+func (p ProfileConfig) exitOnForwardFailure() bool {
+	return p.ExitOnForwardFailure == nil || *p.ExitOnForwardFailure
+}
+
+// dependencies returns the indexes of the tunnels each tunnel comes after,
+// rejecting unknown names and cycles
+func (p ProfileConfig) dependencies() ([][]int, error) {
+	index := make(map[string]int, len(p.Tunnels))
+	for i, tunnel := range p.Tunnels {
+		if _, ok := index[tunnel.ID()]; ok {
+			return nil, fmt.Errorf("two tunnels are named %s, give them distinct names", tunnel.ID())
+		}
+		index[tunnel.ID()] = i
+	}
+
+	deps := make([][]int, len(p.Tunnels))
+	for i, tunnel := range p.Tunnels {
+		for _, name := range tunnel.After {
+			dep, ok := index[name]
+			if !ok {
+				return nil, fmt.Errorf("tunnel %s comes after unknown tunnel %s", tunnel.ID(), name)
+			}
+			deps[i] = append(deps[i], dep)
+		}
+	}
+
+	// Depth-first search for a tunnel that ends up coming after itself
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(p.Tunnels))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return fmt.Errorf("tunnel %s depends on itself through its after list", p.Tunnels[i].ID())
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, dep := range deps[i] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range p.Tunnels {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return deps, nil
+}
+
+// stages returns the stage each tunnel is brought up in: 1 for tunnels that
+// come after none, and one more than the latest of their dependencies otherwise
+func stages(deps [][]int) []int {
+	stage := make([]int, len(deps))
+	var depth func(i int) int
+	depth = func(i int) int {
+		if stage[i] == 0 {
+			stage[i] = 1
+			for _, dep := range deps[i] {
+				stage[i] = max(stage[i], depth(dep)+1)
+			}
+		}
+		return stage[i]
+	}
+	for i := range deps {
+		depth(i)
+	}
+	return stage
+}
+
+// Profile returns the named profile with its parameters filled in from args
+func (kc *KportConfig) Profile(name string, args map[string]string) (ProfileConfig, error) {
+	profile, ok := kc.Profiles[name]
+	if !ok {
+		return ProfileConfig{}, fmt.Errorf("profile %q not found in kport config", name)
+	}
+	profile, err := profile.Instantiate(args)
+	if err != nil {
+		return ProfileConfig{}, fmt.Errorf("profile %q: %w", name, err)
+	}
+	if len(profile.Tunnels) == 0 {
+		return ProfileConfig{}, fmt.Errorf("profile %q has no tunnels", name)
+	}
+	if profile.ignoreCommands && profile.dropCommands() {
+		fmt.Fprintf(os.Stderr, "Debug: Ignoring hooks and prepare commands of profile %s from %s, its source doesn't set allow_hooks\n", name, profile.Source)
+	}
+	if _, err := profile.dependencies(); err != nil {
+		return ProfileConfig{}, fmt.Errorf("profile %q: %w", name, err)
+	}
+	return profile, nil
+}
+
+// dropCommands removes the hooks and prepare commands of the profile and its
+// tunnels, and reports whether it had any
+func (p *ProfileConfig) dropCommands() bool {
+	dropped := p.Hooks.set()
+	p.Hooks = Hooks{}
+	p.Tunnels = slices.Clone(p.Tunnels)
+	for i := range p.Tunnels {
+		dropped = dropped || p.Tunnels[i].Hooks.set() || p.Tunnels[i].Prepare != ""
+		p.Tunnels[i].Hooks = Hooks{}
+		p.Tunnels[i].Prepare = ""
+	}
+	return dropped
+}
+
+// ProfileNames returns the names of all profiles in sorted order
+func (kc *KportConfig) ProfileNames() []string {
+	names := make([]string, 0, len(kc.Profiles))
+	for name := range kc.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// findHost returns the host with the given name
+func findHost(hosts []SSHHost, name string) (SSHHost, error) {
+	for _, host := range hosts {
+		if host.Name == name {
+			return host, nil
+		}
+	}
+	return SSHHost{}, fmt.Errorf("host %q not found in SSH config", name)
+}
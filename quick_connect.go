@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// defaultQuickConnectHosts is how many recent hosts the start screen lists
+	defaultQuickConnectHosts = 5
+
+	// maxQuickConnectHosts is the most hosts the start screen lists, one per digit key
+	maxQuickConnectHosts = 9
+
+	// reachabilityTimeout is the connect timeout of the start screen's reachability checks
+	reachabilityTimeout = 5
+)
+
+// QuickConnectConfig configures the start screen of recently used hosts
+type QuickConnectConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Hosts is how many recent hosts are listed, defaulting to 5 and at most 9
+	Hosts int `yaml:"hosts"`
+}
+
+// limit returns how many hosts the start screen lists
+func (c QuickConnectConfig) limit() int {
+	switch {
+	case c.Hosts <= 0:
+		return defaultQuickConnectHosts
+	case c.Hosts > maxQuickConnectHosts:
+		return maxQuickConnectHosts
+	}
+	return c.Hosts
+}
+
+// HostReachability is the result of checking that a host accepts connections
+type HostReachability struct {
+	Checking bool
+	Latency  time.Duration
+	Err      error
+}
+
+// HostReachableMsg is sent when a reachability check of a host finished
+type HostReachableMsg struct {
+	Host    string
+	Latency time.Duration
+	Err     error
+}
+
+// CheckReachable connects to host without running anything and times how long
+// connecting and authenticating took
+func CheckReachable(host SSHHost) tea.Cmd {
+	return func() tea.Msg {
+		// A host with candidate addresses or a picked agent key can't be
+		// reached without them
+		host, err := resolveConnection(host)
+		if err != nil {
+			return HostReachableMsg{Host: host.Name, Err: err}
+		}
+		start := time.Now()
+		cmd := sshCommand(host, host.probeOptions(reachabilityTimeout), "true")
+		output, err := cmd.CombinedOutput()
+		latency := time.Since(start)
+		recordRemoteCommand(cmd, "reachability check", start, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Reachability check of %s failed: %v\n", host.Name, err)
+			lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+			if sshErr := sshConnectionError(host, output, err); sshErr != nil {
+				err = sshErr
+			} else if last := lines[len(lines)-1]; last != "" {
+				err = fmt.Errorf("%s", last)
+			}
+		}
+		return HostReachableMsg{Host: host.Name, Latency: latency, Err: err}
+	}
+}
+
+// startQuickConnect shows the recently used hosts once the first config load
+// finished, unless the user already moved on. It returns the reachability checks.
+func (m *Model) startQuickConnect() []tea.Cmd {
+	if m.quickConnectShown || !m.kportConfig.QuickConnect.Enabled {
+		return nil
+	}
+	m.quickConnectShown = true
+	if m.state != StateSelectHost || m.err != nil {
+		return nil
+	}
+
+	m.quickHosts = m.quickHosts[:0]
+	for _, name := range m.portHistory.RecentHosts(m.kportConfig.QuickConnect.limit()) {
+		if containsHost(m.hosts, name) {
+			m.quickHosts = append(m.quickHosts, name)
+		}
+	}
+	if len(m.quickHosts) == 0 {
+		return nil
+	}
+	m.state = StateQuickConnect
+	m.cursor = 0
+
+	cmds := make([]tea.Cmd, 0, len(m.quickHosts))
+	for _, name := range m.quickHosts {
+		host := m.hosts[m.hostIndex(name, 0)]
+		// Pre-connect hooks may prompt for SSO, which can't happen unattended
+		if host.PreConnect != "" {
+			continue
+		}
+		m.reachability[name] = HostReachability{Checking: true}
+		cmds = append(cmds, CheckReachable(host))
+	}
+	return cmds
+}
+
+// updateHostReachable records a reachability check and re-sorts the start
+// screen, keeping the cursor on the same host
+func (m *Model) updateHostReachable(msg HostReachableMsg) {
+	current := ""
+	if m.cursor < len(m.quickHosts) {
+		current = m.quickHosts[m.cursor]
+	}
+
+	m.reachability[msg.Host] = HostReachability{Latency: msg.Latency, Err: msg.Err}
+	if m.state != StateQuickConnect {
+		return
+	}
+	m.sortQuickHosts()
+	if i := slices.Index(m.quickHosts, current); i >= 0 {
+		m.cursor = i
+	}
+}
+
+// sortQuickHosts lists reachable hosts first and unreachable ones last, each
+// group staying in order of recent use
+func (m *Model) sortQuickHosts() {
+	rank := func(name string) int {
+		reach, ok := m.reachability[name]
+		switch {
+		case ok && !reach.Checking && reach.Err == nil:
+			return 0
+		case ok && reach.Err != nil:
+			return 2
+		}
+		return 1
+	}
+	slices.SortStableFunc(m.quickHosts, func(a, b string) int {
+		return rank(a) - rank(b)
+	})
+}
+
+// updateQuickConnect handles the start screen, where 1-9 connect to a host
+// right away and going back falls through to the full host list
+func (m *Model) updateQuickConnect(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if key := msg.String(); len(key) == 1 && key >= "1" && key <= "9" {
+		if i := int(key[0] - '1'); i < len(m.quickHosts) {
+			return m, m.quickConnect(i)
+		}
+		return m, nil
+	}
+
+	switch m.keys.Action(StateQuickConnect, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionUp:
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case ActionDown:
+		if m.cursor < len(m.quickHosts)-1 {
+			m.cursor++
+		}
+	case ActionSelect:
+		return m, m.quickConnect(m.cursor)
+	case ActionBack:
+		m.state = StateSelectHost
+		m.cursor = m.hostIndex(m.quickHosts[m.cursor], 0)
+	}
+	return m, nil
+}
+
+// quickConnect connects to the i-th host of the start screen
+func (m *Model) quickConnect(i int) tea.Cmd {
+	// A config reload may have removed the host
+	if !containsHost(m.hosts, m.quickHosts[i]) {
+		return nil
+	}
+	m.cursor = m.hostIndex(m.quickHosts[i], 0)
+	return m.connectHost()
+}
+
+// renderQuickConnect renders the recently used hosts with their reachability
+func (m *Model) renderQuickConnect() string {
+	var s strings.Builder
+
+	s.WriteString("Recent hosts:\n\n")
+
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	upStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575"))
+	downStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+	width := 0
+	for _, name := range m.quickHosts {
+		width = max(width, len(name))
+	}
+	for i, name := range m.quickHosts {
+		cursor := " "
+		style := lipgloss.NewStyle()
+		if m.cursor == i {
+			cursor = ">"
+			style = style.Foreground(lipgloss.Color("#FF75B7"))
+		}
+
+		status := dimStyle.Render("not checked")
+		if reach, ok := m.reachability[name]; ok {
+			switch {
+			case reach.Checking:
+				status = dimStyle.Render("checking...")
+			case reach.Err != nil:
+				status = downStyle.Render("unreachable: " + reach.Err.Error())
+			default:
+				status = upStyle.Render(fmt.Sprintf("reachable (%s)", reach.Latency.Round(time.Millisecond)))
+			}
+		}
+		s.WriteString(fmt.Sprintf("%s %d  %s  %s\n", cursor, i+1, style.Render(fmt.Sprintf("%-*s", width, name)), status))
+	}
+
+	s.WriteString("\n")
+	s.WriteString(dimStyle.Render(fmt.Sprintf("Press %s to show all %d hosts.", m.keys.Label(ActionBack), len(m.hosts))))
+	s.WriteString("\n\n")
+
+	return s.String()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// alternativePortRange is how many ports above a taken remote port are
+// considered for a free one
+const alternativePortRange = 100
+
+// RemotePortInUseError is a remote forward whose port something on the host
+// already listens on, which ssh only reports as a warning or not at all
+type RemotePortInUseError struct {
+	Host string
+	Port int
+
+	// Holder is the process listening on the port, when the remote user may see it
+	Holder string
+
+	// Alternative is a free port close to Port, or 0 when none was found
+	Alternative int
+}
+
+func (e *RemotePortInUseError) Error() string {
+	holder := "another process"
+	if e.Holder != "" {
+		holder = e.Holder
+	}
+	message := fmt.Sprintf("remote port %d on %s is already in use by %s", e.Port, e.Host, holder)
+	if e.Alternative > 0 {
+		message += fmt.Sprintf(", port %d is free", e.Alternative)
+	}
+	return message
+}
+
+// remoteListeners lists the ports listening on host with the processes
+// holding them. The detection commands run in one session, which goes over
+// the prewarmed connection to host when there is one.
+func remoteListeners(ctx context.Context, host SSHHost) ([]int, map[int]string, error) {
+	if host.Plugin.can(PluginDetect) {
+		ports, processes, _, err := detectPluginPorts(host)
+		return ports, processes, err
+	}
+
+	timeout := host.DetectTimeout
+	if timeout <= 0 {
+		timeout = defaultDetectTimeout
+	}
+	output, err := runDetectCommand(ctx, host, strings.Join(listenCommands, "; "), timeout, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	ports, processes, _ := parseListeningPorts(output)
+	if len(processes) == 0 && len(ports) > 0 {
+		fillProcProcesses(ctx, host, ports, processes)
+	}
+	return ports, processes, nil
+}
+
+// checkRemotePorts checks that nothing listens on the ports of host before
+// remote forwards ask for them. A host whose listeners can't be listed passes,
+// leaving the failure to ssh.
+func checkRemotePorts(ctx context.Context, host SSHHost, ports ...int) error {
+	if len(ports) == 0 {
+		return nil
+	}
+	listening, processes, err := remoteListeners(ctx, host)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to list the listening ports of %s: %v\n", host.Name, err)
+		return nil
+	}
+	for _, port := range ports {
+		if slices.Contains(listening, port) {
+			return &RemotePortInUseError{
+				Host:        host.Name,
+				Port:        port,
+				Holder:      processes[port],
+				Alternative: alternativePort(slices.Concat(listening, ports), port),
+			}
+		}
+	}
+	return nil
+}
+
+// alternativePort returns the first port above a taken one that nothing
+// listens on. Privileged ports are swapped for their unprivileged
+// counterparts, as in 8080 for 80, since only root may bind them.
+func alternativePort(taken []int, port int) int {
+	start := port + 1
+	if port < 1024 {
+		start = port + 8000
+	}
+	for candidate := start; candidate < start+alternativePortRange && candidate < 65536; candidate++ {
+		if !slices.Contains(taken, candidate) {
+			return candidate
+		}
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// defaultWatchInterval is how often a tunnel checks that its remote service is still listening
+const defaultWatchInterval = 30 * time.Second
+
+// watchRemote periodically checks that the remote service of the tunnel still
+// accepts connections, so a service that died is reported instead of every
+// proxied connection failing silently
+func (pf *PortForwarder) watchRemote() {
+	defer pf.wg.Done()
+
+	ticker := time.NewTicker(pf.options.WatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pf.stopChan:
+			return
+		case <-pf.sshDone:
+			// Without ssh there is no remote side to check
+			return
+		case <-ticker.C:
+			pf.checkRemote()
+		}
+	}
+}
+
+// checkRemote connects to the active destination through ssh and records
+// whether the remote service went away or came back
+func (pf *PortForwarder) checkRemote() {
+	dest := pf.activeDestination()
+	err := pf.checkDestination(dest)
+
+	select {
+	case <-pf.stopChan:
+		return
+	case <-pf.sshDone:
+		return
+	default:
+	}
+
+	pf.remoteMu.Lock()
+	wasDown := !pf.remoteDownSince.IsZero()
+	if err != nil {
+		if !wasDown {
+			pf.remoteDownSince = time.Now()
+		}
+		pf.remoteErr = err
+	} else {
+		pf.remoteDownSince = time.Time{}
+		pf.remoteErr = nil
+	}
+	pf.remoteMu.Unlock()
+
+	target := fmt.Sprintf("%s -> %s:%d", pf.LocalAddress(), pf.host.Name, dest.Port)
+	if !isLoopbackHost(dest.Host) {
+		target = fmt.Sprintf("%s -> %s via %s", pf.LocalAddress(), dest.Address(), pf.host.Name)
+	}
+	switch {
+	case err != nil && !wasDown:
+		pf.errors.Record(fmt.Errorf("remote service %s is not listening: %w", dest.Address(), err))
+		logEvent("remote service down: %s: %v", target, err)
+		if pf.options.Notify {
+			desktopNotify("kport: remote service down", fmt.Sprintf("%s is not listening: %v", target, err))
+		}
+		// A failover tunnel may have another destination to switch to
+		pf.requestProbe()
+	case err == nil && wasDown:
+		logEvent("remote service back: %s", target)
+		if pf.options.Notify {
+			desktopNotify("kport: remote service back", fmt.Sprintf("%s is listening again", target))
+		}
+	}
+}
+
+// checkDestination opens a connection to a destination through ssh and checks
+// that ssh doesn't close it right away, which it does when the service refuses
+func (pf *PortForwarder) checkDestination(dest *Destination) error {
+	conn, err := pf.dial(dest)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return checkConnOpen(conn)
+}
+
+// RemoteDown returns since when the tunnel's remote service has not been
+// listening and the last check's error, or the zero time while it is up
+func (pf *PortForwarder) RemoteDown() (time.Time, error) {
+	pf.remoteMu.Lock()
+	defer pf.remoteMu.Unlock()
+	return pf.remoteDownSince, pf.remoteErr
+}
+
+// desktopNotify shows a desktop notification with osascript on macOS and
+// notify-send elsewhere, and is a no-op where neither is available
+func desktopNotify(title, message string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("display notification %q with title %q", message, title))
+	case "windows":
+		return
+	default:
+		cmd = exec.Command("notify-send", "--app-name=kport", title, message)
+	}
+
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to show desktop notification: %v\n", err)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tunnelLabel is the pprof label carrying the local port of the tunnel a goroutine belongs to
+const tunnelLabel = "kport.tunnel"
+
+// resourceUsageInterval is how long a reading of kport's resource usage is
+// reused, since reading it briefly stops the world
+const resourceUsageInterval = time.Second
+
+// goroutineRecordPattern matches the start of a record of the goroutine
+// profile, with how many goroutines share the stack
+var goroutineRecordPattern = regexp.MustCompile(`^(\d+) @`)
+
+// tunnelLabelPattern matches the labels line of a goroutine profile record
+// of goroutines labeled with a tunnel
+var tunnelLabelPattern = regexp.MustCompile(`^# labels: \{.*"` + regexp.QuoteMeta(tunnelLabel) + `":"(\d+)"`)
+
+// ResourceUsage is what kport itself is using, to tell a leak in a daemon
+// that has been running for weeks from a busy one
+type ResourceUsage struct {
+	// Heap is the memory of live and not yet collected objects, and Memory
+	// all of the memory kport obtained from the OS
+	Heap   uint64 `json:"heap"`
+	Memory uint64 `json:"memory"`
+
+	Goroutines int `json:"goroutines"`
+
+	// TunnelGoroutines counts the goroutines of each tunnel by local port
+	TunnelGoroutines map[int]int `json:"tunnel_goroutines,omitempty"`
+}
+
+// String describes the usage in a line, such as "4.2 MiB heap, 12.5 MiB from the OS, 31 goroutines"
+func (u ResourceUsage) String() string {
+	return fmt.Sprintf("%s heap, %s from the OS, %d goroutines",
+		formatBytes(int64(u.Heap)), formatBytes(int64(u.Memory)), u.Goroutines)
+}
+
+var (
+	resourceUsageMu sync.Mutex
+	resourceUsage   ResourceUsage
+	resourceUsageAt time.Time
+)
+
+// currentResourceUsage returns kport's memory and goroutines, and the
+// goroutines of each tunnel
+func currentResourceUsage() ResourceUsage {
+	resourceUsageMu.Lock()
+	defer resourceUsageMu.Unlock()
+
+	if time.Since(resourceUsageAt) < resourceUsageInterval {
+		return resourceUsage
+	}
+	resourceUsageAt = time.Now()
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	resourceUsage = ResourceUsage{
+		Heap:             stats.HeapAlloc,
+		Memory:           stats.Sys,
+		Goroutines:       runtime.NumGoroutine(),
+		TunnelGoroutines: tunnelGoroutines(),
+	}
+	return resourceUsage
+}
+
+// labelTunnelGoroutines labels the calling goroutine with a tunnel, so the
+// goroutines it starts are counted as the tunnel's, and so are the ones those
+// start in turn. The returned function restores the labels of ctx.
+func labelTunnelGoroutines(ctx context.Context, localPort int) func() {
+	pprof.SetGoroutineLabels(pprof.WithLabels(ctx, pprof.Labels(tunnelLabel, strconv.Itoa(localPort))))
+	return func() { pprof.SetGoroutineLabels(ctx) }
+}
+
+// tunnelGoroutines counts the goroutines labeled with each tunnel, read from
+// the goroutine profile, which groups goroutines by stack and labels
+func tunnelGoroutines() map[int]int {
+	var profile bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&profile, 1); err != nil {
+		return nil
+	}
+
+	counts := make(map[int]int)
+	count := 0
+	scanner := bufio.NewScanner(&profile)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := goroutineRecordPattern.FindStringSubmatch(line); match != nil {
+			count, _ = strconv.Atoi(match[1])
+			continue
+		}
+		if !strings.HasPrefix(line, "# labels:") {
+			continue
+		}
+		if match := tunnelLabelPattern.FindStringSubmatch(line); match != nil {
+			port, _ := strconv.Atoi(match[1])
+			counts[port] += count
+		}
+	}
+	return counts
+}
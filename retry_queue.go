@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// retryDelays is how long a pending tunnel waits before each automatic retry.
+// The last delay repeats until maxRetryAttempts.
+var retryDelays = []time.Duration{5 * time.Second, 15 * time.Second, 30 * time.Second, time.Minute, 2 * time.Minute, 5 * time.Minute}
+
+// maxRetryAttempts is how many automatic retries a pending tunnel gets before
+// it waits for a manual retry
+const maxRetryAttempts = 10
+
+// PendingTunnel is a tunnel that failed to start and is waiting to be retried
+type PendingTunnel struct {
+	ID         int
+	Host       SSHHost
+	RemotePort int
+	Options    ForwardOptions
+	Err        error
+
+	// Attempts counts the retries so far, and NextRetry is when the next
+	// automatic one is due, zero once they have been given up
+	Attempts  int
+	NextRetry time.Time
+
+	// Retrying is set while a retry is in flight
+	Retrying bool
+}
+
+// Target describes where the tunnel goes, as host:port or host/container:port
+func (p *PendingTunnel) Target() string {
+	if p.Options.Container != "" {
+		return fmt.Sprintf("%s/%s:%d", p.Host.Name, p.Options.Container, p.RemotePort)
+	}
+	return fmt.Sprintf("%s:%d", p.Host.Name, p.RemotePort)
+}
+
+// schedule sets when the next automatic retry is due after a failed attempt
+func (p *PendingTunnel) schedule(now time.Time) {
+	if p.Attempts >= maxRetryAttempts {
+		p.NextRetry = time.Time{}
+		return
+	}
+	delay := retryDelays[min(p.Attempts, len(retryDelays)-1)]
+	p.NextRetry = now.Add(delay)
+}
+
+// RetryQueue holds the tunnels that failed to start, in the order they failed
+type RetryQueue struct {
+	pending []*PendingTunnel
+	nextID  int
+}
+
+// ForwardFailedMsg is sent when a tunnel fails to start in a way that may go
+// away on its own, such as the host being down
+type ForwardFailedMsg struct {
+	Host       SSHHost
+	RemotePort int
+	Options    ForwardOptions
+	Err        error
+
+	// Start tells the TUI which of its starts failed
+	Start int
+}
+
+// PendingRetriedMsg is sent when a retry of a pending tunnel has finished
+type PendingRetriedMsg struct {
+	ID      int
+	Started *ForwardingStartedMsg
+	Err     error
+}
+
+// retryTickMsg advances the automatic retries and their countdowns
+type retryTickMsg time.Time
+
+// retryTick schedules the next retryTickMsg
+func retryTick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return retryTickMsg(t)
+	})
+}
+
+// Park adds a failed tunnel to the queue, replacing an earlier entry for the
+// same port of the same host
+func (q *RetryQueue) Park(msg ForwardFailedMsg, now time.Time) *PendingTunnel {
+	if p := q.find(msg.Host.Name, msg.RemotePort, msg.Options.Container); p != nil {
+		p.Options = msg.Options
+		p.Err = msg.Err
+		p.Attempts = 0
+		p.schedule(now)
+		return p
+	}
+
+	q.nextID++
+	p := &PendingTunnel{
+		ID:         q.nextID,
+		Host:       msg.Host,
+		RemotePort: msg.RemotePort,
+		Options:    msg.Options,
+		Err:        msg.Err,
+	}
+	p.schedule(now)
+	q.pending = append(q.pending, p)
+	return p
+}
+
+// Pending returns the queued tunnels
+func (q *RetryQueue) Pending() []*PendingTunnel {
+	return q.pending
+}
+
+// find returns the queued tunnel to a port of a host or container, or nil
+func (q *RetryQueue) find(host string, port int, container string) *PendingTunnel {
+	for _, p := range q.pending {
+		if p.Host.Name == host && p.RemotePort == port && p.Options.Container == container {
+			return p
+		}
+	}
+	return nil
+}
+
+// Forget drops the queued tunnel to a port of a host or container, once it
+// was started some other way
+func (q *RetryQueue) Forget(host string, port int, container string) {
+	if p := q.find(host, port, container); p != nil {
+		q.Remove(p.ID)
+	}
+}
+
+// Get returns the queued tunnel with id, or nil when it was dropped
+func (q *RetryQueue) Get(id int) *PendingTunnel {
+	for _, p := range q.pending {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// Remove drops the queued tunnel with id
+func (q *RetryQueue) Remove(id int) {
+	for i, p := range q.pending {
+		if p.ID == id {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// Clear drops every queued tunnel
+func (q *RetryQueue) Clear() {
+	q.pending = nil
+}
+
+// RetryNow makes every queued tunnel due. Tunnels whose automatic retries
+// were given up start their schedule over.
+func (q *RetryQueue) RetryNow(now time.Time) {
+	for _, p := range q.pending {
+		if p.Retrying {
+			continue
+		}
+		if p.NextRetry.IsZero() {
+			p.Attempts = 0
+		}
+		p.NextRetry = now
+	}
+}
+
+// Due returns the first queued tunnel whose retry is due, or nil. Retries run
+// one at a time, so none is due while another is in flight.
+func (q *RetryQueue) Due(now time.Time) *PendingTunnel {
+	for _, p := range q.pending {
+		if p.Retrying {
+			return nil
+		}
+	}
+	for _, p := range q.pending {
+		if !p.NextRetry.IsZero() && !now.Before(p.NextRetry) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Failed records a failed retry and schedules the next one
+func (q *RetryQueue) Failed(id int, err error, now time.Time) {
+	p := q.Get(id)
+	if p == nil {
+		return
+	}
+	p.Retrying = false
+	p.Err = err
+	p.Attempts++
+	p.schedule(now)
+	if p.NextRetry.IsZero() {
+		logEvent("giving up retrying localhost -> %s after %d attempts: %v", p.Target(), p.Attempts, err)
+	}
+}
+
+// retryPending starts the tunnel of a pending entry again
+func retryPending(p *PendingTunnel) tea.Cmd {
+	fmt.Fprintf(os.Stderr, "Debug: Retrying pending tunnel to %s, attempt %d\n", p.Target(), p.Attempts+1)
+	id := p.ID
+	start := StartPortForwarding(p.Host, p.RemotePort, p.Options)
+	return func() tea.Msg {
+		switch msg := start().(type) {
+		case ForwardingStartedMsg:
+			return PendingRetriedMsg{ID: id, Started: &msg}
+		case ForwardFailedMsg:
+			return PendingRetriedMsg{ID: id, Err: msg.Err}
+		case ErrorMsg:
+			return PendingRetriedMsg{ID: id, Err: msg.Error}
+		default:
+			return PendingRetriedMsg{ID: id, Err: fmt.Errorf("unexpected result %T", msg)}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// askpassSocketEnv tells a kport started by ssh as SSH_ASKPASS where to report prompts
+	askpassSocketEnv = "KPORT_ASKPASS_SOCKET"
+
+	// askpassHostEnv names the host the ssh command connects to
+	askpassHostEnv = "KPORT_ASKPASS_HOST"
+)
+
+// SecurityKeyMsg is sent when ssh waits for a security key to be touched, and
+// again with Done set once it no longer waits
+type SecurityKeyMsg struct {
+	ID     int
+	Host   string
+	Prompt string
+	Done   bool
+}
+
+var (
+	askpassOnce sync.Once
+	askpassPath string
+
+	securityKeyMu      sync.Mutex
+	securityKeyIDs     int
+	securityKeyHandler = printSecurityKeyPrompt
+)
+
+// setSecurityKeyHandler routes security key prompts to a front end instead of stderr
+func setSecurityKeyHandler(handler func(SecurityKeyMsg)) {
+	securityKeyMu.Lock()
+	defer securityKeyMu.Unlock()
+	securityKeyHandler = handler
+}
+
+// printSecurityKeyPrompt tells the user on stderr to touch their security key,
+// since stdout may carry a tunnel's data
+func printSecurityKeyPrompt(msg SecurityKeyMsg) {
+	if !msg.Done {
+		fmt.Fprintf(os.Stderr, "🔑 Touch your security key to connect to %s (%s)\n", msg.Host, msg.Prompt)
+	}
+}
+
+// notifySecurityKey passes a security key prompt to the current handler
+func notifySecurityKey(msg SecurityKeyMsg) {
+	securityKeyMu.Lock()
+	handler := securityKeyHandler
+	securityKeyMu.Unlock()
+	handler(msg)
+}
+
+// askpassEnv returns the environment of an ssh command that reports its
+// security key prompts to kport, or nil to leave ssh's environment alone.
+// ssh shows these prompts through SSH_ASKPASS when its stderr is not a
+// terminal, which is always the case for the commands kport runs.
+func askpassEnv(host SSHHost, batch bool) []string {
+	// An askpass of the user's own shows the prompts itself
+	if os.Getenv("SSH_ASKPASS") != "" {
+		return nil
+	}
+	path := startAskpassListener()
+	if path == "" {
+		return nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil
+	}
+
+	env := append(os.Environ(),
+		"SSH_ASKPASS="+executable,
+		askpassSocketEnv+"="+path,
+		askpassHostEnv+"="+host.Name,
+	)
+	// Without a display ssh only uses askpass when it is forced to. Batch mode
+	// never asks for passwords, so forcing it only affects the prompts.
+	if batch {
+		env = append(env, "SSH_ASKPASS_REQUIRE=force")
+	}
+	return env
+}
+
+// startAskpassListener listens for the askpass processes of this kport's ssh
+// commands and returns the socket path, or "" when prompts can't be reported
+func startAskpassListener() string {
+	askpassOnce.Do(func() {
+		dir, err := kportCacheDir("askpass")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to create askpass directory: %v\n", err)
+			return
+		}
+		removeStaleAskpassSockets(dir)
+
+		path := filepath.Join(dir, fmt.Sprintf("%d.sock", os.Getpid()))
+		os.Remove(path)
+		listener, err := net.Listen("unix", path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to listen for security key prompts: %v\n", err)
+			return
+		}
+		askpassPath = path
+		go serveAskpass(listener)
+	})
+	return askpassPath
+}
+
+// removeStaleAskpassSockets removes the sockets of kport processes that have exited
+func removeStaleAskpassSockets(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), ".sock"))
+		if err == nil && !processAlive(pid) {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+// serveAskpass accepts connections from askpass processes
+func serveAskpass(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go handleAskpass(conn)
+	}
+}
+
+// handleAskpass reports a prompt until its askpass process goes away, which
+// ssh makes happen as soon as the key was touched or the attempt failed
+func handleAskpass(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return
+	}
+	host, prompt, _ := strings.Cut(strings.TrimSpace(line), "\t")
+
+	securityKeyMu.Lock()
+	securityKeyIDs++
+	msg := SecurityKeyMsg{ID: securityKeyIDs, Host: host, Prompt: prompt}
+	securityKeyMu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "Debug: ssh to %s is waiting for a security key: %s\n", host, prompt)
+	notifySecurityKey(msg)
+	io.Copy(io.Discard, reader)
+	msg.Done = true
+	notifySecurityKey(msg)
+}
+
+// runAskpass runs kport as ssh's SSH_ASKPASS. Security key notices are passed
+// to the kport that started ssh; other prompts need an answer kport can't
+// give, so they are declined.
+func runAskpass(prompt string) int {
+	if os.Getenv("SSH_ASKPASS_PROMPT") != "none" {
+		return 1
+	}
+	conn, err := net.Dial("unix", os.Getenv(askpassSocketEnv))
+	if err != nil {
+		return 1
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "%s\t%s\n", os.Getenv(askpassHostEnv), strings.ReplaceAll(prompt, "\n", " "))
+	// ssh kills the notifier when it is done, closing the connection
+	io.Copy(io.Discard, conn)
+	return 0
+}
+
+// waitForSecurityKey delivers the next security key prompt to the TUI
+func waitForSecurityKey(prompts <-chan SecurityKeyMsg) tea.Cmd {
+	return func() tea.Msg {
+		return <-prompts
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// defaultShareTTL is how long a share link stays up when the config sets no ttl
+	defaultShareTTL = time.Hour
+
+	// shareStartTimeout is how long the relay may take to open the remote forward
+	shareStartTimeout = 30 * time.Second
+)
+
+// allocatedPortPattern matches the line ssh prints when the relay picked the port of a remote forward
+var allocatedPortPattern = regexp.MustCompile(`Allocated port (\d+) for remote forward`)
+
+// ShareConfig configures sharing tunnels through a public relay host
+type ShareConfig struct {
+	// Relay is the SSH host, such as your own VPS, that a shared tunnel is reachable on
+	Relay string `yaml:"relay"`
+
+	// Bind is the address the relay listens on, such as 0.0.0.0, which needs
+	// GatewayPorts clientspecified on the relay. Empty leaves it to GatewayPorts.
+	Bind string `yaml:"bind"`
+
+	// Port is the relay port to listen on, 0 lets the relay pick a free one
+	Port int `yaml:"port"`
+
+	// URL is the public URL with {port} replaced by the relay port, defaulting
+	// to http://<relay>:{port}
+	URL string `yaml:"url"`
+
+	// TTL closes a share link after this long, defaulting to an hour
+	TTL time.Duration `yaml:"ttl"`
+}
+
+// ShareStartedMsg is sent when a share link is up or failed to start
+type ShareStartedMsg struct {
+	Share *Share
+	Err   error
+}
+
+// Share reverse-forwards a local port to a port on the relay host, so the
+// tunnel behind it is reachable from a public URL until the share expires
+type Share struct {
+	relay     SSHHost
+	config    ShareConfig
+	localPort int
+	relayPort int
+	url       string
+	expiresAt time.Time
+	sshCmd    *exec.Cmd
+	sshDone   chan struct{}
+	allocated chan int
+	ttlTimer  *time.Timer
+	lastError string
+	closed    string
+	isRunning bool
+	mu        sync.Mutex
+}
+
+// NewShare creates a share of localPort through relay
+func NewShare(relay SSHHost, config ShareConfig, localPort int) *Share {
+	if config.TTL <= 0 {
+		config.TTL = defaultShareTTL
+	}
+	return &Share{
+		relay:     relay,
+		config:    config,
+		localPort: localPort,
+		sshDone:   make(chan struct{}),
+		allocated: make(chan int, 1),
+	}
+}
+
+// Start opens the remote forward on the relay and waits until it is listening
+func (sh *Share) Start() error {
+	// The relay refuses a fixed port that is taken without saying who holds it
+	if sh.config.Port != 0 {
+		if err := checkRemotePorts(context.Background(), sh.relay, sh.config.Port); err != nil {
+			return err
+		}
+	}
+
+	sh.mu.Lock()
+	if sh.isRunning {
+		sh.mu.Unlock()
+		return fmt.Errorf("share already running")
+	}
+
+	listen := fmt.Sprintf("%d:127.0.0.1:%d", sh.config.Port, sh.localPort)
+	if sh.config.Bind != "" {
+		listen = sh.config.Bind + ":" + listen
+	}
+	options := []string{
+		"-R", listen,
+		"-N",
+		"-o", "ServerAliveInterval=30",
+		"-o", "ServerAliveCountMax=3",
+	}
+	sh.sshCmd = sshCommand(sh.relay, append(options, exitOnForwardFailure(sh.relay)...))
+	sh.sshCmd.Stderr = &lineWriter{fn: sh.handleStderr}
+
+	fmt.Fprintf(os.Stderr, "Debug: Starting share: %s\n", sh.sshCmd.String())
+
+	if err := sh.sshCmd.Start(); err != nil {
+		sh.mu.Unlock()
+		return fmt.Errorf("failed to start share: %w", err)
+	}
+	sh.isRunning = true
+	sh.mu.Unlock()
+
+	go func() {
+		if err := sh.sshCmd.Wait(); err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Share finished with error: %v\n", err)
+		}
+		close(sh.sshDone)
+		sh.stop(fmt.Sprintf("connection to %s closed", sh.relay.Name))
+	}()
+
+	relayPort, err := sh.waitListening()
+	if err != nil {
+		sh.stop("failed to start")
+		return err
+	}
+
+	sh.mu.Lock()
+	sh.relayPort = relayPort
+	sh.url = sh.publicURL(relayPort)
+	sh.expiresAt = time.Now().Add(sh.config.TTL)
+	sh.ttlTimer = time.AfterFunc(sh.config.TTL, func() {
+		sh.stop(fmt.Sprintf("expired after %s", sh.config.TTL))
+	})
+	sh.mu.Unlock()
+
+	logEvent("share opened: localhost:%d at %s (expires in %s)", sh.localPort, sh.url, sh.config.TTL)
+	return nil
+}
+
+// waitListening waits until the relay listens on the shared port and returns it
+func (sh *Share) waitListening() (int, error) {
+	timeout := time.After(shareStartTimeout)
+
+	// A fixed port is confirmed by ssh staying up, since it exits when the relay refuses it
+	if sh.config.Port != 0 {
+		select {
+		case <-sh.sshDone:
+			return 0, sh.exitError()
+		case <-timeout:
+			return 0, fmt.Errorf("timed out waiting for %s", sh.relay.Name)
+		case <-time.After(configuredForwardsGrace):
+			return sh.config.Port, nil
+		}
+	}
+
+	select {
+	case port := <-sh.allocated:
+		return port, nil
+	case <-sh.sshDone:
+		return 0, sh.exitError()
+	case <-timeout:
+		return 0, fmt.Errorf("timed out waiting for %s to allocate a port", sh.relay.Name)
+	}
+}
+
+// publicURL returns the URL the share is reachable at
+func (sh *Share) publicURL(relayPort int) string {
+	if sh.config.URL == "" {
+		host := sh.relay.Hostname
+		if host == "" {
+			host = sh.relay.Name
+		}
+		return fmt.Sprintf("http://%s:%d", host, relayPort)
+	}
+	return strings.ReplaceAll(sh.config.URL, "{port}", strconv.Itoa(relayPort))
+}
+
+// handleStderr picks up the port the relay allocated and keeps the last line
+// ssh printed to explain a failure
+func (sh *Share) handleStderr(line string) {
+	fmt.Fprintf(os.Stderr, "Debug: ssh share %s: %s\n", sh.relay.Name, line)
+
+	if match := allocatedPortPattern.FindStringSubmatch(line); match != nil {
+		if port, err := strconv.Atoi(match[1]); err == nil {
+			select {
+			case sh.allocated <- port:
+			default:
+			}
+		}
+		return
+	}
+
+	sh.mu.Lock()
+	sh.lastError = line
+	sh.mu.Unlock()
+}
+
+// exitError describes why ssh exited before the share was up
+func (sh *Share) exitError() error {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if sshErr := classifySSHError(sh.relay, []byte(sh.lastError)); sshErr != nil {
+		return sshErr
+	}
+	if sh.lastError != "" {
+		return fmt.Errorf("ssh to %s exited: %s", sh.relay.Name, sh.lastError)
+	}
+	return fmt.Errorf("ssh to %s exited before the share was up", sh.relay.Name)
+}
+
+// Stop closes the share link
+func (sh *Share) Stop() {
+	sh.stop("stopped")
+}
+
+// stop closes the share link and logs why
+func (sh *Share) stop(reason string) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if !sh.isRunning {
+		return
+	}
+	sh.isRunning = false
+	sh.closed = reason
+	if sh.ttlTimer != nil {
+		sh.ttlTimer.Stop()
+	}
+	if sh.url != "" {
+		logEvent("share closed: localhost:%d at %s (%s)", sh.localPort, sh.url, reason)
+	}
+
+	if sh.sshCmd != nil && sh.sshCmd.Process != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Stopping share of localhost:%d\n", sh.localPort)
+		sh.sshCmd.Process.Kill()
+	}
+}
+
+// IsRunning reports whether the share link is still up
+func (sh *Share) IsRunning() bool {
+	select {
+	case <-sh.sshDone:
+		return false
+	default:
+	}
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.isRunning
+}
+
+// Closed returns why the share link was closed, empty while it is up
+func (sh *Share) Closed() string {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.closed
+}
+
+// URL returns the public URL of the share
+func (sh *Share) URL() string {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.url
+}
+
+// ExpiresAt returns when the share link closes
+func (sh *Share) ExpiresAt() time.Time {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.expiresAt
+}
+
+// Relay returns the host the share is reachable on
+func (sh *Share) Relay() SSHHost {
+	return sh.relay
+}
+
+// StartShare shares localPort through relay in the background
+func StartShare(relay SSHHost, config ShareConfig, localPort int) tea.Cmd {
+	return func() tea.Msg {
+		relay, err := prepareHost(relay)
+		if err != nil {
+			return ShareStartedMsg{Err: err}
+		}
+
+		share := NewShare(relay, config, localPort)
+		if err := share.Start(); err != nil {
+			return ShareStartedMsg{Err: err}
+		}
+		return ShareStartedMsg{Share: share}
+	}
+}
+
+// StopShare closes a share link in the background
+func StopShare(share *Share) tea.Cmd {
+	return func() tea.Msg {
+		share.Stop()
+		return nil
+	}
+}
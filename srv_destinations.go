@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// serviceNamePattern matches SRV names of TCP services, such as _postgres._tcp.internal
+var serviceNamePattern = regexp.MustCompile(`^_[A-Za-z0-9-]+\._tcp(\.[A-Za-z0-9-]+)+\.?$`)
+
+// isServiceName reports whether a destination is an SRV name rather than host:port
+func isServiceName(addr string) bool {
+	return serviceNamePattern.MatchString(addr)
+}
+
+// srvRecord is an SRV record of a service
+type srvRecord struct {
+	Priority int
+	Weight   int
+	Port     int
+	Target   string
+}
+
+// Address returns the record's target as host:port
+func (r srvRecord) Address() string {
+	return net.JoinHostPort(r.Target, strconv.Itoa(r.Port))
+}
+
+// srvLookupScript is a remote shell snippet that prints the SRV records of a
+// service as "srv <index> <priority> <weight> <port> <target>" lines. It uses
+// dig and falls back to host.
+func srvLookupScript(index int, service string) string {
+	return fmt.Sprintf(`{ if command -v dig >/dev/null 2>&1; then dig +short SRV %s; else host -t SRV %s | sed -n 's/.* has SRV record //p'; fi; } 2>/dev/null | sed 's/^/srv %d /'; `,
+		service, service, index)
+}
+
+// parseSRVOutput reads the lines of srvLookupScript snippets, keyed by index,
+// with each service's records in the order they should be tried: lowest
+// priority first and, within a priority, the heaviest first
+func parseSRVOutput(output []byte) map[int][]srvRecord {
+	records := make(map[int][]srvRecord)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 || fields[0] != "srv" {
+			continue
+		}
+		index, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		var numbers [3]int
+		for i := range numbers {
+			if numbers[i], err = strconv.Atoi(fields[2+i]); err != nil {
+				break
+			}
+		}
+		// A target of "." says the service isn't available at this domain
+		target := strings.TrimSuffix(fields[5], ".")
+		if err != nil || target == "" || !destinationHostPattern.MatchString(target) || numbers[2] <= 0 || numbers[2] > 65535 {
+			continue
+		}
+		records[index] = append(records[index], srvRecord{Priority: numbers[0], Weight: numbers[1], Port: numbers[2], Target: target})
+	}
+
+	for _, list := range records {
+		sort.SliceStable(list, func(i, j int) bool {
+			if list[i].Priority != list[j].Priority {
+				return list[i].Priority < list[j].Priority
+			}
+			return list[i].Weight > list[j].Weight
+		})
+	}
+	return records
+}
+
+// resolveServices looks up the SRV records of the destinations given as SRV
+// names on the remote host, and replaces each with a destination per record
+// in the order they should be tried
+func (pf *PortForwarder) resolveServices(ctx context.Context, destinations []*Destination) ([]*Destination, error) {
+	var script strings.Builder
+	for i, dest := range destinations {
+		if dest.Service != "" {
+			script.WriteString(srvLookupScript(i, dest.Service))
+		}
+	}
+	if script.Len() == 0 {
+		return destinations, nil
+	}
+
+	output, err := tracedOutput(ctx, "resolve services", sshCommand(pf.host, pf.host.probeOptions(10), script.String()))
+	if err != nil {
+		if sshErr := sshConnectionError(pf.host, nil, err); sshErr != nil {
+			return nil, sshErr
+		}
+		return nil, fmt.Errorf("failed to look up SRV records on %s: %w", pf.host.Name, err)
+	}
+	records := parseSRVOutput(output)
+
+	resolved := make([]*Destination, 0, len(destinations))
+	for i, dest := range destinations {
+		if dest.Service == "" {
+			resolved = append(resolved, dest)
+			continue
+		}
+		if len(records[i]) == 0 {
+			return nil, fmt.Errorf("%s has no SRV records on %s, which needs dig or host to look them up", dest.Service, pf.host.Name)
+		}
+		targets := make([]string, 0, len(records[i]))
+		for _, record := range records[i] {
+			resolved = append(resolved, &Destination{Host: record.Target, Port: record.Port, Healthy: true, Service: dest.Service})
+			targets = append(targets, record.Address())
+		}
+		fmt.Fprintf(os.Stderr, "Debug: %s resolves to %s on %s\n", dest.Service, strings.Join(targets, ", "), pf.host.Name)
+	}
+	return resolved, nil
+}
+
+// services returns the SRV names the tunnel's destinations came from, in order
+func (pf *PortForwarder) services() []string {
+	pf.destMu.Lock()
+	defer pf.destMu.Unlock()
+
+	services := make([]string, 0)
+	for _, dest := range pf.destinations {
+		if dest.Service != "" && !slices.Contains(services, dest.Service) {
+			services = append(services, dest.Service)
+		}
+	}
+	return services
+}
+
+// followsServices reports whether any destination came from an SRV name
+func (pf *PortForwarder) followsServices() bool {
+	return len(pf.services()) > 0
+}
+
+// checkServices compares the current SRV records of the tunnel's services
+// with the destinations ssh forwards. ssh can't forward new destinations
+// without restarting, so a service that moved elsewhere is reported.
+func (pf *PortForwarder) checkServices(services []string, records map[int][]srvRecord) {
+	for i, service := range services {
+		current, ok := records[i]
+		if !ok {
+			// A failed lookup says nothing about where the service went
+			continue
+		}
+
+		pf.destMu.Lock()
+		forwarded := make([]string, 0)
+		for _, dest := range pf.destinations {
+			if dest.Service == service {
+				forwarded = append(forwarded, dest.Address())
+			}
+		}
+		pf.destMu.Unlock()
+
+		moved := make([]string, 0)
+		for _, record := range current {
+			if !slices.Contains(forwarded, record.Address()) {
+				moved = append(moved, record.Address())
+			}
+		}
+		if len(moved) == 0 {
+			continue
+		}
+		points := "now also points to"
+		if len(moved) == len(current) {
+			points = "moved to"
+		}
+		pf.errors.Record(fmt.Errorf("%s %s %s, which this tunnel doesn't forward. Reopen the tunnel to follow it", service, points, strings.Join(moved, ", ")))
+	}
+}
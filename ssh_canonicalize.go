@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// canonicalizeLookupTimeout bounds the DNS lookup of each canonical domain
+	canonicalizeLookupTimeout = 2 * time.Second
+
+	// maxCanonicalizeLookups is how many hosts are canonicalized at once
+	maxCanonicalizeLookups = 8
+)
+
+// lookupHost resolves a hostname, replaceable so canonicalization doesn't need DNS
+var lookupHost = net.DefaultResolver.LookupHost
+
+// canonicalizeOptions are the CanonicalizeHostname settings of an SSH config block
+type canonicalizeOptions struct {
+	// Hostname is CanonicalizeHostname: no, yes or always
+	Hostname      string
+	Domains       []string
+	MaxDots       string
+	FallbackLocal string
+
+	// Proxy is the ProxyJump or ProxyCommand, which CanonicalizeHostname yes skips
+	Proxy string
+}
+
+// set records an option of a config line. As with ssh, the first value obtained wins.
+func (o *canonicalizeOptions) set(key, value string) {
+	switch key {
+	case "canonicalizehostname":
+		if o.Hostname == "" {
+			o.Hostname = strings.ToLower(value)
+		}
+	case "canonicaldomains":
+		if o.Domains == nil {
+			o.Domains = strings.Fields(value)
+		}
+	case "canonicalizemaxdots":
+		if o.MaxDots == "" {
+			o.MaxDots = value
+		}
+	case "canonicalizefallbacklocal":
+		if o.FallbackLocal == "" {
+			o.FallbackLocal = strings.ToLower(value)
+		}
+	case "proxyjump", "proxycommand":
+		if o.Proxy == "" {
+			o.Proxy = value
+		}
+	}
+}
+
+// merge fills in the options other sets and o doesn't
+func (o *canonicalizeOptions) merge(other canonicalizeOptions) {
+	o.set("canonicalizehostname", other.Hostname)
+	if other.Domains != nil {
+		o.set("canonicaldomains", strings.Join(other.Domains, " "))
+	}
+	o.set("canonicalizemaxdots", other.MaxDots)
+	o.set("canonicalizefallbacklocal", other.FallbackLocal)
+	o.set("proxyjump", other.Proxy)
+}
+
+// maxDots returns CanonicalizeMaxDots, which defaults to 1
+func (o canonicalizeOptions) maxDots() int {
+	if dots, err := strconv.Atoi(o.MaxDots); err == nil && dots >= 0 {
+		return dots
+	}
+	return 1
+}
+
+// canonicalizeOptionsFor collects the canonicalization options that apply to
+// host from the global lines and the blocks matching it, in file order
+func (sc *SSHConfig) canonicalizeOptionsFor(host SSHHost) canonicalizeOptions {
+	options := sc.global
+	for _, block := range sc.Hosts {
+		if block.Name == host.Name || matchHostPatterns(block.Name, host.Name) {
+			options.merge(block.canonicalize)
+		}
+	}
+	return options
+}
+
+// applyCanonicalization resolves the fully qualified names of hosts the way ssh
+// does with CanonicalizeHostname, so the blocks written for those names apply.
+// Hosts only touch their own fields, so they are resolved concurrently.
+func (sc *SSHConfig) applyCanonicalization() {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxCanonicalizeLookups)
+
+	for i := range sc.Hosts {
+		host := &sc.Hosts[i]
+		if isHostPattern(host.Name) {
+			continue
+		}
+		options := sc.canonicalizeOptionsFor(*host)
+		if options.Hostname != "yes" && options.Hostname != "always" {
+			continue
+		}
+		// Like ssh, yes leaves hosts reached through a proxy alone
+		if options.Hostname == "yes" && options.Proxy != "" && !strings.EqualFold(options.Proxy, "none") {
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			name, resolved := canonicalHostname(*host, options)
+			host.CanonicalName = name
+			if resolved {
+				host.Hostname = name
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// canonicalHostname returns the name ssh matches the config against again
+// after canonicalizing host, which is the fully qualified name when one of the
+// canonical domains resolves it and otherwise the HostName as written
+func canonicalHostname(host SSHHost, options canonicalizeOptions) (string, bool) {
+	name := host.Hostname
+	if name == "" {
+		name = host.Name
+	}
+	name = strings.ToLower(strings.ReplaceAll(name, "%h", host.Name))
+
+	// A trailing dot marks a name as fully qualified already
+	if strings.HasSuffix(name, ".") {
+		return strings.TrimSuffix(name, "."), false
+	}
+	if net.ParseIP(name) != nil || strings.Count(name, ".") > options.maxDots() {
+		return name, false
+	}
+
+	for _, domain := range options.Domains {
+		fqdn := name + "." + strings.Trim(domain, ".")
+		ctx, cancel := context.WithTimeout(context.Background(), canonicalizeLookupTimeout)
+		_, err := lookupHost(ctx, fqdn)
+		cancel()
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Debug: Canonicalized %s to %s\n", host.Name, fqdn)
+			return fqdn, true
+		}
+	}
+
+	if options.FallbackLocal == "no" && len(options.Domains) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: %s is in none of the CanonicalDomains, ssh will refuse to connect\n", host.Name)
+	}
+	return name, false
+}
+
+// blockApplies reports whether the settings of block apply to host, matching
+// it against the host's name and, as ssh does after canonicalizing, its
+// fully qualified name
+func blockApplies(block, host SSHHost) bool {
+	if block.Name == host.Name {
+		return false
+	}
+	if host.CanonicalName != "" && matchHostPatterns(block.Name, host.CanonicalName) {
+		return true
+	}
+	return isHostPattern(block.Name) && matchHostPatterns(block.Name, host.Name)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+)
+
+// sshCommand builds an ssh command for host with kport's per-host settings applied.
+// options are passed before the host name and remoteCommand after it.
+func sshCommand(host SSHHost, options []string, remoteCommand ...string) *exec.Cmd {
+	return sshCommandContext(context.Background(), host, options, remoteCommand...)
+}
+
+// sshCommandContext is sshCommand for a command that is killed once ctx is done
+func sshCommandContext(ctx context.Context, host SSHHost, options []string, remoteCommand ...string) *exec.Cmd {
+	args := append([]string{}, options...)
+	// Reuse a prewarmed connection so the command skips the handshake. ssh
+	// connects directly if the master has gone away in the meantime. Control
+	// commands for prewarmed masters themselves are run under the prewarmer's
+	// lock, so it is only consulted for commands that can use it.
+	if multiplexable(options) {
+		if path := prewarmedControlPath(host); path != "" {
+			args = append(args, "-S", path, "-o", "ControlMaster=no")
+		}
+	}
+	// ssh opens the LocalForward and RemoteForward lines of the SSH config on
+	// every connection. Commands without forwards of their own leave them to
+	// the configured forwards, so they don't take those ports.
+	if len(host.Forwards) > 0 && !forwardsRequested(options) {
+		args = append(args, "-o", "ClearAllForwardings=yes")
+	}
+	args = append(args, host.sshOptions()...)
+	args = append(args, host.destination())
+	args = append(args, remoteCommand...)
+
+	cmd := exec.CommandContext(ctx, "ssh", args...)
+	cmd.Env = askpassEnv(host, slices.Contains(options, "BatchMode=yes"))
+	bindToParent(cmd)
+	return cmd
+}
+
+// probeOptions are the options of commands kport runs in the background, which
+// must never prompt. The host's ConnectTimeout from the SSH config takes
+// precedence over kport's defaultTimeout in seconds.
+func (h SSHHost) probeOptions(defaultTimeout int) []string {
+	options := []string{"-o", "BatchMode=yes"}
+	if h.ConnectTimeout == "" {
+		options = append(options, "-o", fmt.Sprintf("ConnectTimeout=%d", defaultTimeout))
+	}
+	return options
+}
+
+// destination returns the destination argument passed to ssh
+func (h SSHHost) destination() string {
+	if h.Inline {
+		return h.Hostname
+	}
+	return h.Name
+}
+
+// sshOptions returns the ssh options derived from kport's settings for the host
+func (h SSHHost) sshOptions() []string {
+	options := make([]string, 0)
+
+	// ssh uses the first -l it is given. The user a pre-connect hook minted
+	// credentials for comes first, since they are only valid for that user.
+	// Otherwise a user chosen at connect time wins over the SSH config and
+	// any other user kport passes.
+	if h.HookUser != "" {
+		options = append(options, "-l", h.HookUser)
+	}
+	if h.UserOverride != "" {
+		options = append(options, "-l", h.UserOverride)
+	}
+
+	// ssh uses whichever of -p and -o Port comes first, so the port given on
+	// kport's command line goes before the -o options and an inline host's port
+	if h.PortOverride != "" {
+		options = append(options, "-p", h.PortOverride)
+	}
+
+	// ssh keeps the first value of an -o option, so these win over the SSH config
+	options = append(options, h.CLIOptions...)
+	options = append(options, h.AlgorithmOptions...)
+
+	// Inline hosts have no config block for ssh to read the user and port from
+	if h.Inline {
+		options = append(options, "-p", h.Port)
+		if h.User != "" {
+			options = append(options, "-l", h.User)
+		}
+		if h.Identity != "" {
+			options = append(options, "-i", h.Identity)
+		}
+	}
+
+	// The candidate address that answered replaces HostName. The host key is
+	// still looked up under HostName, so every address matches the key
+	// accepted for it.
+	if h.Address != "" {
+		options = append(options, "-o", "HostName="+h.Address, "-o", "HostKeyAlias="+hostKeyName(h))
+	}
+
+	// Teleport nodes use the OpenSSH config generated by tsh, which proxies
+	// through `tsh proxy ssh` and presents the tsh certificate
+	if h.Transport == TransportTeleport {
+		if path, err := teleportSSHConfigPath(); err == nil {
+			options = append(options, "-F", path)
+		}
+		if h.User != "" {
+			options = append(options, "-l", h.User)
+		}
+	}
+
+	// Local VMs use the SSH config generated by Vagrant, Lima or Colima
+	if h.Transport == TransportVM {
+		if path, err := vmSSHConfigPath(); err == nil {
+			options = append(options, "-F", path)
+		}
+	}
+
+	// SSM hosts have no public SSH, so the connection is carried by an SSM session
+	if h.Transport == TransportSSM {
+		options = append(options, "-o", h.ssmProxyCommand())
+	}
+
+	// Plugin transports relay the connection through the plugin's connect call
+	if h.Transport == TransportPlugin {
+		options = append(options, "-o", h.pluginProxyCommand())
+	}
+
+	// In strict mode ssh must not fall back to the default keys in ~/.ssh.
+	// Explicitly configured identities and the agent are still used.
+	if h.StrictIdentities && h.Identity == "" && h.HookIdentity == "" && h.AgentKeyFile == "" {
+		options = append(options, "-o", "IdentityFile=none")
+	}
+
+	// An agent key picked for the host is offered alone, so servers that
+	// disconnect after a few rejected keys get to it
+	if h.AgentKeyFile != "" {
+		options = append(options, "-o", "IdentitiesOnly=yes", "-i", h.AgentKeyFile)
+	}
+
+	// Credentials minted by a pre-connect hook take precedence over the SSH config
+	if h.HookIdentity != "" {
+		options = append(options, "-i", h.HookIdentity)
+	}
+	if h.HookCertificate != "" {
+		options = append(options, "-o", "CertificateFile="+h.HookCertificate)
+	}
+
+	return options
+}
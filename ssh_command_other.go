@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import (
+	"os/exec"
+)
+
+// bindToParent is a no-op where the kernel cannot signal children on parent death
+func bindToParent(cmd *exec.Cmd) {}
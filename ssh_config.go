@@ -4,8 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"os/user"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // SSHHost represents an SSH host configuration
@@ -15,11 +18,110 @@ type SSHHost struct {
 	User     string
 	Port     string
 	Identity string
+
+	// UserSource says where User came from when the host block doesn't set it:
+	// the matching wildcard block, or the local user as OpenSSH falls back to
+	UserSource string
+
+	// UserOverride is a user chosen at connect time, passed to ssh with -l
+	UserOverride string `json:"-"`
+
+	// PortOverride and CLIOptions were given on kport's command line with -p
+	// and -o, and take precedence over the SSH config
+	PortOverride string   `json:"-"`
+	CLIOptions   []string `json:"-"`
+
+	// Inline hosts were given on the command line or listed by a plugin and
+	// are not in any SSH config, so ssh is told the hostname, user and port directly
+	Inline bool
+
+	// Transport selects how the host is reached, empty for plain ssh
+	Transport string
+
+	// SSM holds the instance to reach when Transport is TransportSSM
+	SSM SSMConfig
+
+	// Plugin is the plugin the host is listed by or reached through
+	Plugin HostPlugin
+
+	// StrictIdentities limits authentication to configured identities and the agent
+	StrictIdentities bool
+
+	// PreConnect is a command run to mint credentials before connecting
+	PreConnect string
+
+	// AlgorithmOptions are the ssh options of the algorithms kport's config sets for the host
+	AlgorithmOptions []string
+
+	// AgentKey is the fingerprint or comment of the only agent key to offer,
+	// and AgentKeyFile its public key, written for ssh when connecting
+	AgentKey     string
+	AgentKeyFile string `json:"-"`
+
+	// Addresses are candidate addresses tried in place of HostName, and
+	// Address the one that answered when connecting
+	Addresses []string
+	Address   string `json:"-"`
+
+	// ProxyJump is the ProxyJump of the SSH config, and JumpChain the jump
+	// hosts it resolves to in the order ssh connects through them
+	ProxyJump string
+	JumpChain []string
+
+	// Forwards are the LocalForward and RemoteForward lines of the SSH config
+	Forwards []ConfiguredForward
+
+	// ConnectTimeout is the ConnectTimeout of the SSH config in seconds, empty when unset
+	ConnectTimeout string
+
+	// BindAddress is the BindAddress of the SSH config, which the listeners
+	// kport opens for the host's tunnels bind to instead of loopback
+	BindAddress string
+
+	// DetectTimeout limits each port detection command, with the default used when zero
+	DetectTimeout time.Duration
+
+	// CanonicalName is the name ssh matches the config against after
+	// CanonicalizeHostname, empty when canonicalization is off
+	CanonicalName string
+
+	// canonicalize holds the block's own canonicalization options
+	canonicalize canonicalizeOptions
+
+	// Description and Notes come from "# key: value" comments right above the
+	// Host line, "desc" or "description" being the description
+	Description string
+	Notes       []HostNote
+
+	// Tags come from the host's kport settings and its "tags" and "env"
+	// notes, such as prod, which the policy's prod_tags match
+	Tags []string
+
+	// Environment is the environment the tags make the host part of, such as prod
+	Environment string
+
+	// Sources are where the host's Host blocks are, as file:line, more than
+	// one when the host is defined in several places
+	Sources []string
+
+	// Credentials minted by the pre-connect hook, passed to ssh explicitly
+	HookIdentity    string `json:"-"`
+	HookCertificate string `json:"-"`
+	HookUser        string `json:"-"`
 }
 
 // SSHConfig handles parsing SSH configuration
 type SSHConfig struct {
 	Hosts []SSHHost
+
+	// Files lists every config file read, including included files
+	Files []string
+
+	// global holds the canonicalization options set before the first Host line
+	global canonicalizeOptions
+
+	// bindAddress is the BindAddress set before the first Host line
+	bindAddress string
 }
 
 // NewSSHConfig creates a new SSH config parser
@@ -42,7 +144,80 @@ func (sc *SSHConfig) LoadConfig() error {
 
 // LoadConfigFromFile loads SSH configuration from a specific file
 func (sc *SSHConfig) LoadConfigFromFile(path string) error {
-	return sc.loadConfigFromFileRecursive(path, make(map[string]bool))
+	if err := sc.loadConfigFromFileRecursive(path, make(map[string]bool)); err != nil {
+		return err
+	}
+	sc.mergeDuplicateHosts()
+	sc.applyCanonicalization()
+	sc.applyDefaultUsers()
+	sc.applyPatternBlocks()
+	sc.applyJumpChains()
+	return nil
+}
+
+// applyDefaultUsers fills in the user of hosts without a User directive the way
+// OpenSSH resolves it: from the first matching wildcard block, or block for its
+// canonical name, that sets one, otherwise the current local user
+func (sc *SSHConfig) applyDefaultUsers() {
+	localUser := currentUsername()
+
+	for i := range sc.Hosts {
+		host := &sc.Hosts[i]
+		if host.User != "" || isHostPattern(host.Name) {
+			continue
+		}
+
+		for _, block := range sc.Hosts {
+			if block.User != "" && blockApplies(block, *host) {
+				host.User = block.User
+				host.UserSource = "Host " + block.Name
+				break
+			}
+		}
+		if host.User == "" && localUser != "" {
+			host.User = localUser
+			host.UserSource = "local user"
+		}
+	}
+}
+
+// currentUsername returns the name of the user running kport
+func currentUsername() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
+
+// isHostPattern reports whether a Host value is a pattern rather than a single host
+func isHostPattern(value string) bool {
+	return strings.ContainsAny(value, "*?! \t")
+}
+
+// matchHostPatterns reports whether name matches a Host value of whitespace
+// separated patterns. A matching negated pattern (!pattern) excludes the name.
+func matchHostPatterns(patterns, name string) bool {
+	matched := false
+	for _, pattern := range strings.Fields(patterns) {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if ok, _ := path.Match(pattern, name); !ok {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// EffectiveUser returns the user kport connects as, taking a connect-time override into account
+func (h SSHHost) EffectiveUser() string {
+	if h.UserOverride != "" {
+		return h.UserOverride
+	}
+	return h.User
 }
 
 // loadConfigFromFileRecursive loads SSH config with include support and cycle detection
@@ -60,6 +235,8 @@ func (sc *SSHConfig) loadConfigFromFileRecursive(path string, visited map[string
 	visited[absPath] = true
 	defer delete(visited, absPath)
 
+	sc.Files = append(sc.Files, absPath)
+
 	file, err := os.Open(path)
 	if err != nil {
 		return fmt.Errorf("failed to open SSH config file %s: %w", path, err)
@@ -67,15 +244,31 @@ func (sc *SSHConfig) loadConfigFromFileRecursive(path string, visited map[string
 	defer file.Close()
 
 	scanner := bufio.NewScanner(file)
+	// Blocks are added as their Host line is read, so they stay in file order
+	// around included files, and currentHost points into sc.Hosts
 	var currentHost *SSHHost
+	current := -1
+	lineNumber := 0
+	// Notes collects the "# key: value" comments directly above the next Host line
+	var notes []HostNote
 
 	for scanner.Scan() {
+		lineNumber++
 		line := strings.TrimSpace(scanner.Text())
 		
 		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
+		if line == "" {
+			notes = nil
 			continue
 		}
+		if strings.HasPrefix(line, "#") {
+			if note, ok := parseHostNote(line); ok {
+				notes = append(notes, note)
+			}
+			continue
+		}
+		blockNotes := notes
+		notes = nil
 
 		key, value, err := parseConfigLine(line)
 		if err != nil {
@@ -89,16 +282,19 @@ func (sc *SSHConfig) loadConfigFromFileRecursive(path string, visited map[string
 				// Log error but continue processing
 				fmt.Fprintf(os.Stderr, "Warning: failed to process include %s: %v\n", value, err)
 			}
-		case "host":
-			// Save previous host if exists
-			if currentHost != nil {
-				sc.Hosts = append(sc.Hosts, *currentHost)
-			}
-			// Start new host
-			currentHost = &SSHHost{
-				Name: value,
-				Port: "22", // default port
+			// Hosts of the included files may have moved sc.Hosts
+			if current >= 0 {
+				currentHost = &sc.Hosts[current]
 			}
+		case "host":
+			// Start new host, whose port defaults to 22 once duplicate blocks are merged
+			sc.Hosts = append(sc.Hosts, SSHHost{
+				Name:    value,
+				Sources: []string{fmt.Sprintf("%s:%d", absPath, lineNumber)},
+			})
+			current = len(sc.Hosts) - 1
+			currentHost = &sc.Hosts[current]
+			currentHost.addNotes(blockNotes)
 		case "hostname":
 			if currentHost != nil {
 				currentHost.Hostname = value
@@ -115,14 +311,35 @@ func (sc *SSHConfig) loadConfigFromFileRecursive(path string, visited map[string
 			if currentHost != nil {
 				currentHost.Identity = value
 			}
+		case "connecttimeout":
+			if currentHost != nil {
+				currentHost.ConnectTimeout = value
+			}
+		case "bindaddress":
+			if currentHost != nil {
+				currentHost.BindAddress = value
+			} else if sc.bindAddress == "" {
+				sc.bindAddress = value
+			}
+		case "canonicalizehostname", "canonicaldomains", "canonicalizemaxdots",
+			"canonicalizefallbacklocal", "proxyjump", "proxycommand":
+			options := &sc.global
+			if currentHost != nil {
+				options = &currentHost.canonicalize
+			}
+			options.set(key, value)
+			if key == "proxyjump" && currentHost != nil && currentHost.ProxyJump == "" {
+				currentHost.ProxyJump = value
+			}
+		case "localforward", "remoteforward":
+			if currentHost != nil {
+				if forward, ok := parseConfiguredForward(value, key == "remoteforward"); ok {
+					currentHost.Forwards = append(currentHost.Forwards, forward)
+				}
+			}
 		}
 	}
 
-	// Add the last host
-	if currentHost != nil {
-		sc.Hosts = append(sc.Hosts, *currentHost)
-	}
-
 	if err := scanner.Err(); err != nil {
 		return fmt.Errorf("error reading SSH config file %s: %w", path, err)
 	}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// mergeDuplicateHosts merges Host blocks of the same name, such as one in the
+// main config and one in an included file, into a single host. ssh reads every
+// block that matches and keeps the first value it obtains for each option, so
+// the earlier block wins and later ones only fill in what it doesn't set.
+func (sc *SSHConfig) mergeDuplicateHosts() {
+	merged := make([]SSHHost, 0, len(sc.Hosts))
+	index := make(map[string]int)
+	for _, host := range sc.Hosts {
+		// Pattern blocks all apply in order, so they are kept apart
+		i, ok := index[host.Name]
+		if !ok || isHostPattern(host.Name) {
+			index[host.Name] = len(merged)
+			merged = append(merged, host)
+			continue
+		}
+		merged[i].fillFrom(host)
+	}
+
+	for i := range merged {
+		host := &merged[i]
+		if host.Port == "" {
+			host.Port = "22"
+		}
+		if len(host.Sources) > 1 && !isHostPattern(host.Name) {
+			fmt.Fprintf(os.Stderr, "Debug: Host %s is defined in %d blocks (%s), merged with the first value of each option winning\n",
+				host.Name, len(host.Sources), strings.Join(host.Sources, ", "))
+		}
+	}
+	sc.Hosts = merged
+}
+
+// fillFrom sets the options of host that are unset from a later block of the
+// same name. Forwards add up, as ssh opens those of every block.
+func (h *SSHHost) fillFrom(block SSHHost) {
+	if h.Hostname == "" {
+		h.Hostname = block.Hostname
+	}
+	if h.User == "" {
+		h.User = block.User
+	}
+	if h.Port == "" {
+		h.Port = block.Port
+	}
+	if h.Identity == "" {
+		h.Identity = block.Identity
+	}
+	if h.ConnectTimeout == "" {
+		h.ConnectTimeout = block.ConnectTimeout
+	}
+	if h.BindAddress == "" {
+		h.BindAddress = block.BindAddress
+	}
+	if h.ProxyJump == "" {
+		h.ProxyJump = block.ProxyJump
+	}
+	h.canonicalize.merge(block.canonicalize)
+	if h.Description == "" {
+		h.Description = block.Description
+	}
+	h.addNotes(block.Notes)
+	h.Forwards = append(h.Forwards, block.Forwards...)
+	h.Sources = append(h.Sources, block.Sources...)
+}
+
+// describeSources lists where a host is defined, with the home directory shortened to ~
+func (h SSHHost) describeSources() string {
+	sources := make([]string, 0, len(h.Sources))
+	for _, source := range h.Sources {
+		sources = append(sources, shortenHome(source))
+	}
+	description := strings.Join(sources, ", ")
+	if len(sources) > 1 {
+		description += " (merged, the first value of each option wins)"
+	}
+	return description
+}
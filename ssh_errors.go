@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// SSHError is an ssh connection failure explained with how to fix it
+type SSHError struct {
+	// Line is what ssh printed about the failure
+	Line string
+	Hint string
+
+	// Mismatch is set when the server offered no algorithm ssh has enabled
+	Mismatch *AlgorithmMismatch
+
+	// TooManyKeys is set when the server disconnected after rejecting too
+	// many of the offered keys
+	TooManyKeys bool
+}
+
+// Error returns the hint, which is more useful than ssh's own message
+func (e *SSHError) Error() string {
+	return e.Hint
+}
+
+// sshErrorRule maps an ssh error message to a hint, given the host and the
+// submatches of the message
+type sshErrorRule struct {
+	pattern *regexp.Regexp
+	hint    func(host SSHHost, match []string) string
+}
+
+// tooManyKeysPattern matches ssh's message for a server that stopped
+// accepting keys, usually because the agent holds more than MaxAuthTries
+var tooManyKeysPattern = regexp.MustCompile(`Too many authentication failures`)
+
+// sshErrorRules are checked in order, so a more specific message, such as a
+// changed host key, wins over the generic one ssh prints after it
+var sshErrorRules = []sshErrorRule{
+	{
+		regexp.MustCompile(`no matching host key type found\. Their offer: ([^\s,]+)`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("%s only offers %s host keys, which ssh disables by default. Enable legacy algorithms for this host with `HostKeyAlgorithms +%s` and `PubkeyAcceptedAlgorithms +%s` in its SSH config, or the legacy algorithms preset in kport's config",
+				host.Name, match[1], match[1], match[1])
+		},
+	},
+	{
+		regexp.MustCompile(`no matching key exchange method found\. Their offer: ([^\s,]+)`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("%s only offers legacy key exchange methods such as %s. Enable it for this host with `KexAlgorithms +%s` in its SSH config, or the legacy algorithms preset in kport's config", host.Name, match[1], match[1])
+		},
+	},
+	{
+		regexp.MustCompile(`no matching cipher found\. Their offer: ([^\s,]+)`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("%s only offers legacy ciphers such as %s. Enable it for this host with `Ciphers +%s` in its SSH config, or the legacy algorithms preset in kport's config", host.Name, match[1], match[1])
+		},
+	},
+	{
+		regexp.MustCompile(`no matching MAC found\. Their offer: ([^\s,]+)`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("%s only offers legacy MACs such as %s. Enable it for this host with `MACs +%s` in its SSH config, or the legacy algorithms preset in kport's config", host.Name, match[1], match[1])
+		},
+	},
+	{
+		tooManyKeysPattern,
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("%s disconnected after the agent offered too many keys. Pick the key to offer with agent_key in kport's config, or set `IdentitiesOnly yes` with the host's IdentityFile", host.Name)
+		},
+	},
+	{
+		regexp.MustCompile(`Permission denied \(([^)]*)\)`),
+		func(host SSHHost, match []string) string {
+			if !strings.Contains(match[1], "publickey") {
+				return fmt.Sprintf("%s only accepts %s authentication, which kport can't answer without a prompt. Set up key authentication for %s",
+					host.Name, strings.ReplaceAll(match[1], ",", " or "), host.EffectiveUser())
+			}
+			return fmt.Sprintf("%s rejected every key and authentication method offered (%s). Check the user (%s) and IdentityFile, and that the key is loaded with `ssh-add`",
+				host.Name, match[1], host.EffectiveUser())
+		},
+	},
+	{
+		regexp.MustCompile(`REMOTE HOST IDENTIFICATION HAS CHANGED`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("the host key of %s changed since it was saved in known_hosts. If the change is expected, remove the old key with `ssh-keygen -R %s`", host.Name, hostKeyName(host))
+		},
+	},
+	{
+		regexp.MustCompile(`Host key verification failed`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("the host key of %s isn't in known_hosts, and kport can't ask to trust it in the background. Connect once with `ssh %s` to accept it", host.Name, host.Name)
+		},
+	},
+	{
+		regexp.MustCompile(`timed out during banner exchange|banner exchange: .*(timed out|invalid format)`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("%s accepted the connection but never completed the SSH banner exchange. The port may not run an SSH server, or a proxy or firewall is holding the connection", host.Name)
+		},
+	},
+	{
+		regexp.MustCompile(`kex_exchange_identification: .*(Connection closed|Connection reset)`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("%s closed the connection before the SSH handshake. It may be limiting new connections (MaxStartups), blocking your address, or not be an SSH server", host.Name)
+		},
+	},
+	{
+		regexp.MustCompile(`Could not resolve hostname`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("%s doesn't resolve. Check its HostName, or its CanonicalDomains for a short name", hostKeyName(host))
+		},
+	},
+	{
+		regexp.MustCompile(`port (\d+): Connection refused`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("%s refused the connection on port %s. Check the host's Port and that sshd is running", host.Name, match[1])
+		},
+	},
+	{
+		regexp.MustCompile(`connect to host .*: (Connection|Operation) timed out`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("couldn't reach %s within the connect timeout. Check that it is up and whether it needs a VPN or ProxyJump", host.Name)
+		},
+	},
+	{
+		regexp.MustCompile(`No route to host`),
+		func(host SSHHost, match []string) string {
+			return fmt.Sprintf("there is no route to %s. Check your network or VPN connection", host.Name)
+		},
+	},
+}
+
+// classifySSHError looks for a connection failure kport knows how to fix in
+// what ssh printed, and returns nil when there is none
+func classifySSHError(host SSHHost, output []byte) *SSHError {
+	lines := strings.Split(string(output), "\n")
+	for _, rule := range sshErrorRules {
+		for _, line := range lines {
+			if match := rule.pattern.FindStringSubmatch(line); match != nil {
+				return &SSHError{
+					Line:        strings.TrimSpace(line),
+					Hint:        rule.hint(host, match),
+					Mismatch:    parseAlgorithmMismatch(line),
+					TooManyKeys: rule.pattern == tooManyKeysPattern,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sshConnectionError explains why ssh failed to connect to host, given the
+// error of running a remote command and what ssh printed. It returns nil when
+// ssh connected and the remote command itself failed, or the cause is unknown.
+func sshConnectionError(host SSHHost, output []byte, err error) error {
+	// ssh exits with 255 on its own errors and otherwise with the command's status
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != 255 {
+		return nil
+	}
+	if output == nil {
+		output = exitErr.Stderr
+	}
+	if sshErr := classifySSHError(host, output); sshErr != nil {
+		fmt.Fprintf(os.Stderr, "Debug: ssh to %s failed: %s\n", host.Name, sshErr.Line)
+		return sshErr
+	}
+	return nil
+}
+
+// hostKeyName returns the name ssh resolves and saves the host key of host under
+func hostKeyName(host SSHHost) string {
+	if host.Hostname != "" {
+		return host.Hostname
+	}
+	return host.Name
+}
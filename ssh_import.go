@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// sshValueOptions are the ssh options that take a value
+	sshValueOptions = "BbcDEeFIiJLlmOoPpQRSWw"
+
+	// sshQuietOptions are the ssh flags that make no difference to a tunnel
+	// kport runs, such as -N or -f
+	sshQuietOptions = "fNnqTtvx"
+)
+
+// ImportedTunnel is a tunnel of a profile imported from an ssh command line,
+// written without the settings it leaves at their defaults
+type ImportedTunnel struct {
+	Name        string `yaml:"name,omitempty"`
+	Host        string `yaml:"host"`
+	RemotePort  int    `yaml:"remote_port"`
+	LocalPort   int    `yaml:"local_port,omitempty"`
+	Destination string `yaml:"destination,omitempty"`
+}
+
+// SSHImport is what an ssh command line amounts to in kport: the tunnels of
+// its -L flags, and the lines a Host block needs for the rest
+type SSHImport struct {
+	// Host is the destination's host, which the tunnels go through
+	Host    string
+	Tunnels []ImportedTunnel
+
+	// HostLines are ssh config lines for the connection options and the -R and
+	// -D forwards, which kport leaves to the host's Host block
+	HostLines []string
+
+	// Notes explain what couldn't be carried over
+	Notes []string
+}
+
+// splitShellWords splits a command line into words the way a POSIX shell
+// does for plain quoting and escapes, without expansions
+func splitShellWords(line string) ([]string, error) {
+	words := make([]string, 0)
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", line)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// splitForwardSpec splits the value of -L or -R at its colons, keeping
+// bracketed IPv6 addresses whole
+func splitForwardSpec(spec string) []string {
+	fields := make([]string, 0, 4)
+	start, depth := 0, 0
+	for i, r := range spec {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				fields = append(fields, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, spec[start:])
+}
+
+// isLoopbackDestination reports whether an -L destination is the host itself,
+// which is where kport forwards by default
+func isLoopbackDestination(host string) bool {
+	host = strings.Trim(host, "[]")
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// parseSSHCommand reads the forwards and connection options of an ssh command
+// line, with or without the leading "ssh"
+func parseSSHCommand(args []string) (SSHImport, error) {
+	var imp SSHImport
+	if len(args) > 0 && (args[0] == "ssh" || strings.HasSuffix(args[0], "/ssh")) {
+		args = args[1:]
+	}
+
+	type option struct{ flag, value string }
+	options := make([]option, 0)
+	destination := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if destination != "" {
+			// ssh runs the rest as a remote command
+			imp.Notes = append(imp.Notes, fmt.Sprintf("left out the remote command %q, since tunnels don't run one", strings.Join(args[i:], " ")))
+			break
+		}
+		if arg == "--" {
+			if i+1 < len(args) {
+				destination = args[i+1]
+				i++
+			}
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			destination = arg
+			continue
+		}
+
+		// Flags can be combined, as in -NfL 8080:localhost:80, and a value can follow its flag directly
+		for j := 1; j < len(arg); j++ {
+			flag := string(arg[j])
+			if !strings.Contains(sshValueOptions, flag) {
+				options = append(options, option{flag: flag})
+				continue
+			}
+			value := arg[j+1:]
+			if value == "" {
+				if i+1 == len(args) {
+					return imp, fmt.Errorf("ssh option -%s needs a value", flag)
+				}
+				i++
+				value = args[i]
+			}
+			options = append(options, option{flag, value})
+			break
+		}
+	}
+	if destination == "" {
+		return imp, fmt.Errorf("the ssh command has no destination host")
+	}
+
+	// The destination is [user@]host or ssh://[user@]host[:port]
+	user, port := "", ""
+	if strings.HasPrefix(destination, "ssh://") {
+		u, err := url.Parse(destination)
+		if err != nil {
+			return imp, fmt.Errorf("invalid destination %q: %w", destination, err)
+		}
+		imp.Host, port = u.Hostname(), u.Port()
+		if u.User != nil {
+			user = u.User.Username()
+		}
+	} else {
+		imp.Host = destination
+		if at := strings.LastIndex(destination, "@"); at >= 0 {
+			user, imp.Host = destination[:at], destination[at+1:]
+		}
+	}
+	if imp.Host == "" {
+		return imp, fmt.Errorf("invalid destination %q", destination)
+	}
+
+	hostLine := func(format string, args ...any) {
+		imp.HostLines = append(imp.HostLines, fmt.Sprintf(format, args...))
+	}
+	if user != "" {
+		hostLine("User %s", user)
+	}
+	if port != "" {
+		hostLine("Port %s", port)
+	}
+
+	localPorts := make(map[int]bool)
+	ids := make(map[string]bool)
+	for _, opt := range options {
+		switch opt.flag {
+		case "L":
+			tunnel, err := importLocalForward(imp.Host, opt.value)
+			if err != nil {
+				imp.Notes = append(imp.Notes, fmt.Sprintf("left out -L %s: %v", opt.value, err))
+				continue
+			}
+			if tunnel.LocalPort > 0 && localPorts[tunnel.LocalPort] {
+				imp.Notes = append(imp.Notes, fmt.Sprintf("left out -L %s, since local port %d is forwarded already", opt.value, tunnel.LocalPort))
+				continue
+			}
+			localPorts[tunnel.LocalPort] = true
+			// Profiles refer to tunnels by host:remote_port, which two forwards
+			// to different destinations can share
+			id := fmt.Sprintf("%s:%d", tunnel.Host, tunnel.RemotePort)
+			if ids[id] {
+				tunnel.Name = fmt.Sprintf("localhost:%d", tunnel.LocalPort)
+			}
+			ids[id] = true
+			imp.Tunnels = append(imp.Tunnels, tunnel)
+			if fields := splitForwardSpec(opt.value); len(fields) == 4 && !isLoopbackDestination(fields[0]) {
+				imp.Notes = append(imp.Notes, fmt.Sprintf("-L %s listens on %s, but kport listens on localhost; list port %d under lan in the host's kport settings to share it", opt.value, fields[0], tunnel.RemotePort))
+			}
+		case "R":
+			fields := splitForwardSpec(opt.value)
+			switch {
+			case len(fields) >= 3:
+				hostLine("RemoteForward %s %s", strings.Join(fields[:len(fields)-2], ":"), strings.Join(fields[len(fields)-2:], ":"))
+			case len(fields) == 2 && strings.Contains(opt.value, "/"):
+				hostLine("RemoteForward %s %s", fields[0], fields[1])
+			default:
+				hostLine("RemoteForward %s", opt.value)
+			}
+		case "D":
+			hostLine("DynamicForward %s", opt.value)
+		case "l":
+			hostLine("User %s", opt.value)
+		case "p":
+			hostLine("Port %s", opt.value)
+		case "i":
+			hostLine("IdentityFile %s", opt.value)
+		case "J":
+			hostLine("ProxyJump %s", opt.value)
+		case "c":
+			hostLine("Ciphers %s", opt.value)
+		case "m":
+			hostLine("MACs %s", opt.value)
+		case "o":
+			key, value, ok := strings.Cut(opt.value, "=")
+			if !ok {
+				key, value, _ = strings.Cut(opt.value, " ")
+			}
+			hostLine("%s %s", strings.TrimSpace(key), strings.TrimSpace(value))
+		case "A":
+			hostLine("ForwardAgent yes")
+		case "C":
+			hostLine("Compression yes")
+		case "4":
+			hostLine("AddressFamily inet")
+		case "6":
+			hostLine("AddressFamily inet6")
+		case "g":
+			imp.Notes = append(imp.Notes, "left out -g: kport listens on localhost, so list the ports other machines need under lan in the host's kport settings")
+		case "F":
+			imp.Notes = append(imp.Notes, fmt.Sprintf("left out -F %s: kport reads ~/.ssh/config, so the Host block belongs there", opt.value))
+		default:
+			if !strings.Contains(sshQuietOptions, opt.flag) {
+				imp.Notes = append(imp.Notes, fmt.Sprintf("left out %s, which kport has no use for", strings.TrimSpace("-"+opt.flag+" "+opt.value)))
+			}
+		}
+	}
+	if len(imp.Tunnels) == 0 {
+		return imp, fmt.Errorf("the ssh command has no -L forward kport can run")
+	}
+	// Forwards read best after the options of the connection
+	slices.SortStableFunc(imp.HostLines, func(a, b string) int {
+		return cmp.Compare(isForwardLine(a), isForwardLine(b))
+	})
+	return imp, nil
+}
+
+// isForwardLine returns 1 for a RemoteForward or DynamicForward line of a Host block, and 0 otherwise
+func isForwardLine(line string) int {
+	if strings.HasPrefix(line, "RemoteForward ") || strings.HasPrefix(line, "DynamicForward ") {
+		return 1
+	}
+	return 0
+}
+
+// importLocalForward turns the value of an -L flag into a tunnel through host
+func importLocalForward(host, spec string) (ImportedTunnel, error) {
+	if strings.Contains(spec, "/") {
+		return ImportedTunnel{}, fmt.Errorf("kport forwards TCP ports, not Unix sockets")
+	}
+	fields := splitForwardSpec(spec)
+	switch len(fields) {
+	case 3, 4:
+		// A bind address comes first, which kport leaves at localhost
+		fields = fields[len(fields)-3:]
+	default:
+		return ImportedTunnel{}, fmt.Errorf("expected [bind_address:]port:host:hostport")
+	}
+
+	localPort, err := strconv.Atoi(fields[0])
+	if err != nil || localPort < 0 || localPort > 65535 {
+		return ImportedTunnel{}, fmt.Errorf("invalid local port %q", fields[0])
+	}
+	remotePort, err := strconv.Atoi(fields[2])
+	if err != nil || remotePort <= 0 || remotePort > 65535 {
+		return ImportedTunnel{}, fmt.Errorf("invalid port %q", fields[2])
+	}
+
+	tunnel := ImportedTunnel{Host: host, RemotePort: remotePort}
+	if localPort != remotePort {
+		tunnel.LocalPort = localPort
+	}
+	if !isLoopbackDestination(fields[1]) {
+		tunnel.Destination = net.JoinHostPort(strings.Trim(fields[1], "[]"), fields[2])
+	}
+	return tunnel, nil
+}
+
+// importedProfile returns the kport config holding the imported tunnels as a
+// profile of the given name
+func importedProfile(name string, imp SSHImport) ([]byte, error) {
+	config := map[string]any{
+		"profiles": map[string]any{
+			name: map[string]any{"tunnels": imp.Tunnels},
+		},
+	}
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config); err != nil {
+		return nil, fmt.Errorf("failed to encode the profile: %w", err)
+	}
+	encoder.Close()
+	return out.Bytes(), nil
+}
+
+// runImportCmd creates a profile from an ssh command line, given as a single
+// argument or as separate words, with --name choosing the profile's name and
+// --print writing it to stdout instead of the kport config
+func runImportCmd(args []string) error {
+	name, printOnly := "", false
+	words := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--print":
+			printOnly = true
+		case args[i] == "--name" && i+1 < len(args):
+			name = args[i+1]
+			i++
+		default:
+			words = append(words, args[i])
+		}
+	}
+	if len(words) == 1 {
+		var err error
+		if words, err = splitShellWords(words[0]); err != nil {
+			return err
+		}
+	}
+	if len(words) == 0 {
+		return fmt.Errorf(`usage: kport import-cmd [--name profile] [--print] "ssh -L 8080:localhost:3000 host"`)
+	}
+
+	imp, err := parseSSHCommand(words)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = imp.Host
+	}
+	profile, err := importedProfile(name, imp)
+	if err != nil {
+		return err
+	}
+
+	if printOnly {
+		os.Stdout.Write(profile)
+	} else {
+		kportConfig, err := LoadKportConfig()
+		if err != nil {
+			return err
+		}
+		if _, ok := kportConfig.Profiles[name]; ok {
+			return fmt.Errorf("profile %q already exists, choose another name with --name", name)
+		}
+		path, _, err := importConfigBundle(profile)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✅ Added profile %s with %d tunnels to %s, bring it up with kport up %s\n", name, len(imp.Tunnels), path, name)
+	}
+
+	// Profiles go through hosts of the SSH config, so the options of the
+	// command, and a host the config doesn't have yet, go in a Host block
+	configured := false
+	sshConfig := NewSSHConfig()
+	if err := sshConfig.LoadConfig(); err == nil {
+		_, err := findHost(sshConfig.GetHosts(), imp.Host)
+		configured = err == nil
+	}
+	lines := imp.HostLines
+	block := "the Host block"
+	if !configured {
+		lines = append([]string{"HostName " + imp.Host}, lines...)
+		block = "a new Host block"
+	}
+	if len(lines) > 0 {
+		fmt.Fprintf(os.Stderr, "\nAdd these to %s of %s in ~/.ssh/config to connect like the command does:\n\n", block, imp.Host)
+		fmt.Fprintf(os.Stderr, "Host %s\n", imp.Host)
+		for _, line := range lines {
+			fmt.Fprintf(os.Stderr, "    %s\n", line)
+		}
+		fmt.Fprintln(os.Stderr)
+	}
+	if slices.ContainsFunc(imp.HostLines, func(line string) bool { return isForwardLine(line) == 1 }) {
+		fmt.Fprintf(os.Stderr, "Profiles only forward local ports, so the -R and -D forwards stay in the Host block. Open them from the port list of %s with f.\n", imp.Host)
+	}
+	for _, note := range imp.Notes {
+		fmt.Fprintf(os.Stderr, "⚠️  %s\n", note)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SSHOverrides are ssh options given on kport's command line, which take
+// precedence over the SSH config like they do for ssh itself
+type SSHOverrides struct {
+	Port    string
+	User    string
+	Options []string
+}
+
+// parseSSHOverrides reads -p, -l and -o options from the start of args, in
+// ssh's separate (-p 2222) or attached (-p2222) form, and returns the rest.
+// Like ssh, it keeps the first -p and -l.
+func parseSSHOverrides(args []string) (SSHOverrides, []string, error) {
+	var overrides SSHOverrides
+	for len(args) > 0 && len(args[0]) >= 2 && args[0][0] == '-' {
+		flag, value := args[0][:2], args[0][2:]
+		args = args[1:]
+		if flag != "-p" && flag != "-l" && flag != "-o" {
+			return SSHOverrides{}, nil, fmt.Errorf("unknown option %s, only -p, -l and -o are supported", flag)
+		}
+		if value == "" {
+			if len(args) == 0 {
+				return SSHOverrides{}, nil, fmt.Errorf("option %s needs a value", flag)
+			}
+			value, args = args[0], args[1:]
+		}
+
+		switch flag {
+		case "-p":
+			if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+				return SSHOverrides{}, nil, fmt.Errorf("invalid port %q for -p", value)
+			}
+			if overrides.Port == "" {
+				overrides.Port = value
+			}
+		case "-l":
+			if overrides.User == "" {
+				overrides.User = value
+			}
+		case "-o":
+			if !strings.ContainsAny(value, "= ") {
+				return SSHOverrides{}, nil, fmt.Errorf("invalid option %q for -o, expected Key=Value", value)
+			}
+			overrides.Options = append(overrides.Options, value)
+		}
+	}
+	return overrides, args, nil
+}
+
+// Apply returns a copy of host with the overrides applied. Like ssh, the first
+// value of an option wins, so -o User= and -o Port= only count without -l or -p.
+func (o SSHOverrides) Apply(host SSHHost) SSHHost {
+	user, port, hostname := o.User, o.Port, ""
+	for _, option := range o.Options {
+		key, value, _ := strings.Cut(strings.Replace(option, " ", "=", 1), "=")
+		switch strings.ToLower(key) {
+		case "hostname":
+			if hostname == "" {
+				hostname = value
+			}
+		case "user":
+			if user == "" {
+				user = value
+			}
+		case "port":
+			if port == "" {
+				port = value
+			}
+		}
+		host.CLIOptions = append(host.CLIOptions, "-o", option)
+	}
+
+	if hostname != "" {
+		host.Hostname = hostname
+	}
+	if user != "" {
+		host.UserOverride = user
+	}
+	if port != "" {
+		host.Port = port
+		host.PortOverride = port
+	}
+	return host
+}
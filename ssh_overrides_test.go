@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestSSHOverridesMatchSSH checks that the host, user and port kport takes
+// from its command line are the ones ssh connects with
+func TestSSHOverridesMatchSSH(t *testing.T) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh isn't installed")
+	}
+
+	tests := []struct {
+		args                 []string
+		hostname, user, port string
+	}{
+		{[]string{}, "example", "", "22"},
+		{[]string{"-p", "2222"}, "example", "", "2222"},
+		{[]string{"-p", "2222", "-p", "3333"}, "example", "", "2222"},
+		{[]string{"-p2222", "-p3333"}, "example", "", "2222"},
+		{[]string{"-p", "2222", "-o", "Port=3333"}, "example", "", "2222"},
+		{[]string{"-o", "Port=3333", "-p", "2222"}, "example", "", "2222"},
+		{[]string{"-o", "Port=3333", "-o", "Port=4444"}, "example", "", "3333"},
+		{[]string{"-o", "Port 3333", "-p", "2222", "-o", "Port=4444"}, "example", "", "2222"},
+		{[]string{"-l", "alice", "-l", "bob"}, "example", "alice", "22"},
+		{[]string{"-o", "User=bob", "-l", "alice"}, "example", "alice", "22"},
+		{[]string{"-o", "User=bob", "-o", "User=carol"}, "example", "bob", "22"},
+		{[]string{"-o", "HostName=10.0.0.8", "-o", "HostName=10.0.0.9"}, "10.0.0.8", "", "22"},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			overrides, rest, err := parseSSHOverrides(append(tt.args, "example"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rest) != 1 {
+				t.Fatalf("parseSSHOverrides left %v", rest)
+			}
+			host := overrides.Apply(SSHHost{Name: "example", Hostname: "example", Port: "22"})
+			if host.Hostname != tt.hostname || host.UserOverride != tt.user || host.Port != tt.port {
+				t.Errorf("kport uses %s@%s:%s, want %s@%s:%s",
+					host.UserOverride, host.Hostname, host.Port, tt.user, tt.hostname, tt.port)
+			}
+
+			args := append([]string{"-G", "-F", "/dev/null"}, host.sshOptions()...)
+			output, err := exec.Command("ssh", append(args, host.destination())...).Output()
+			if err != nil {
+				t.Fatalf("ssh -G failed: %v", err)
+			}
+			config := make(map[string]string)
+			for _, line := range strings.Split(string(output), "\n") {
+				key, value, _ := strings.Cut(line, " ")
+				config[key] = value
+			}
+			if config["hostname"] != host.Hostname || config["port"] != host.Port {
+				t.Errorf("ssh connects to %s:%s, kport uses %s:%s",
+					config["hostname"], config["port"], host.Hostname, host.Port)
+			}
+			if host.UserOverride != "" && config["user"] != host.UserOverride {
+				t.Errorf("ssh connects as %s, kport uses %s", config["user"], host.UserOverride)
+			}
+		})
+	}
+}
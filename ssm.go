@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// TransportSSM marks hosts reached through an AWS SSM Session Manager session
+const TransportSSM = "ssm"
+
+// instanceIDPattern matches EC2 instance IDs and SSM managed instance IDs
+var instanceIDPattern = regexp.MustCompile(`^m?i-[0-9a-f]{8,17}$`)
+
+// SSMConfig holds the SSM settings for a host
+type SSMConfig struct {
+	// InstanceID is the EC2 or managed instance ID to start the session with
+	InstanceID string `yaml:"instance_id"`
+
+	// Region and Profile are passed to the AWS CLI, defaulting to its own configuration
+	Region  string `yaml:"region"`
+	Profile string `yaml:"profile"`
+}
+
+// ssmConfigFor returns the SSM settings for host, using the host name as the
+// instance ID when it looks like one
+func ssmConfigFor(host SSHHost, hostConfig HostConfig) SSMConfig {
+	ssm := hostConfig.SSM
+	if ssm.InstanceID == "" && instanceIDPattern.MatchString(host.Hostname) {
+		ssm.InstanceID = host.Hostname
+	}
+	return ssm
+}
+
+// ssmProxyCommand returns an ssh ProxyCommand that tunnels the connection
+// through an SSM session using the session-manager-plugin
+func (h SSHHost) ssmProxyCommand() string {
+	args := []string{
+		"aws", "ssm", "start-session",
+		"--target", h.SSM.InstanceID,
+		"--document-name", "AWS-StartSSHSession",
+		"--parameters", "portNumber=%p",
+	}
+	if h.SSM.Region != "" {
+		args = append(args, "--region", h.SSM.Region)
+	}
+	if h.SSM.Profile != "" {
+		args = append(args, "--profile", h.SSM.Profile)
+	}
+	return fmt.Sprintf("ProxyCommand=%s", strings.Join(args, " "))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// TransportTeleport marks hosts reached through a Teleport proxy
+const TransportTeleport = "teleport"
+
+// teleportNodePort is the port Teleport SSH nodes listen on
+const teleportNodePort = "3022"
+
+// TeleportConfig configures listing and connecting to Teleport nodes
+type TeleportConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Proxy and Cluster select the Teleport cluster, defaulting to the active tsh profile
+	Proxy   string `yaml:"proxy"`
+	Cluster string `yaml:"cluster"`
+
+	// Login is the remote user, defaulting to the first login allowed by the tsh profile
+	Login string `yaml:"login"`
+}
+
+// teleportStatus is the subset of `tsh status --format=json` kport uses
+type teleportStatus struct {
+	Active struct {
+		Cluster string   `json:"cluster"`
+		Logins  []string `json:"logins"`
+	} `json:"active"`
+}
+
+// teleportNode is the subset of a node in `tsh ls --format=json` kport uses
+type teleportNode struct {
+	Spec struct {
+		Hostname string `json:"hostname"`
+	} `json:"spec"`
+}
+
+// teleportSSHConfigPath returns where the OpenSSH config generated by `tsh config` is kept
+func teleportSSHConfigPath() (string, error) {
+	dir, err := kportCacheDir("teleport")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ssh_config"), nil
+}
+
+// tshArgs returns the tsh flags that select the configured proxy and cluster
+func (tc TeleportConfig) tshArgs() []string {
+	args := make([]string, 0)
+	if tc.Proxy != "" {
+		args = append(args, "--proxy="+tc.Proxy)
+	}
+	if tc.Cluster != "" {
+		args = append(args, "--cluster="+tc.Cluster)
+	}
+	return args
+}
+
+// loadTeleportHosts lists the Teleport nodes the user can access and prepares
+// the OpenSSH config that routes ssh through `tsh proxy ssh`
+func loadTeleportHosts(tc TeleportConfig) ([]SSHHost, error) {
+	statusOutput, err := exec.Command("tsh", append([]string{"status", "--format=json"}, tc.tshArgs()...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tsh status (are you logged in with tsh login?): %w", err)
+	}
+
+	var status teleportStatus
+	if err := json.Unmarshal(statusOutput, &status); err != nil {
+		return nil, fmt.Errorf("failed to parse tsh status: %w", err)
+	}
+
+	cluster := tc.Cluster
+	if cluster == "" {
+		cluster = status.Active.Cluster
+	}
+	if cluster == "" {
+		return nil, fmt.Errorf("no active Teleport cluster")
+	}
+
+	login := tc.Login
+	if login == "" && len(status.Active.Logins) > 0 {
+		login = status.Active.Logins[0]
+	}
+
+	// tsh config prints an OpenSSH config with the tsh certificates and a
+	// ProxyCommand for *.<cluster> hosts
+	sshConfig, err := exec.Command("tsh", append([]string{"config"}, tc.tshArgs()...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate SSH config with tsh config: %w", err)
+	}
+	configPath, err := teleportSSHConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(configPath, sshConfig, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write Teleport SSH config: %w", err)
+	}
+
+	lsOutput, err := exec.Command("tsh", append([]string{"ls", "--format=json"}, tc.tshArgs()...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Teleport nodes: %w", err)
+	}
+
+	var nodes []teleportNode
+	if err := json.Unmarshal(lsOutput, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to parse Teleport nodes: %w", err)
+	}
+
+	hosts := make([]SSHHost, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Spec.Hostname == "" {
+			continue
+		}
+		hosts = append(hosts, SSHHost{
+			Name:      fmt.Sprintf("%s.%s", node.Spec.Hostname, cluster),
+			Hostname:  node.Spec.Hostname,
+			User:      login,
+			Port:      teleportNodePort,
+			Transport: TransportTeleport,
+		})
+	}
+
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Name < hosts[j].Name
+	})
+
+	return hosts, nil
+}
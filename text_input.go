@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/cursor"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// textInputKeys are the editing keys of the TUI's text inputs. Up, down and
+// tab are left to the views, which use them for suggestions and fields.
+var textInputKeys = func() textinput.KeyMap {
+	keys := textinput.DefaultKeyMap
+	keys.AcceptSuggestion.SetEnabled(false)
+	keys.NextSuggestion.SetEnabled(false)
+	keys.PrevSuggestion.SetEnabled(false)
+	return keys
+}()
+
+// newTextInput creates a text input of width columns that takes at most limit
+// characters, with validate checking the value after every edit
+func newTextInput(placeholder string, width, limit int, validate textinput.ValidateFunc) textinput.Model {
+	input := textinput.New()
+	input.Prompt = ""
+	input.Placeholder = placeholder
+	input.PlaceholderStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("#666666")).Italic(true)
+	input.Width = width
+	input.CharLimit = limit
+	input.Validate = validate
+	input.KeyMap = textInputKeys
+	input.Cursor.Style = lipgloss.NewStyle().Foreground(lipgloss.Color("#FF75B7"))
+	// A blinking cursor would redraw the whole view twice a second
+	input.Cursor.SetMode(cursor.CursorStatic)
+	return input
+}
+
+// resetTextInput replaces the value of input and focuses it
+func resetTextInput(input *textinput.Model, value string) {
+	input.SetValue(value)
+	input.CursorEnd()
+	input.Focus()
+}
+
+// isTextEditKey reports whether msg moves the cursor of a text input, deletes
+// or pastes, rather than triggering an action of the keymap
+func isTextEditKey(msg tea.KeyMsg) bool {
+	if msg.Paste {
+		return true
+	}
+	keys := textInputKeys
+	return key.Matches(msg,
+		keys.CharacterForward, keys.CharacterBackward,
+		keys.WordForward, keys.WordBackward,
+		keys.DeleteWordBackward, keys.DeleteWordForward,
+		keys.DeleteAfterCursor, keys.DeleteBeforeCursor,
+		keys.DeleteCharacterBackward, keys.DeleteCharacterForward,
+		keys.LineStart, keys.LineEnd, keys.Paste,
+	)
+}
+
+// renderTextInput renders input in a box, with its validation message below
+// it. An input without focus gets a dimmed border.
+func renderTextInput(input textinput.Model) string {
+	border := lipgloss.Color("#7D56F4")
+	if !input.Focused() {
+		border = lipgloss.Color("#666666")
+	}
+	if input.Err != nil {
+		border = lipgloss.Color("#FF5F87")
+	}
+	boxStyle := lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(border).
+		Padding(0, 1).
+		Width(input.Width + 3).
+		Align(lipgloss.Left)
+
+	view := boxStyle.Render(input.View())
+	if input.Err != nil {
+		errStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+		view += "\n" + errStyle.Render("✗ "+input.Err.Error())
+	}
+	return view
+}
+
+// validatePortInput accepts a port number from 1 to 65535, or nothing yet
+func validatePortInput(value string) error {
+	if value == "" {
+		return nil
+	}
+	port, err := strconv.Atoi(value)
+	if err != nil || strings.ContainsAny(value, "+-") {
+		return fmt.Errorf("port must be a number")
+	}
+	if port < 1 || port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535")
+	}
+	return nil
+}
+
+// validateUserInput rejects what ssh would take as more than a user name
+func validateUserInput(value string) error {
+	if strings.ContainsAny(value, " \t@") {
+		return fmt.Errorf("a user name can't contain spaces or @")
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// throughputInterval is how often the traffic of a tunnel is sampled
+	throughputInterval = time.Second
+
+	// maxThroughputSamples keeps a day of samples per tunnel
+	maxThroughputSamples = 24 * 60 * 60
+
+	// throughputGraphWidth is how many samples the forwarding view graphs
+	throughputGraphWidth = 60
+)
+
+// throughputFormats are the formats throughput samples can be exported in
+var throughputFormats = []string{"csv", "json"}
+
+// sparkBars draw the throughput graph, from no traffic to the busiest sample shown
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// ThroughputSample is the traffic of a tunnel during one throughputInterval
+type ThroughputSample struct {
+	Time        time.Time `json:"time"`
+	BytesIn     int64     `json:"bytes_in"`
+	BytesOut    int64     `json:"bytes_out"`
+	ActiveConns int       `json:"active_conns"`
+}
+
+// TunnelThroughput is the throughput of a tunnel over its lifetime, as exported
+type TunnelThroughput struct {
+	Host            string             `json:"host"`
+	LocalPort       int                `json:"local_port"`
+	RemotePort      int                `json:"remote_port"`
+	IntervalSeconds float64            `json:"interval_seconds"`
+	Samples         []ThroughputSample `json:"samples"`
+}
+
+// ThroughputExportedMsg is sent when the TUI has written the throughput of a tunnel
+type ThroughputExportedMsg struct {
+	Paths []string
+	Count int
+	Err   error
+}
+
+// throughputHistory holds the throughput samples of a tunnel
+type throughputHistory struct {
+	mu       sync.Mutex
+	samples  []ThroughputSample
+	lastIn   int64
+	lastOut  int64
+	lastTime time.Time
+}
+
+// record adds a sample with the traffic since the last one, given the byte
+// counts of the tunnel so far
+func (th *throughputHistory) record(now time.Time, stats ConnStats) {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+
+	if !th.lastTime.IsZero() {
+		// The oldest tenth goes at once, so the samples are rarely copied
+		if len(th.samples) >= maxThroughputSamples {
+			n := copy(th.samples, th.samples[maxThroughputSamples/10:])
+			th.samples = th.samples[:n]
+		}
+		th.samples = append(th.samples, ThroughputSample{
+			Time:        now,
+			BytesIn:     stats.BytesIn - th.lastIn,
+			BytesOut:    stats.BytesOut - th.lastOut,
+			ActiveConns: stats.Active,
+		})
+	}
+	th.lastIn, th.lastOut, th.lastTime = stats.BytesIn, stats.BytesOut, now
+}
+
+// last returns a copy of the n most recent samples, or of all of them when n
+// is 0, the most recent last
+func (th *throughputHistory) last(n int) []ThroughputSample {
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	samples := th.samples
+	if n > 0 && len(samples) > n {
+		samples = samples[len(samples)-n:]
+	}
+	return append([]ThroughputSample(nil), samples...)
+}
+
+// recordThroughput samples the traffic of the tunnel until it stops
+func (pf *PortForwarder) recordThroughput() {
+	defer pf.wg.Done()
+
+	ticker := time.NewTicker(throughputInterval)
+	defer ticker.Stop()
+
+	pf.throughput.record(time.Now(), pf.ConnStats())
+	for {
+		select {
+		case <-pf.stopChan:
+			return
+		case now := <-ticker.C:
+			pf.throughput.record(now, pf.ConnStats())
+		}
+	}
+}
+
+// Throughput returns the throughput of the tunnel since it started
+func (pf *PortForwarder) Throughput() TunnelThroughput {
+	return TunnelThroughput{
+		Host:            pf.Host().Name,
+		LocalPort:       pf.LocalPort(),
+		RemotePort:      pf.RemotePort(),
+		IntervalSeconds: throughputInterval.Seconds(),
+		Samples:         pf.throughput.last(0),
+	}
+}
+
+// formatThroughput renders throughput samples as CSV or JSON
+func formatThroughput(throughput TunnelThroughput, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(throughput, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case "csv":
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write([]string{"time", "bytes_in", "bytes_out", "active_conns"})
+		for _, sample := range throughput.Samples {
+			w.Write([]string{
+				sample.Time.Format(time.RFC3339),
+				strconv.FormatInt(sample.BytesIn, 10),
+				strconv.FormatInt(sample.BytesOut, 10),
+				strconv.Itoa(sample.ActiveConns),
+			})
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	default:
+		return nil, fmt.Errorf("unknown format %q, use one of %s", format, strings.Join(throughputFormats, ", "))
+	}
+}
+
+// ExportThroughput writes the throughput samples of a tunnel as CSV and JSON
+// files in kport's cache directory, ready to attach to a report of a slow path
+func ExportThroughput(pf *PortForwarder) tea.Cmd {
+	return func() tea.Msg {
+		throughput := pf.Throughput()
+		dir, err := kportCacheDir("exports")
+		if err != nil {
+			return ThroughputExportedMsg{Err: err}
+		}
+
+		name := fmt.Sprintf("throughput-%s-%d-%s", throughput.Host, throughput.RemotePort, time.Now().Format("20060102-150405"))
+		paths := make([]string, 0, len(throughputFormats))
+		for _, format := range throughputFormats {
+			data, err := formatThroughput(throughput, format)
+			if err != nil {
+				return ThroughputExportedMsg{Err: err}
+			}
+			path := filepath.Join(dir, name+"."+format)
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				return ThroughputExportedMsg{Err: fmt.Errorf("failed to write throughput samples: %w", err)}
+			}
+			paths = append(paths, path)
+		}
+		return ThroughputExportedMsg{Paths: paths, Count: len(throughput.Samples)}
+	}
+}
+
+// RecentThroughput returns the n most recent throughput samples of the tunnel
+func (pf *PortForwarder) RecentThroughput(n int) []ThroughputSample {
+	return pf.throughput.last(n)
+}
+
+// sparkline graphs values as a row of bars scaled to the largest of them
+func sparkline(values []int64) string {
+	var peak int64
+	for _, value := range values {
+		peak = max(peak, value)
+	}
+	var s strings.Builder
+	for _, value := range values {
+		bar := 0
+		if peak > 0 {
+			bar = int(value * int64(len(sparkBars)-1) / peak)
+		}
+		s.WriteRune(sparkBars[bar])
+	}
+	return s.String()
+}
+
+// renderThroughput graphs the traffic of samples in each direction, with the
+// rate of the latest one
+func renderThroughput(samples []ThroughputSample) string {
+	if len(samples) == 0 {
+		return ""
+	}
+	in := make([]int64, len(samples))
+	out := make([]int64, len(samples))
+	for i, sample := range samples {
+		in[i], out[i] = sample.BytesIn, sample.BytesOut
+	}
+	latest := samples[len(samples)-1]
+	perSecond := func(n int64) string {
+		return formatBytes(int64(float64(n)/throughputInterval.Seconds())) + "/s"
+	}
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "  ↓ %s %s\n", sparkline(in), perSecond(latest.BytesIn))
+	fmt.Fprintf(&s, "  ↑ %s %s\n", sparkline(out), perSecond(latest.BytesOut))
+	return s.String()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+const (
+	// toastDuration is how long a notification stays on screen, errors twice as long
+	toastDuration = 4 * time.Second
+
+	// maxToasts is how many notifications show at once, the oldest going first
+	maxToasts = 3
+)
+
+// toastKind sets the icon and color of a notification
+type toastKind int
+
+const (
+	toastInfo toastKind = iota
+	toastSuccess
+	toastError
+)
+
+// toast is a notification shown below the header, whatever the view, until
+// it times out
+type toast struct {
+	id   int
+	kind toastKind
+	text string
+}
+
+// toastExpiredMsg dismisses the notification with ID
+type toastExpiredMsg struct {
+	ID int
+}
+
+// notify shows text as a notification, which unlike the message of a view
+// survives moving to another view, and returns the command dismissing it
+func (m *Model) notify(kind toastKind, text string) tea.Cmd {
+	m.toastSeq++
+	id := m.toastSeq
+	m.toasts = append(m.toasts, toast{id: id, kind: kind, text: text})
+	if len(m.toasts) > maxToasts {
+		m.toasts = m.toasts[len(m.toasts)-maxToasts:]
+	}
+
+	duration := toastDuration
+	if kind == toastError {
+		duration *= 2
+	}
+	return tea.Tick(duration, func(time.Time) tea.Msg { return toastExpiredMsg{ID: id} })
+}
+
+// dismissToast removes a notification once it timed out
+func (m *Model) dismissToast(msg toastExpiredMsg) (tea.Model, tea.Cmd) {
+	for i, t := range m.toasts {
+		if t.id == msg.ID {
+			m.toasts = append(m.toasts[:i:i], m.toasts[i+1:]...)
+			break
+		}
+	}
+	return m, nil
+}
+
+// renderToasts renders the notifications on screen, the newest last
+func (m *Model) renderToasts() string {
+	if len(m.toasts) == 0 {
+		return ""
+	}
+
+	var s strings.Builder
+	for _, t := range m.toasts {
+		switch t.kind {
+		case toastSuccess:
+			s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575")).Render("✓ " + t.text))
+		case toastError:
+			s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87")).Render("✗ " + t.text))
+		default:
+			s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#00BFFF")).Render("• " + t.text))
+		}
+		s.WriteString("\n")
+	}
+	s.WriteString("\n")
+	return s.String()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracingShutdownTimeout bounds how long exiting waits for pending spans to be exported
+const tracingShutdownTimeout = 5 * time.Second
+
+// sshReadyTimeout bounds how long a traced tunnel waits for ssh to open its forward
+const sshReadyTimeout = 60 * time.Second
+
+// tracer creates kport's spans. Spans are dropped unless initTracing installed an exporter.
+var tracer = otel.Tracer("kport")
+
+// tracingEnabled is set when spans are exported, so hot paths can skip building them
+var tracingEnabled bool
+
+// initTracing exports spans over OTLP/HTTP when an endpoint is configured with the
+// standard OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+// variables. The returned function flushes pending spans.
+func initTracing() func() {
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func() {}
+	}
+
+	ctx := context.Background()
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to create OTLP exporter: %v\n", err)
+		return func() {}
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "kport")),
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Incomplete tracing resource: %v\n", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	tracingEnabled = true
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to flush traces: %v\n", err)
+		}
+	}
+}
+
+// endSpan records err on span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// hostAttributes describes the host a span is about
+func hostAttributes(host SSHHost) []attribute.KeyValue {
+	transport := host.Transport
+	if transport == "" {
+		transport = "ssh"
+	}
+	return []attribute.KeyValue{
+		attribute.String("kport.host", host.Name),
+		attribute.String("kport.transport", transport),
+	}
+}
+
+// tracedPrepareHost runs prepareHost in a span, so slow pre-connect hooks show up in traces
+func tracedPrepareHost(ctx context.Context, host SSHHost) (SSHHost, error) {
+	_, span := tracer.Start(ctx, "kport.pre_connect", trace.WithAttributes(
+		attribute.Bool("kport.pre_connect.configured", host.PreConnect != ""),
+	))
+	host, err := prepareHost(host)
+	endSpan(span, err)
+	return host, err
+}
+
+// tracedOutput runs an ssh command in a span and returns its output. Every ssh
+// invocation dials and authenticates on its own, so each one shows up separately.
+func tracedOutput(ctx context.Context, name string, cmd *exec.Cmd) ([]byte, error) {
+	_, span := tracer.Start(ctx, "ssh.exec", trace.WithAttributes(attribute.String("kport.ssh.purpose", name)))
+	started := time.Now()
+	output, err := cmd.Output()
+	recordRemoteCommand(cmd, name, started, err)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		span.SetAttributes(attribute.Int("kport.ssh.exit_code", exitErr.ExitCode()))
+	}
+	span.SetAttributes(attribute.Int("kport.ssh.output_bytes", len(output)))
+	endSpan(span, err)
+	return output, err
+}
+
+// traceSSHReady records how long ssh takes to connect, authenticate and open the
+// tunnel's forward. ssh only listens on its forward port once it is authenticated,
+// so the span ends when that port accepts a connection.
+func (pf *PortForwarder) traceSSHReady(ctx context.Context, sshPort int) {
+	defer pf.wg.Done()
+
+	_, span := tracer.Start(ctx, "ssh.connect", trace.WithAttributes(hostAttributes(pf.host)...))
+	address := fmt.Sprintf("127.0.0.1:%d", sshPort)
+	deadline := time.Now().Add(sshReadyTimeout)
+
+	for time.Now().Before(deadline) {
+		if conn, err := net.DialTimeout("tcp", address, time.Second); err == nil {
+			conn.Close()
+			endSpan(span, nil)
+			return
+		}
+
+		select {
+		case <-pf.stopChan:
+			endSpan(span, fmt.Errorf("tunnel stopped before ssh was ready"))
+			return
+		case <-pf.sshDone:
+			endSpan(span, fmt.Errorf("ssh exited before the forward was ready"))
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	endSpan(span, fmt.Errorf("ssh not ready after %s", sshReadyTimeout))
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	// maxTranscriptSize is how large a host's transcript grows before it is
+	// moved aside for a new one
+	maxTranscriptSize = 1 << 20
+
+	// transcriptPreview is how many commands the host information panel shows
+	transcriptPreview = 8
+)
+
+// sshValueFlags are the ssh flags that take a value as the next argument
+const sshValueFlags = "BbcDEeFIiJLlmOopQRSWw"
+
+// transcriptMu serializes writes to the transcripts
+var transcriptMu sync.Mutex
+
+// TranscriptEntry is a command kport ran on a remote host
+type TranscriptEntry struct {
+	Time    time.Time `json:"time"`
+	Purpose string    `json:"purpose"`
+	Command string    `json:"command"`
+
+	// ExitCode is the exit code of ssh, which is the remote command's unless
+	// ssh itself failed with 255, or -1 when it was killed or never started
+	ExitCode   int    `json:"exit_code"`
+	DurationMS int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// TranscriptLoadedMsg is sent when the transcript of a host has been read
+type TranscriptLoadedMsg struct {
+	Host    string
+	Entries []TranscriptEntry
+}
+
+// transcriptPath returns the transcript of the host ssh knows as destination
+func transcriptPath(destination string) (string, error) {
+	dir, err := kportCacheDir("transcripts")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, destination+".jsonl"), nil
+}
+
+// remoteCommandOf splits the arguments of an ssh command into its destination
+// and the command it runs there, parsing them the way ssh does
+func remoteCommandOf(args []string) (string, string) {
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			if i+1 < len(args) {
+				return args[i+1], strings.Join(args[i+2:], " ")
+			}
+			return "", ""
+		}
+		if strings.HasPrefix(arg, "-") && len(arg) > 1 {
+			if len(arg) == 2 && strings.IndexByte(sshValueFlags, arg[1]) >= 0 {
+				i++
+			}
+			continue
+		}
+		return arg, strings.Join(args[i+1:], " ")
+	}
+	return "", ""
+}
+
+// recordRemoteCommand appends cmd, an ssh command that has finished, to the
+// transcript of its host. Commands without a remote command, such as tunnels,
+// are not recorded.
+func recordRemoteCommand(cmd *exec.Cmd, purpose string, started time.Time, err error) {
+	destination, command := remoteCommandOf(cmd.Args)
+	if command == "" {
+		return
+	}
+
+	entry := TranscriptEntry{
+		Time:       started,
+		Purpose:    purpose,
+		Command:    command,
+		ExitCode:   -1,
+		DurationMS: time.Since(started).Milliseconds(),
+	}
+	if cmd.ProcessState != nil {
+		entry.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		entry.Error = err.Error()
+	}
+
+	if err := appendTranscript(destination, entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to record remote command on %s: %v\n", destination, err)
+	}
+}
+
+// appendTranscript adds an entry to a host's transcript, moving the
+// transcript to a .1 file once it reaches maxTranscriptSize
+func appendTranscript(destination string, entry TranscriptEntry) error {
+	path, err := transcriptPath(destination)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	transcriptMu.Lock()
+	defer transcriptMu.Unlock()
+
+	if info, err := os.Stat(path); err == nil && info.Size() >= maxTranscriptSize {
+		os.Rename(path, path+".1")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// readTranscript returns the commands run on host, the oldest first
+func readTranscript(host SSHHost) ([]TranscriptEntry, error) {
+	path, err := transcriptPath(host.destination())
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []TranscriptEntry
+	for _, file := range []string{path + ".1", path} {
+		f, err := os.Open(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxTranscriptSize)
+		for scanner.Scan() {
+			var entry TranscriptEntry
+			// A line cut short by a crash is skipped
+			if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// LoadTranscript reads the transcript of host in the background
+func LoadTranscript(host SSHHost) tea.Cmd {
+	return func() tea.Msg {
+		entries, err := readTranscript(host)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to read the transcript of %s: %v\n", host.Name, err)
+		}
+		return TranscriptLoadedMsg{Host: host.Name, Entries: entries}
+	}
+}
+
+// status describes how a transcript entry ended
+func (e TranscriptEntry) status() string {
+	switch {
+	case e.Error != "":
+		return "failed"
+	case e.ExitCode < 0:
+		return "killed"
+	default:
+		return fmt.Sprintf("exit %d", e.ExitCode)
+	}
+}
+
+// summary is a one-line description of the entry
+func (e TranscriptEntry) summary() string {
+	return fmt.Sprintf("%s  %-7s %6s  %s", e.Time.Format("2006-01-02 15:04:05"), e.status(), (time.Duration(e.DurationMS) * time.Millisecond).String(), e.Purpose)
+}
+
+// formatTranscript renders entries with their full commands, indented below
+// their summary
+func formatTranscript(entries []TranscriptEntry) string {
+	var s strings.Builder
+	for _, entry := range entries {
+		s.WriteString(entry.summary())
+		s.WriteString("\n")
+		for _, line := range strings.Split(strings.TrimSpace(entry.Command), "\n") {
+			s.WriteString("    " + line + "\n")
+		}
+		if entry.Error != "" {
+			s.WriteString("    error: " + entry.Error + "\n")
+		}
+	}
+	return s.String()
+}
+
+// runTranscript prints the commands kport ran on a host
+func runTranscript(args []string) error {
+	usage := fmt.Errorf("usage: kport transcript <host> [--json]")
+	asJSON := false
+	var name string
+	for _, arg := range args {
+		switch {
+		case arg == "--json":
+			asJSON = true
+		case name == "" && !strings.HasPrefix(arg, "-"):
+			name = arg
+		default:
+			return usage
+		}
+	}
+	if name == "" {
+		return usage
+	}
+
+	sshConfig := NewSSHConfig()
+	if err := sshConfig.LoadConfig(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	kportConfig, err := LoadKportConfig()
+	if err != nil {
+		return err
+	}
+	host, err := resolveHost(name, collectHosts(sshConfig, kportConfig), kportConfig)
+	if err != nil {
+		return err
+	}
+	entries, err := readTranscript(host)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Fprintf(os.Stderr, "kport has not run any commands on %s\n", name)
+		return nil
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		for _, entry := range entries {
+			if err := encoder.Encode(entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	_, err = fmt.Print(formatTranscript(entries))
+	return err
+}
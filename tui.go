@@ -1,9 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 )
@@ -18,56 +22,337 @@ const (
 	StateManualPort
 	StateStartingForward
 	StateForwarding
+	StateHostInfo
+	StateEditUser
+	StateSelectContainer
+	StateEditLabel
+	StateQuickConnect
+	StateConfirmPolicy
+	StateAlgorithms
+	StateAgentKeys
 )
 
+// tickMsg refreshes views that show live tunnel information
+type tickMsg time.Time
+
+// tick schedules the next tickMsg
+func tick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg {
+		return tickMsg(t)
+	})
+}
+
+// forwardsStarting is the status of configured forwards that are being established
+const forwardsStarting = "starting"
+
+// shareStarting is the share status while the relay opens the share link
+const shareStarting = "Opening a share link..."
+
+// ttlPresets are the tunnel time limits cycled through with the t key
+var ttlPresets = []time.Duration{0, 15 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour, 4 * time.Hour}
+
 // Model represents the TUI model
 type Model struct {
 	state       AppState
 	sshConfig   *SSHConfig
+	kportConfig *KportConfig
 	hosts       []SSHHost
 	selectedHost int
 	ports       []int
-	selectedPort int
+	portsCachedAt time.Time
+	portsRefreshing bool
+	newPorts     map[int]bool
+	removedPorts []int
+	portDiffAt   time.Time
+	portWatchGen int
+	processes    map[int]string
+	addresses    map[int]string
+	portFilter   PortCategory
+	containers   []Container
+	containersLoading bool
+	container    *Container
+	containerPorts []int
+	containerPortsLoading bool
+	httpProbes   map[int]HTTPProbe
+	probingHTTP  bool
 	cursor      int
-	manualPort  string
+	portInput   textinput.Model
+	ttl         time.Duration
+	hostsLoading bool
+	hostsCached  bool
+	reloadStatus string
+	exportStatus string
+	modeIssues   []FileModeIssue
+	watchGen     int
+	infoHost     string
+	infoLoading  bool
+	infoErr      error
+	hostFacts    map[string]*HostFacts
+	// infoTranscript is the transcript of the host shown in the info panel
+	infoTranscript []TranscriptEntry
+	portHistory  *PortHistory
+	suggestion   int
+	userInput    textinput.Model
+	labelInput   textinput.Model
+	noteInput    textinput.Model
+	editingNote  bool
+	userOverrides map[string]string
+	relaxedAlgorithms map[string][]string
+	algorithmReport   AlgorithmReport
+	algorithmsLoading bool
+	algorithmsErr     error
+	// agentKeyChoices are the agent keys picked per host this session, and
+	// untestedAgentKey the host whose pick hasn't connected yet
+	agentKeyChoices  map[string]string
+	untestedAgentKey string
+	agentKeys        []AgentKey
+	agentKeysLoading bool
+	agentKeysErr     error
+	pickedAgentKey   string
 	forwarder   *PortForwarder
+	// forwardStart counts the tunnels started, so the result of one the user
+	// backed out of isn't taken for the one started after it
+	forwardStart int
+	tunnels     *TunnelManager
+	prewarm     *Prewarmer
+	otherTunnels []PortReservation
+	retries      RetryQueue
+	retryTicking bool
+	exitNotice   string
+	confirmInput   textinput.Model
+	confirmRisks   []Risk
+	confirmHost    string
+	confirmReturn  AppState
+	confirmProceed func(confirmed bool) tea.Cmd
+	agentForwarder *AgentForwarder
+	agentStatus    string
+	configuredForwarders map[string]*ConfiguredForwarder
+	forwardsStatus       map[string]string
+	share                *Share
+	shareStatus          string
+	// sharePort replaces the configured relay port for the next share link
+	// after the configured one turned out to be taken
+	sharePort            int
+	securityKeys         chan SecurityKeyMsg
+	securityKeyPrompts   []SecurityKeyMsg
+	quickConnectShown    bool
+	quickHosts           []string
+	reachability         map[string]HostReachability
+	jumpChains           map[string][]HopStatus
+	handshakes           chan HandshakeMsg
+	handshake            *HandshakeProgress
+	keys        KeyMap
+	showHelp    bool
+	width       int
 	message     string
+	toasts      []toast
+	toastSeq    int
 	err         error
 }
 
 // NewModel creates a new TUI model
 func NewModel() *Model {
 	return &Model{
-		state:     StateSelectHost,
-		sshConfig: NewSSHConfig(),
-		cursor:    0,
+		state:       StateSelectHost,
+		sshConfig:   NewSSHConfig(),
+		kportConfig: NewKportConfig(),
+		cursor:      0,
+		tunnels:     NewTunnelManager(),
+		hostFacts:   make(map[string]*HostFacts),
+		httpProbes:  make(map[int]HTTPProbe),
+		portHistory: &PortHistory{Hosts: make(map[string]*HostPortHistory)},
+		suggestion:  -1,
+		portInput:   newTextInput("e.g., 3000", 16, 32, validatePortInput),
+		userInput:   newTextInput("configured user", 26, 64, validateUserInput),
+		labelInput:  newTextInput("e.g., staging db", 46, 60, nil),
+		noteInput:   newTextInput("what the tunnel is for", 46, 200, nil),
+		confirmInput: newTextInput("host name", 46, 255, nil),
+		userOverrides: make(map[string]string),
+		relaxedAlgorithms: make(map[string][]string),
+		agentKeyChoices:   make(map[string]string),
+		configuredForwarders: make(map[string]*ConfiguredForwarder),
+		forwardsStatus:       make(map[string]string),
+		reachability:         make(map[string]HostReachability),
+		jumpChains:           make(map[string][]HopStatus),
+		keys:        DefaultKeyMap(),
 	}
 }
 
 // Init initializes the model
 func (m *Model) Init() tea.Cmd {
-	// Load SSH config
-	if err := m.sshConfig.LoadConfig(); err != nil {
-		m.err = err
+	// Show the hosts from the previous run while the configs are parsed
+	if hosts, err := loadHostSnapshot(); err == nil {
+		m.hosts = hosts
+		m.hostsCached = true
+	}
+	m.hostsLoading = true
+	m.portHistory = LoadPortHistory()
+
+	// Security key prompts of ssh commands are shown until the key is touched
+	m.securityKeys = make(chan SecurityKeyMsg)
+	setSecurityKeyHandler(func(msg SecurityKeyMsg) { m.securityKeys <- msg })
+
+	// The connecting view follows the steps of the handshake
+	m.handshakes = make(chan HandshakeMsg)
+	setHandshakeHandler(func(msg HandshakeMsg) { m.handshakes <- msg })
+
+	return tea.Batch(LoadHosts(), waitForSecurityKey(m.securityKeys), waitForHandshake(m.handshakes))
+}
+
+// updateHostsLoaded merges freshly parsed hosts into the model
+func (m *Model) updateHostsLoaded(msg HostsLoadedMsg) (tea.Model, tea.Cmd) {
+	reloading := len(m.hosts) > 0 && !m.hostsCached
+	m.hostsLoading = false
+	m.hostsCached = false
+
+	// Restart watching from the files this load read; older watches are ignored
+	m.watchGen++
+	watch := watchConfig(m.watchGen, msg.ConfigPaths, msg.ConfigStamp)
+
+	if msg.Err != nil {
+		// Keep the current hosts when a reload fails
+		if reloading {
+			m.reloadStatus = fmt.Sprintf("Config reload failed: %v", msg.Err)
+			return m, watch
+		}
+		m.err = msg.Err
 		// Don't quit immediately, let user see the error
-		return nil
+		return m, watch
 	}
-	m.hosts = m.sshConfig.GetHosts()
-	
+
+	m.sshConfig = msg.SSHConfig
+	m.kportConfig = msg.KportConfig
+	setAuditConfig(m.kportConfig.Audit)
+	setPolicy(m.kportConfig.Policy)
+	setStrictModes(m.kportConfig.StrictModes)
+	setFileLimit(m.kportConfig.FileLimit)
+	if keys, err := m.kportConfig.Keys.KeyMap(); err == nil {
+		m.keys = keys
+	}
+	m.reloadStatus = ""
+	m.modeIssues = msg.FileModeIssues
+	m.setHosts(msg.Hosts)
+	prewarms := m.syncPrewarm()
+
 	// Check if we have any hosts
 	if len(m.hosts) == 0 {
 		m.err = fmt.Errorf("no SSH hosts found in config file")
+	} else if m.state == StateSelectHost {
+		// A config error shown earlier has been fixed
+		m.err = nil
+	}
+
+	prewarms = append(prewarms, m.startQuickConnect()...)
+	return m, tea.Batch(append(prewarms, watch)...)
+}
+
+// syncPrewarm keeps prewarmed connections open to the pinned hosts while prewarming is enabled
+func (m *Model) syncPrewarm() []tea.Cmd {
+	if !m.kportConfig.Prewarm.Enabled {
+		if m.prewarm != nil {
+			m.prewarm.Stop()
+			m.prewarm = nil
+		}
 		return nil
 	}
-	
-	return nil
+
+	if m.prewarm == nil {
+		m.prewarm = NewPrewarmer(m.kportConfig.Prewarm)
+	}
+	pinned := make([]SSHHost, 0)
+	for _, host := range m.hosts {
+		if m.kportConfig.Hosts[host.Name].Pinned {
+			pinned = append(pinned, host)
+		}
+	}
+	return m.prewarm.Sync(pinned)
+}
+
+// reloadHosts re-parses the SSH and kport configs unless a load is already running
+func (m *Model) reloadHosts() tea.Cmd {
+	if m.hostsLoading {
+		return nil
+	}
+	m.hostsLoading = true
+	return LoadHosts()
+}
+
+// setHosts replaces the host list, keeping the cursor and selection on the same hosts
+func (m *Model) setHosts(hosts []SSHHost) {
+	cursorName := m.hostNameAt(m.cursor)
+	selectedName := m.hostNameAt(m.selectedHost)
+
+	// Keep a host that is in use even if it was removed from the config,
+	// so the active session keeps pointing at it
+	if m.state != StateSelectHost && m.state != StateQuickConnect && selectedName != "" && !containsHost(hosts, selectedName) {
+		hosts = append(hosts, m.hosts[m.selectedHost])
+	}
+
+	m.hosts = hosts
+	for i := range m.hosts {
+		m.hosts[i].UserOverride = m.userOverrides[m.hosts[i].Name]
+		if options, ok := m.relaxedAlgorithms[m.hosts[i].Name]; ok {
+			m.hosts[i].AlgorithmOptions = options
+		}
+		if key, ok := m.agentKeyChoices[m.hosts[i].Name]; ok {
+			m.hosts[i].AgentKey = key
+		}
+	}
+
+	if m.state == StateSelectHost {
+		m.cursor = m.hostIndex(cursorName, m.cursor)
+	}
+	m.selectedHost = m.hostIndex(selectedName, m.selectedHost)
+}
+
+// containsHost reports whether hosts has a host with the given name
+func containsHost(hosts []SSHHost, name string) bool {
+	for _, host := range hosts {
+		if host.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// hostNameAt returns the name of the host at index i, or "" if out of range
+func (m *Model) hostNameAt(i int) string {
+	if i < 0 || i >= len(m.hosts) {
+		return ""
+	}
+	return m.hosts[i].Name
+}
+
+// hostIndex returns the index of the named host, or fallback clamped to the host list
+func (m *Model) hostIndex(name string, fallback int) int {
+	for i, host := range m.hosts {
+		if host.Name == name {
+			return i
+		}
+	}
+	if fallback >= len(m.hosts) {
+		fallback = len(m.hosts) - 1
+	}
+	if fallback < 0 {
+		fallback = 0
+	}
+	return fallback
 }
 
 // Update handles messages and updates the model
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.showHelp {
+			return m.updateHelp(msg)
+		}
+		if m.state != StateEditUser && m.state != StateEditLabel && m.state != StateConfirmPolicy && m.keys.Action(m.state, msg) == ActionHelp {
+			m.showHelp = true
+			return m, nil
+		}
 		switch m.state {
+		case StateQuickConnect:
+			return m.updateQuickConnect(msg)
 		case StateSelectHost:
 			return m.updateHostSelection(msg)
 		case StateConnecting:
@@ -80,27 +365,217 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.updateStartingForward(msg)
 		case StateForwarding:
 			return m.updateForwarding(msg)
+		case StateHostInfo:
+			return m.updateHostInfo(msg)
+		case StateEditUser:
+			return m.updateEditUser(msg)
+		case StateSelectContainer:
+			return m.updateContainerSelection(msg)
+		case StateEditLabel:
+			return m.updateEditLabel(msg)
+		case StateConfirmPolicy:
+			return m.updateConfirmPolicy(msg)
+		case StateAlgorithms:
+			return m.updateAlgorithms(msg)
+		case StateAgentKeys:
+			return m.updateAgentKeys(msg)
 		}
-	case PortsDetectedMsg:
-		m.ports = msg.Ports
-		m.state = StateSelectPort
-		m.cursor = 0
-		// Set a message about the connection attempt
-		if len(msg.Ports) == 0 {
-			m.message = fmt.Sprintf("Could not connect to %s or no ports detected", m.hosts[m.selectedHost].Name)
+	case tea.WindowSizeMsg:
+		m.width = msg.Width
+		return m, nil
+	case InventoryExportedMsg:
+		if msg.Err != nil {
+			m.exportStatus = fmt.Sprintf("Export failed: %v", msg.Err)
 		} else {
-			m.message = ""
+			m.exportStatus = fmt.Sprintf("Exported %d active tunnels to %s", msg.Count, msg.Path)
 		}
 		return m, nil
-	case ForwardingStartedMsg:
-		if msg.LocalPort == msg.RemotePort {
-			m.message = fmt.Sprintf("Port forwarding started: localhost:%d -> %s:%d (same port)", 
-				msg.LocalPort, m.hosts[m.selectedHost].Name, msg.RemotePort)
+	case ThroughputExportedMsg:
+		if msg.Err != nil {
+			m.exportStatus = fmt.Sprintf("Export failed: %v", msg.Err)
 		} else {
-			m.message = fmt.Sprintf("Port forwarding started: localhost:%d -> %s:%d (port %d was unavailable)", 
-				msg.LocalPort, m.hosts[m.selectedHost].Name, msg.RemotePort, msg.RemotePort)
+			m.exportStatus = fmt.Sprintf("Exported %d throughput samples to %s", msg.Count, strings.Join(msg.Paths, " and "))
+		}
+		return m, nil
+	case HostPrewarmedMsg:
+		// The host list shows which prewarmed connections are up
+		return m, nil
+	case HostReachableMsg:
+		m.updateHostReachable(msg)
+		return m, nil
+	case JumpHopCheckedMsg:
+		return m, m.updateJumpHopChecked(msg)
+	case HandshakeMsg:
+		return m, m.updateHandshake(msg)
+	case SecurityKeyMsg:
+		m.securityKeyPrompts = slices.DeleteFunc(m.securityKeyPrompts, func(prompt SecurityKeyMsg) bool {
+			return prompt.ID == msg.ID
+		})
+		if !msg.Done {
+			m.securityKeyPrompts = append(m.securityKeyPrompts, msg)
+		}
+		return m, waitForSecurityKey(m.securityKeys)
+	case HostsLoadedMsg:
+		return m.updateHostsLoaded(msg)
+	case configCheckedMsg:
+		if msg.Generation != m.watchGen {
+			return m, nil
+		}
+		if msg.Changed {
+			return m, m.reloadHosts()
+		}
+		return m, watchConfig(m.watchGen, msg.Paths, msg.Stamp)
+	case HostInfoMsg:
+		if msg.Host == m.infoHost {
+			m.infoLoading = false
+			m.infoErr = msg.Err
+		}
+		if msg.Facts != nil {
+			m.hostFacts[msg.Host] = msg.Facts
+		}
+		// Gathering the facts ran a command on the host
+		if msg.Host == m.infoHost && m.state == StateHostInfo {
+			return m, LoadTranscript(m.hosts[m.hostIndex(m.infoHost, m.cursor)])
+		}
+		return m, nil
+	case TranscriptLoadedMsg:
+		if msg.Host == m.infoHost {
+			m.infoTranscript = msg.Entries
+		}
+		return m, nil
+	case AgentForwardingMsg:
+		if msg.Err != nil {
+			m.agentStatus = fmt.Sprintf("Agent forwarding failed: %v", msg.Err)
+			return m, nil
+		}
+		m.agentForwarder = msg.Forwarder
+		m.agentStatus = ""
+		return m, m.notify(toastSuccess, fmt.Sprintf("SSH agent forwarded to %s", msg.Forwarder.Host().Name))
+	case ConfiguredForwardsMsg:
+		return m.updateConfiguredForwards(msg)
+	case ShareStartedMsg:
+		// The tunnel was closed while the share started, so nothing is left to share
+		if m.state != StateForwarding || m.shareStatus != shareStarting {
+			if msg.Share != nil {
+				msg.Share.Stop()
+			}
+			return m, nil
+		}
+		m.share = msg.Share
+		m.shareStatus = ""
+		if msg.Err != nil {
+			m.shareStatus = fmt.Sprintf("Sharing failed: %v", msg.Err)
+			var inUse *RemotePortInUseError
+			if errors.As(msg.Err, &inUse) && inUse.Alternative > 0 {
+				m.sharePort = inUse.Alternative
+				m.shareStatus += fmt.Sprintf(", press %s to share on it", m.keys.Label(ActionShare))
+			}
+		}
+		return m, nil
+	case portWatchMsg:
+		return m.updatePortWatch(msg)
+	case portDiffExpiredMsg:
+		return m.updatePortDiffExpired(msg)
+	case toastExpiredMsg:
+		return m.dismissToast(msg)
+	case PortsDetectedMsg:
+		return m.updatePortsDetected(msg)
+	case ContainersListedMsg:
+		if msg.Host != m.hostNameAt(m.selectedHost) || m.state != StateSelectContainer {
+			return m, nil
+		}
+		m.containersLoading = false
+		m.containers = msg.Containers
+		m.message = ""
+		if msg.Err != nil {
+			m.message = fmt.Sprintf("Error: %v", msg.Err)
+		}
+		return m, nil
+	case ContainerPortsDetectedMsg:
+		if msg.Host != m.hostNameAt(m.selectedHost) || m.container == nil || msg.Container != m.container.Name {
+			return m, nil
+		}
+		m.containerPortsLoading = false
+		m.containerPorts = msg.Ports
+		m.message = ""
+		if msg.Err != nil {
+			m.message = fmt.Sprintf("Error: %v", msg.Err)
+		}
+		return m, nil
+	case AgentKeysMsg:
+		return m.updateAgentKeysListed(msg)
+	case AlgorithmsDiagnosedMsg:
+		return m.updateAlgorithmsDiagnosed(msg)
+	case HTTPProbedMsg:
+		if msg.Host != m.hostNameAt(m.selectedHost) {
+			return m, nil
+		}
+		m.probingHTTP = false
+		if msg.Err != nil {
+			m.message = fmt.Sprintf("HTTP probe failed: %v", msg.Err)
+			return m, nil
+		}
+		for port, probe := range msg.Results {
+			m.httpProbes[port] = probe
 		}
+		return m, nil
+	case ForwardingStartedMsg:
+		// The user backed out while the tunnel was starting, so nobody would own it
+		if m.state != StateStartingForward || msg.Start != m.forwardStart {
+			m.tunnels.Stop(msg.Forwarder)
+			return m, nil
+		}
+		target := m.hosts[m.selectedHost].Name
+		if m.container != nil {
+			target += "/" + m.container.Name
+		}
+		m.message = ""
+		notice := m.notify(toastSuccess, startedMessage(msg, target))
+		if m.forwarder != nil {
+			m.tunnels.Stop(m.forwarder)
+		}
+		m.forwarder = msg.Forwarder
+		m.tunnels.Adopt("", msg.Forwarder)
+		m.otherTunnels = msg.OtherTunnels
 		m.state = StateForwarding
+		m.retries.Forget(m.hosts[m.selectedHost].Name, msg.RemotePort, msg.Forwarder.Options().Container)
+		// Container ports aren't ports of the host, so they stay out of its history
+		if m.container != nil {
+			return m, tea.Batch(tick(), notice)
+		}
+		m.portHistory.RecordForwarded(m.hosts[m.selectedHost].Name, msg.RemotePort)
+		// A port labeled before keeps its label
+		msg.Forwarder.SetLabel(m.portHistory.LabelFor(m.hosts[m.selectedHost].Name, msg.RemotePort))
+		return m, tea.Batch(tick(), notice, SavePortHistory(m.portHistory))
+	case ForwardFailedMsg:
+		// The user backed out while the tunnel was starting, so it isn't wanted anymore
+		if m.state != StateStartingForward || msg.Start != m.forwardStart {
+			return m, nil
+		}
+		pending := m.retries.Park(msg, time.Now())
+		m.message = fmt.Sprintf("Error: %v (retrying %s from the host list in %s)", msg.Err, pending.Target(),
+			formatCountdown(time.Until(pending.NextRetry).Round(time.Second)))
+		if len(m.ports) > 0 || m.container != nil {
+			m.state = StateSelectPort
+		} else {
+			m.state = StateManualPort
+		}
+		return m, m.startRetryTick()
+	case PendingRetriedMsg:
+		return m.updatePendingRetried(msg)
+	case BackgroundedMsg:
+		return m.updateBackgrounded(msg)
+	case retryTickMsg:
+		if len(m.retries.Pending()) == 0 {
+			m.retryTicking = false
+			return m, nil
+		}
+		return m, tea.Batch(retryTick(), m.retryDue())
+	case tickMsg:
+		// Keep refreshing only while a tunnel is active
+		if m.state == StateForwarding {
+			return m, tick()
+		}
 		return m, nil
 	case ErrorMsg:
 		// Don't quit on errors, just show them and let user continue
@@ -112,7 +587,7 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.ports = []int{} // Show empty ports list
 		case StateStartingForward:
 			// Go back to port selection or manual port depending on where we came from
-			if len(m.ports) > 0 {
+			if len(m.ports) > 0 || m.container != nil {
 				m.state = StateSelectPort
 			} else {
 				m.state = StateManualPort
@@ -120,220 +595,1362 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 		return m, nil
 	}
-	return m, nil
-}
 
-// updateHostSelection handles host selection state
-func (m *Model) updateHostSelection(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "ctrl+c", "q":
-		return m, tea.Quit
-	case "up", "k":
-		if m.cursor > 0 {
-			m.cursor--
-		}
-	case "down", "j":
-		if m.cursor < len(m.hosts)-1 {
-			m.cursor++
-		}
-	case "enter", " ":
-		m.selectedHost = m.cursor
-		m.state = StateConnecting
-		m.message = fmt.Sprintf("Connecting to %s...", m.hosts[m.selectedHost].Name)
-		// Detect ports on selected host
-		return m, DetectPorts(m.hosts[m.selectedHost])
-	case "m":
-		// Manual port forwarding
-		m.selectedHost = m.cursor
-		m.state = StateManualPort
-		m.manualPort = ""
-		return m, nil
+	// Clipboard pastes come back to the text input as messages of their own
+	if input := m.focusedInput(); input != nil {
+		var cmd tea.Cmd
+		*input, cmd = input.Update(msg)
+		return m, cmd
 	}
 	return m, nil
 }
 
-// updateConnecting handles connecting state
-func (m *Model) updateConnecting(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "ctrl+c", "q":
-		return m, tea.Quit
-	case "esc":
-		m.state = StateSelectHost
-		m.cursor = m.selectedHost
-		m.message = ""
-		return m, nil
+// focusedInput returns the text input the current view types into, if any
+func (m *Model) focusedInput() *textinput.Model {
+	switch m.state {
+	case StateManualPort:
+		return &m.portInput
+	case StateEditUser:
+		return &m.userInput
+	case StateConfirmPolicy:
+		return &m.confirmInput
+	case StateEditLabel:
+		if m.editingNote {
+			return &m.noteInput
+		}
+		return &m.labelInput
 	}
-	return m, nil
+	return nil
 }
 
-// updatePortSelection handles port selection state
-func (m *Model) updatePortSelection(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "ctrl+c", "q":
-		return m, tea.Quit
-	case "esc":
-		m.state = StateSelectHost
-		m.cursor = m.selectedHost
+// updatePortsDetected applies a port detection result. When ports are already
+// shown, cached or from an earlier detection, the fresh result replaces them
+// and the differences are highlighted.
+func (m *Model) updatePortsDetected(msg PortsDetectedMsg) (tea.Model, tea.Cmd) {
+	// Results for a host the user has since moved away from are stale
+	if msg.Host != m.hostNameAt(m.selectedHost) {
 		return m, nil
-	case "up", "k":
-		if m.cursor > 0 {
-			m.cursor--
-		}
-	case "down", "j":
-		if m.cursor < len(m.ports)-1 {
-			m.cursor++
+	}
+	// The host's ports only feed history while a container's ports are shown
+	if m.container != nil {
+		if msg.Err != nil {
+			return m, nil
 		}
-	case "enter", " ":
-		m.selectedPort = m.cursor
-		m.state = StateStartingForward
-		m.message = "Starting port forwarding..."
-		// Start port forwarding
-		return m, StartPortForwarding(m.hosts[m.selectedHost], m.ports[m.selectedPort])
-	case "m":
-		// Manual port forwarding
-		m.state = StateManualPort
-		m.manualPort = ""
-		return m, nil
+		m.ports, m.processes, m.addresses = msg.Ports, msg.Processes, msg.Addresses
+		m.portsCachedAt = time.Time{}
+		m.portHistory.RecordDetected(msg.Host, msg.Ports)
+		return m, SavePortHistory(m.portHistory)
+	}
+	m.portsRefreshing = false
+	m.setJumpTarget(msg.Host, msg.Err)
+	// A handshake that failed on algorithms gets a comparison instead of an empty port list
+	if mismatch := algorithmMismatchOf(msg.Err); mismatch != nil && (m.state == StateConnecting || !m.portsCachedAt.IsZero()) {
+		m.message = fmt.Sprintf("Error: %v", msg.Err)
+		return m, m.showAlgorithms(*mismatch)
+	}
+	// So does one that ran out of attempts before the agent got to the right key
+	if tooManyKeys(msg.Err) && (m.state == StateConnecting || !m.portsCachedAt.IsZero()) {
+		m.message = fmt.Sprintf("Error: %v", msg.Err)
+		return m, m.showAgentKeys()
+	}
+	showing := m.state == StateConnecting || m.state == StateSelectPort
+	refresh := m.state == StateSelectPort || !m.portsCachedAt.IsZero()
+	cmds := []tea.Cmd{m.watchPorts()}
+	// A picked agent key is remembered once it has connected
+	if msg.Err == nil && msg.Host == m.untestedAgentKey {
+		cmds = append(cmds, RememberAgentKey(msg.Host, m.agentKeyChoices[msg.Host]))
+		m.untestedAgentKey = ""
 	}
-	return m, nil
-}
 
-// updateManualPort handles manual port input state
-func (m *Model) updateManualPort(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "ctrl+c", "q":
-		return m, tea.Quit
-	case "esc":
-		if len(m.ports) > 0 {
-			m.state = StateSelectPort
-		} else {
-			m.state = StateSelectHost
-		}
-		return m, nil
-	case "enter":
-		if m.manualPort != "" {
-			m.state = StateStartingForward
-			m.message = "Starting port forwarding..."
-			// Parse and start manual port forwarding
-			return m, StartManualPortForwarding(m.hosts[m.selectedHost], m.manualPort)
-		}
-	case "backspace":
-		if len(m.manualPort) > 0 {
-			m.manualPort = m.manualPort[:len(m.manualPort)-1]
-		}
-	default:
-		// Add character to manual port
-		if len(msg.String()) == 1 && msg.String() >= "0" && msg.String() <= "9" {
-			m.manualPort += msg.String()
+	if msg.Err != nil {
+		if refresh {
+			cmds = append(cmds, m.notify(toastError, fmt.Sprintf("Refreshing the ports of %s failed: %v", msg.Host, msg.Err)))
+			return m, tea.Batch(cmds...)
 		}
+		msg.Ports = []int{}
 	}
-	return m, nil
-}
 
-// updateStartingForward handles the starting forward state
-func (m *Model) updateStartingForward(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "ctrl+c", "q":
-		return m, tea.Quit
-	case "esc":
-		// Cancel the forwarding attempt
+	if refresh {
+		cmds = append(cmds, m.diffPortList(msg))
+		m.portsCachedAt = time.Time{}
+	}
+
+	// Keep the cursor on the same row when the list changes under it
+	var cursorRow PortRow
+	if m.state == StateSelectPort {
+		cursorRow, _ = m.cursorRow()
+	}
+	m.ports = msg.Ports
+	if msg.Err == nil {
+		m.processes, m.addresses = msg.Processes, msg.Addresses
+	}
+	if m.state == StateConnecting {
 		m.state = StateSelectPort
-		m.message = ""
-		return m, nil
+		// Start on the port forwarded last time, so repeating it is a single Enter
+		m.cursor = m.lastForwardedIndex()
+	} else {
+		m.restoreCursor(cursorRow)
 	}
-	return m, nil
-}
 
-// updateForwarding handles forwarding state
-func (m *Model) updateForwarding(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "ctrl+c", "q":
-		if m.forwarder != nil {
-			m.forwarder.Stop()
-		}
-		return m, tea.Quit
-	case "esc":
-		if m.forwarder != nil {
-			m.forwarder.Stop()
-		}
-		m.state = StateSelectHost
-		m.cursor = 0
+	switch {
+	case !showing:
+		// The user moved on, e.g. to manual entry, and the result only feeds suggestions
+	case msg.Err != nil:
+		m.message = fmt.Sprintf("Error: %v", msg.Err)
+	case len(msg.Ports) == 0:
+		m.message = fmt.Sprintf("Could not connect to %s or no ports detected", msg.Host)
+	default:
 		m.message = ""
-		return m, nil
 	}
-	return m, nil
-}
 
-// View renders the TUI
-func (m *Model) View() string {
-	if m.err != nil {
-		errorStyle := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#FF5F87")).
-			Bold(true)
-		
-		return fmt.Sprintf("%s\n\n%s\n\nPress q to quit.", 
-			errorStyle.Render("❌ Error"), m.err.Error())
+	if len(msg.Ports) == 0 {
+		return m, tea.Batch(cmds...)
 	}
+	m.portHistory.RecordDetected(msg.Host, msg.Ports)
+	cmds = append(cmds, SavePortHistory(m.portHistory))
 
-	var s strings.Builder
-
-	// Header
-	headerStyle := lipgloss.NewStyle().
-		Bold(true).
-		Foreground(lipgloss.Color("#FAFAFA")).
-		Background(lipgloss.Color("#7D56F4")).
-		Padding(0, 1)
+	if m.kportConfig.ProbeHTTP {
+		cmds = append(cmds, m.probeHTTP())
+	}
+	return m, tea.Batch(cmds...)
+}
 
-	s.WriteString(headerStyle.Render("kport - SSH Port Forwarder"))
-	s.WriteString("\n\n")
+// portCategory returns the category of a listed port
+func (m *Model) portCategory(port int) PortCategory {
+	return categorizePort(port, m.portProcess(port))
+}
 
-	switch m.state {
-	case StateSelectHost:
-		s.WriteString(m.renderHostSelection())
-	case StateConnecting:
-		s.WriteString(m.renderConnecting())
-	case StateSelectPort:
-		s.WriteString(m.renderPortSelection())
-	case StateManualPort:
-		s.WriteString(m.renderManualPort())
-	case StateStartingForward:
-		s.WriteString(m.renderStartingForward())
-	case StateForwarding:
-		s.WriteString(m.renderForwarding())
+// portProcess returns the process listening on a listed port, if known
+func (m *Model) portProcess(port int) string {
+	if m.container != nil {
+		return ""
 	}
+	return m.processes[port]
+}
 
-	return s.String()
+// forwardOptions returns the tunnel options for a listed port
+func (m *Model) forwardOptions(port int) ForwardOptions {
+	options := forwardOptionsFor(m.hosts[m.selectedHost], m.selectedHostConfig(), port)
+	if m.container != nil {
+		// Published, destination and failover settings are about the host's ports
+		options.Destination, options.Failover = "", nil
+		options.Container = m.container.Name
+	} else {
+		options.RemoteHost = m.addresses[port]
+	}
+	return options
 }
 
-// renderHostSelection renders the host selection view
-func (m *Model) renderHostSelection() string {
-	var s strings.Builder
-	
-	s.WriteString("Select an SSH host:\n\n")
+// listedPorts returns the ports the port list is about: the host's, or those inside the chosen container
+func (m *Model) listedPorts() []int {
+	if m.container != nil {
+		return m.containerPorts
+	}
+	return m.ports
+}
 
-	for i, host := range m.hosts {
-		cursor := " "
-		if m.cursor == i {
-			cursor = ">"
+// visiblePorts returns the listed ports in the category filtered on
+func (m *Model) visiblePorts() []int {
+	ports := m.listedPorts()
+	if m.portFilter == "" {
+		return ports
+	}
+	visible := make([]int, 0, len(ports))
+	for _, port := range ports {
+		if m.portCategory(port) == m.portFilter {
+			visible = append(visible, port)
 		}
+	}
+	return visible
+}
 
-		hostInfo := fmt.Sprintf("%s@%s", host.User, host.Hostname)
-		if host.User == "" {
-			hostInfo = host.Hostname
-		}
+// lastForwardedIndex returns the index of the selected host's last forwarded
+// port among the detected ports, or of the first detected port when it isn't
+// detected
+func (m *Model) lastForwardedIndex() int {
+	port, ok := m.portHistory.LastForwarded(m.hosts[m.selectedHost].Name)
+	if index := m.rowIndex(PortRow{Section: SectionDetected, Port: port}); ok && index >= 0 {
+		return index
+	}
+	return m.sectionStart(SectionDetected)
+}
 
-		style := lipgloss.NewStyle()
-		if m.cursor == i {
-			style = style.Foreground(lipgloss.Color("#FF75B7"))
-		}
+// setPortFilter shows only the ports in category, or all ports for "",
+// keeping the cursor on the same row while it stays visible
+func (m *Model) setPortFilter(category PortCategory) {
+	row, _ := m.cursorRow()
+	m.portFilter = category
+	m.restoreCursor(row)
+}
 
-		s.WriteString(fmt.Sprintf("%s %s (%s)\n", cursor, 
-			style.Render(host.Name), hostInfo))
+// restoreCursor puts the cursor back on row after the port list changed, or
+// on the first detected port when the row is gone
+func (m *Model) restoreCursor(row PortRow) {
+	if index := m.rowIndex(row); index >= 0 {
+		m.cursor = index
+		return
 	}
+	m.cursor = m.sectionStart(SectionDetected)
+}
 
-	s.WriteString("\n")
-	s.WriteString("Controls:\n")
-	s.WriteString("  ↑/↓: Navigate  Enter: Select  m: Manual port  q: Quit\n")
+// leaveContainer goes from a container's ports back to the container list
+func (m *Model) leaveContainer() {
+	m.state = StateSelectContainer
+	m.cursor = max(0, slices.IndexFunc(m.containers, func(c Container) bool { return c.Name == m.container.Name }))
+	m.container = nil
+	m.containerPorts = nil
+	m.portFilter = ""
+	m.message = ""
+}
+
+// updateContainerSelection handles choosing a container on the selected host
+func (m *Model) updateContainerSelection(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.Action(StateSelectContainer, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionBack:
+		m.state = StateSelectPort
+		m.cursor = 0
+		m.message = ""
+	case ActionUp:
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case ActionDown:
+		if m.cursor < len(m.containers)-1 {
+			m.cursor++
+		}
+	case ActionSelect:
+		if m.cursor >= len(m.containers) {
+			return m, nil
+		}
+		container := m.containers[m.cursor]
+		m.container = &container
+		m.containerPorts = nil
+		m.portFilter = ""
+		m.containerPortsLoading = true
+		m.state = StateSelectPort
+		m.cursor = 0
+		m.message = ""
+		return m, DetectContainerPorts(m.hosts[m.selectedHost], container.Name)
+	}
+	return m, nil
+}
+
+// probeHTTP probes the detected ports of the selected host for HTTP responses
+func (m *Model) probeHTTP() tea.Cmd {
+	m.probingHTTP = true
+	return ProbeHTTP(m.hosts[m.selectedHost], m.ports)
+}
+
+// selectedHostConfig returns the kport settings for the selected host with the
+// time limit chosen in the TUI applied
+func (m *Model) selectedHostConfig() HostConfig {
+	hostConfig := m.kportConfig.HostFor(m.hosts[m.selectedHost])
+	ttl := m.ttl
+	hostConfig.TTL = &ttl
+	return hostConfig
+}
+
+// activeHost returns the host the current view works with: the host of the
+// tunnel being shown, or the selected host while picking its ports
+func (m *Model) activeHost() (SSHHost, bool) {
+	switch m.state {
+	case StateForwarding:
+		if m.forwarder != nil {
+			return m.forwarder.Host(), true
+		}
+	case StateConnecting, StateSelectPort, StateSelectContainer, StateManualPort, StateStartingForward, StateConfirmPolicy:
+		if m.selectedHost < len(m.hosts) {
+			return m.hosts[m.selectedHost], true
+		}
+	}
+	return SSHHost{}, false
+}
+
+// selectHost makes the host under the cursor the selected host
+func (m *Model) selectHost() {
+	m.selectedHost = m.cursor
+
+	// Start from the host's default time limit
+	m.ttl = 0
+	if ttl := m.kportConfig.Host(m.hosts[m.selectedHost].Name).TTL; ttl != nil {
+		m.ttl = *ttl
+	}
+}
+
+// cycleTTL switches to the next tunnel time limit preset
+func (m *Model) cycleTTL() {
+	// Wrap around to no time limit after the longest preset
+	next := ttlPresets[0]
+	for _, preset := range ttlPresets {
+		if preset > m.ttl {
+			next = preset
+			break
+		}
+	}
+	m.ttl = next
+}
+
+// toggleAgentForwarding starts or stops forwarding the local SSH agent to the selected host
+func (m *Model) toggleAgentForwarding() tea.Cmd {
+	if m.agentForwarder != nil {
+		forwarder := m.agentForwarder
+		m.agentForwarder = nil
+		m.agentStatus = ""
+		return StopAgentForwarding(forwarder)
+	}
+
+	m.agentStatus = "Forwarding SSH agent..."
+	return StartAgentForwarding(m.hosts[m.selectedHost])
+}
+
+// toggleConfiguredForwards establishes or closes the LocalForward and
+// RemoteForward lines of the selected host's SSH config
+func (m *Model) toggleConfiguredForwards() tea.Cmd {
+	host := m.hosts[m.selectedHost]
+	if len(host.Forwards) == 0 {
+		return nil
+	}
+	// Forwards whose ssh connection dropped are reconnected
+	if forwarder := m.configuredForwarders[host.Name]; forwarder != nil {
+		delete(m.configuredForwarders, host.Name)
+		delete(m.forwardsStatus, host.Name)
+		if forwarder.IsRunning() {
+			return StopConfiguredForwards(forwarder)
+		}
+	}
+	if m.forwardsStatus[host.Name] == forwardsStarting {
+		return nil
+	}
+
+	risks := currentPolicy().configuredForwardRisks(host)
+	if slices.ContainsFunc(host.Forwards, func(forward ConfiguredForward) bool { return forward.Remote }) {
+		risks = append(risks, currentPolicy().reverseForwardRisks(host, "RemoteForward lines")...)
+	}
+	cmd, err := m.confirmPolicy(host.Name, risks, func(bool) tea.Cmd {
+		m.forwardsStatus[host.Name] = forwardsStarting
+		return StartConfiguredForwards(host)
+	})
+	if err != nil {
+		m.forwardsStatus[host.Name] = fmt.Sprintf("Configured forwards failed: %v", err)
+	}
+	return cmd
+}
+
+// toggleShare shares the active tunnel through the configured relay host, or
+// closes its share link
+func (m *Model) toggleShare() tea.Cmd {
+	if m.forwarder == nil {
+		return nil
+	}
+	if m.share != nil {
+		share := m.share
+		m.share = nil
+		m.shareStatus = ""
+		if share.IsRunning() {
+			return StopShare(share)
+		}
+	}
+	if m.shareStatus == shareStarting {
+		return nil
+	}
+
+	config := m.kportConfig.Share
+	if m.sharePort != 0 {
+		config.Port = m.sharePort
+		m.sharePort = 0
+	}
+	if config.Relay == "" {
+		m.shareStatus = "Set share.relay in the kport config to the SSH host that serves share links"
+		return nil
+	}
+	index := slices.IndexFunc(m.hosts, func(host SSHHost) bool { return host.Name == config.Relay })
+	if index < 0 {
+		m.shareStatus = fmt.Sprintf("Share relay %s is not a host in the SSH config", config.Relay)
+		return nil
+	}
+
+	relay, localPort := m.hosts[index], m.forwarder.LocalPort()
+	cmd, err := m.confirmPolicy(relay.Name, currentPolicy().reverseForwardRisks(relay, "a share link"), func(bool) tea.Cmd {
+		m.shareStatus = shareStarting
+		return StartShare(relay, config, localPort)
+	})
+	if err != nil {
+		m.shareStatus = fmt.Sprintf("Sharing failed: %v", err)
+	}
+	return cmd
+}
+
+// updateConfiguredForwards records the outcome of establishing configured forwards
+func (m *Model) updateConfiguredForwards(msg ConfiguredForwardsMsg) (tea.Model, tea.Cmd) {
+	if msg.Err != nil {
+		m.forwardsStatus[msg.Host] = fmt.Sprintf("Configured forwards failed: %v", msg.Err)
+		return m, nil
+	}
+	delete(m.forwardsStatus, msg.Host)
+	m.configuredForwarders[msg.Host] = msg.Forwarder
+	return m, m.notify(toastSuccess, fmt.Sprintf("Configured forwards of %s are up", msg.Host))
+}
+
+// numberStart numbers a tunnel start, so the TUI can tell its tunnel from
+// those of starts the user has since backed out of
+func (m *Model) numberStart(start tea.Cmd) tea.Cmd {
+	m.forwardStart++
+	id := m.forwardStart
+	return func() tea.Msg {
+		switch msg := start().(type) {
+		case ForwardingStartedMsg:
+			msg.Start = id
+			return msg
+		case ForwardFailedMsg:
+			msg.Start = id
+			return msg
+		default:
+			return msg
+		}
+	}
+}
+
+// Cleanup stops everything the TUI started so no ssh processes outlive kport
+func (m *Model) Cleanup() {
+	// Nothing receives the TUI's prompts anymore
+	setSecurityKeyHandler(printSecurityKeyPrompt)
+	setHandshakeHandler(func(HandshakeMsg) {})
+	m.tunnels.StopAll()
+	m.forwarder = nil
+	if m.prewarm != nil {
+		m.prewarm.Detach()
+		m.prewarm = nil
+	}
+	if m.agentForwarder != nil {
+		m.agentForwarder.Stop()
+		m.agentForwarder = nil
+	}
+	for name, forwarder := range m.configuredForwarders {
+		forwarder.Stop()
+		delete(m.configuredForwarders, name)
+	}
+	if m.share != nil {
+		m.share.Stop()
+		m.share = nil
+	}
+}
+
+// updateHelp handles the help overlay, which closes with the help or back keys
+func (m *Model) updateHelp(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.Action(m.state, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionHelp, ActionBack:
+		m.showHelp = false
+	}
+	return m, nil
+}
+
+// connectHost selects the host under the cursor and detects its ports
+func (m *Model) connectHost() tea.Cmd {
+	m.selectHost()
+	m.state = StateConnecting
+	m.message = fmt.Sprintf("Connecting to %s...", m.hosts[m.selectedHost].Name)
+	m.newPorts, m.removedPorts = nil, nil
+	m.processes, m.addresses, m.portFilter = nil, nil, ""
+	m.container, m.containers = nil, nil
+	m.httpProbes = make(map[int]HTTPProbe)
+	m.probingHTTP = false
+	m.portsCachedAt = time.Time{}
+	m.portsRefreshing = true
+	m.handshake = newHandshakeProgress(m.hosts[m.selectedHost].Name)
+
+	// Show the ports found last time right away while detection re-runs
+	if ports, detectedAt, ok := m.portHistory.LastDetected(m.hosts[m.selectedHost].Name); ok {
+		m.ports = ports
+		m.portsCachedAt = detectedAt
+		m.state = StateSelectPort
+		m.cursor = m.lastForwardedIndex()
+		m.message = ""
+	}
+
+	// Detect ports on selected host while its jump hosts are checked hop by hop
+	host := m.hosts[m.selectedHost]
+	return tea.Batch(DetectPorts(host), m.startJumpCheck(host))
+}
+
+// updateHostSelection handles host selection state
+func (m *Model) updateHostSelection(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.Action(StateSelectHost, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionUp:
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case ActionDown:
+		if m.cursor < len(m.hosts)-1 {
+			m.cursor++
+		}
+	case ActionSelect:
+		if len(m.hosts) == 0 {
+			return m, nil
+		}
+		return m, m.connectHost()
+	case ActionReload:
+		// Re-read the configs after editing them
+		return m, m.reloadHosts()
+	case ActionExport:
+		return m, ExportInventory(m.tunnels.Tunnels())
+	case ActionRetry:
+		m.retries.RetryNow(time.Now())
+		return m, m.retryDue()
+	case ActionDropPending:
+		m.retries.Clear()
+		return m, nil
+	case ActionInfo:
+		if len(m.hosts) == 0 {
+			return m, nil
+		}
+		m.state = StateHostInfo
+		m.infoHost = m.hosts[m.cursor].Name
+		m.infoErr = nil
+		m.infoTranscript = nil
+		// Facts are cached until refreshed with r
+		if _, ok := m.hostFacts[m.infoHost]; ok {
+			return m, LoadTranscript(m.hosts[m.cursor])
+		}
+		return m, m.refreshHostInfo()
+	case ActionAgentKeys:
+		if len(m.hosts) == 0 {
+			return m, nil
+		}
+		m.selectedHost = m.cursor
+		m.message = ""
+		return m, m.showAgentKeys()
+	case ActionEditUser:
+		if len(m.hosts) == 0 {
+			return m, nil
+		}
+		m.state = StateEditUser
+		resetTextInput(&m.userInput, m.hosts[m.cursor].EffectiveUser())
+		return m, nil
+	case ActionManualPort:
+		if len(m.hosts) == 0 {
+			return m, nil
+		}
+		// Manual port forwarding
+		m.selectHost()
+		m.state = StateManualPort
+		resetTextInput(&m.portInput, "")
+		m.ports = nil // Ports of a previously selected host don't apply
+		m.processes, m.addresses, m.portFilter = nil, nil, ""
+		m.container, m.containers = nil, nil
+		m.portsCachedAt = time.Time{}
+		m.suggestion = -1
+		return m, nil
+	}
+	return m, nil
+}
+
+// startRetryTick starts ticking for the pending tunnels unless it already does
+func (m *Model) startRetryTick() tea.Cmd {
+	if m.retryTicking {
+		return nil
+	}
+	m.retryTicking = true
+	return retryTick()
+}
+
+// retryDue retries the next pending tunnel that is due. Retries only run on
+// the host list, so a tunnel that comes up doesn't take over another view.
+func (m *Model) retryDue() tea.Cmd {
+	if m.state != StateSelectHost {
+		return nil
+	}
+	pending := m.retries.Due(time.Now())
+	if pending == nil {
+		return nil
+	}
+	pending.Retrying = true
+	return retryPending(pending)
+}
+
+// updatePendingRetried makes a pending tunnel that came up the active tunnel,
+// or schedules its next retry
+func (m *Model) updatePendingRetried(msg PendingRetriedMsg) (tea.Model, tea.Cmd) {
+	pending := m.retries.Get(msg.ID)
+	if msg.Err != nil {
+		m.retries.Failed(msg.ID, msg.Err, time.Now())
+		return m, nil
+	}
+	// The user left the host list or dropped the tunnel while it was retried
+	if pending == nil || m.state != StateSelectHost {
+		m.tunnels.Stop(msg.Started.Forwarder)
+		if pending != nil {
+			pending.Retrying = false
+			pending.NextRetry = time.Now()
+		}
+		return m, nil
+	}
+	m.retries.Remove(msg.ID)
+
+	m.selectedHost = m.hostIndex(pending.Host.Name, m.selectedHost)
+	m.cursor = m.selectedHost
+	m.container = nil
+	m.message = ""
+	notice := m.notify(toastSuccess, fmt.Sprintf("%s, after %d retries",
+		startedMessage(*msg.Started, strings.TrimSuffix(pending.Target(), fmt.Sprintf(":%d", pending.RemotePort))), pending.Attempts+1))
+	m.forwarder = msg.Started.Forwarder
+	m.tunnels.Adopt("", msg.Started.Forwarder)
+	m.otherTunnels = msg.Started.OtherTunnels
+	m.state = StateForwarding
+	logEvent("pending tunnel localhost:%d -> %s started after %d retries", msg.Started.LocalPort, pending.Target(), pending.Attempts+1)
+
+	if pending.Options.Container != "" {
+		return m, tea.Batch(tick(), notice)
+	}
+	m.portHistory.RecordForwarded(pending.Host.Name, pending.RemotePort)
+	msg.Started.Forwarder.SetLabel(m.portHistory.LabelFor(pending.Host.Name, pending.RemotePort))
+	return m, tea.Batch(tick(), notice, SavePortHistory(m.portHistory))
+}
+
+// tunnelSummary describes the active tunnel, which stays on screen while the
+// notification that it started times out
+func (m *Model) tunnelSummary() string {
+	if m.forwarder == nil {
+		return ""
+	}
+	target := m.forwarder.Host().Name
+	if container := m.forwarder.Options().Container; container != "" {
+		target += "/" + container
+	}
+	return fmt.Sprintf("%s -> %s:%d", m.forwarder.LocalAddress(), target, m.forwarder.RemotePort())
+}
+
+// startedMessage describes a tunnel that started to target
+func startedMessage(msg ForwardingStartedMsg, target string) string {
+	if msg.Forwarder != nil && msg.Forwarder.LocalSocket() != "" {
+		return fmt.Sprintf("Port forwarding started: %s -> %s:%d", msg.Forwarder.LocalSocket(), target, msg.RemotePort)
+	}
+	if msg.LocalPort == msg.RemotePort {
+		return fmt.Sprintf("Port forwarding started: localhost:%d -> %s:%d (same port)",
+			msg.LocalPort, target, msg.RemotePort)
+	}
+	return fmt.Sprintf("Port forwarding started: localhost:%d -> %s:%d (port %d was unavailable)",
+		msg.LocalPort, target, msg.RemotePort, msg.RemotePort)
+}
+
+// updateEditUser handles editing the user to connect as
+func (m *Model) updateEditUser(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.state = StateSelectHost
+	case tea.KeyEnter:
+		if m.userInput.Err != nil {
+			return m, nil
+		}
+		host := &m.hosts[m.cursor]
+		user := strings.TrimSpace(m.userInput.Value())
+		// Entering the configured user, or nothing, clears the override
+		if user == "" || user == host.User {
+			delete(m.userOverrides, host.Name)
+			host.UserOverride = ""
+		} else {
+			m.userOverrides[host.Name] = user
+			host.UserOverride = user
+		}
+		m.state = StateSelectHost
+	default:
+		var cmd tea.Cmd
+		m.userInput, cmd = m.userInput.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+// updateEditLabel handles editing the label and note of the active tunnel.
+// Tab switches between the two fields.
+func (m *Model) updateEditLabel(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.state = StateForwarding
+	case tea.KeyTab, tea.KeyShiftTab, tea.KeyUp, tea.KeyDown:
+		m.editingNote = !m.editingNote
+		if m.editingNote {
+			m.labelInput.Blur()
+			return m, m.noteInput.Focus()
+		}
+		m.noteInput.Blur()
+		return m, m.labelInput.Focus()
+	case tea.KeyEnter:
+		m.state = StateForwarding
+		if m.forwarder == nil {
+			return m, nil
+		}
+		label, note := strings.TrimSpace(m.labelInput.Value()), strings.TrimSpace(m.noteInput.Value())
+		m.forwarder.SetLabel(label, note)
+		// Container ports stay out of the host's history
+		if m.container != nil {
+			return m, nil
+		}
+		m.portHistory.RecordLabel(m.forwarder.Host().Name, m.forwarder.RemotePort(), label, note)
+		return m, SavePortHistory(m.portHistory)
+	default:
+		input := m.focusedInput()
+		var cmd tea.Cmd
+		*input, cmd = input.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+// refreshHostInfo gathers facts for the host shown in the info panel
+func (m *Model) refreshHostInfo() tea.Cmd {
+	m.infoLoading = true
+	m.infoErr = nil
+	return FetchHostInfo(m.hosts[m.hostIndex(m.infoHost, m.cursor)])
+}
+
+// updateHostInfo handles the host information panel
+func (m *Model) updateHostInfo(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.Action(StateHostInfo, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionBack, ActionInfo:
+		m.state = StateSelectHost
+		m.cursor = m.hostIndex(m.infoHost, m.cursor)
+		return m, nil
+	case ActionReload:
+		if m.infoLoading {
+			return m, nil
+		}
+		return m, m.refreshHostInfo()
+	case ActionSelect:
+		m.state = StateSelectHost
+		m.cursor = m.hostIndex(m.infoHost, m.cursor)
+		return m.updateHostSelection(msg)
+	}
+	return m, nil
+}
+
+// updateConnecting handles connecting state
+func (m *Model) updateConnecting(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.Action(StateConnecting, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionBack:
+		m.state = StateSelectHost
+		m.cursor = m.selectedHost
+		m.message = ""
+		return m, nil
+	}
+	return m, nil
+}
+
+// updatePortSelection handles port selection state
+func (m *Model) updatePortSelection(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.Action(StateSelectPort, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionBack:
+		if m.container != nil {
+			m.leaveContainer()
+			return m, nil
+		}
+		m.state = StateSelectHost
+		m.cursor = m.selectedHost
+		return m, nil
+	case ActionReload:
+		if m.container != nil {
+			return m, nil
+		}
+		return m, m.refreshPorts()
+	case ActionUp:
+		if m.cursor > 0 {
+			m.cursor--
+		}
+	case ActionDown:
+		if m.cursor < len(m.portRows())-1 {
+			m.cursor++
+		}
+	case ActionNextSection:
+		m.nextSection()
+	case ActionSelect, ActionForwardHTTPS:
+		// HTTPS forwarding terminates TLS on the local listener
+		row, ok := m.cursorRow()
+		if !ok {
+			return m, nil
+		}
+		return m, m.forwardRow(row, m.keys.Action(StateSelectPort, msg) == ActionForwardHTTPS)
+	case ActionManualPort:
+		// Manual port forwarding, always to the host itself
+		m.container = nil
+		m.portFilter = ""
+		m.state = StateManualPort
+		resetTextInput(&m.portInput, "")
+		m.suggestion = -1
+		return m, nil
+	case ActionAgent:
+		return m, m.toggleAgentForwarding()
+	case ActionForwards:
+		return m, m.toggleConfiguredForwards()
+	case ActionTTL:
+		m.cycleTTL()
+	case ActionProbeHTTP:
+		// Probes run on the host, where container ports aren't reachable
+		if len(m.ports) == 0 || m.probingHTTP || m.container != nil {
+			return m, nil
+		}
+		return m, m.probeHTTP()
+	case ActionContainers:
+		m.state = StateSelectContainer
+		m.cursor = 0
+		m.containersLoading = true
+		m.message = ""
+		return m, ListContainers(m.hosts[m.selectedHost])
+	case ActionFilterAll:
+		m.setPortFilter("")
+	case ActionFilterWeb, ActionFilterDatabase, ActionFilterCache, ActionFilterMessaging, ActionFilterSystem:
+		category := portCategories[slices.Index(filterActions, m.keys.Action(StateSelectPort, msg))]
+		// Pressing the key of the active filter again shows all ports
+		if category == m.portFilter {
+			category = ""
+		}
+		m.setPortFilter(category)
+	}
+	return m, nil
+}
+
+// updateManualPort handles manual port input state
+func (m *Model) updateManualPort(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	// Digits, pastes and editing keys go to the input, everything else
+	// goes through the keymap
+	if key := msg.String(); isTextEditKey(msg) || len(key) == 1 && key >= "0" && key <= "9" {
+		var cmd tea.Cmd
+		m.portInput, cmd = m.portInput.Update(msg)
+		m.suggestion = -1
+		return m, cmd
+	}
+
+	switch m.keys.Action(StateManualPort, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionBack:
+		if len(m.ports) > 0 {
+			m.state = StateSelectPort
+		} else {
+			m.state = StateSelectHost
+		}
+		return m, nil
+	case ActionUp:
+		if m.suggestion >= 0 {
+			m.suggestion--
+		}
+	case ActionDown:
+		if m.suggestion < len(m.manualSuggestions())-1 {
+			m.suggestion++
+		}
+	case ActionSelect:
+		// A highlighted suggestion takes precedence over the typed digits
+		if suggestions := m.manualSuggestions(); m.suggestion >= 0 && m.suggestion < len(suggestions) {
+			resetTextInput(&m.portInput, fmt.Sprint(suggestions[m.suggestion].Port))
+		}
+		if m.portInput.Value() != "" && m.portInput.Err == nil {
+			// The input only takes valid port numbers
+			port, _ := parsePort(m.portInput.Value())
+			return m, m.startForwarding(port, forwardOptionsFor(m.hosts[m.selectedHost], m.selectedHostConfig(), port), "Starting port forwarding...")
+		}
+	case ActionTTL:
+		m.cycleTTL()
+	}
+	return m, nil
+}
+
+// manualSuggestions returns the history suggestions matching the typed port
+func (m *Model) manualSuggestions() []PortSuggestion {
+	suggestions := m.portHistory.Suggestions(m.hosts[m.selectedHost].Name, m.ports)
+	return filterSuggestions(suggestions, m.portInput.Value())
+}
+
+// updateStartingForward handles the starting forward state
+func (m *Model) updateStartingForward(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.Action(StateStartingForward, msg) {
+	case ActionQuit:
+		return m, tea.Quit
+	case ActionBack:
+		// Cancel the forwarding attempt
+		m.state = StateSelectPort
+		m.message = ""
+		return m, nil
+	}
+	return m, nil
+}
+
+// updateForwarding handles forwarding state
+func (m *Model) updateForwarding(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.keys.Action(StateForwarding, msg) {
+	case ActionQuit:
+		// Cleanup stops the tunnel once the program exits
+		return m, tea.Quit
+	case ActionBack:
+		if m.forwarder != nil {
+			m.tunnels.Stop(m.forwarder)
+			m.forwarder = nil
+		}
+		if m.share != nil {
+			m.share.Stop()
+			m.share = nil
+		}
+		m.shareStatus = ""
+		m.sharePort = 0
+		m.state = StateSelectHost
+		m.cursor = 0
+		m.message = ""
+		return m, nil
+	case ActionAgent:
+		return m, m.toggleAgentForwarding()
+	case ActionForwards:
+		return m, m.toggleConfiguredForwards()
+	case ActionLabel:
+		if m.forwarder != nil {
+			label, note := m.forwarder.Label()
+			resetTextInput(&m.labelInput, label)
+			resetTextInput(&m.noteInput, note)
+			m.noteInput.Blur()
+			m.editingNote = false
+			m.state = StateEditLabel
+		}
+		return m, nil
+	case ActionExport:
+		return m, ExportInventory(m.tunnels.Tunnels())
+	case ActionThroughput:
+		if m.forwarder != nil {
+			return m, ExportThroughput(m.forwarder)
+		}
+		return m, nil
+	case ActionBackground:
+		return m, m.moveToBackground()
+	case ActionShare:
+		return m, m.toggleShare()
+	case ActionCapture:
+		// Cycle traffic capture mode for this tunnel
+		if m.forwarder != nil {
+			if _, err := m.forwarder.CycleCapture(); err != nil {
+				m.err = err
+			}
+		}
+		return m, nil
+	}
+	return m, nil
+}
+
+// View renders the TUI
+func (m *Model) View() string {
+	if m.err != nil {
+		errorStyle := lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FF5F87")).
+			Bold(true)
+		
+		if m.state == StateSelectHost {
+			return fmt.Sprintf("%s\n\n%s\n\nFix the config and press %s to reload, or press %s to quit.",
+				errorStyle.Render("❌ Error"), m.err.Error(), m.keys.Label(ActionReload), m.keys.Label(ActionQuit))
+		}
+		return fmt.Sprintf("%s\n\n%s\n\nPress %s to quit.", 
+			errorStyle.Render("❌ Error"), m.err.Error(), m.keys.Label(ActionQuit))
+	}
+
+	var s strings.Builder
+
+	// Header, in the color of the environment of the host in use
+	headerStyle := lipgloss.NewStyle().
+		Bold(true).
+		Foreground(lipgloss.Color("#FAFAFA")).
+		Background(lipgloss.Color("#7D56F4")).
+		Padding(0, 1)
+	header := "kport - SSH Port Forwarder"
+	if host, ok := m.activeHost(); ok && host.Environment != "" {
+		header += " · " + host.Environment
+		if env, _ := m.kportConfig.Environment(host.Environment); env.Color != "" {
+			headerStyle = headerStyle.Background(lipgloss.Color(env.Color))
+		}
+	}
+
+	s.WriteString(headerStyle.Render(header))
+	s.WriteString("\n\n")
+	s.WriteString(m.renderSecurityKeyPrompts())
+	s.WriteString(m.renderToasts())
+
+	if m.showHelp {
+		s.WriteString(m.renderHelp())
+		return s.String()
+	}
+
+	switch m.state {
+	case StateQuickConnect:
+		s.WriteString(m.renderQuickConnect())
+	case StateSelectHost:
+		s.WriteString(m.renderHostSelection())
+	case StateConnecting:
+		s.WriteString(m.renderConnecting())
+	case StateSelectPort:
+		s.WriteString(m.renderPortSelection())
+	case StateManualPort:
+		s.WriteString(m.renderManualPort())
+	case StateStartingForward:
+		s.WriteString(m.renderStartingForward())
+	case StateForwarding:
+		s.WriteString(m.renderForwarding())
+	case StateHostInfo:
+		s.WriteString(m.renderHostInfo())
+	case StateEditUser:
+		s.WriteString(m.renderEditUser())
+	case StateSelectContainer:
+		s.WriteString(m.renderContainerSelection())
+	case StateEditLabel:
+		s.WriteString(m.renderEditLabel())
+	case StateConfirmPolicy:
+		s.WriteString(m.renderConfirmPolicy())
+	case StateAlgorithms:
+		s.WriteString(m.renderAlgorithms())
+	case StateAgentKeys:
+		s.WriteString(m.renderAgentKeys())
+	}
+	s.WriteString(m.renderHelpBar())
+
+	return s.String()
+}
+
+// renderSecurityKeyPrompts asks the user to touch their security key while ssh waits for it
+func (m *Model) renderSecurityKeyPrompts() string {
+	if len(m.securityKeyPrompts) == 0 {
+		return ""
+	}
+
+	var s strings.Builder
+
+	promptStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#FFA500")).
+		Bold(true)
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+
+	for _, prompt := range m.securityKeyPrompts {
+		s.WriteString(promptStyle.Render(fmt.Sprintf("🔑 Touch your security key to connect to %s", prompt.Host)))
+		s.WriteString("\n")
+		s.WriteString(dimStyle.Render("   " + prompt.Prompt))
+		s.WriteString("\n")
+	}
+	s.WriteString("\n")
+
+	return s.String()
+}
+
+// renderHelpBar renders the keys of the current view, generated from the keymap
+func (m *Model) renderHelpBar() string {
+	width := m.width
+	if width <= 0 {
+		width = 80
+	}
+	// A tunnel that reached its time limit can only be left
+	if m.state == StateForwarding && m.forwarder != nil && m.forwarder.Expired() {
+		return m.keys.Bar(m.state, width, ActionBack, ActionQuit)
+	}
+	// The retry keys only mean something while tunnels are pending
+	if m.state == StateSelectHost && len(m.retries.Pending()) == 0 {
+		return m.keys.Bar(m.state, width, actionsWithout(m.state, ActionRetry, ActionDropPending)...)
+	}
+	return m.keys.Bar(m.state, width)
+}
+
+// renderHostSelection renders the host selection view
+func (m *Model) renderHostSelection() string {
+	var s strings.Builder
+	
+	s.WriteString("Select an SSH host:")
+	if m.hostsLoading {
+		loadingStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666")).Italic(true)
+		if m.hostsCached {
+			s.WriteString(loadingStyle.Render(" (cached, refreshing...)"))
+		} else {
+			s.WriteString("\n\n")
+			s.WriteString(loadingStyle.Render("Loading SSH hosts..."))
+		}
+	}
+	if m.reloadStatus != "" {
+		s.WriteString("\n\n")
+		s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87")).Render(m.reloadStatus))
+	}
+	if m.exportStatus != "" {
+		s.WriteString("\n\n")
+		s.WriteString(m.renderExportStatus())
+	}
+	s.WriteString(m.renderModeIssues())
+	s.WriteString("\n\n")
+
+	for i, host := range m.hosts {
+		cursor := " "
+		if m.cursor == i {
+			cursor = ">"
+		}
+
+		hostInfo := fmt.Sprintf("%s@%s", host.EffectiveUser(), host.Hostname)
+		if host.EffectiveUser() == "" {
+			hostInfo = host.Hostname
+		}
+		if host.UserOverride != "" {
+			hostInfo += ", user overridden"
+		}
+		if host.Transport != "" {
+			hostInfo = fmt.Sprintf("%s via %s", hostInfo, host.Transport)
+		}
+
+		style := lipgloss.NewStyle()
+		if m.cursor == i {
+			style = style.Foreground(lipgloss.Color("#FF75B7"))
+		}
+
+		line := fmt.Sprintf("%s %s (%s)", cursor, style.Render(host.Name), hostInfo)
+		if m.kportConfig.Hosts[host.Name].Pinned {
+			line += " ★"
+		}
+		line += m.kportConfig.environmentBadge(host)
+		if host.Description != "" {
+			line += lipgloss.NewStyle().Foreground(lipgloss.Color("#999999")).Render(" — " + host.Description)
+		}
+		if len(host.Sources) > 1 {
+			line += lipgloss.NewStyle().Foreground(lipgloss.Color("#666666")).Render(fmt.Sprintf(" merged from %d Host blocks", len(host.Sources)))
+		}
+		if m.prewarm != nil && m.prewarm.Ready(host) {
+			line += lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575")).Render(" connected")
+		}
+		s.WriteString(line + "\n")
+	}
+
+	s.WriteString("\n")
+	s.WriteString(m.renderPending())
+
+	return s.String()
+}
+
+// renderPending renders the tunnels that failed to start and are waiting to be retried
+func (m *Model) renderPending() string {
+	pending := m.retries.Pending()
+	if len(pending) == 0 {
+		return ""
+	}
+
+	var s strings.Builder
+	titleStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500")).Bold(true)
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+
+	s.WriteString(titleStyle.Render(fmt.Sprintf("Pending tunnels (%d):", len(pending))))
+	s.WriteString("\n")
+	for _, p := range pending {
+		var status string
+		switch {
+		case p.Retrying:
+			status = "retrying now..."
+		case p.NextRetry.IsZero():
+			status = fmt.Sprintf("gave up after %d retries, press %s to retry", p.Attempts, m.keys.Label(ActionRetry))
+		default:
+			status = fmt.Sprintf("retry %d of %d in %s", p.Attempts+1, maxRetryAttempts, formatCountdown(time.Until(p.NextRetry).Round(time.Second)))
+		}
+		s.WriteString(fmt.Sprintf("  ↻ localhost -> %s  %s\n", p.Target(), dimStyle.Render(status)))
+		s.WriteString(fmt.Sprintf("    %s\n", errorStyle.Render(p.Err.Error())))
+	}
+	s.WriteString("\n")
+	return s.String()
+}
+
+// renderHelp renders the keybindings of the current view from the active keymap
+func (m *Model) renderHelp() string {
+	var s strings.Builder
+
+	titleStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#7D56F4")).
+		Bold(true)
+	s.WriteString(titleStyle.Render(fmt.Sprintf("Keybindings (%s keymap)", m.keys.Preset)))
+	s.WriteString("\n\n")
+	s.WriteString(m.keys.Help(m.state))
+	s.WriteString("\n")
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	s.WriteString(dimStyle.Render("Keys can be remapped under keys: in ~/.config/kport/config.yaml"))
+	s.WriteString("\n\n")
+	s.WriteString(fmt.Sprintf("Press %s or %s to close help.\n", m.keys.Label(ActionHelp), m.keys.Label(ActionBack)))
+
+	return s.String()
+}
+
+// renderEditUser renders the prompt for the user to connect as
+func (m *Model) renderEditUser() string {
+	var s strings.Builder
+
+	host := m.hosts[m.cursor]
+	hostStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#7D56F4")).
+		Bold(true)
+
+	s.WriteString(fmt.Sprintf("Connect to %s as:\n\n", hostStyle.Render(host.Name)))
+
+	s.WriteString(renderTextInput(m.userInput))
+	s.WriteString("\n\n")
+
+	configured := host.User
+	if configured == "" {
+		configured = "none"
+	}
+	if host.UserSource != "" {
+		configured = fmt.Sprintf("%s (from %s)", configured, host.UserSource)
+	}
+	s.WriteString(fmt.Sprintf("Configured user: %s\n", configured))
+	s.WriteString("Clear the input or enter the configured user to remove the override.\n")
+
+	s.WriteString("\n")
+
+	return s.String()
+}
+
+// renderEditLabel renders the label and note inputs of the active tunnel
+func (m *Model) renderEditLabel() string {
+	var s strings.Builder
+
+	s.WriteString(fmt.Sprintf("Label %s -> %s:%d\n\n", m.forwarder.LocalAddress(), m.forwarder.Host().Name, m.forwarder.RemotePort()))
+
+	s.WriteString("Label:\n")
+	s.WriteString(renderTextInput(m.labelInput))
+	s.WriteString("\nNote:\n")
+	s.WriteString(renderTextInput(m.noteInput))
+	s.WriteString("\n\n")
+	s.WriteString("The label and note are remembered for this port and shown in exported inventories.\n")
+
+	s.WriteString("\n")
+
+	return s.String()
+}
+
+// renderHostInfo renders the resolved config and live facts of a host
+func (m *Model) renderHostInfo() string {
+	var s strings.Builder
+
+	host := m.hosts[m.hostIndex(m.infoHost, m.cursor)]
+	hostConfig := m.kportConfig.Host(host.Name)
+
+	titleStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#7D56F4")).
+		Bold(true)
+	labelStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	row := func(label, value string) {
+		if value == "" {
+			value = "-"
+		}
+		s.WriteString(fmt.Sprintf("  %s %s\n", labelStyle.Render(fmt.Sprintf("%-16s", label+":")), value))
+	}
+
+	s.WriteString(titleStyle.Render(fmt.Sprintf("Host %s", host.Name)))
+	s.WriteString("\n")
+	if host.Description != "" {
+		s.WriteString(host.Description)
+		s.WriteString("\n")
+	}
+	s.WriteString("\n")
+
+	if len(host.Notes) > 0 {
+		s.WriteString("Notes:\n")
+		for _, note := range host.Notes {
+			row(note.Key, note.Value)
+		}
+		s.WriteString("\n")
+	}
+
+	s.WriteString("Configuration:\n")
+	row("HostName", host.Hostname)
+	if len(host.Addresses) > 0 {
+		row("Addresses", strings.Join(host.Addresses, ", ")+" (first that answers)")
+	}
+	if host.CanonicalName != "" {
+		row("Canonical name", host.CanonicalName)
+	}
+	userValue := host.User
+	if host.UserSource != "" {
+		userValue = fmt.Sprintf("%s (from %s)", host.User, host.UserSource)
+	}
+	if host.UserOverride != "" {
+		userValue = fmt.Sprintf("%s (overridden, configured %s)", host.UserOverride, userValue)
+	}
+	row("User", userValue)
+	row("Port", host.Port)
+	row("IdentityFile", host.Identity)
+	if len(host.Sources) > 0 {
+		row("Defined in", host.describeSources())
+	}
+	if len(host.JumpChain) > 0 {
+		row("ProxyJump", strings.Join(host.JumpChain, " → "))
+	}
+	transport := host.Transport
+	if transport == "" {
+		transport = "ssh"
+	}
+	row("Transport", transport)
+	if host.Transport == TransportSSM {
+		row("Instance", host.SSM.InstanceID)
+	}
+	if host.Plugin.Name != "" {
+		row("Plugin", fmt.Sprintf("%s (%s)", host.Plugin.Name, strings.Join(host.Plugin.Capabilities, ", ")))
+	}
+	row("Strict keys", fmt.Sprintf("%t", host.StrictIdentities))
+	if host.PreConnect != "" {
+		row("Pre-connect", "hook configured")
+	}
+	if _, ok := m.relaxedAlgorithms[host.Name]; ok {
+		row("Algorithms", "legacy preset, relaxed for this session")
+	} else if len(host.AlgorithmOptions) > 0 {
+		row("Algorithms", hostConfig.Algorithms.describe())
+	}
+	if hostConfig.TTL != nil {
+		row("Default TTL", hostConfig.TTL.String())
+	}
+	if len(hostConfig.HTTPS) > 0 {
+		row("HTTPS ports", fmt.Sprint(hostConfig.HTTPS))
+	}
+	if len(hostConfig.LAN) > 0 {
+		row("LAN ports", fmt.Sprint(hostConfig.LAN))
+	}
+	s.WriteString("\n")
+
+	s.WriteString("Live facts:\n")
+	facts := m.hostFacts[host.Name]
+	switch {
+	case m.infoLoading:
+		s.WriteString(labelStyle.Render("  Gathering facts over SSH..."))
+		s.WriteString("\n")
+	case m.infoErr != nil:
+		errorStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+		s.WriteString(errorStyle.Render(fmt.Sprintf("  %v", m.infoErr)))
+		s.WriteString("\n")
+	}
+	if facts != nil {
+		row("OS", facts.OS)
+		row("Kernel", facts.Kernel)
+		row("Uptime", facts.Uptime)
+		row("Load", facts.Load)
+		row("Disk (/)", facts.Disk)
+		row("Listening ports", fmt.Sprintf("%d", facts.ListeningPorts))
+		s.WriteString(labelStyle.Render(fmt.Sprintf("  Gathered %s ago", time.Since(facts.FetchedAt).Round(time.Second))))
+		s.WriteString("\n")
+	}
+
+	if len(m.infoTranscript) > 0 {
+		s.WriteString("\nRecent remote commands:\n")
+		for _, entry := range m.infoTranscript[max(0, len(m.infoTranscript)-transcriptPreview):] {
+			s.WriteString("  " + entry.summary() + "\n")
+		}
+		s.WriteString(labelStyle.Render(fmt.Sprintf("  %d commands in total, run `kport transcript %s` for the full commands", len(m.infoTranscript), host.Name)))
+		s.WriteString("\n")
+	}
+
+	s.WriteString("\n")
 
 	return s.String()
 }
@@ -349,8 +1966,8 @@ func (m *Model) renderConnecting() string {
 	s.WriteString(connectingStyle.Render("🔄 " + m.message))
 	s.WriteString("\n\n")
 	s.WriteString("Please wait while connecting to the remote host...\n\n")
-	s.WriteString("Controls:\n")
-	s.WriteString("  Esc: Cancel and go back  q: Quit\n")
+	s.WriteString(m.renderJumpChain(m.hostNameAt(m.selectedHost)))
+	s.WriteString(m.renderHandshake(m.hostNameAt(m.selectedHost)))
 
 	return s.String()
 }
@@ -359,38 +1976,224 @@ func (m *Model) renderConnecting() string {
 func (m *Model) renderPortSelection() string {
 	var s strings.Builder
 	
+	if m.container != nil {
+		return m.renderContainerPorts()
+	}
+
 	host := m.hosts[m.selectedHost]
-	s.WriteString(fmt.Sprintf("Detected ports on %s:\n\n", host.Name))
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	s.WriteString(fmt.Sprintf("Ports on %s:", host.Name))
+	if !m.portsCachedAt.IsZero() {
+		status := fmt.Sprintf(" cached %s ago", formatAge(time.Since(m.portsCachedAt)))
+		if m.portsRefreshing {
+			status += ", refreshing..."
+		}
+		s.WriteString(dimStyle.Render(status))
+	} else if m.portsRefreshing && len(m.ports) > 0 {
+		s.WriteString(dimStyle.Render(" refreshing..."))
+	}
+	s.WriteString("\n\n")
+	s.WriteString(m.renderJumpChain(host.Name))
+
+	if m.message != "" {
+		warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+		s.WriteString(warningStyle.Render("⚠️  " + m.message))
+		s.WriteString("\n\n")
+	}
+
+	// Configured forwards and recently used ports come first, so every
+	// source of ports is in one list
+	s.WriteString(m.renderPortSections(m.portRows()))
 
 	if len(m.ports) == 0 {
-		if m.message != "" {
-			warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
-			s.WriteString(warningStyle.Render("⚠️  " + m.message))
-			s.WriteString("\n\n")
-		}
-		s.WriteString("No open ports detected.\n\n")
-		s.WriteString("Press 'm' for manual port forwarding or Esc to go back.\n")
+		s.WriteString("No open ports detected.\n")
+		s.WriteString(m.renderRemovedPorts())
+		s.WriteString(m.renderAgentForwarding())
+		s.WriteString("\n")
+		s.WriteString(fmt.Sprintf("Press %s for manual port forwarding, %s to toggle SSH agent forwarding or %s to go back.\n",
+			m.keys.Label(ActionManualPort), m.keys.Label(ActionAgent), m.keys.Label(ActionBack)))
 		return s.String()
 	}
 
-	for i, port := range m.ports {
+	lastForwarded, _ := m.portHistory.LastForwarded(host.Name)
+	visible := m.visiblePorts()
+	if m.portFilter != "" {
+		s.WriteString(m.portFilter.Style().Render(fmt.Sprintf("Showing %s ports, %d of %d", m.portFilter, len(visible), len(m.ports))))
+		s.WriteString("\n\n")
+		if len(visible) == 0 {
+			s.WriteString(fmt.Sprintf("No %s ports detected. Press %s to show all ports.\n", m.portFilter, m.keys.Label(ActionFilterAll)))
+		}
+	}
+
+	i := m.sectionStart(SectionDetected) - 1
+	for _, port := range m.portListRows() {
+		if slices.Contains(m.removedPorts, port) {
+			s.WriteString(m.renderRemovedPort(port) + "\n")
+			continue
+		}
+		i++
+
+		cursor := " "
+		if m.cursor == i {
+			cursor = ">"
+		}
+
+		style := lipgloss.NewStyle()
+		switch {
+		case m.cursor == i:
+			style = style.Foreground(lipgloss.Color("#FF75B7"))
+		case m.newPorts[port]:
+			style = style.Foreground(lipgloss.Color("#04B575"))
+		}
+
+		category := m.portCategory(port)
+		line := fmt.Sprintf("%s %s  %s", cursor, style.Render(fmt.Sprintf("Port %-5d", port)), category.Style().Render(fmt.Sprintf("%-9s", category)))
+		if process := m.processes[port]; process != "" {
+			line += "  " + dimStyle.Render(process)
+		}
+		if label := m.httpProbes[port].Label(); label != "" {
+			line += "  " + lipgloss.NewStyle().Bold(true).Render(label)
+		}
+		if address := m.addresses[port]; m.container == nil && address != "" && !isLoopbackHost(address) {
+			line += "  " + dimStyle.Render("on "+address)
+		}
+		if m.newPorts[port] {
+			line += lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575")).Render("  new")
+		}
+		if port == lastForwarded {
+			line += dimStyle.Render("  last forwarded")
+		}
+		if probe, ok := m.httpProbes[port]; ok {
+			line += "  " + probeStyle(probe).Render(probe.String())
+		}
+		s.WriteString(line + "\n")
+	}
+	if m.probingHTTP {
+		s.WriteString("\n" + dimStyle.Render("Probing HTTP ports...") + "\n")
+	}
+
+	s.WriteString(m.renderTTL())
+	s.WriteString(m.renderAgentForwarding())
+	s.WriteString("\n")
+
+	return s.String()
+}
+
+// renderContainerSelection renders the list of containers on the selected host
+func (m *Model) renderContainerSelection() string {
+	var s strings.Builder
+
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	s.WriteString(fmt.Sprintf("Containers on %s:\n\n", m.hosts[m.selectedHost].Name))
+
+	switch {
+	case m.containersLoading:
+		s.WriteString("Listing containers...\n")
+	case m.message != "":
+		warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+		s.WriteString(warningStyle.Render("⚠️  " + m.message))
+		s.WriteString("\n")
+	case len(m.containers) == 0:
+		s.WriteString("No running containers.\n")
+	}
+
+	for i, container := range m.containers {
 		cursor := " "
+		style := lipgloss.NewStyle()
 		if m.cursor == i {
 			cursor = ">"
+			style = style.Foreground(lipgloss.Color("#FF75B7"))
+		}
+
+		line := fmt.Sprintf("%s %s  %s", cursor, style.Render(container.Name), dimStyle.Render(container.Image))
+		if len(container.Published) > 0 {
+			published := make([]string, 0, len(container.Published))
+			for containerPort, hostPort := range container.Published {
+				published = append(published, fmt.Sprintf("%d->%d", hostPort, containerPort))
+			}
+			slices.Sort(published)
+			line += "  " + dimStyle.Render("publishes "+strings.Join(published, ", "))
 		}
+		s.WriteString(line + "\n")
+	}
+
+	s.WriteString("\n")
+
+	return s.String()
+}
+
+// renderContainerPorts renders the ports listening inside the chosen container
+func (m *Model) renderContainerPorts() string {
+	var s strings.Builder
+
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	s.WriteString(fmt.Sprintf("Detected ports inside container %s on %s:\n\n", m.container.Name, m.hosts[m.selectedHost].Name))
+
+	if m.message != "" {
+		warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+		s.WriteString(warningStyle.Render("⚠️  " + m.message))
+		s.WriteString("\n\n")
+	}
+
+	if m.containerPortsLoading {
+		s.WriteString("Detecting ports...\n")
+	} else if len(m.containerPorts) == 0 {
+		s.WriteString("No open ports detected in the container.\n")
+	}
+
+	visible := m.visiblePorts()
+	if m.portFilter != "" && len(m.containerPorts) > 0 {
+		s.WriteString(m.portFilter.Style().Render(fmt.Sprintf("Showing %s ports, %d of %d", m.portFilter, len(visible), len(m.containerPorts))))
+		s.WriteString("\n\n")
+	}
 
+	for i, port := range visible {
+		cursor := " "
 		style := lipgloss.NewStyle()
 		if m.cursor == i {
+			cursor = ">"
 			style = style.Foreground(lipgloss.Color("#FF75B7"))
 		}
 
-		s.WriteString(fmt.Sprintf("%s %s\n", cursor, style.Render(fmt.Sprintf("Port %d", port))))
+		category := m.portCategory(port)
+		line := fmt.Sprintf("%s %s  %s", cursor, style.Render(fmt.Sprintf("Port %-5d", port)), category.Style().Render(fmt.Sprintf("%-9s", category)))
+		if hostPort, ok := m.container.Published[port]; ok {
+			line += "  " + dimStyle.Render(fmt.Sprintf("published as host port %d", hostPort))
+		}
+		s.WriteString(line + "\n")
 	}
 
+	s.WriteString(m.renderAgentForwarding())
 	s.WriteString("\n")
-	s.WriteString("Controls:\n")
-	s.WriteString("  ↑/↓: Navigate  Enter: Forward  m: Manual port  Esc: Back  q: Quit\n")
+	s.WriteString(dimStyle.Render("Connections are relayed into the container with docker exec.") + "\n\n")
+
+	return s.String()
+}
 
+// probeStyle colors an HTTP probe result by its status class
+func probeStyle(probe HTTPProbe) lipgloss.Style {
+	switch {
+	case probe.Status == "":
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	case strings.HasPrefix(probe.Status, "2"), strings.HasPrefix(probe.Status, "3"):
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575"))
+	default:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+	}
+}
+
+// renderRemovedPorts lists the ports that the latest refresh no longer found
+// when none are listening anymore
+func (m *Model) renderRemovedPorts() string {
+	if len(m.removedPorts) == 0 {
+		return ""
+	}
+
+	var s strings.Builder
+	s.WriteString("\n")
+	for _, port := range m.removedPorts {
+		s.WriteString(m.renderRemovedPort(port) + "\n")
+	}
 	return s.String()
 }
 
@@ -413,39 +2216,26 @@ func (m *Model) renderManualPort() string {
 	s.WriteString(labelStyle.Render("Remote port number:"))
 	s.WriteString("\n\n")
 	
-	// Input box styling
-	inputStyle := lipgloss.NewStyle().
-		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("#7D56F4")).
-		Padding(0, 1).
-		Width(20).
-		Align(lipgloss.Left)
-	
-	// Show placeholder or current input
-	displayText := m.manualPort
-	if displayText == "" {
-		placeholderStyle := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#666666")).
-			Italic(true)
-		displayText = placeholderStyle.Render("e.g., 3000")
-	}
-	
-	s.WriteString(inputStyle.Render(displayText))
+	s.WriteString(renderTextInput(m.portInput))
 	s.WriteString("\n\n")
 	
-	// Add cursor indicator
-	if m.manualPort != "" {
-		cursorStyle := lipgloss.NewStyle().
-			Foreground(lipgloss.Color("#FF75B7"))
-		s.WriteString(cursorStyle.Render("│"))
-		s.WriteString("\n\n")
-	} else {
+	if suggestions := m.manualSuggestions(); len(suggestions) > 0 {
+		s.WriteString("Suggestions:\n")
+		reasonStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+		for i, suggestion := range suggestions {
+			cursor := " "
+			portStyle := lipgloss.NewStyle()
+			if i == m.suggestion {
+				cursor = ">"
+				portStyle = portStyle.Foreground(lipgloss.Color("#FF75B7"))
+			}
+			s.WriteString(fmt.Sprintf("%s %s  %s\n", cursor, portStyle.Render(fmt.Sprintf("%-5d", suggestion.Port)), reasonStyle.Render(suggestion.Reason)))
+		}
 		s.WriteString("\n")
 	}
-	
-	s.WriteString("Controls:\n")
-	s.WriteString("  0-9: Enter digits  Backspace: Delete  Enter: Start forwarding\n")
-	s.WriteString("  Esc: Back  q: Quit\n")
+
+	s.WriteString(m.renderTTL())
+	s.WriteString("\n")
 
 	return s.String()
 }
@@ -461,8 +2251,6 @@ func (m *Model) renderStartingForward() string {
 	s.WriteString(startingStyle.Render("🚀 " + m.message))
 	s.WriteString("\n\n")
 	s.WriteString("Setting up SSH tunnel and port forwarding...\n\n")
-	s.WriteString("Controls:\n")
-	s.WriteString("  Esc: Cancel  q: Quit\n")
 
 	return s.String()
 }
@@ -475,10 +2263,33 @@ func (m *Model) renderForwarding() string {
 		Foreground(lipgloss.Color("#04B575")).
 		Bold(true)
 	
+	if m.forwarder != nil && m.forwarder.Expired() {
+		expiredStyle := lipgloss.NewStyle().
+			Foreground(lipgloss.Color("#FFA500")).
+			Bold(true)
+		s.WriteString(expiredStyle.Render(fmt.Sprintf("⌛ Time limit of %s reached, tunnel closed", m.forwarder.Options().TTL)))
+		s.WriteString("\n\n")
+		s.WriteString(m.tunnelSummary())
+		s.WriteString("\n\n")
+		return s.String()
+	}
+
 	s.WriteString(successStyle.Render("✓ Port Forwarding Active"))
 	s.WriteString("\n\n")
-	s.WriteString(m.message)
+	s.WriteString(m.renderRemoteDown())
+	s.WriteString(m.renderLabel())
+	s.WriteString(m.tunnelSummary())
 	s.WriteString("\n\n")
+	if m.forwarder != nil {
+		s.WriteString(m.renderJumpChain(m.forwarder.Host().Name))
+	}
+
+	if m.forwarder != nil && !m.forwarder.ExpiresAt().IsZero() {
+		remaining := time.Until(m.forwarder.ExpiresAt()).Round(time.Second)
+		countdownStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+		s.WriteString(countdownStyle.Render(fmt.Sprintf("⏳ Closes in %s (time limit %s)", formatCountdown(remaining), m.forwarder.Options().TTL)))
+		s.WriteString("\n\n")
+	}
 	
 	// Add helpful access information
 	accessStyle := lipgloss.NewStyle().
@@ -488,20 +2299,475 @@ func (m *Model) renderForwarding() string {
 	s.WriteString(accessStyle.Render("Access your service:"))
 	s.WriteString("\n")
 	
-	// Extract local port from message for display
-	if strings.Contains(m.message, "localhost:") {
-		parts := strings.Split(m.message, "localhost:")
-		if len(parts) > 1 {
-			portPart := strings.Split(parts[1], " ")[0]
-			s.WriteString(fmt.Sprintf("  • http://localhost:%s\n", portPart))
-			s.WriteString(fmt.Sprintf("  • https://localhost:%s\n", portPart))
-			s.WriteString(fmt.Sprintf("  • Or connect to localhost:%s with any client\n", portPart))
+	if m.forwarder != nil && m.forwarder.LocalSocket() == "" {
+		localPort := m.forwarder.LocalPort()
+		if m.forwarder.Options().HTTPS {
+			s.WriteString(fmt.Sprintf("  • https://localhost:%d (TLS terminated by kport)\n", localPort))
+			s.WriteString(fmt.Sprintf("  • Trust the kport CA to avoid warnings: %s\n", m.forwarder.CACertPath()))
+		} else {
+			s.WriteString(fmt.Sprintf("  • http://localhost:%d\n", localPort))
+			s.WriteString(fmt.Sprintf("  • https://localhost:%d\n", localPort))
+		}
+		s.WriteString(fmt.Sprintf("  • Or connect to localhost:%d with any client\n", localPort))
+	} else if m.forwarder != nil && m.forwarder.LocalSocket() != "" {
+		s.WriteString(fmt.Sprintf("  • curl --unix-socket %s http://localhost/\n", m.forwarder.LocalSocket()))
+		s.WriteString(fmt.Sprintf("  • Or connect to %s with any client that speaks Unix sockets\n", m.forwarder.LocalSocket()))
+	}
+
+	// Extra ports and the IPv6 listener all feed the same tunnel, which
+	// listens on the host's BindAddress when it has one
+	if m.forwarder != nil {
+		if addrs := m.forwarder.ListenAddrs(); len(addrs) > 1 || m.forwarder.Options().BindAddress != "" {
+			s.WriteString(fmt.Sprintf("  • Listening on %s\n", strings.Join(addrs, ", ")))
+		}
+		if m.forwarder.Options().LANAddress != "" {
+			s.WriteString("  • Other machines connect over TLS with a client certificate from `kport lan issue <name>`\n")
+		}
+		if accessLog := m.forwarder.AccessLog(); accessLog != nil {
+			s.WriteString(fmt.Sprintf("  • Requests are logged to %s\n", accessLog.Path()))
 		}
 	}
 	
+	s.WriteString(m.renderConnections())
+	s.WriteString(m.renderErrors())
+	s.WriteString(m.renderLocalHints())
+	s.WriteString(m.renderOtherTunnels())
+	s.WriteString(m.renderDestinations())
+	s.WriteString(m.renderCapture())
+	s.WriteString(m.renderAgentForwarding())
+	s.WriteString(m.renderShare())
+	if forwards := m.renderConfiguredForwards(); forwards != "" {
+		s.WriteString("\n" + forwards)
+	}
+	if m.exportStatus != "" {
+		s.WriteString("\n" + m.renderExportStatus() + "\n")
+	}
+
+	s.WriteString("\n")
+
+	return s.String()
+}
+
+// renderRemoteDown warns that the active tunnel's remote service stopped listening
+func (m *Model) renderRemoteDown() string {
+	if m.forwarder == nil {
+		return ""
+	}
+
+	since, err := m.forwarder.RemoteDown()
+	if since.IsZero() {
+		return ""
+	}
+
+	var s strings.Builder
+
+	downStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#FF5F87")).
+		Bold(true)
+
+	s.WriteString(downStyle.Render(fmt.Sprintf("⚠️  Remote service %s:%d stopped listening %s ago",
+		m.forwarder.Host().Name, m.forwarder.RemotePort(), time.Since(since).Round(time.Second))))
+	s.WriteString("\n")
+	s.WriteString(fmt.Sprintf("  Connections to %s will fail until it is back: %v\n\n", m.forwarder.LocalAddress(), err))
+
+	return s.String()
+}
+
+// renderCapture renders the traffic capture status of the active tunnel
+func (m *Model) renderCapture() string {
+	var s strings.Builder
+
+	captureStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#7D56F4")).
+		Bold(true)
+
+	s.WriteString("\n")
+	s.WriteString(captureStyle.Render("Traffic capture:"))
+
+	var capture *Capture
+	if m.forwarder != nil {
+		capture = m.forwarder.Capture()
+	}
+	if capture == nil {
+		s.WriteString(" off\n")
+		return s.String()
+	}
+
+	s.WriteString(fmt.Sprintf(" %s -> %s\n", capture.Mode(), capture.Path()))
+
+	if capture.Mode() == CaptureHTTP {
+		recent := capture.Recent()
+		if len(recent) == 0 {
+			s.WriteString("  (no HTTP requests yet)\n")
+		}
+		for _, summary := range recent {
+			s.WriteString(fmt.Sprintf("  %s\n", summary))
+		}
+	}
+
+	return s.String()
+}
+
+// renderConnections renders diagnostics about the active tunnel's proxied connections
+func (m *Model) renderConnections() string {
+	if m.forwarder == nil {
+		return ""
+	}
+
+	var s strings.Builder
+
+	titleStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#7D56F4")).
+		Bold(true)
+	streamStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#00BFFF"))
+
+	stats := m.forwarder.ConnStats()
+
+	s.WriteString("\n")
+	s.WriteString(titleStyle.Render("Connections:"))
+	s.WriteString(fmt.Sprintf(" %d active, %d total\n", stats.Active, stats.Total))
+
+	// Every proxied connection is a channel on the tunnel's ssh client
+	channels := fmt.Sprintf("SSH channels: %d open", stats.Channels)
+	if stats.ChannelLimit > 0 {
+		channels = fmt.Sprintf("SSH channels: %d of %d open", stats.Channels, stats.ChannelLimit)
+		if stats.LimitLearned {
+			channels += " (limit learned from the server)"
+		}
+	}
+	if stats.Queued > 0 {
+		channels += fmt.Sprintf(", %d queued", stats.Queued)
+	}
+	if stats.NearChannelLimit() || stats.Queued > 0 {
+		warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+		s.WriteString("  " + warningStyle.Render("⚠ "+channels+", new connections wait at the limit") + "\n")
+	} else {
+		s.WriteString("  " + channels + "\n")
+	}
+
+	// Heavy browser traffic can run kport out of files, 256 by default on macOS
+	if usage, ok := currentFileUsage(); ok {
+		files := fmt.Sprintf("Sockets: %d, %d of %d files open in kport", m.forwarder.OpenSockets(), usage.Open, usage.Limit)
+		if usage.Near() {
+			warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+			s.WriteString("  " + warningStyle.Render("⚠ "+files+", raise file_limit in the kport config") + "\n")
+		} else {
+			s.WriteString("  " + files + "\n")
+		}
+	}
+
+	// A daemon or TUI left running for weeks shows leaks here first
+	resources := currentResourceUsage()
+	s.WriteString(fmt.Sprintf("  kport: %s, %d in this tunnel\n", resources, resources.TunnelGoroutines[m.forwarder.LocalPort()]))
+
+	s.WriteString(renderThroughput(m.forwarder.RecentThroughput(throughputGraphWidth)))
+
+	if stats.Active > 0 {
+		s.WriteString(fmt.Sprintf("  %d short", stats.Short))
+		if stats.WebSocket > 0 || stats.Streaming > 0 {
+			s.WriteString(streamStyle.Render(fmt.Sprintf("  ⇄ %d WebSocket  %d streaming", stats.WebSocket, stats.Streaming)))
+		}
+		s.WriteString("\n")
+	}
+
+	options := m.forwarder.Options()
+	if options.IdleTimeout > 0 || options.StreamIdleTimeout > 0 {
+		s.WriteString(fmt.Sprintf("  Idle timeout: %s (streams: %s)\n",
+			formatTimeout(options.IdleTimeout), formatTimeout(options.StreamIdleTimeout)))
+	}
+
+	return s.String()
+}
+
+// renderErrors renders the active tunnel's recent errors with repeats coalesced
+func (m *Model) renderErrors() string {
+	if m.forwarder == nil {
+		return ""
+	}
+
+	recent := m.forwarder.Errors()
+	if len(recent) == 0 {
+		return ""
+	}
+
+	var s strings.Builder
+
+	errorStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#FF5F87")).
+		Bold(true)
+
+	s.WriteString("\n")
+	s.WriteString(errorStyle.Render("Recent errors:"))
+	s.WriteString("\n")
+	for _, err := range recent {
+		s.WriteString(fmt.Sprintf("  • %s\n", err))
+	}
+
+	return s.String()
+}
+
+// renderExportStatus renders where the last tunnel inventory export was written
+func (m *Model) renderExportStatus() string {
+	if m.exportStatus == "" {
+		return ""
+	}
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	return dimStyle.Render(m.exportStatus)
+}
+
+// renderLocalHints renders guidance for local problems reaching the active tunnel
+func (m *Model) renderLocalHints() string {
+	if m.forwarder == nil {
+		return ""
+	}
+
+	hints := m.forwarder.LocalHints()
+	if len(hints) == 0 {
+		return ""
+	}
+
+	var s strings.Builder
+
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+
+	s.WriteString("\n")
+	s.WriteString(warningStyle.Render("⚠️  Local clients may not reach this tunnel:"))
+	s.WriteString("\n")
+	for _, hint := range hints {
+		s.WriteString(fmt.Sprintf("  • %s\n", hint))
+	}
+
+	return s.String()
+}
+
+// renderOtherTunnels lists the local ports held by other running kport instances
+func (m *Model) renderOtherTunnels() string {
+	if len(m.otherTunnels) == 0 {
+		return ""
+	}
+
+	var s strings.Builder
+
+	s.WriteString("\n")
+	s.WriteString("Other kport tunnels:\n")
+	for _, reservation := range m.otherTunnels {
+		s.WriteString(fmt.Sprintf("  • localhost:%d -> %s:%d (pid %d)\n",
+			reservation.Port, reservation.Host, reservation.RemotePort, reservation.PID))
+	}
+
+	return s.String()
+}
+
+// formatTimeout renders a timeout where zero means disabled
+func formatTimeout(timeout time.Duration) string {
+	if timeout == 0 {
+		return "none"
+	}
+	return timeout.String()
+}
+
+// renderDestinations renders the failover destinations of the active tunnel
+// and the address each one is reached on
+func (m *Model) renderDestinations() string {
+	if m.forwarder == nil {
+		return ""
+	}
+
+	destinations, active := m.forwarder.Destinations()
+	if len(destinations) < 2 && len(destinations[0].Addresses) < 2 && destinations[0].Service == "" {
+		return ""
+	}
+
+	var s strings.Builder
+
+	titleStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#7D56F4")).
+		Bold(true)
+	downStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF5F87"))
+
+	s.WriteString("\n")
+	s.WriteString(titleStyle.Render("Destinations:"))
+	s.WriteString("\n")
+
+	for i, dest := range destinations {
+		marker := " "
+		if i == active {
+			marker = "*"
+		}
+
+		status := "up"
+		if !dest.Healthy {
+			status = downStyle.Render("down")
+		}
+		if dest.Checked.IsZero() {
+			status = "not checked yet"
+		}
+
+		via := ""
+		if dest.Via < len(dest.Addresses) {
+			via = fmt.Sprintf(" via %s of %d addresses", dest.Addresses[dest.Via].IP, len(dest.Addresses))
+		}
+
+		if dest.Service != "" {
+			via += " from " + dest.Service
+		}
+		s.WriteString(fmt.Sprintf("  %s %s%s (%s)\n", marker, dest.Address(), via, status))
+	}
+
+	return s.String()
+}
+
+// renderForwardsStatus describes whether the configured forwards of the selected host are open
+func (m *Model) renderForwardsStatus() string {
+	host := m.hosts[m.selectedHost]
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+	activeStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#04B575"))
+
+	forwarder := m.configuredForwarders[host.Name]
+	switch status := m.forwardsStatus[host.Name]; {
+	case forwarder != nil && forwarder.IsRunning():
+		return activeStyle.Render("established") + dimStyle.Render(fmt.Sprintf(", press %s to close them", m.keys.Label(ActionForwards)))
+	case forwarder != nil:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500")).Render("ssh exited") + dimStyle.Render(fmt.Sprintf(", press %s to reconnect", m.keys.Label(ActionForwards)))
+	case status == forwardsStarting:
+		return dimStyle.Render("establishing...")
+	default:
+		return dimStyle.Render(fmt.Sprintf("press %s to establish them", m.keys.Label(ActionForwards)))
+	}
+}
+
+// renderConfiguredForwards lists the forwards the SSH config defines for the
+// selected host and whether kport has established them
+func (m *Model) renderConfiguredForwards() string {
+	host := m.hosts[m.selectedHost]
+	if len(host.Forwards) == 0 {
+		return ""
+	}
+
+	var s strings.Builder
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#666666"))
+
+	s.WriteString("Configured forwards: ")
+	s.WriteString(m.renderForwardsStatus())
+	s.WriteString("\n")
+
+	for _, forward := range host.Forwards {
+		line := "  " + forward.String()
+		if forward.Source != "" {
+			line += dimStyle.Render("  from " + forward.Source)
+		}
+		s.WriteString(line + "\n")
+	}
+	if status := m.forwardsStatus[host.Name]; status != "" && status != forwardsStarting {
+		s.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500")).Render("⚠️  " + status))
+		s.WriteString("\n")
+	}
+	s.WriteString("\n")
+
+	return s.String()
+}
+
+// renderLabel renders the label and note of the active tunnel
+func (m *Model) renderLabel() string {
+	if m.forwarder == nil {
+		return ""
+	}
+	label, note := m.forwarder.Label()
+	if label == "" && note == "" {
+		return ""
+	}
+
+	var s strings.Builder
+	if label != "" {
+		labelStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FF75B7")).Bold(true)
+		s.WriteString(labelStyle.Render("🏷  " + label))
+		s.WriteString("\n")
+	}
+	if note != "" {
+		s.WriteString(lipgloss.NewStyle().Italic(true).Render(note))
+		s.WriteString("\n")
+	}
+	s.WriteString("\n")
+	return s.String()
+}
+
+// renderAgentForwarding renders the status of SSH agent forwarding
+func (m *Model) renderAgentForwarding() string {
+	if m.agentForwarder == nil && m.agentStatus == "" {
+		return ""
+	}
+
+	var s strings.Builder
+
+	agentStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#04B575")).
+		Bold(true)
+
+	s.WriteString("\n")
+	if m.agentForwarder == nil {
+		s.WriteString(m.agentStatus)
+		s.WriteString("\n")
+		return s.String()
+	}
+
+	s.WriteString(agentStyle.Render(fmt.Sprintf("🔑 SSH agent forwarded to %s", m.agentForwarder.Host().Name)))
+	s.WriteString("\n")
+	s.WriteString(fmt.Sprintf("  On the remote host run: export SSH_AUTH_SOCK=%s\n", m.agentForwarder.RemotePath()))
+
+	return s.String()
+}
+
+// renderShare renders the public share link of the active tunnel
+func (m *Model) renderShare() string {
+	if m.share == nil && m.shareStatus == "" {
+		return ""
+	}
+
+	var s strings.Builder
+
 	s.WriteString("\n")
-	s.WriteString("Controls:\n")
-	s.WriteString("  Esc: Stop forwarding and return  q: Quit\n")
+	if m.share == nil {
+		s.WriteString(m.shareStatus)
+		s.WriteString("\n")
+		return s.String()
+	}
+	if !m.share.IsRunning() {
+		s.WriteString(fmt.Sprintf("Share link %s closed: %s\n", m.share.URL(), m.share.Closed()))
+		return s.String()
+	}
+
+	shareStyle := lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#04B575")).
+		Bold(true)
+	warningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+
+	remaining := time.Until(m.share.ExpiresAt()).Round(time.Second)
+	s.WriteString(shareStyle.Render(fmt.Sprintf("🌐 Shared at %s", m.share.URL())))
+	s.WriteString(fmt.Sprintf(" (expires in %s)\n", formatCountdown(remaining)))
+	s.WriteString("  " + warningStyle.Render(fmt.Sprintf("Anyone with the link reaches this tunnel through %s", m.share.Relay().Name)) + "\n")
 
 	return s.String()
+}
+
+// renderTTL renders the time limit that will be applied to the next tunnel
+func (m *Model) renderTTL() string {
+	if m.ttl == 0 {
+		return "\nTime limit: none\n"
+	}
+	ttlStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#FFA500"))
+	return "\n" + ttlStyle.Render(fmt.Sprintf("Time limit: %s", m.ttl)) + "\n"
+}
+
+// formatCountdown renders a remaining duration as h:mm:ss or m:ss
+func formatCountdown(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	hours := int(d.Hours())
+	minutes := int(d.Minutes()) % 60
+	seconds := int(d.Seconds()) % 60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+	}
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
 }
\ No newline at end of file
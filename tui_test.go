@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/exp/teatest"
+)
+
+// TestForwardFromTUI picks a demo host and one of its ports in the TUI and
+// checks that the tunnel it starts reaches the service
+func TestForwardFromTUI(t *testing.T) {
+	if err := startDemo(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	m := NewModel()
+	defer func() {
+		// Quitting leaves prewarmed connections open for the next run
+		if m.prewarm != nil {
+			m.prewarm.Stop()
+		}
+		m.Cleanup()
+	}()
+	tm := teatest.NewTestModel(t, m, teatest.WithInitialTermSize(120, 40))
+
+	waitForOutput(t, tm, "web-1")
+	tm.Send(tea.KeyMsg{Type: tea.KeyEnter})
+
+	// Ports 22 and 80 come before the node service on 3000
+	waitForOutput(t, tm, "prometheus")
+	tm.Send(tea.KeyMsg{Type: tea.KeyDown})
+	tm.Send(tea.KeyMsg{Type: tea.KeyDown})
+	tm.Send(tea.KeyMsg{Type: tea.KeyEnter})
+
+	waitForOutput(t, tm, "-> web-1:3000")
+	tm.Send(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	final, ok := tm.FinalModel(t, teatest.WithFinalTimeout(5*time.Second)).(*Model)
+	if !ok || final.forwarder == nil {
+		t.Fatal("the TUI quit without a tunnel")
+	}
+
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", final.forwarder.LocalPort()), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(response, []byte("HTTP/1.1 200 OK")) {
+		t.Fatalf("unexpected response through the tunnel:\n%s", response)
+	}
+}
+
+// waitForOutput waits until the TUI has shown text
+func waitForOutput(t *testing.T, tm *teatest.TestModel, text string) {
+	t.Helper()
+	teatest.WaitFor(t, tm.Output(), func(output []byte) bool {
+		return bytes.Contains(output, []byte(text))
+	}, teatest.WithDuration(10*time.Second))
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tunnel is a running tunnel owned by the tunnel manager
+type Tunnel struct {
+	Profile   string
+	Forwarder *PortForwarder
+
+	// ID is a short ID the CLI refers to the tunnel by, the same each time
+	// the profile comes up
+	ID string
+
+	// Name is the tunnel's name in its profile, empty when it has none
+	Name string
+
+	// Health is the HTTP path checked by `kport up --wait`, empty for a TCP check
+	Health string
+
+	// hooks run as the tunnel goes down, with vars filled in from the tunnel
+	hooks Hooks
+	vars  HookVars
+}
+
+// tunnelID derives the short ID of a tunnel from its profile and the name,
+// or host:remote_port, it has there
+func tunnelID(profile, name string) string {
+	sum := sha256.Sum256([]byte(profile + "\x00" + name))
+	return hex.EncodeToString(sum[:2])
+}
+
+// matches reports whether selector names the tunnel: its ID, its local port
+// or socket, its name or host:remote_port, either on their own or after "profile/"
+func (t *Tunnel) matches(selector string) bool {
+	if socket := t.Forwarder.LocalSocket(); socket != "" {
+		if selector == t.ID || selector == socket {
+			return true
+		}
+	} else if selector == t.ID || selector == strconv.Itoa(t.Forwarder.LocalPort()) {
+		return true
+	}
+	if profile, name, ok := strings.Cut(selector, "/"); ok {
+		if profile != t.Profile {
+			return false
+		}
+		selector = name
+	}
+	return (t.Name != "" && selector == t.Name) || selector == fmt.Sprintf("%s:%d", t.Forwarder.Host().Name, t.Forwarder.RemotePort())
+}
+
+// stop closes the tunnel between its pre-down and post-down hooks
+func (t *Tunnel) stop() {
+	if err := t.hooks.run(HookPreDown, t.vars); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: %v\n", err)
+	}
+	t.Forwarder.Stop()
+	if err := t.hooks.run(HookPostDown, t.vars); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: %v\n", err)
+	}
+}
+
+// TunnelManager owns the tunnels started for profiles and guarantees they are stopped
+type TunnelManager struct {
+	tunnels []*Tunnel
+	mu      sync.Mutex
+}
+
+// NewTunnelManager creates an empty tunnel manager
+func NewTunnelManager() *TunnelManager {
+	return &TunnelManager{
+		tunnels: make([]*Tunnel, 0),
+	}
+}
+
+// Up starts every tunnel of a profile, each one as soon as the tunnels it
+// comes after are healthy, so independent tunnels start in parallel. If a
+// tunnel fails, the tunnels already started for the profile are stopped
+// again, unless the profile turns off exit_on_forward_failure: then the
+// tunnels that started are kept and returned along with the error.
+// progress, when not nil, is told about each tunnel as it goes.
+func (tm *TunnelManager) Up(name string, profile ProfileConfig, hosts []SSHHost, kportConfig *KportConfig, progress func(TunnelProgress)) ([]*Tunnel, error) {
+	report := func(tunnel TunnelConfig, state string, err error) {
+		if progress == nil {
+			return
+		}
+		update := TunnelProgress{Profile: name, Tunnel: tunnel.ID(), State: state}
+		if err != nil {
+			update.Error = err.Error()
+		}
+		progress(update)
+	}
+
+	if running := tm.ProfileTunnels(name); len(running) > 0 {
+		return running, nil
+	}
+
+	deps, err := profile.dependencies()
+	if err != nil {
+		return nil, err
+	}
+	for i := range profile.Tunnels {
+		profile.Tunnels[i].Hooks = profile.Tunnels[i].Hooks.inherit(profile.Hooks)
+		if err := profile.Tunnels[i].Hooks.validate(); err != nil {
+			return nil, fmt.Errorf("tunnel %s: %w", profile.Tunnels[i].ID(), err)
+		}
+	}
+	stage := stages(deps)
+	dependedOn := make([]bool, len(profile.Tunnels))
+	for _, tunnelDeps := range deps {
+		for _, dep := range tunnelDeps {
+			dependedOn[dep] = true
+		}
+	}
+
+	type result struct {
+		tunnel  *Tunnel
+		err     error
+		skipped bool
+	}
+	results := make([]result, len(profile.Tunnels))
+	done := make([]chan struct{}, len(profile.Tunnels))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	for i, tunnelConfig := range profile.Tunnels {
+		if len(deps[i]) > 0 {
+			report(tunnelConfig, ProgressWaiting, nil)
+		}
+		go func() {
+			defer close(done[i])
+			for _, dep := range deps[i] {
+				<-done[dep]
+				if results[dep].err != nil || results[dep].skipped {
+					results[i].skipped = true
+					report(tunnelConfig, ProgressSkipped, nil)
+					return
+				}
+			}
+
+			report(tunnelConfig, ProgressStarting, nil)
+			tunnel, err := tm.start(name, tunnelConfig, hosts, kportConfig)
+			// Tunnels that come after this one need its remote service, not just its listener
+			if err == nil && dependedOn[i] {
+				if err = waitTunnelHealthy(healthAddress(tunnel.Forwarder.LocalPort(), tunnel.Forwarder.LocalSocket()), tunnel.Health, time.Now().Add(defaultWaitTimeout)); err != nil {
+					err = fmt.Errorf("not healthy after %s: %w", defaultWaitTimeout, err)
+				}
+			}
+			if err != nil {
+				report(tunnelConfig, ProgressFailed, err)
+			} else {
+				report(tunnelConfig, ProgressUp, nil)
+			}
+			results[i] = result{tunnel: tunnel, err: err}
+		}()
+	}
+	for i := range done {
+		<-done[i]
+	}
+
+	started := make([]*Tunnel, 0, len(profile.Tunnels))
+	upErr := &ProfileUpError{Stages: slices.Max(stage)}
+	for i, r := range results {
+		if r.tunnel != nil {
+			started = append(started, r.tunnel)
+		}
+		switch {
+		case r.err != nil:
+			upErr.Failed = append(upErr.Failed, StageFailure{Tunnel: profile.Tunnels[i].ID(), Stage: stage[i], Err: r.err})
+		case r.skipped:
+			upErr.Skipped = append(upErr.Skipped, profile.Tunnels[i].ID())
+		}
+	}
+	if len(upErr.Failed) > 0 && (profile.exitOnForwardFailure() || len(started) == 0) {
+		for i, r := range results {
+			if r.tunnel != nil {
+				r.tunnel.stop()
+				report(profile.Tunnels[i], ProgressClosed, nil)
+			}
+		}
+		return nil, upErr
+	}
+
+	tm.mu.Lock()
+	tm.tunnels = append(tm.tunnels, started...)
+	tm.mu.Unlock()
+
+	if len(upErr.Failed) > 0 {
+		return started, upErr
+	}
+	return started, nil
+}
+
+// StageFailure is a tunnel that failed while a profile was brought up
+type StageFailure struct {
+	Tunnel string
+	Stage  int
+	Err    error
+}
+
+// ProfileUpError reports which stage of bringing up a profile broke and the
+// tunnels that were skipped because they come after a failed one
+type ProfileUpError struct {
+	Stages  int
+	Failed  []StageFailure
+	Skipped []string
+}
+
+// Error describes every failed tunnel, with its stage when the profile has several
+func (e *ProfileUpError) Error() string {
+	var s strings.Builder
+	for i, failure := range e.Failed {
+		if i > 0 {
+			s.WriteString("\n")
+		}
+		if e.Stages > 1 {
+			fmt.Fprintf(&s, "stage %d of %d failed: ", failure.Stage, e.Stages)
+		}
+		fmt.Fprintf(&s, "failed to bring up %s: %v", failure.Tunnel, failure.Err)
+	}
+	if len(e.Skipped) > 0 {
+		fmt.Fprintf(&s, "\nskipped, since they come after a failed tunnel: %s", strings.Join(e.Skipped, ", "))
+	}
+	return s.String()
+}
+
+// start starts a single tunnel of a profile
+func (tm *TunnelManager) start(profile string, tunnelConfig TunnelConfig, hosts []SSHHost, kportConfig *KportConfig) (tunnel *Tunnel, err error) {
+	host, err := findHost(hosts, tunnelConfig.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := tracer.Start(context.Background(), "kport.forward", trace.WithAttributes(append(hostAttributes(host),
+		attribute.String("kport.profile", profile),
+		attribute.Int("kport.remote_port", tunnelConfig.RemotePort),
+	)...))
+	defer func() { endSpan(span, err) }()
+
+	// Profiles are written down ahead of time, so they count as confirmed and
+	// only a policy that denies stops them
+	options := forwardOptionsFor(host, kportConfig.HostFor(host), tunnelConfig.RemotePort)
+	options.Confirmed = true
+	if tunnelConfig.Destination != "" {
+		options.Destination = tunnelConfig.Destination
+	}
+	if tunnelConfig.LocalSocket != "" {
+		if tunnelConfig.LocalPort != 0 {
+			return nil, fmt.Errorf("tunnel %s sets both local_port and local_socket", tunnelConfig.ID())
+		}
+		if options.LocalSocket, err = localSocketPath(tunnelConfig.LocalSocket); err != nil {
+			return nil, err
+		}
+	}
+	policy := currentPolicy()
+	if err := policyError(policy.tunnelRisks(tunnelConfig.LocalPort, tunnelConfig.RemotePort, options), true); err != nil {
+		return nil, err
+	}
+
+	localPort := tunnelConfig.LocalPort
+	switch {
+	case options.LocalSocket != "":
+		// Tunnels on a local socket take no port
+	case localPort == 0:
+		localPort, _, err = reserveLocalPort(host.Name, tunnelConfig.RemotePort, policy.allowsPrivilegedPort(options))
+		if err != nil {
+			return nil, fmt.Errorf("failed to find available local port: %w", err)
+		}
+	default:
+		if err := reserveExactLocalPort(localPort, host.Name, tunnelConfig.RemotePort); err != nil {
+			return nil, err
+		}
+	}
+
+	host, err = tracedPrepareHost(ctx, host)
+	if err != nil {
+		releaseLocalPort(localPort)
+		return nil, err
+	}
+
+	if tunnelConfig.Prepare != "" {
+		if err := runPrepare(host, tunnelConfig.Prepare); err != nil {
+			releaseLocalPort(localPort)
+			return nil, err
+		}
+	}
+
+	vars := HookVars{
+		Profile:    profile,
+		Tunnel:     tunnelConfig.ID(),
+		Host:       host.Name,
+		LocalPort:  localPort,
+		RemotePort: tunnelConfig.RemotePort,
+	}
+	if err := tunnelConfig.Hooks.run(HookPreUp, vars); err != nil {
+		releaseLocalPort(localPort)
+		return nil, err
+	}
+
+	forwarder := NewPortForwarder(host, localPort, tunnelConfig.RemotePort, options)
+	if err := forwarder.StartContext(ctx); err != nil {
+		releaseLocalPort(localPort)
+		return nil, err
+	}
+
+	// The tunnel is up either way, so a failing post-up hook only shows in its errors
+	if err := tunnelConfig.Hooks.run(HookPostUp, vars); err != nil {
+		forwarder.errors.Record(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Debug: Profile %s: forwarding %s -> %s:%d\n", profile, forwarder.LocalAddress(), host.Name, tunnelConfig.RemotePort)
+	return &Tunnel{
+		Profile:   profile,
+		Forwarder: forwarder,
+		ID:        tunnelID(profile, tunnelConfig.ID()),
+		Name:      tunnelConfig.Name,
+		Health:    tunnelConfig.Health,
+		hooks:     tunnelConfig.Hooks,
+		vars:      vars,
+	}, nil
+}
+
+// runPrepare runs a tunnel's prepare command on its host and fails unless it succeeds
+func runPrepare(host SSHHost, command string) error {
+	fmt.Fprintf(os.Stderr, "Debug: Running prepare command on %s: %s\n", host.Name, command)
+
+	cmd := sshCommand(host, host.probeOptions(10), command)
+	started := time.Now()
+	output, err := cmd.CombinedOutput()
+	recordRemoteCommand(cmd, "prepare", started, err)
+	if err != nil {
+		if sshErr := sshConnectionError(host, output, err); sshErr != nil {
+			return fmt.Errorf("prepare command %q failed: %w", command, sshErr)
+		}
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			return fmt.Errorf("prepare command %q failed: %w: %s", command, err, last)
+		}
+		return fmt.Errorf("prepare command %q failed: %w", command, err)
+	}
+	return nil
+}
+
+// Adopt hands a started forwarder to the manager, which then owns stopping it
+func (tm *TunnelManager) Adopt(profile string, forwarder *PortForwarder) *Tunnel {
+	// Adopted tunnels have no name, and several may go to the same host and port
+	key := fmt.Sprintf("%s:%d:%d", forwarder.Host().Name, forwarder.RemotePort(), forwarder.LocalPort())
+	tunnel := &Tunnel{Profile: profile, Forwarder: forwarder, ID: tunnelID(profile, key)}
+
+	tm.mu.Lock()
+	tm.tunnels = append(tm.tunnels, tunnel)
+	tm.mu.Unlock()
+
+	return tunnel
+}
+
+// Stop stops a forwarder and releases it from the manager
+func (tm *TunnelManager) Stop(forwarder *PortForwarder) {
+	tm.mu.Lock()
+	var stopping *Tunnel
+	remaining := make([]*Tunnel, 0, len(tm.tunnels))
+	for _, tunnel := range tm.tunnels {
+		if tunnel.Forwarder != forwarder {
+			remaining = append(remaining, tunnel)
+		} else {
+			stopping = tunnel
+		}
+	}
+	tm.tunnels = remaining
+	tm.mu.Unlock()
+
+	if stopping != nil {
+		stopping.stop()
+		return
+	}
+	// Stop is idempotent, so forwarders the manager never saw are stopped too
+	forwarder.Stop()
+}
+
+// Down stops the tunnels of a profile, or of every instance of a profile
+// template, and returns how many were stopped
+func (tm *TunnelManager) Down(profile string) int {
+	tm.mu.Lock()
+	stopping := make([]*Tunnel, 0)
+	remaining := make([]*Tunnel, 0, len(tm.tunnels))
+	for _, tunnel := range tm.tunnels {
+		if isProfileInstance(tunnel.Profile, profile) {
+			stopping = append(stopping, tunnel)
+		} else {
+			remaining = append(remaining, tunnel)
+		}
+	}
+	tm.tunnels = remaining
+	tm.mu.Unlock()
+
+	for _, tunnel := range stopping {
+		tunnel.stop()
+	}
+	return len(stopping)
+}
+
+// StopAll stops every tunnel
+func (tm *TunnelManager) StopAll() {
+	tm.mu.Lock()
+	tunnels := tm.tunnels
+	tm.tunnels = make([]*Tunnel, 0)
+	tm.mu.Unlock()
+
+	for _, tunnel := range tunnels {
+		tunnel.stop()
+	}
+}
+
+// Tunnels returns all tunnels
+func (tm *TunnelManager) Tunnels() []*Tunnel {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	return append([]*Tunnel{}, tm.tunnels...)
+}
+
+// Find returns the tunnel selector names, failing when it names none or
+// several of them
+func (tm *TunnelManager) Find(selector string) (*Tunnel, error) {
+	var found []*Tunnel
+	for _, tunnel := range tm.Tunnels() {
+		// An ID that happens to be all digits wins over a local port
+		if tunnel.ID == selector {
+			return tunnel, nil
+		}
+		if tunnel.matches(selector) {
+			found = append(found, tunnel)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("the daemon has no tunnel %s, see `kport status`", selector)
+	case 1:
+		return found[0], nil
+	}
+	names := make([]string, 0, len(found))
+	for _, tunnel := range found {
+		names = append(names, fmt.Sprintf("%s (%s -> %s:%d)", tunnel.ID, tunnel.Forwarder.LocalAddress(), tunnel.Forwarder.Host().Name, tunnel.Forwarder.RemotePort()))
+	}
+	return nil, fmt.Errorf("%s names several tunnels, pick one by its ID or local port: %s", selector, strings.Join(names, ", "))
+}
+
+// ProfileTunnels returns the tunnels of a profile
+func (tm *TunnelManager) ProfileTunnels(profile string) []*Tunnel {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	tunnels := make([]*Tunnel, 0)
+	for _, tunnel := range tm.tunnels {
+		if tunnel.Profile == profile {
+			tunnels = append(tunnels, tunnel)
+		}
+	}
+	return tunnels
+}
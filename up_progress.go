@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of a tunnel coming up, streamed by the daemon to `kport up`
+const (
+	ProgressWaiting  = "waiting"
+	ProgressStarting = "starting"
+	ProgressUp       = "up"
+	ProgressFailed   = "failed"
+	ProgressSkipped  = "skipped"
+	ProgressClosed   = "closed"
+)
+
+// spinnerFrames animate the tunnels and profiles that are still coming up
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// TunnelProgress is a step of a tunnel of a profile coming up
+type TunnelProgress struct {
+	Profile string `json:"profile"`
+	Tunnel  string `json:"tunnel"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"`
+}
+
+// upProfile is a profile `kport up` brings up, with its tunnels in the order
+// the daemon first reported them
+type upProfile struct {
+	name    string
+	tunnels []TunnelProgress
+
+	// icon shows how the profile came up once it is done
+	icon string
+}
+
+// upProgress shows the tunnels of the profiles `kport up` brings up at once.
+// On a terminal it redraws a spinner per tunnel in place, and elsewhere it
+// prints a line per step.
+type upProgress struct {
+	mu       sync.Mutex
+	profiles []*upProfile
+	live     bool
+	frame    int
+
+	// drawn is how many lines the last redraw printed
+	drawn int
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newUpProgress starts showing the progress of profiles
+func newUpProgress(profiles []string) *upProgress {
+	p := &upProgress{stop: make(chan struct{})}
+	for _, name := range profiles {
+		p.profiles = append(p.profiles, &upProfile{name: name})
+	}
+	if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		p.live = true
+		p.redraw()
+		p.wg.Add(1)
+		go p.animate()
+	}
+	return p
+}
+
+// animate advances the spinners until the progress is finished
+func (p *upProgress) animate() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			p.frame++
+			p.redraw()
+			p.mu.Unlock()
+		}
+	}
+}
+
+// update records a step of a tunnel of the profile at index
+func (p *upProgress) update(index int, update TunnelProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	profile := p.profiles[index]
+	found := false
+	for i := range profile.tunnels {
+		if profile.tunnels[i].Tunnel == update.Tunnel {
+			profile.tunnels[i] = update
+			found = true
+		}
+	}
+	if !found {
+		profile.tunnels = append(profile.tunnels, update)
+	}
+
+	if p.live {
+		p.redraw()
+		return
+	}
+	line := fmt.Sprintf("   [%s] %s %s", profile.name, update.Tunnel, update.State)
+	if update.Error != "" {
+		line += ": " + strings.ReplaceAll(update.Error, "\n", "; ")
+	}
+	fmt.Println(line)
+}
+
+// finish records that the profile at index is done coming up, partly when
+// some of its tunnels failed and not at all when err is set
+func (p *upProgress) finish(index int, partly bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case err != nil:
+		p.profiles[index].icon = "❌"
+	case partly:
+		p.profiles[index].icon = "⚠️ "
+	default:
+		p.profiles[index].icon = "✅"
+	}
+	if p.live {
+		p.redraw()
+	}
+}
+
+// close stops the spinners and clears the progress, leaving the terminal
+// for the summary
+func (p *upProgress) close() {
+	close(p.stop)
+	p.wg.Wait()
+	if p.live && p.drawn > 0 {
+		fmt.Printf("\033[%dA\033[J", p.drawn)
+	}
+}
+
+// redraw replaces the lines drawn last time with the current progress. Errors
+// are left to the summary, since a line that wraps would throw off the count.
+func (p *upProgress) redraw() {
+	var s strings.Builder
+	if p.drawn > 0 {
+		fmt.Fprintf(&s, "\033[%dA\033[J", p.drawn)
+	}
+	spinner := spinnerFrames[p.frame%len(spinnerFrames)]
+
+	lines := 0
+	for _, profile := range p.profiles {
+		icon := profile.icon
+		if icon == "" {
+			icon = spinner
+		}
+		fmt.Fprintf(&s, "%s %s\n", icon, profile.name)
+		lines++
+
+		for _, tunnel := range profile.tunnels {
+			fmt.Fprintf(&s, "   %s %s %s\n", progressIcon(tunnel.State, spinner), tunnel.Tunnel, tunnel.State)
+			lines++
+		}
+	}
+	fmt.Print(s.String())
+	p.drawn = lines
+}
+
+// progressIcon shows the state of a tunnel, with spinner while it is starting
+func progressIcon(state, spinner string) string {
+	switch state {
+	case ProgressUp:
+		return "✅"
+	case ProgressFailed:
+		return "❌"
+	case ProgressWaiting:
+		return "⏳"
+	case ProgressSkipped, ProgressClosed:
+		return "⏭ "
+	}
+	return spinner
+}
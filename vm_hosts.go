@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TransportVM marks local development VMs, reached with the SSH config the
+// tool running them generates
+const TransportVM = "vm"
+
+// vagrantSSHConfigTimeout bounds `vagrant ssh-config`, which loads the machine's Vagrantfile
+const vagrantSSHConfigTimeout = 20 * time.Second
+
+// VMsConfig configures listing local development VMs as hosts
+type VMsConfig struct {
+	// Enabled lists running Vagrant machines and Lima and Colima instances,
+	// unless set to false
+	Enabled *bool `yaml:"enabled"`
+}
+
+// enabled reports whether local VMs are listed
+func (vc VMsConfig) enabled() bool {
+	return vc.Enabled == nil || *vc.Enabled
+}
+
+// vmHost is the generated SSH config of a running VM
+type vmHost struct {
+	Name        string
+	Description string
+	Config      string
+}
+
+// vagrantIndex is the subset of Vagrant's machine index kport uses, which is
+// what `vagrant global-status` reads
+type vagrantIndex struct {
+	Machines map[string]struct {
+		Name            string `json:"name"`
+		Provider        string `json:"provider"`
+		State           string `json:"state"`
+		VagrantfilePath string `json:"vagrantfile_path"`
+	} `json:"machines"`
+}
+
+// vmSSHConfigPath returns where the SSH config of the local VMs is kept
+func vmSSHConfigPath() (string, error) {
+	dir, err := kportCacheDir("vms")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "ssh_config"), nil
+}
+
+// loadVMHosts lists the running Vagrant machines and Lima and Colima
+// instances, with the SSH config their tools generate for them written to
+// one file that ssh is pointed at
+func loadVMHosts() ([]SSHHost, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	vms := vagrantHosts(home)
+	vms = append(vms, limaHosts(filepath.Join(home, ".lima"), "lima-", "Lima instance")...)
+	vms = append(vms, limaHosts(filepath.Join(home, ".colima", "_lima"), "", "Colima instance")...)
+	if len(vms) == 0 {
+		return nil, nil
+	}
+
+	var config strings.Builder
+	descriptions := make(map[string]string)
+	for _, vm := range vms {
+		config.WriteString(vm.Config)
+		config.WriteString("\n")
+		descriptions[vm.Name] = vm.Description
+	}
+	path, err := vmSSHConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(config.String()), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write the SSH config of local VMs: %w", err)
+	}
+
+	sshConfig := NewSSHConfig()
+	if err := sshConfig.LoadConfigFromFile(path); err != nil {
+		return nil, err
+	}
+	hosts := make([]SSHHost, 0, len(sshConfig.Hosts))
+	for _, host := range sshConfig.GetHosts() {
+		if isHostPattern(host.Name) {
+			continue
+		}
+		host.Transport = TransportVM
+		host.Description = descriptions[host.Name]
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return hosts[i].Name < hosts[j].Name
+	})
+	return hosts, nil
+}
+
+// renameHost replaces the name on the Host line of a generated SSH config
+// with name, keeping the rest of the config
+func renameHost(config, name string) string {
+	var s strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(config))
+	renamed := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if key, _, err := parseConfigLine(line); err == nil && key == "host" && !renamed {
+			line = "Host " + name
+			renamed = true
+		}
+		s.WriteString(line + "\n")
+	}
+	return s.String()
+}
+
+// limaHosts lists the running instances under a Lima directory, which keeps
+// the SSH config of each instance next to its ha.pid while it runs. Colima
+// runs its VMs as Lima instances in a directory of its own.
+func limaHosts(dir, prefix, kind string) []vmHost {
+	configs, _ := filepath.Glob(filepath.Join(dir, "*", "ssh.config"))
+	hosts := make([]vmHost, 0, len(configs))
+	for _, path := range configs {
+		instanceDir := filepath.Dir(path)
+		if _, err := os.Stat(filepath.Join(instanceDir, "ha.pid")); err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Debug: Failed to read %s: %v\n", path, err)
+			continue
+		}
+		instance := filepath.Base(instanceDir)
+		name := prefix + instance
+		hosts = append(hosts, vmHost{
+			Name:        name,
+			Description: fmt.Sprintf("%s %s", kind, instance),
+			Config:      renameHost(string(data), name),
+		})
+	}
+	return hosts
+}
+
+// vagrantHosts lists the running Vagrant machines from Vagrant's machine
+// index, asking Vagrant for the SSH config of each
+func vagrantHosts(home string) []vmHost {
+	vagrantHome := os.Getenv("VAGRANT_HOME")
+	if vagrantHome == "" {
+		vagrantHome = filepath.Join(home, ".vagrant.d")
+	}
+	indexPath := filepath.Join(vagrantHome, "data", "machine-index", "index")
+	data, err := os.ReadFile(indexPath)
+	if err != nil {
+		return nil
+	}
+	var index vagrantIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to parse Vagrant machine index %s: %v\n", indexPath, err)
+		return nil
+	}
+	if _, err := exec.LookPath("vagrant"); err != nil {
+		return nil
+	}
+
+	ids := make([]string, 0, len(index.Machines))
+	for id, machine := range index.Machines {
+		if machine.State == "running" {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	hosts := make([]vmHost, len(ids))
+	taken := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		machine := index.Machines[id]
+		name := "vagrant-" + filepath.Base(machine.VagrantfilePath)
+		if machine.Name != "default" {
+			name += "-" + machine.Name
+		}
+		// Projects in directories of the same name are told apart by their machine ID
+		if taken[name] {
+			name += "-" + id[:min(7, len(id))]
+		}
+		taken[name] = true
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			config, err := vagrantSSHConfig(id, name, indexPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Debug: Failed to get the SSH config of Vagrant machine %s: %v\n", name, err)
+				return
+			}
+			hosts[i] = vmHost{
+				Name:        name,
+				Description: fmt.Sprintf("Vagrant machine %s (%s) in %s", machine.Name, machine.Provider, shortenHome(machine.VagrantfilePath)),
+				Config:      config,
+			}
+		}()
+	}
+	wg.Wait()
+
+	found := make([]vmHost, 0, len(hosts))
+	for _, host := range hosts {
+		if host.Config != "" {
+			found = append(found, host)
+		}
+	}
+	return found
+}
+
+// vagrantSSHConfig runs `vagrant ssh-config` for a machine, which takes
+// seconds, so its output is cached until the machine index changes, as it
+// does when a machine is brought up, halted or reloaded
+func vagrantSSHConfig(id, name, indexPath string) (string, error) {
+	dir, err := kportCacheDir("vms")
+	if err != nil {
+		return "", err
+	}
+	cachePath := filepath.Join(dir, "vagrant-"+id)
+	if cached, err := os.Stat(cachePath); err == nil {
+		if index, err := os.Stat(indexPath); err == nil && cached.ModTime().After(index.ModTime()) {
+			if data, err := os.ReadFile(cachePath); err == nil {
+				return renameHost(string(data), name), nil
+			}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), vagrantSSHConfigTimeout)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, "vagrant", "ssh-config", id).Output()
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(cachePath, output, 0o600); err != nil {
+		fmt.Fprintf(os.Stderr, "Debug: Failed to cache the SSH config of %s: %v\n", name, err)
+	}
+	return renameHost(string(output), name), nil
+}
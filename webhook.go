@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultWebhookMaxDuration caps how long a webhook call keeps a profile up
+// when the config sets no max_duration
+const defaultWebhookMaxDuration = time.Hour
+
+// maxWebhookReason caps the reason a call gives, which ends up in the audit log
+const maxWebhookReason = 512
+
+// WebhookConfig lets authenticated calls, such as from CI, bring up profiles
+// through the daemon for a limited time
+type WebhookConfig struct {
+	// Listen is the loopback address the daemon accepts webhook calls on
+	Listen string `yaml:"listen"`
+
+	// TokenFile holds the token callers send as `Authorization: Bearer <token>`
+	TokenFile string `yaml:"token_file"`
+
+	// Profiles are the profiles webhook calls may bring up
+	Profiles []string `yaml:"profiles"`
+
+	// MaxDuration caps the minutes a call asks for, an hour by default
+	MaxDuration time.Duration `yaml:"max_duration"`
+}
+
+// WebhookResponse is the JSON reply to a webhook call
+type WebhookResponse struct {
+	Error     string         `json:"error,omitempty"`
+	Profile   string         `json:"profile,omitempty"`
+	ExpiresAt time.Time      `json:"expires_at,omitzero"`
+	Tunnels   []TunnelStatus `json:"tunnels,omitempty"`
+
+	// Failures are the tunnels of the profile that failed while the rest came up
+	Failures string `json:"failures,omitempty"`
+}
+
+// validate checks that a webhook listener has a token and profiles to bring up
+func (c WebhookConfig) validate() error {
+	if c.MaxDuration < 0 {
+		return fmt.Errorf("webhook max_duration must not be negative")
+	}
+	if c.Listen == "" {
+		return nil
+	}
+	if c.TokenFile == "" {
+		return fmt.Errorf("webhook listens on %s without a token_file", c.Listen)
+	}
+	if len(c.Profiles) == 0 {
+		return fmt.Errorf("webhook listens on %s without any profiles to bring up", c.Listen)
+	}
+	return nil
+}
+
+// maxDuration returns how long a call may keep a profile up
+func (c WebhookConfig) maxDuration() time.Duration {
+	if c.MaxDuration == 0 {
+		return defaultWebhookMaxDuration
+	}
+	return c.MaxDuration
+}
+
+// token reads the token callers authenticate with
+func (c WebhookConfig) token() ([]byte, error) {
+	data, err := os.ReadFile(expandShellVars(c.TokenFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook token: %w", err)
+	}
+	token := bytes.TrimSpace(data)
+	if len(token) == 0 {
+		return nil, fmt.Errorf("webhook token file %s is empty", c.TokenFile)
+	}
+	return token, nil
+}
+
+// webhookLease is a profile brought up by a webhook call, torn down by its
+// timer unless a call ends it first
+type webhookLease struct {
+	timer   *time.Timer
+	started time.Time
+	expires time.Time
+}
+
+// webhookServer brings up and tears down profiles for webhook calls
+type webhookServer struct {
+	daemon *Daemon
+	config WebhookConfig
+	token  [sha256.Size]byte
+
+	// mu is held while a profile comes up, so concurrent calls for a profile
+	// don't both start it
+	mu     sync.Mutex
+	leases map[string]*webhookLease
+}
+
+// startWebhook serves webhook calls for d on the configured loopback address.
+// It returns a function that stops the server, leaving the profiles it brought
+// up to the daemon.
+func startWebhook(config WebhookConfig, d *Daemon) (func(), error) {
+	token, err := config.token()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := listenLoopback(config.Listen, "webhook")
+	if err != nil {
+		return nil, err
+	}
+
+	ws := &webhookServer{
+		daemon: d,
+		config: config,
+		token:  sha256.Sum256(token),
+		leases: make(map[string]*webhookLease),
+	}
+	d.webhook = ws
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /profiles/{name}/up", ws.authorized(ws.handleUp))
+	mux.HandleFunc("POST /profiles/{name}/down", ws.authorized(ws.handleDown))
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(os.Stderr, "Debug: Webhook server stopped: %v\n", err)
+		}
+	}()
+
+	return func() { server.Close() }, nil
+}
+
+// authorized rejects calls without the token or for a profile the config
+// doesn't list before passing them to handler
+func (ws *webhookServer) authorized(handler func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.PathValue("name")
+		// Hashing both sides keeps the comparison constant-time whatever the length
+		token, bearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		given := sha256.Sum256([]byte(token))
+		if !bearer || subtle.ConstantTimeCompare(given[:], ws.token[:]) != 1 {
+			auditWebhook("webhook_denied", name, 0, "invalid token from "+r.RemoteAddr)
+			logEvent("webhook call for profile %s denied: invalid token", name)
+			writeWebhookResponse(w, http.StatusUnauthorized, WebhookResponse{Error: "invalid token"})
+			return
+		}
+		if !slices.Contains(ws.config.Profiles, name) {
+			auditWebhook("webhook_denied", name, 0, "profile not allowed for webhooks")
+			writeWebhookResponse(w, http.StatusForbidden, WebhookResponse{Error: fmt.Sprintf("profile %q can't be brought up by webhooks", name)})
+			return
+		}
+		handler(w, r, name)
+	}
+}
+
+// handleUp brings up a profile for the minutes the call asks for, or extends
+// the time of a profile an earlier call brought up
+func (ws *webhookServer) handleUp(w http.ResponseWriter, r *http.Request, name string) {
+	minutes, err := strconv.Atoi(r.URL.Query().Get("minutes"))
+	if err != nil || minutes <= 0 {
+		writeWebhookResponse(w, http.StatusBadRequest, WebhookResponse{Error: "minutes must be a positive number"})
+		return
+	}
+	// Checked in minutes, since a huge count overflows once made a duration
+	if minutes > int(ws.config.maxDuration()/time.Minute) {
+		writeWebhookResponse(w, http.StatusBadRequest, WebhookResponse{Error: fmt.Sprintf("profiles can be brought up for at most %s", ws.config.maxDuration())})
+		return
+	}
+	duration := time.Duration(minutes) * time.Minute
+	reason, valid := webhookReason(w, r)
+	if !valid {
+		return
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	lease, extending := ws.leases[name]
+	if !extending && len(ws.daemon.manager.ProfileTunnels(name)) > 0 {
+		// Tearing it down later would surprise whoever brought it up
+		writeWebhookResponse(w, http.StatusConflict, WebhookResponse{Error: fmt.Sprintf("profile %q is already up and wasn't brought up by a webhook", name)})
+		return
+	}
+
+	tunnels, upErr := ws.daemon.up(name, nil, nil)
+	if upErr != nil && len(tunnels) == 0 {
+		auditWebhook("webhook_failed", name, duration, joinReason(reason, upErr.Error()))
+		logEvent("webhook failed to bring up profile %s: %v", name, upErr)
+		writeWebhookResponse(w, http.StatusBadGateway, WebhookResponse{Error: upErr.Error(), Profile: name})
+		return
+	}
+
+	// A new lease replaces an extended one, so its timer does nothing should
+	// it already be waiting to tear the profile down
+	started := time.Now()
+	if extending {
+		lease.timer.Stop()
+		started = lease.started
+	}
+	lease = &webhookLease{started: started, expires: time.Now().Add(duration)}
+	lease.timer = time.AfterFunc(duration, func() { ws.expire(name, lease) })
+	ws.leases[name] = lease
+
+	event := "webhook_up"
+	if extending {
+		event = "webhook_extend"
+	}
+	auditWebhook(event, name, duration, reason)
+	logEvent("webhook brought up profile %s until %s", name, lease.expires.Format(time.Kitchen))
+
+	resp := WebhookResponse{Profile: name, ExpiresAt: lease.expires, Tunnels: tunnelStatuses(tunnels)}
+	if upErr != nil {
+		resp.Failures = upErr.Error()
+	}
+	writeWebhookResponse(w, http.StatusOK, resp)
+}
+
+// handleDown tears down a profile a webhook call brought up, such as when
+// the job that needed it finishes early
+func (ws *webhookServer) handleDown(w http.ResponseWriter, r *http.Request, name string) {
+	reason, valid := webhookReason(w, r)
+	if !valid {
+		return
+	}
+
+	ws.mu.Lock()
+	lease, ok := ws.leases[name]
+	if ok {
+		lease.timer.Stop()
+		delete(ws.leases, name)
+	}
+	ws.mu.Unlock()
+
+	if !ok {
+		writeWebhookResponse(w, http.StatusNotFound, WebhookResponse{Error: fmt.Sprintf("profile %q wasn't brought up by a webhook", name)})
+		return
+	}
+	ws.daemon.manager.Down(name)
+	auditWebhook("webhook_down", name, time.Since(lease.started), reason)
+	logEvent("webhook tore down profile %s", name)
+	writeWebhookResponse(w, http.StatusOK, WebhookResponse{Profile: name})
+}
+
+// expire tears down a profile once the time its webhook call asked for is up
+func (ws *webhookServer) expire(name string, lease *webhookLease) {
+	ws.mu.Lock()
+	if ws.leases[name] != lease {
+		// Torn down or extended in the meantime
+		ws.mu.Unlock()
+		return
+	}
+	delete(ws.leases, name)
+	ws.mu.Unlock()
+
+	ws.daemon.manager.Down(name)
+	auditWebhook("webhook_expired", name, time.Since(lease.started), "")
+	logEvent("webhook time for profile %s is up, tore it down", name)
+}
+
+// forget drops the lease of a profile taken down by other means, so its
+// timer doesn't tear down the profile when it is brought up again by hand
+func (ws *webhookServer) forget(name string) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if lease, ok := ws.leases[name]; ok {
+		lease.timer.Stop()
+		delete(ws.leases, name)
+	}
+}
+
+// webhookReason returns the reason a call gives, rejecting the call when the
+// reason is too long
+func webhookReason(w http.ResponseWriter, r *http.Request) (string, bool) {
+	reason := r.URL.Query().Get("reason")
+	if len(reason) > maxWebhookReason {
+		writeWebhookResponse(w, http.StatusBadRequest, WebhookResponse{Error: fmt.Sprintf("reason must be at most %d bytes", maxWebhookReason)})
+		return "", false
+	}
+	return reason, true
+}
+
+// joinReason adds detail to the reason a caller gave
+func joinReason(reason, detail string) string {
+	if reason == "" {
+		return detail
+	}
+	return reason + ": " + detail
+}
+
+// writeWebhookResponse sends resp as JSON with status
+func writeWebhookResponse(w http.ResponseWriter, status int, resp WebhookResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}